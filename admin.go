@@ -0,0 +1,438 @@
+package memebot
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultMaxUploadBytes is the largest image that can be uploaded if no limit is configured.
+const DefaultMaxUploadBytes = 10 << 20
+
+// Keywords are stored in file names, so their total length is limited by the file system.
+const maxMemeFileNameLength = 255
+
+var (
+	ErrMemeExists     = errors.New("meme already exists")
+	ErrMemeNotFound   = errors.New("meme not found")
+	ErrFileExists     = errors.New("a meme with the same keywords already exists")
+	ErrInvalidKeyword = errors.New("keywords must not be empty, or contain commas, slashes or control characters")
+	ErrReadOnly       = errors.New("memepository is read-only")
+)
+
+// AddMeme writes a new image to the directory, named after its keywords, and reloads the index.
+// The image must be valid, and must not already be in the directory.
+func (m *FileServingMemepository) AddMeme(data []byte, ext string, keywords []string) (*FileMeme, error) {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	if !m.ImageExtensions.Contains(ext) {
+		return nil, ErrMediaTypeNotSupported
+	}
+	if analysis := analyzeImage(data, ext, m.Server.MediaTypes); analysis.invalid != nil {
+		return nil, analysis.invalid
+	}
+	name, err := memeFileName(keywords, ext)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := generateSha1Base64Hash(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.writable(); err != nil {
+		return nil, err
+	}
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+
+	if _, found := m.FindByContentHash(hash); found {
+		return nil, ErrMemeExists
+	}
+	path := filepath.Join(m.Path, name)
+	if err := checkFileNotExists(path); err != nil {
+		return nil, err
+	}
+	if err := WriteFileAtomically(path, data, 0644); err != nil {
+		return nil, err
+	}
+	return m.reloadAndFind(hash)
+}
+
+// SetKeywords renames the file of the meme with the content hash hash to match keywords,
+// and reloads the index.
+func (m *FileServingMemepository) SetKeywords(hash string, keywords []string) (*FileMeme, error) {
+	if err := m.UpdateKeywords(map[string][]string{hash: keywords}); err != nil {
+		return nil, err
+	}
+	meme, found := m.FindByContentHash(hash)
+	if !found {
+		return nil, ErrMemeNotFound
+	}
+	return meme, nil
+}
+
+// UpdateKeywords is like SetKeywords, but renames multiple memes, keyed by content hash, and
+// only reloads the index once. If a meme can't be renamed, the others are still renamed,
+// and the first error is returned.
+func (m *FileServingMemepository) UpdateKeywords(keywordsByHash map[string][]string) error {
+	if err := m.writable(); err != nil {
+		return err
+	}
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+
+	var firstErr error
+	for hash, keywords := range keywordsByHash {
+		if err := m.rename(hash, keywords); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := m.Reload(); err != nil {
+		return err
+	}
+	return firstErr
+}
+
+// rename must be called with writeLock held.
+func (m *FileServingMemepository) rename(hash string, keywords []string) error {
+	meme, found := m.FindByContentHash(hash)
+	if !found {
+		return ErrMemeNotFound
+	}
+	name, err := memeFileName(keywords, getNormalizedExtensionWithoutDot(meme.path))
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(m.Path, name)
+	if path == meme.path {
+		return nil
+	}
+	// Allow changing only the case of keywords on case-insensitive file systems, where
+	// path is the meme's own file. On other file systems it may be a different meme.
+	if !sameFile(path, meme.path) {
+		if err := checkFileNotExists(path); err != nil {
+			return err
+		}
+	}
+	return os.Rename(meme.path, path)
+}
+
+// sameFile returns true if both paths exist and refer to the same file.
+func sameFile(a, b string) bool {
+	aInfo, err := os.Lstat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Lstat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
+
+// DeleteMeme removes the file of the meme with the content hash hash, and reloads the index.
+// It returns the meme that was deleted.
+func (m *FileServingMemepository) DeleteMeme(hash string) (*FileMeme, error) {
+	if err := m.writable(); err != nil {
+		return nil, err
+	}
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+
+	meme, found := m.FindByContentHash(hash)
+	if !found {
+		return nil, ErrMemeNotFound
+	}
+	if err := os.Remove(meme.path); err != nil {
+		return nil, err
+	}
+	// Don't resurrect the flags if the meme is uploaded again.
+	if err := m.updateMetadata(hash, MemeFlags{}); err != nil {
+		m.Log.Error("error removing metadata of deleted meme", "meme", hash, "err", err)
+	}
+	return meme, m.Reload()
+}
+
+// FindByContentHash returns the meme whose file has the content hash hash.
+func (m *FileServingMemepository) FindByContentHash(hash string) (*FileMeme, bool) {
+	if _, err := m.Load(); err != nil {
+		return nil, false
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, meme := range m.memesById {
+		if meme.ContentHash() == hash {
+			return meme, true
+		}
+	}
+	return nil, false
+}
+
+// writable returns ErrReadOnly if memes are not loaded directly from disk, e.g. from an archive.
+func (m *FileServingMemepository) writable() error {
+	if _, ok := m.FileSystem.(defaultFileSystem); !ok || m.Path == "" {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+func (m *FileServingMemepository) reloadAndFind(hash string) (*FileMeme, error) {
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	meme, found := m.FindByContentHash(hash)
+	if !found {
+		// E.g. the new name isn't recognized as an image.
+		return nil, ErrMemeNotFound
+	}
+	return meme, nil
+}
+
+// memeFileName returns the name of a file that parseKeywords parses as keywords.
+func memeFileName(keywords []string, ext string) (string, error) {
+	var normalized []string
+	for _, keyword := range keywords {
+		keyword = strings.TrimSpace(keyword)
+		if !validKeyword(keyword) {
+			return "", ErrInvalidKeyword
+		}
+		normalized = append(normalized, keyword)
+	}
+	if len(normalized) == 0 || strings.HasPrefix(normalized[0], ".") {
+		return "", ErrInvalidKeyword
+	}
+
+	name := strings.Join(normalized, ",") + "." + ext
+	if len(name) > maxMemeFileNameLength {
+		return "", ErrInvalidKeyword
+	}
+	return name, nil
+}
+
+func validKeyword(keyword string) bool {
+	if keyword == "" {
+		return false
+	}
+	for _, r := range keyword {
+		if r == ',' || r == '/' || r == '\\' || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func checkFileNotExists(path string) error {
+	if _, err := os.Lstat(path); err == nil {
+		return ErrFileExists
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// WriteFileAtomically writes data to a temporary file that isn't loaded as a meme, then
+// renames it to path with mode, so the file is never loaded half-written. An existing
+// file at path is replaced.
+func WriteFileAtomically(path string, data []byte, mode os.FileMode) error {
+	temp, err := ioutil.TempFile(filepath.Dir(path), ".memebot-write")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+type AdminAPIConfig struct {
+	// Memes are uploaded to, retagged and deleted from this directory.
+	Memepository *FileServingMemepository
+
+	// Requests must have an "Authorization: Bearer <Token>" header.
+	Token string
+
+	// Largest image that can be uploaded. Defaults to DefaultMaxUploadBytes.
+	MaxUploadBytes int64
+
+	// Each change is logged here at LevelInfo, and each rejected request at LevelWarn.
+	// Defaults to Log, with an "audit" field so the entries can be picked out.
+	AuditLog *Logger
+
+	Log *Logger // Internal errors are written here. Defaults to DefaultLogger.
+}
+
+// AdminAPI serves token-protected endpoints for curating a FileServingMemepository:
+//
+//	POST   /memes                Upload a multipart "image" file with "keywords".
+//	PUT    /memes/{id}/keywords  Replace a meme's keywords with a JSON {"keywords": [...]}.
+//	DELETE /memes/{id}           Delete a meme.
+//
+// Memes are identified by the same IDs as the read-only API, and returned in the same format.
+type AdminAPI struct {
+	AdminAPIConfig
+}
+
+type AdminKeywords struct {
+	Keywords []string `json:"keywords"`
+}
+
+func CreateAdminAPI(router *mux.Router, config AdminAPIConfig) (*AdminAPI, error) {
+	if config.Memepository == nil {
+		return nil, errors.New("Memepository must be specified")
+	}
+	if config.Token == "" {
+		return nil, errors.New("Token must be specified")
+	}
+	if config.MaxUploadBytes <= 0 {
+		config.MaxUploadBytes = DefaultMaxUploadBytes
+	}
+	if config.AuditLog == nil {
+		config.AuditLog = config.Log.With("audit", true)
+	}
+
+	api := &AdminAPI{config}
+	router.Path("/memes").Methods("POST").Handler(api.authorized(api.upload).handler(api.Log))
+	router.Path("/memes/{id}/keywords").Methods("PUT").Handler(api.authorized(api.setKeywords).handler(api.Log))
+	router.Path("/memes/{id}").Methods("DELETE").Handler(api.authorized(api.delete).handler(api.Log))
+	return api, nil
+}
+
+func (a *AdminAPI) authorized(handler apiHandler) apiHandler {
+	return func(req *http.Request) (interface{}, error) {
+		expected := "Bearer " + a.Token
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte(expected)) != 1 {
+			a.AuditLog.Warn("admin request rejected", "method", req.Method, "path", req.URL.Path,
+				"remote", req.RemoteAddr, "err", "invalid token")
+			return nil, &apiError{http.StatusUnauthorized, "invalid token"}
+		}
+		return handler(req)
+	}
+}
+
+func (a *AdminAPI) upload(req *http.Request) (interface{}, error) {
+	filename, data, keywords, err := a.readUpload(req)
+	if err != nil {
+		return nil, err
+	}
+
+	meme, err := a.Memepository.AddMeme(data, filepath.Ext(filename), keywords)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	a.AuditLog.Info("admin uploaded meme", "id", meme.ContentHash(), "file", filepath.Base(meme.path), "remote", req.RemoteAddr)
+	return apiCreated{NewAPIMeme(meme)}, nil
+}
+
+// readUpload reads the image and keywords from a multipart upload, without reading more
+// than MaxUploadBytes of the image.
+func (a *AdminAPI) readUpload(req *http.Request) (filename string, data []byte, keywords []string, err error) {
+	// Leave room for the other fields.
+	req.Body = ioutil.NopCloser(io.LimitReader(req.Body, a.MaxUploadBytes+1<<20))
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return "", nil, nil, &apiError{http.StatusBadRequest, "expected a multipart upload"}
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", nil, nil, &apiError{http.StatusBadRequest, "error reading upload: " + err.Error()}
+		}
+
+		switch part.FormName() {
+		case "image":
+			filename = part.FileName()
+			data, err = ioutil.ReadAll(io.LimitReader(part, a.MaxUploadBytes+1))
+			if err != nil {
+				return "", nil, nil, &apiError{http.StatusBadRequest, "error reading image: " + err.Error()}
+			}
+			if int64(len(data)) > a.MaxUploadBytes {
+				return "", nil, nil, &apiError{http.StatusRequestEntityTooLarge,
+					fmt.Sprintf("image must not be larger than %d bytes", a.MaxUploadBytes)}
+			}
+		case "keywords":
+			value, err := ioutil.ReadAll(io.LimitReader(part, maxMemeFileNameLength+1))
+			if err != nil {
+				return "", nil, nil, &apiError{http.StatusBadRequest, "error reading keywords: " + err.Error()}
+			}
+			keywords = append(keywords, strings.Split(string(value), ",")...)
+		}
+	}
+
+	if data == nil {
+		return "", nil, nil, &apiError{http.StatusBadRequest, "image must be specified"}
+	}
+	return filename, data, keywords, nil
+}
+
+func (a *AdminAPI) setKeywords(req *http.Request) (interface{}, error) {
+	var body AdminKeywords
+	if err := json.NewDecoder(io.LimitReader(req.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, &apiError{http.StatusBadRequest, "expected a JSON object with keywords"}
+	}
+
+	id := mux.Vars(req)["id"]
+	old, found := a.Memepository.FindByContentHash(id)
+	if !found {
+		return nil, adminError(ErrMemeNotFound)
+	}
+	oldName := filepath.Base(old.path)
+
+	meme, err := a.Memepository.SetKeywords(id, body.Keywords)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	a.AuditLog.Info("admin renamed meme", "id", id, "from", oldName, "to", filepath.Base(meme.path), "remote", req.RemoteAddr)
+	return NewAPIMeme(meme), nil
+}
+
+func (a *AdminAPI) delete(req *http.Request) (interface{}, error) {
+	id := mux.Vars(req)["id"]
+	meme, err := a.Memepository.DeleteMeme(id)
+	if err != nil {
+		return nil, adminError(err)
+	}
+	a.AuditLog.Info("admin deleted meme", "id", id, "file", filepath.Base(meme.path), "remote", req.RemoteAddr)
+	return NewAPIMeme(meme), nil
+}
+
+// adminError converts errors from changing memes to responses.
+func adminError(err error) error {
+	switch err {
+	case ErrMemeNotFound:
+		return &apiError{http.StatusNotFound, err.Error()}
+	case ErrMemeExists, ErrFileExists:
+		return &apiError{http.StatusConflict, err.Error()}
+	case ErrInvalidKeyword:
+		return &apiError{http.StatusBadRequest, err.Error()}
+	case ErrMediaTypeNotSupported, ErrContentTypeMismatch, ErrTruncatedImage, ErrUndecodableImage, ErrImageTooLarge:
+		return &apiError{http.StatusUnsupportedMediaType, err.Error()}
+	case ErrReadOnly:
+		return &apiError{http.StatusForbidden, err.Error()}
+	}
+	return err
+}
@@ -0,0 +1,328 @@
+package memebot
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAdminToken = "secret"
+
+func newTestAdminAPI(t *testing.T, files map[string]string) (memepository *FileServingMemepository, router *mux.Router, audit *bytes.Buffer, dir string) {
+	memepository, router, dir = newTestFileServingMemepository(t, files, ObjectServerConfig{})
+	audit = new(bytes.Buffer)
+	_, err := CreateAdminAPI(router.PathPrefix("/admin").Subrouter(), AdminAPIConfig{
+		Memepository:   memepository,
+		Token:          testAdminToken,
+		MaxUploadBytes: 16 << 10,
+		AuditLog:       NewLogger(LoggerConfig{Output: audit}),
+	})
+	require.NoError(t, err)
+	return
+}
+
+func serveTestAdminRequest(t *testing.T, router http.Handler, method, url, contentType string, body io.Reader) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, url, body)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+func uploadTestMeme(t *testing.T, router http.Handler, filename string, data []byte, keywords ...string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("image", filename)
+	require.NoError(t, err)
+	part.Write(data)
+	for _, keyword := range keywords {
+		require.NoError(t, writer.WriteField("keywords", keyword))
+	}
+	require.NoError(t, writer.Close())
+
+	return serveTestAdminRequest(t, router, "POST", "/admin/memes", writer.FormDataContentType(), &body)
+}
+
+func listTestDir(t *testing.T, dir string) (names []string) {
+	infos, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	return
+}
+
+func TestAdminUpload(t *testing.T) {
+	memepository, router, audit, dir := newTestAdminAPI(t, nil)
+	defer os.RemoveAll(dir)
+	pngData, _, _ := encodeTestImages(t)
+
+	resp := uploadTestMeme(t, router, "upload.PNG", pngData, "grumpy cat, cat")
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+	var meme APIMeme
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &meme))
+	assert.Equal(t, []string{"grumpy cat", "cat"}, meme.Keywords)
+	assert.Equal(t, int64(len(pngData)), meme.Size)
+
+	assert.Equal(t, []string{"grumpy cat,cat.png"}, listTestDir(t, dir))
+	memes, err := memepository.Load()
+	require.NoError(t, err)
+	require.Len(t, memes.FindByKeyword("grumpy cat"), 1)
+	assert.Equal(t, meme.ID, apiMemeID(memes.FindByKeyword("cat")[0]))
+	assert.Contains(t, audit.String(), `msg="admin uploaded meme" id=`+strconv.Quote(meme.ID)+` file="grumpy cat,cat.png"`)
+
+	// The same image can't be uploaded twice.
+	resp = uploadTestMeme(t, router, "upload.png", pngData, "other")
+	assert.Equal(t, http.StatusConflict, resp.Code)
+}
+
+func TestAdminUploadRejected(t *testing.T) {
+	_, router, _, dir := newTestAdminAPI(t, map[string]string{"cat.png": "foo data"})
+	defer os.RemoveAll(dir)
+	pngData, jpegData, _ := encodeTestImages(t)
+
+	for _, test := range []struct {
+		name     string
+		filename string
+		data     []byte
+		keywords []string
+		status   int
+	}{
+		{"no keywords", "a.png", pngData, nil, http.StatusBadRequest},
+		{"slash", "a.png", pngData, []string{"../cat"}, http.StatusBadRequest},
+		{"hidden", "a.png", pngData, []string{".cat"}, http.StatusBadRequest},
+		{"existing name", "a.png", pngData, []string{"cat"}, http.StatusConflict},
+		{"unknown type", "a.bmp", pngData, []string{"dog"}, http.StatusUnsupportedMediaType},
+		{"mismatch", "a.png", jpegData, []string{"dog"}, http.StatusUnsupportedMediaType},
+		{"truncated", "a.png", pngData[:len(pngData)/2], []string{"dog"}, http.StatusUnsupportedMediaType},
+		{"too large", "a.png", bytes.Repeat([]byte{0}, 32<<10), []string{"dog"}, http.StatusRequestEntityTooLarge},
+	} {
+		resp := uploadTestMeme(t, router, test.filename, test.data, test.keywords...)
+		assert.Equal(t, test.status, resp.Code, test.name)
+		var apiErr APIError
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &apiErr), test.name)
+		assert.Equal(t, test.status, apiErr.Status, test.name)
+	}
+
+	assert.Equal(t, []string{"cat.png"}, listTestDir(t, dir))
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	_, router, audit, dir := newTestAdminAPI(t, map[string]string{"cat.jpg": "foo data"})
+	defer os.RemoveAll(dir)
+
+	for _, token := range []string{"", "Bearer wrong", testAdminToken} {
+		req, err := http.NewRequest("DELETE", "/admin/memes/"+testContentHash(t, "foo data"), nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusUnauthorized, resp.Code, token)
+	}
+
+	assert.Equal(t, []string{"cat.jpg"}, listTestDir(t, dir))
+	assert.Contains(t, audit.String(), `level=warn msg="admin request rejected" method=DELETE`)
+}
+
+func TestAdminAuditLogDefaultsToLog(t *testing.T) {
+	memepository, router, dir := newTestFileServingMemepository(t, nil, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+	var output bytes.Buffer
+	_, err := CreateAdminAPI(router.PathPrefix("/admin").Subrouter(), AdminAPIConfig{
+		Memepository: memepository,
+		Token:        testAdminToken,
+		Log:          NewLogger(LoggerConfig{Output: &output}),
+	})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("DELETE", "/admin/memes/foo", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Contains(t, output.String(), `msg="admin request rejected" audit=true method=DELETE`)
+}
+
+func TestAdminSetKeywords(t *testing.T) {
+	memepository, router, audit, dir := newTestAdminAPI(t, map[string]string{
+		"cat.jpg": "foo data",
+		"dog.jpg": "bar data",
+	})
+	defer os.RemoveAll(dir)
+	id := testContentHash(t, "foo data")
+
+	resp := serveTestAdminRequest(t, router, "PUT", "/admin/memes/"+id+"/keywords", "application/json",
+		strings.NewReader(`{"keywords": ["grumpy", "cat"]}`))
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	var meme APIMeme
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &meme))
+	assert.Equal(t, id, meme.ID)
+	assert.Equal(t, []string{"grumpy", "cat"}, meme.Keywords)
+
+	assert.Equal(t, []string{"dog.jpg", "grumpy,cat.jpg"}, listTestDir(t, dir))
+	memes, _ := memepository.Load()
+	assert.Len(t, memes.FindByKeyword("grumpy"), 1)
+	assert.Contains(t, audit.String(), `msg="admin renamed meme" id=`+strconv.Quote(id)+` from=cat.jpg to=grumpy,cat.jpg`)
+
+	resp = serveTestAdminRequest(t, router, "PUT", "/admin/memes/"+id+"/keywords", "application/json",
+		strings.NewReader(`{"keywords": ["dog"]}`))
+	assert.Equal(t, http.StatusConflict, resp.Code)
+
+	resp = serveTestAdminRequest(t, router, "PUT", "/admin/memes/"+id+"/keywords", "application/json",
+		strings.NewReader(`{"keywords": []}`))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = serveTestAdminRequest(t, router, "PUT", "/admin/memes/nope/keywords", "application/json",
+		strings.NewReader(`{"keywords": ["dog"]}`))
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestAdminSetKeywordsCaseOnly(t *testing.T) {
+	_, router, _, dir := newTestAdminAPI(t, map[string]string{
+		"dog.jpg": "foo data",
+		"Dog.jpg": "bar data",
+	})
+	defer os.RemoveAll(dir)
+	if len(listTestDir(t, dir)) != 2 {
+		t.Skip("file system is case-insensitive")
+	}
+	id := testContentHash(t, "foo data")
+
+	// Dog.jpg is a different meme, so it isn't replaced.
+	resp := serveTestAdminRequest(t, router, "PUT", "/admin/memes/"+id+"/keywords", "application/json",
+		strings.NewReader(`{"keywords": ["Dog"]}`))
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "Dog.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, "bar data", string(data))
+
+	resp = serveTestAdminRequest(t, router, "PUT", "/admin/memes/"+id+"/keywords", "application/json",
+		strings.NewReader(`{"keywords": ["DOG"]}`))
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	assert.Equal(t, []string{"DOG.jpg", "Dog.jpg"}, listTestDir(t, dir))
+}
+
+func TestAdminDelete(t *testing.T) {
+	memepository, router, audit, dir := newTestAdminAPI(t, map[string]string{
+		"cat.jpg": "foo data",
+		"dog.jpg": "bar data",
+	})
+	defer os.RemoveAll(dir)
+	id := testContentHash(t, "foo data")
+
+	resp := serveTestAdminRequest(t, router, "DELETE", "/admin/memes/"+id, "", nil)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+	assert.Equal(t, []string{"dog.jpg"}, listTestDir(t, dir))
+	memes, _ := memepository.Load()
+	assert.Empty(t, memes.FindByKeyword("cat"))
+	assert.Contains(t, audit.String(), `msg="admin deleted meme" id=`+strconv.Quote(id)+` file=cat.jpg`)
+
+	resp = serveTestAdminRequest(t, router, "DELETE", "/admin/memes/"+id, "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestFileServingMemepositoryReload(t *testing.T) {
+	memepository, _, dir := newTestFileServingMemepository(t, map[string]string{"cat.jpg": "foo data"}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+
+	memes, err := memepository.Load()
+	require.NoError(t, err)
+	assert.Equal(t, 1, memes.Len())
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "dog.jpg"), []byte("bar data"), 0644))
+	memes, _ = memepository.Load()
+	assert.Equal(t, 1, memes.Len())
+
+	require.NoError(t, memepository.Reload())
+	memes, _ = memepository.Load()
+	assert.Equal(t, 2, memes.Len())
+	_, found := memepository.FindByContentHash(testContentHash(t, "bar data"))
+	assert.True(t, found)
+
+	// The old memes are kept if the directory can't be read.
+	require.NoError(t, os.Rename(dir, dir+"-moved"))
+	defer os.RemoveAll(dir + "-moved")
+	assert.Error(t, memepository.Reload())
+	memes, err = memepository.Load()
+	require.NoError(t, err)
+	assert.Equal(t, 2, memes.Len())
+}
+
+func TestFileServingMemepositoryReloadReusesUnchangedFiles(t *testing.T) {
+	memepository, _, dir := newTestFileServingMemepository(t, map[string]string{"cat.jpg": "foo data"}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cat.jpg")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	fooId := findTestFileMeme(t, memepository, "cat").id
+
+	// Files with the same size and modification time aren't read again.
+	require.NoError(t, ioutil.WriteFile(path, []byte("bar data"), 0644))
+	require.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+	require.NoError(t, memepository.Reload())
+	assert.Equal(t, fooId, findTestFileMeme(t, memepository, "cat").id)
+
+	modTime := info.ModTime().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+	require.NoError(t, memepository.Reload())
+	meme := findTestFileMeme(t, memepository, "cat")
+	assert.Equal(t, testContentHash(t, "bar data"), meme.ContentHash())
+	assert.True(t, modTime.Equal(meme.LastModified()))
+}
+
+func TestFileServingMemepositoryReadOnly(t *testing.T) {
+	memepository := NewFileServingMemepository(FileServingMemepositoryConfig{
+		Path:            "/",
+		ImageExtensions: MakeSet("jpg"),
+		Router:          mux.NewRouter(),
+		FileSystem:      new(MockFileSystem),
+	})
+	_, err := memepository.DeleteMeme("foo")
+	assert.Equal(t, ErrReadOnly, err)
+}
+
+func testContentHash(t *testing.T, data string) string {
+	hash, err := generateSha1Base64Hash(strings.NewReader(data))
+	require.NoError(t, err)
+	return hash
+}
+
+func TestWriteFileAtomically(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memebot-write")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cat.jpg")
+
+	require.NoError(t, WriteFileAtomically(path, []byte("foo data"), 0600))
+	require.NoError(t, WriteFileAtomically(path, []byte("bar data"), 0640))
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "bar data", string(data))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	assert.Equal(t, []string{"cat.jpg"}, listTestDir(t, dir))
+}
@@ -0,0 +1,457 @@
+package memebot
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Filters for the memes listed by the admin UI.
+const (
+	AdminShowAll         = ""
+	AdminShowUnrequested = "unrequested"
+	AdminShowNSFW        = "nsfw"
+	AdminShowRetired     = "retired"
+)
+
+type AdminUIConfig struct {
+	// Memes are retagged and flagged in this directory.
+	Memepository *FileServingMemepository
+
+	// Used as the password for HTTP basic authentication. The user name is ignored.
+	Token string
+
+	// If not nil, the number of times each meme has been posted, as recorded by the bot, is
+	// shown, and memes that have never been posted can be listed.
+	Stats StatsStore
+
+	// Used to recognize videos. Defaults to DefaultMediaTypes.
+	MediaTypes MediaTypes
+
+	// Shared with the admin API, see AdminAPIConfig.AuditLog.
+	AuditLog *Logger
+
+	Log *Logger // Internal errors are written here. Defaults to DefaultLogger.
+}
+
+// AdminUI serves HTML pages for curating a FileServingMemepository: keywords can be edited
+// for single memes, or added to and removed from many memes at once, and memes can be flagged
+// as NSFW or retired. Keywords are stored in file names, and flags in MemeMetadataFileName.
+type AdminUI struct {
+	AdminUIConfig
+
+	csrfToken     string
+	indexRoute    *mux.Route
+	keywordsRoute *mux.Route
+	flagsRoute    *mux.Route
+	bulkRoute     *mux.Route
+}
+
+func CreateAdminUI(router *mux.Router, config AdminUIConfig) (*AdminUI, error) {
+	if config.Memepository == nil {
+		return nil, errors.New("Memepository must be specified")
+	}
+	if config.Token == "" {
+		return nil, errors.New("Token must be specified")
+	}
+	if config.AuditLog == nil {
+		config.AuditLog = config.Log.With("audit", true)
+	}
+
+	ui := &AdminUI{
+		AdminUIConfig: config,
+		csrfToken:     csrfToken(config.Token),
+	}
+	ui.indexRoute = router.Path("/").Methods("GET", "HEAD").HandlerFunc(ui.authorized(ui.serveIndex))
+	ui.keywordsRoute = router.Path("/memes/{id}/keywords").Methods("POST").HandlerFunc(ui.authorized(ui.setKeywords))
+	ui.flagsRoute = router.Path("/memes/{id}/flags").Methods("POST").HandlerFunc(ui.authorized(ui.setFlags))
+	ui.bulkRoute = router.Path("/bulk").Methods("POST").HandlerFunc(ui.authorized(ui.bulkEdit))
+	return ui, nil
+}
+
+// csrfToken is included in forms, since browsers send basic auth credentials with
+// requests from other sites. It's derived from the admin token so it's the same on all
+// instances and across restarts.
+func csrfToken(token string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte("memebot admin csrf"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (ui *AdminUI) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		_, password, ok := req.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(ui.Token)) != 1 {
+			if ok {
+				ui.AuditLog.Warn("admin request rejected", "method", req.Method, "path", req.URL.Path,
+					"remote", req.RemoteAddr, "err", "invalid token")
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="memebot admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if req.Method == "POST" {
+			if subtle.ConstantTimeCompare([]byte(req.PostFormValue("csrf")), []byte(ui.csrfToken)) != 1 {
+				ui.AuditLog.Warn("admin request rejected", "method", req.Method, "path", req.URL.Path,
+					"remote", req.RemoteAddr, "err", "invalid csrf token")
+				http.Error(w, "invalid form, reload the page and try again", http.StatusForbidden)
+				return
+			}
+		}
+		handler(w, req)
+	}
+}
+
+type adminPage struct {
+	Title      string
+	Message    string
+	CSRF       string
+	Show       string
+	IndexURL   string
+	BulkURL    string
+	HasCounter bool
+	Since      string
+	Filters    []adminFilter
+	Memes      []adminMeme
+}
+
+type adminFilter struct {
+	Label  string
+	URL    string
+	Count  int
+	Active bool
+}
+
+type adminMeme struct {
+	ID           string
+	URL          string
+	ThumbnailURL string
+	Video        bool
+	FileName     string
+	Keywords     string
+	NSFW         bool
+	Retired      bool
+	Requests     int
+	KeywordsURL  string
+	FlagsURL     string
+}
+
+func (ui *AdminUI) serveIndex(w http.ResponseWriter, req *http.Request) {
+	show := req.URL.Query().Get("show")
+	all := ui.Memepository.AllMemes()
+
+	page := adminPage{
+		Title:    "memebot admin",
+		Message:  req.URL.Query().Get("msg"),
+		CSRF:     ui.csrfToken,
+		Show:     show,
+		IndexURL: ui.indexURL("", "").String(),
+		BulkURL:  ui.routeURL(ui.bulkRoute),
+	}
+	counts, since, err := ui.requestCounts()
+	if err != nil {
+		ui.Log.Error("error loading usage stats", "err", err)
+	}
+	if counts != nil {
+		page.HasCounter = true
+		page.Since = since.Format("2006-01-02 15:04 MST")
+	}
+
+	filters := []struct {
+		show  string
+		label string
+	}{
+		{AdminShowAll, "All"},
+		{AdminShowUnrequested, "Never requested"},
+		{AdminShowNSFW, "NSFW"},
+		{AdminShowRetired, "Retired"},
+	}
+	for _, filter := range filters {
+		if filter.show == AdminShowUnrequested && counts == nil {
+			continue
+		}
+		matching := filterAdminMemes(all, filter.show, counts)
+		page.Filters = append(page.Filters, adminFilter{
+			Label:  filter.label,
+			URL:    ui.indexURL(filter.show, "").String(),
+			Count:  len(matching),
+			Active: filter.show == show,
+		})
+		if filter.show == show {
+			for _, meme := range matching {
+				page.Memes = append(page.Memes, ui.adminMeme(meme, counts))
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := adminTemplate.Execute(&buf, page); err != nil {
+		ui.Log.Error("error rendering admin page", "err", err)
+		http.Error(w, "error rendering admin page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// requestCounts returns the number of times each meme has been posted, keyed by API ID, and
+// when the first request was recorded. counts is nil if there are no Stats.
+func (ui *AdminUI) requestCounts() (counts map[string]int, since time.Time, err error) {
+	if ui.Stats == nil {
+		return nil, since, nil
+	}
+	records, err := ui.Stats.Records(time.Time{})
+	if err != nil {
+		return nil, since, err
+	}
+
+	counts = make(map[string]int)
+	since = time.Now()
+	for _, record := range records {
+		if record.Time.Before(since) {
+			since = record.Time
+		}
+		for _, meme := range record.Memes {
+			counts[meme.ID]++
+		}
+	}
+	return counts, since, nil
+}
+
+func filterAdminMemes(memes []*FileMeme, show string, counts map[string]int) (filtered []*FileMeme) {
+	for _, meme := range memes {
+		var matches bool
+		switch show {
+		case AdminShowUnrequested:
+			matches = !meme.flags.Retired && counts[apiMemeID(meme)] == 0
+		case AdminShowNSFW:
+			matches = meme.flags.NSFW
+		case AdminShowRetired:
+			matches = meme.flags.Retired
+		default:
+			matches = true
+		}
+		if matches {
+			filtered = append(filtered, meme)
+		}
+	}
+	return
+}
+
+func (ui *AdminUI) adminMeme(meme *FileMeme, counts map[string]int) adminMeme {
+	id := meme.ContentHash()
+	return adminMeme{
+		ID:           id,
+		URL:          meme.URL().String(),
+		ThumbnailURL: thumbnailURL(meme).String(),
+		Video:        ui.MediaTypes.isVideo(meme),
+		FileName:     filepath.Base(meme.path),
+		Keywords:     strings.Join(meme.Keywords(), ", "),
+		NSFW:         meme.flags.NSFW,
+		Retired:      meme.flags.Retired,
+		KeywordsURL:  ui.routeURL(ui.keywordsRoute, "id", id),
+		FlagsURL:     ui.routeURL(ui.flagsRoute, "id", id),
+		Requests:     counts[apiMemeID(meme)],
+	}
+}
+
+func (ui *AdminUI) setKeywords(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	old, found := ui.Memepository.FindByContentHash(id)
+	if !found {
+		ui.redirect(w, req, ErrMemeNotFound.Error())
+		return
+	}
+	oldName := filepath.Base(old.path)
+
+	meme, err := ui.Memepository.SetKeywords(id, strings.Split(req.PostFormValue("keywords"), ","))
+	if err != nil {
+		ui.redirect(w, req, fmt.Sprintf("Couldn't change the keywords of %s: %s", oldName, err))
+		return
+	}
+	ui.auditRename(req, id, oldName, meme)
+	ui.redirect(w, req, "Saved "+filepath.Base(meme.path))
+}
+
+func (ui *AdminUI) setFlags(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	flags := MemeFlags{
+		NSFW:    req.PostFormValue("nsfw") != "",
+		Retired: req.PostFormValue("retired") != "",
+	}
+
+	meme, err := ui.Memepository.SetFlags(id, flags)
+	if err != nil {
+		ui.redirect(w, req, "Couldn't change the flags: "+err.Error())
+		return
+	}
+	ui.AuditLog.Info("admin flagged meme", "id", id, "file", filepath.Base(meme.path),
+		"nsfw", flags.NSFW, "retired", flags.Retired, "remote", req.RemoteAddr)
+	ui.redirect(w, req, "Saved "+filepath.Base(meme.path))
+}
+
+func (ui *AdminUI) bulkEdit(w http.ResponseWriter, req *http.Request) {
+	tag := strings.TrimSpace(req.PostFormValue("tag"))
+	action := req.PostFormValue("action")
+	ids := req.PostForm["id"]
+	if tag == "" || len(ids) == 0 || (action != "add" && action != "remove") {
+		ui.redirect(w, req, "Select some memes and enter a keyword to add or remove.")
+		return
+	}
+
+	oldNames := make(map[string]string)
+	keywordsByHash := make(map[string][]string)
+	for _, id := range ids {
+		meme, found := ui.Memepository.FindByContentHash(id)
+		if !found {
+			continue
+		}
+		keywords, changed := editKeywords(meme.Keywords(), tag, action == "add")
+		if changed {
+			oldNames[id] = filepath.Base(meme.path)
+			keywordsByHash[id] = keywords
+		}
+	}
+
+	err := ui.Memepository.UpdateKeywords(keywordsByHash)
+	renamed := 0
+	for id, oldName := range oldNames {
+		if meme, found := ui.Memepository.FindByContentHash(id); found && filepath.Base(meme.path) != oldName {
+			ui.auditRename(req, id, oldName, meme)
+			renamed++
+		}
+	}
+
+	message := fmt.Sprintf("Added “%s” to %d memes", tag, renamed)
+	if action == "remove" {
+		message = fmt.Sprintf("Removed “%s” from %d memes", tag, renamed)
+	}
+	if err != nil {
+		message += ". Some memes couldn't be changed: " + err.Error()
+	}
+	ui.redirect(w, req, message)
+}
+
+// editKeywords adds or removes keyword from keywords, ignoring case.
+func editKeywords(keywords []string, keyword string, add bool) (edited []string, changed bool) {
+	normalized := normalizeKeyword(keyword)
+	for _, existing := range keywords {
+		if normalizeKeyword(existing) == normalized {
+			if add {
+				return keywords, false
+			}
+			changed = true
+			continue
+		}
+		edited = append(edited, existing)
+	}
+	if add {
+		return append(edited, keyword), true
+	}
+	return edited, changed
+}
+
+func (ui *AdminUI) auditRename(req *http.Request, id, oldName string, meme *FileMeme) {
+	newName := filepath.Base(meme.path)
+	if newName != oldName {
+		ui.AuditLog.Info("admin renamed meme", "id", id, "from", oldName, "to", newName, "remote", req.RemoteAddr)
+	}
+}
+
+// redirect returns to the list of memes the form was submitted from, with a message.
+func (ui *AdminUI) redirect(w http.ResponseWriter, req *http.Request, message string) {
+	http.Redirect(w, req, ui.indexURL(req.PostFormValue("show"), message).String(), http.StatusSeeOther)
+}
+
+func (ui *AdminUI) indexURL(show, message string) *url.URL {
+	indexURL, err := ui.indexRoute.URL()
+	if err != nil {
+		panic(err)
+	}
+	query := url.Values{}
+	if show != "" {
+		query.Set("show", show)
+	}
+	if message != "" {
+		query.Set("msg", message)
+	}
+	indexURL.RawQuery = query.Encode()
+	return indexURL
+}
+
+func (ui *AdminUI) routeURL(route *mux.Route, pairs ...string) string {
+	routeURL, err := route.URL(pairs...)
+	if err != nil {
+		panic(err)
+	}
+	return routeURL.String()
+}
+
+var adminTemplate = template.Must(template.New("admin").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+nav a { margin-right: 1em; }
+nav a.active { font-weight: bold; }
+.message { background: #ffc; padding: 0.5em; }
+.grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(240px, 1fr)); gap: 1em; margin-top: 1em; }
+.meme img, .meme video { width: 100%; height: 180px; object-fit: cover; background: #eee; }
+.meme input[type=text] { width: 100%; box-sizing: border-box; }
+.meme.retired { opacity: 0.5; }
+.meme form { margin: 0.25em 0; }
+.filename, .requests { color: #666; font-size: small; overflow-wrap: anywhere; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<nav>{{range .Filters}}<a href="{{.URL}}"{{if .Active}} class="active"{{end}}>{{.Label}} ({{.Count}})</a>{{end}}</nav>
+{{if .HasCounter}}<p class="requests">Requests are counted since {{.Since}}.</p>{{end}}
+{{with .Message}}<p class="message">{{.}}</p>{{end}}
+<form id="bulk" action="{{.BulkURL}}" method="post">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+<input type="hidden" name="show" value="{{.Show}}">
+<input type="text" name="tag" placeholder="Keyword">
+<button type="submit" name="action" value="add">Add to selected</button>
+<button type="submit" name="action" value="remove">Remove from selected</button>
+</form>
+<div class="grid">
+{{range .Memes}}<div class="meme{{if .Retired}} retired{{end}}">
+<label><input type="checkbox" name="id" value="{{.ID}}" form="bulk"> select</label>
+{{if .Video}}<video src="{{.URL}}" preload="none" controls muted></video>{{else}}<a href="{{.URL}}"><img src="{{.ThumbnailURL}}" loading="lazy" alt=""></a>{{end}}
+<div class="filename">{{.FileName}}</div>
+<form action="{{.KeywordsURL}}" method="post">
+<input type="hidden" name="csrf" value="{{$.CSRF}}">
+<input type="hidden" name="show" value="{{$.Show}}">
+<input type="text" name="keywords" value="{{.Keywords}}">
+<button type="submit">Save keywords</button>
+</form>
+<form action="{{.FlagsURL}}" method="post">
+<input type="hidden" name="csrf" value="{{$.CSRF}}">
+<input type="hidden" name="show" value="{{$.Show}}">
+<label><input type="checkbox" name="nsfw" value="1"{{if .NSFW}} checked{{end}}> NSFW</label>
+<label><input type="checkbox" name="retired" value="1"{{if .Retired}} checked{{end}}> Retired</label>
+<button type="submit">Save flags</button>
+</form>
+{{if $.HasCounter}}<div class="requests">Requested {{.Requests}} times</div>{{end}}
+</div>
+{{end}}</div>
+</body>
+</html>
+`))
@@ -0,0 +1,206 @@
+package memebot
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAdminUI(t *testing.T, files map[string]string) (memepository *FileServingMemepository, router *mux.Router, stats *MemoryStatsStore, audit *bytes.Buffer, dir string) {
+	memepository, router, dir = newTestFileServingMemepository(t, files, ObjectServerConfig{})
+	stats = NewMemoryStatsStore()
+	audit = new(bytes.Buffer)
+	_, err := CreateAdminUI(router.PathPrefix("/admin").Subrouter(), AdminUIConfig{
+		Memepository: memepository,
+		Token:        testAdminToken,
+		Stats:        stats,
+		AuditLog:     NewLogger(LoggerConfig{Output: audit}),
+	})
+	require.NoError(t, err)
+	return
+}
+
+func serveTestAdminForm(t *testing.T, router http.Handler, url string, form url.Values) *httptest.ResponseRecorder {
+	if form.Get("csrf") == "" {
+		form.Set("csrf", csrfToken(testAdminToken))
+	}
+	req, err := http.NewRequest("POST", url, strings.NewReader(form.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("admin", testAdminToken)
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+func serveTestAdminPage(t *testing.T, router http.Handler, url string) string {
+	req, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", testAdminToken)
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	return resp.Body.String()
+}
+
+func TestAdminUIRequiresToken(t *testing.T) {
+	_, router, _, audit, dir := newTestAdminUI(t, map[string]string{"cat.jpg": "foo data"})
+	defer os.RemoveAll(dir)
+
+	resp := serveTestRequest(t, router, "/admin/", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Equal(t, `Basic realm="memebot admin"`, resp.Header().Get("WWW-Authenticate"))
+
+	req, err := http.NewRequest("GET", "/admin/", nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "wrong")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Contains(t, audit.String(), `level=warn msg="admin request rejected" method=GET path=/admin/`)
+
+	body := serveTestAdminPage(t, router, "/admin/")
+	assert.Contains(t, body, `value="cat"`)
+	assert.Contains(t, body, csrfToken(testAdminToken))
+}
+
+func TestAdminUIRequiresCSRFToken(t *testing.T) {
+	_, router, _, _, dir := newTestAdminUI(t, map[string]string{"cat.jpg": "foo data"})
+	defer os.RemoveAll(dir)
+
+	resp := serveTestAdminForm(t, router, "/admin/memes/"+testContentHash(t, "foo data")+"/keywords",
+		url.Values{"keywords": {"dog"}, "csrf": {"wrong"}})
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	assert.Equal(t, []string{"cat.jpg"}, listTestDir(t, dir))
+}
+
+func TestAdminUISetKeywords(t *testing.T) {
+	_, router, _, audit, dir := newTestAdminUI(t, map[string]string{"cat.jpg": "foo data"})
+	defer os.RemoveAll(dir)
+	id := testContentHash(t, "foo data")
+
+	resp := serveTestAdminForm(t, router, "/admin/memes/"+id+"/keywords",
+		url.Values{"keywords": {"grumpy, cat"}, "show": {"nsfw"}})
+	require.Equal(t, http.StatusSeeOther, resp.Code)
+	location, err := url.Parse(resp.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "/admin/", location.Path)
+	assert.Equal(t, "nsfw", location.Query().Get("show"))
+	assert.Equal(t, "Saved grumpy,cat.jpg", location.Query().Get("msg"))
+
+	assert.Equal(t, []string{"grumpy,cat.jpg"}, listTestDir(t, dir))
+	assert.Contains(t, audit.String(), `msg="admin renamed meme" id=`+strconv.Quote(id)+` from=cat.jpg to=grumpy,cat.jpg`)
+
+	resp = serveTestAdminForm(t, router, "/admin/memes/"+id+"/keywords", url.Values{"keywords": {" , "}})
+	require.Equal(t, http.StatusSeeOther, resp.Code)
+	assert.Contains(t, resp.Header().Get("Location"), "Couldn")
+	assert.Equal(t, []string{"grumpy,cat.jpg"}, listTestDir(t, dir))
+}
+
+func TestAdminUISetFlags(t *testing.T) {
+	memepository, router, _, audit, dir := newTestAdminUI(t, map[string]string{
+		"cat.jpg": "foo data",
+		"dog.jpg": "bar data",
+	})
+	defer os.RemoveAll(dir)
+	id := testContentHash(t, "foo data")
+
+	resp := serveTestAdminForm(t, router, "/admin/memes/"+id+"/flags", url.Values{"retired": {"1"}})
+	require.Equal(t, http.StatusSeeOther, resp.Code)
+	memes, _ := memepository.Load()
+	assert.Empty(t, memes.FindByKeyword("cat"))
+	assert.Contains(t, audit.String(), `msg="admin flagged meme" id=`+strconv.Quote(id)+` file=cat.jpg nsfw=false retired=true`)
+
+	body := serveTestAdminPage(t, router, "/admin/?show=retired")
+	assert.Contains(t, body, "Retired (1)")
+	assert.Contains(t, body, `value="cat"`)
+	assert.NotContains(t, body, `value="dog"`)
+
+	resp = serveTestAdminForm(t, router, "/admin/memes/"+id+"/flags", url.Values{"nsfw": {"1"}})
+	require.Equal(t, http.StatusSeeOther, resp.Code)
+	memes, _ = memepository.Load()
+	require.Len(t, memes.FindByKeyword("cat"), 1)
+	assert.True(t, memeFlags(memes.FindByKeyword("cat")[0]).NSFW)
+}
+
+func TestAdminUIBulkEdit(t *testing.T) {
+	_, router, _, audit, dir := newTestAdminUI(t, map[string]string{
+		"cat.jpg":        "foo data",
+		"grumpy,Cat.jpg": "bar data",
+		"dog.jpg":        "baz data",
+	})
+	defer os.RemoveAll(dir)
+	ids := []string{testContentHash(t, "foo data"), testContentHash(t, "bar data")}
+
+	resp := serveTestAdminForm(t, router, "/admin/bulk", url.Values{"id": ids, "tag": {"cat"}, "action": {"add"}})
+	require.Equal(t, http.StatusSeeOther, resp.Code)
+	// Both already had the tag.
+	assert.Contains(t, resp.Header().Get("Location"), url.QueryEscape("Added “cat” to 0 memes"))
+
+	resp = serveTestAdminForm(t, router, "/admin/bulk", url.Values{"id": ids, "tag": {"funny"}, "action": {"add"}})
+	require.Equal(t, http.StatusSeeOther, resp.Code)
+	assert.Equal(t, []string{"cat,funny.jpg", "dog.jpg", "grumpy,Cat,funny.jpg"}, listTestDir(t, dir))
+	assert.Contains(t, audit.String(), "from=grumpy,Cat.jpg to=grumpy,Cat,funny.jpg")
+
+	resp = serveTestAdminForm(t, router, "/admin/bulk", url.Values{"id": ids, "tag": {"CAT"}, "action": {"remove"}})
+	require.Equal(t, http.StatusSeeOther, resp.Code)
+	assert.Equal(t, []string{"dog.jpg", "funny.jpg", "grumpy,funny.jpg"}, listTestDir(t, dir))
+
+	// Memes must keep at least one keyword.
+	resp = serveTestAdminForm(t, router, "/admin/bulk", url.Values{"id": ids, "tag": {"funny"}, "action": {"remove"}})
+	require.Equal(t, http.StatusSeeOther, resp.Code)
+	assert.Contains(t, resp.Header().Get("Location"), "couldn")
+	assert.Equal(t, []string{"dog.jpg", "funny.jpg", "grumpy.jpg"}, listTestDir(t, dir))
+}
+
+func TestAdminUIUnrequested(t *testing.T) {
+	memepository, router, stats, _, dir := newTestAdminUI(t, map[string]string{
+		"cat.jpg": "foo data",
+		"dog.jpg": "bar data",
+	})
+	defer os.RemoveAll(dir)
+	cat, _ := memepository.FindByContentHash(testContentHash(t, "foo data"))
+	stats.Record(UsageRecord{
+		Time:    time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC),
+		Keyword: "cat",
+		Memes:   []UsageMeme{{ID: apiMemeID(cat)}},
+	})
+
+	body := serveTestAdminPage(t, router, "/admin/?show=unrequested")
+	assert.Contains(t, body, "Never requested (1)")
+	assert.Contains(t, body, `value="dog"`)
+	assert.NotContains(t, body, `value="cat"`)
+
+	body = serveTestAdminPage(t, router, "/admin/")
+	assert.Contains(t, body, "Requested 1 times")
+	assert.Contains(t, body, "Requests are counted since 2016-01-02 03:04 UTC.")
+}
+
+func TestEditKeywords(t *testing.T) {
+	keywords, changed := editKeywords([]string{"grumpy", "Cat"}, "cat", true)
+	assert.False(t, changed)
+	assert.Equal(t, []string{"grumpy", "Cat"}, keywords)
+
+	keywords, changed = editKeywords([]string{"grumpy"}, "cat", true)
+	assert.True(t, changed)
+	assert.Equal(t, []string{"grumpy", "cat"}, keywords)
+
+	keywords, changed = editKeywords([]string{"grumpy", "Cat"}, "cat", false)
+	assert.True(t, changed)
+	assert.Equal(t, []string{"grumpy"}, keywords)
+
+	_, changed = editKeywords([]string{"grumpy"}, "cat", false)
+	assert.False(t, changed)
+}
@@ -0,0 +1,305 @@
+package memebot
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// DefaultAPIPageSize is the number of memes listed per page if no limit is given.
+	DefaultAPIPageSize = 100
+	MaxAPIPageSize     = 1000
+
+	// DefaultAPISearchLimit is the number of memes returned by searches if no limit is given.
+	DefaultAPISearchLimit = 10
+	MaxAPISearchLimit     = 50
+)
+
+type APIConfig struct {
+	Memepository Memepository
+
+	// Used for /search. If nil, searches are answered from Memepository by keyword.
+	Searcher MemeSearcher
+
+	// Options for searches. E.g. external searchers can be disabled.
+	SearchOptions SearchOptions
+
+	Log *Logger // Internal errors are written here. Defaults to DefaultLogger.
+}
+
+// API serves a read-only JSON API for querying memes:
+//
+//	GET /memes?offset=0&limit=100  All memes, paginated.
+//	GET /memes/{id}                A single meme.
+//	GET /keywords                  All keywords, with the number of memes for each.
+//	GET /search?q=cat&limit=10     Memes found by the configured searcher.
+//
+// Errors are returned as JSON objects with an "error" message and the HTTP "status".
+type API struct {
+	APIConfig
+}
+
+// APIMeme is the JSON representation of a meme.
+type APIMeme struct {
+	// The content hash of the image if known, otherwise a hash of its URL.
+	ID       string   `json:"id"`
+	URL      string   `json:"url"`
+	Keywords []string `json:"keywords"`
+
+	// Only set for memes served by this bot.
+	Size         int64      `json:"size,omitempty"`
+	LastModified *time.Time `json:"mtime,omitempty"`
+
+	NSFW    bool `json:"nsfw,omitempty"`
+	Retired bool `json:"retired,omitempty"`
+}
+
+type APIMemePage struct {
+	Memes  []APIMeme `json:"memes"`
+	Total  int       `json:"total"`
+	Offset int       `json:"offset"`
+	Limit  int       `json:"limit"`
+}
+
+type APIKeyword struct {
+	Keyword string `json:"keyword"`
+	Count   int    `json:"count"`
+}
+
+type APIKeywords struct {
+	Keywords []APIKeyword `json:"keywords"`
+}
+
+type APISearchResults struct {
+	Query string    `json:"query"`
+	Memes []APIMeme `json:"memes"`
+}
+
+type APIError struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+// apiError is returned by API handlers to send an error response.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// apiCreated is returned by API handlers to respond with 201 Created.
+type apiCreated struct {
+	value interface{}
+}
+
+func CreateAPI(router *mux.Router, config APIConfig) (*API, error) {
+	if config.Memepository == nil {
+		return nil, errors.New("Memepository must be specified")
+	}
+
+	api := &API{config}
+	router.Path("/memes").Methods("GET", "HEAD").Handler(apiHandler(api.listMemes).handler(api.Log))
+	router.Path("/memes/{id}").Methods("GET", "HEAD").Handler(apiHandler(api.getMeme).handler(api.Log))
+	router.Path("/keywords").Methods("GET", "HEAD").Handler(apiHandler(api.listKeywords).handler(api.Log))
+	router.Path("/search").Methods("GET", "HEAD").Handler(apiHandler(api.search).handler(api.Log))
+	return api, nil
+}
+
+// apiHandler writes the value returned by a handler as JSON, or the error it returns.
+type apiHandler func(req *http.Request) (interface{}, error)
+
+// handler returns an http.Handler for h that writes internal errors to log.
+func (h apiHandler) handler(log *Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h.serve(w, req, log)
+	})
+}
+
+func (h apiHandler) serve(w http.ResponseWriter, req *http.Request, log *Logger) {
+	status := http.StatusOK
+	value, err := h(req)
+	if err != nil {
+		apiErr, ok := err.(*apiError)
+		if !ok {
+			log.Error("api error", "url", req.URL, "err", err)
+			apiErr = &apiError{http.StatusInternalServerError, "internal error"}
+		}
+		status = apiErr.status
+		value = APIError{apiErr.message, status}
+	} else if created, ok := value.(apiCreated); ok {
+		status = http.StatusCreated
+		value = created.value
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Error("error encoding api response", "url", req.URL, "err", err)
+		status = http.StatusInternalServerError
+		data, _ = json.Marshal(APIError{"internal error", status})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func (a *API) listMemes(req *http.Request) (interface{}, error) {
+	offset, err := intParam(req, "offset", 0, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := intParam(req, "limit", DefaultAPIPageSize, 1, MaxAPIPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	memes, err := a.Memepository.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	all := memes.Memes()
+	page := APIMemePage{
+		Memes:  []APIMeme{},
+		Total:  len(all),
+		Offset: offset,
+		Limit:  limit,
+	}
+	if offset < len(all) {
+		for _, meme := range all[offset:minInt(offset+limit, len(all))] {
+			page.Memes = append(page.Memes, NewAPIMeme(meme))
+		}
+	}
+	return page, nil
+}
+
+func (a *API) getMeme(req *http.Request) (interface{}, error) {
+	id := mux.Vars(req)["id"]
+
+	memes, err := a.Memepository.Load()
+	if err != nil {
+		return nil, err
+	}
+	for _, meme := range memes.Memes() {
+		if apiMemeID(meme) == id {
+			return NewAPIMeme(meme), nil
+		}
+	}
+	return nil, &apiError{http.StatusNotFound, fmt.Sprintf("no meme with id %s", id)}
+}
+
+func (a *API) listKeywords(req *http.Request) (interface{}, error) {
+	memes, err := a.Memepository.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	result := APIKeywords{Keywords: []APIKeyword{}}
+	for _, keyword := range memes.Keywords() {
+		result.Keywords = append(result.Keywords, APIKeyword{keyword, len(memes.FindByKeyword(keyword))})
+	}
+	return result, nil
+}
+
+func (a *API) search(req *http.Request) (interface{}, error) {
+	query := strings.TrimSpace(req.URL.Query().Get("q"))
+	if query == "" {
+		return nil, &apiError{http.StatusBadRequest, "q must be specified"}
+	}
+	limit, err := intParam(req, "limit", DefaultAPISearchLimit, 1, MaxAPISearchLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	searcher := a.Searcher
+	if searcher == nil {
+		searcher = &MemepositorySearcher{Memepository: a.Memepository}
+	}
+
+	results := APISearchResults{Query: query, Memes: []APIMeme{}}
+	memes, err := findMemes(searcher, query, limit, a.SearchOptions)
+	if err == ErrNoMemeFound {
+		return results, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, meme := range memes {
+		results.Memes = append(results.Memes, NewAPIMeme(meme))
+	}
+	return results, nil
+}
+
+// intParam parses an integer query parameter between min and max, or returns def if it's
+// not set. A negative max means there's no maximum.
+func intParam(req *http.Request, name string, def, min, max int) (int, error) {
+	value := req.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || (max >= 0 && n > max) {
+		message := fmt.Sprintf("%s must be an integer of at least %d", name, min)
+		if max >= 0 {
+			message = fmt.Sprintf("%s must be an integer between %d and %d", name, min, max)
+		}
+		return 0, &apiError{http.StatusBadRequest, message}
+	}
+	return n, nil
+}
+
+func NewAPIMeme(meme Meme) APIMeme {
+	apiMeme := APIMeme{
+		ID:       apiMemeID(meme),
+		URL:      meme.URL().String(),
+		Keywords: meme.Keywords(),
+	}
+	if apiMeme.Keywords == nil {
+		apiMeme.Keywords = []string{}
+	}
+	flags := memeFlags(meme)
+	apiMeme.NSFW, apiMeme.Retired = flags.NSFW, flags.Retired
+	if object, ok := memeObject(meme); ok {
+		lastModified := object.LastModified().UTC()
+		apiMeme.Size = object.Size()
+		apiMeme.LastModified = &lastModified
+	}
+	return apiMeme
+}
+
+// apiMemeID returns the content hash of meme, or of the meme it wraps, if known.
+// Otherwise it returns a hash of its URL.
+func apiMemeID(meme Meme) string {
+	if hash := memeContentHash(meme); hash != "" {
+		return hash
+	}
+	hash := sha1.Sum([]byte(meme.URL().String()))
+	return hex.EncodeToString(hash[:])
+}
+
+// memeContentHash returns the content hash of meme, or of the meme it wraps, or "" if
+// it's not known.
+func memeContentHash(meme Meme) string {
+	for {
+		if hash := contentHash(meme); hash != "" {
+			return hash
+		}
+		wrapper, ok := meme.(memeWrapper)
+		if !ok {
+			return ""
+		}
+		meme = wrapper.unwrap()
+	}
+}
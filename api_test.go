@@ -0,0 +1,186 @@
+package memebot
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAPI(t *testing.T, config APIConfig) *mux.Router {
+	router := mux.NewRouter()
+	_, err := CreateAPI(router.PathPrefix("/api").Subrouter(), config)
+	require.NoError(t, err)
+	return router
+}
+
+func serveTestAPIRequest(t *testing.T, router http.Handler, url string, status int, value interface{}) {
+	resp := serveTestRequest(t, router, url, nil)
+	require.Equal(t, status, resp.Code, resp.Body.String())
+	assert.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), value))
+}
+
+func TestAPIListMemes(t *testing.T) {
+	router := newTestAPI(t, APIConfig{Memepository: &MockMemepository{NewTestMemeIndex(
+		NewMockMeme("http://example.com/grumpy.jpg", "grumpy", "cat"),
+		NewMockMeme("http://example.com/dance.gif", "dance"),
+		NewMockMeme("http://example.com/longcat.png", "cat"),
+	)}})
+
+	var page APIMemePage
+	serveTestAPIRequest(t, router, "/api/memes", http.StatusOK, &page)
+	assert.Equal(t, 3, page.Total)
+	assert.Equal(t, DefaultAPIPageSize, page.Limit)
+	require.Len(t, page.Memes, 3)
+
+	assert.Equal(t, "http://example.com/grumpy.jpg", page.Memes[0].URL)
+	assert.Equal(t, []string{"grumpy", "cat"}, page.Memes[0].Keywords)
+	assert.Len(t, page.Memes[0].ID, 40)
+	assert.Nil(t, page.Memes[0].LastModified)
+
+	page = APIMemePage{}
+	serveTestAPIRequest(t, router, "/api/memes?offset=1&limit=1", http.StatusOK, &page)
+	assert.Equal(t, 3, page.Total)
+	assert.Equal(t, 1, page.Offset)
+	require.Len(t, page.Memes, 1)
+	assert.Equal(t, "http://example.com/dance.gif", page.Memes[0].URL)
+
+	page = APIMemePage{}
+	serveTestAPIRequest(t, router, "/api/memes?offset=10", http.StatusOK, &page)
+	assert.Equal(t, 3, page.Total)
+	assert.Empty(t, page.Memes)
+}
+
+func TestAPIListMemesBadParams(t *testing.T) {
+	router := newTestAPI(t, APIConfig{Memepository: &MockMemepository{NewTestMemeIndex()}})
+
+	for _, url := range []string{
+		"/api/memes?limit=0",
+		"/api/memes?limit=100000",
+		"/api/memes?offset=-1",
+		"/api/memes?offset=one",
+	} {
+		var apiErr APIError
+		serveTestAPIRequest(t, router, url, http.StatusBadRequest, &apiErr)
+		assert.Equal(t, http.StatusBadRequest, apiErr.Status, url)
+		assert.NotEmpty(t, apiErr.Error, url)
+	}
+}
+
+func TestAPIGetMeme(t *testing.T) {
+	grumpy := NewMockMeme("http://example.com/grumpy.jpg", "grumpy")
+	router := newTestAPI(t, APIConfig{Memepository: &MockMemepository{NewTestMemeIndex(
+		grumpy,
+		NewMockMeme("http://example.com/dance.gif", "dance"),
+	)}})
+
+	var meme APIMeme
+	serveTestAPIRequest(t, router, "/api/memes/"+apiMemeID(grumpy), http.StatusOK, &meme)
+	assert.Equal(t, NewAPIMeme(grumpy), meme)
+
+	var apiErr APIError
+	serveTestAPIRequest(t, router, "/api/memes/nope", http.StatusNotFound, &apiErr)
+	assert.Equal(t, APIError{"no meme with id nope", http.StatusNotFound}, apiErr)
+}
+
+func TestAPIFileMemes(t *testing.T) {
+	memepository, _, dir := newTestFileServingMemepository(t, map[string]string{"grumpy.jpg": "foo data"}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+	router := newTestAPI(t, APIConfig{Memepository: memepository})
+
+	var page APIMemePage
+	serveTestAPIRequest(t, router, "/api/memes", http.StatusOK, &page)
+	require.Len(t, page.Memes, 1)
+	meme := page.Memes[0]
+	assert.Equal(t, contentHash(findTestFileMeme(t, memepository, "grumpy")), meme.ID)
+	assert.Equal(t, int64(len("foo data")), meme.Size)
+	assert.NotNil(t, meme.LastModified)
+
+	var found APIMeme
+	serveTestAPIRequest(t, router, "/api/memes/"+meme.ID, http.StatusOK, &found)
+	assert.Equal(t, meme.URL, found.URL)
+}
+
+func TestAPIKeywords(t *testing.T) {
+	router := newTestAPI(t, APIConfig{Memepository: &MockMemepository{NewTestMemeIndex(
+		NewMockMeme("http://example.com/grumpy.jpg", "grumpy", "cat"),
+		NewMockMeme("http://example.com/longcat.png", "cat"),
+	)}})
+
+	var keywords APIKeywords
+	serveTestAPIRequest(t, router, "/api/keywords", http.StatusOK, &keywords)
+	assert.Equal(t, []APIKeyword{{"cat", 2}, {"grumpy", 1}}, keywords.Keywords)
+}
+
+func TestAPISearch(t *testing.T) {
+	searcher := new(MockSearcher)
+	searcher.On("FindMeme", "cat").Return(NewMockMeme("http://example.com/grumpy.jpg", "grumpy", "cat"), nil)
+	searcher.On("FindMeme", "dog").Return(nil, ErrNoMemeFound)
+	searcher.On("FindMeme", "broken").Return(nil, errors.New("oops"))
+	router := newTestAPI(t, APIConfig{
+		Memepository: &MockMemepository{NewTestMemeIndex()},
+		Searcher:     searcher,
+	})
+
+	var results APISearchResults
+	serveTestAPIRequest(t, router, "/api/search?q=cat", http.StatusOK, &results)
+	assert.Equal(t, "cat", results.Query)
+	require.Len(t, results.Memes, 1)
+	assert.Equal(t, "http://example.com/grumpy.jpg", results.Memes[0].URL)
+
+	results = APISearchResults{}
+	serveTestAPIRequest(t, router, "/api/search?q=dog", http.StatusOK, &results)
+	assert.Equal(t, []APIMeme{}, results.Memes)
+
+	var apiErr APIError
+	serveTestAPIRequest(t, router, "/api/search?q=broken", http.StatusInternalServerError, &apiErr)
+	assert.Equal(t, APIError{"internal error", http.StatusInternalServerError}, apiErr)
+
+	apiErr = APIError{}
+	serveTestAPIRequest(t, router, "/api/search", http.StatusBadRequest, &apiErr)
+	assert.Equal(t, APIError{"q must be specified", http.StatusBadRequest}, apiErr)
+
+	searcher.AssertExpectations(t)
+}
+
+func TestAPISearchDefaultsToMemepository(t *testing.T) {
+	router := newTestAPI(t, APIConfig{Memepository: &MockMemepository{NewTestMemeIndex(
+		NewMockMeme("http://example.com/grumpy.jpg", "grumpy", "cat"),
+		NewMockMeme("http://example.com/dance.gif", "dance"),
+	)}})
+
+	var results APISearchResults
+	serveTestAPIRequest(t, router, "/api/search?q=cat", http.StatusOK, &results)
+	require.Len(t, results.Memes, 1)
+	assert.Equal(t, "http://example.com/grumpy.jpg", results.Memes[0].URL)
+}
+
+func TestAPISearchDisablesExternal(t *testing.T) {
+	external := new(MockSearcher)
+	router := newTestAPI(t, APIConfig{
+		Memepository: &MockMemepository{NewTestMemeIndex()},
+		Searcher: &ChainSearcher{Searchers: []ChainedSearcher{
+			{Name: "giphy", MemeSearcher: external, External: true},
+		}},
+		SearchOptions: SearchOptions{DisableExternal: true},
+	})
+
+	var results APISearchResults
+	serveTestAPIRequest(t, router, "/api/search?q=cat", http.StatusOK, &results)
+	assert.Equal(t, []APIMeme{}, results.Memes)
+	external.AssertNotCalled(t, "FindMeme", "cat")
+}
+
+func TestAPILoadError(t *testing.T) {
+	router := newTestAPI(t, APIConfig{Memepository: FailingMemepository{errors.New("oops")}})
+
+	var apiErr APIError
+	serveTestAPIRequest(t, router, "/api/keywords", http.StatusInternalServerError, &apiErr)
+	assert.Equal(t, http.StatusInternalServerError, apiErr.Status)
+}
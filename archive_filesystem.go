@@ -0,0 +1,320 @@
+package memebot
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxArchiveFileBytes is the largest file that can be read out of an archive, since
+	// files are read into memory when they're opened.
+	MaxArchiveFileBytes = 32 << 20
+
+	// MaxCompressedTarBytes is the most data that will be kept in memory for the files of a
+	// .tar.gz archive.
+	MaxCompressedTarBytes = 128 << 20
+)
+
+var (
+	ErrUnsupportedArchive  = errors.New("unsupported archive format, must be .zip, .tar, .tar.gz or .tgz")
+	ErrArchiveFileTooLarge = errors.New("file in archive is too large")
+	ErrArchiveTooLarge     = errors.New("compressed archive is too large to keep in memory, use .zip or .tar")
+
+	errArchiveFileSize = errors.New("file in archive doesn't match the size in its header")
+)
+
+// ArchiveFileSystem is a read-only FileSystem that reads files straight out of a zip or
+// tar archive without extracting it to disk. Paths are relative to the root of the archive.
+type ArchiveFileSystem struct {
+	dirs  map[string][]os.FileInfo
+	files StringSet
+
+	// Returns the entire contents of the file at a cleaned path.
+	readFile func(name string) ([]byte, error)
+	closer   io.Closer
+}
+
+var _ FileSystem = &ArchiveFileSystem{}
+
+// IsArchive returns true if name has the extension of an archive supported by ArchiveFileSystem.
+func IsArchive(name string) bool {
+	_, _, err := archiveFormat(name)
+	return err == nil
+}
+
+func archiveFormat(name string) (isZip, gzipped bool, err error) {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		isZip = true
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		gzipped = true
+	case strings.HasSuffix(name, ".tar"):
+	default:
+		err = ErrUnsupportedArchive
+	}
+	return
+}
+
+// OpenArchiveFileSystem reads the index of the archive at archivePath.
+// The format is determined from the extension.
+func OpenArchiveFileSystem(archivePath string) (*ArchiveFileSystem, error) {
+	isZip, gzipped, err := archiveFormat(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &ArchiveFileSystem{
+		dirs:  map[string][]os.FileInfo{"": nil},
+		files: make(StringSet),
+	}
+	if isZip {
+		err = fs.openZip(archivePath)
+	} else {
+		err = fs.openTar(archivePath, gzipped)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *ArchiveFileSystem) openZip(archivePath string) error {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+
+	filesByName := make(map[string]*zip.File)
+	for _, file := range reader.File {
+		name := cleanArchivePath(file.Name)
+		fs.addEntry(name, file.FileInfo())
+		filesByName[name] = file
+	}
+
+	fs.closer = reader
+	fs.readFile = func(name string) ([]byte, error) {
+		file := filesByName[name]
+		if file.UncompressedSize64 > MaxArchiveFileBytes {
+			return nil, ErrArchiveFileTooLarge
+		}
+		data, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer data.Close()
+		return readArchiveFile(data, int64(file.UncompressedSize64))
+	}
+	return nil
+}
+
+// openTar reads the archive in a single pass. Files in uncompressed archives are read from
+// their offsets when opened, but compressed archives can't be seeked, so their files are
+// kept in memory, up to MaxCompressedTarBytes.
+func (fs *ArchiveFileSystem) openTar(archivePath string, gzipped bool) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = file
+	if gzipped {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		defer gzipReader.Close()
+		r = gzipReader
+	}
+
+	counter := &countingReader{Reader: r}
+	tarReader := tar.NewReader(counter)
+	contents := make(map[string][]byte)
+	var contentsBytes int64
+	sections := make(map[string]tarSection)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			file.Close()
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA && header.Typeflag != tar.TypeDir {
+			continue
+		}
+		name := cleanArchivePath(header.Name)
+		fs.addEntry(name, header.FileInfo())
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+
+		if gzipped {
+			data, err := readArchiveFile(tarReader, header.Size)
+			if err == ErrArchiveFileTooLarge {
+				// Reported when the file is opened.
+				continue
+			} else if err != nil {
+				file.Close()
+				return err
+			}
+			if contentsBytes += header.Size; contentsBytes > MaxCompressedTarBytes {
+				file.Close()
+				return ErrArchiveTooLarge
+			}
+			contents[name] = data
+		} else {
+			// The file's data starts right after its header.
+			sections[name] = tarSection{counter.n, header.Size}
+		}
+	}
+
+	if gzipped {
+		file.Close()
+		fs.readFile = func(name string) ([]byte, error) {
+			data, found := contents[name]
+			if !found {
+				return nil, ErrArchiveFileTooLarge
+			}
+			return data, nil
+		}
+		return nil
+	}
+
+	fs.closer = file
+	fs.readFile = func(name string) ([]byte, error) {
+		section := sections[name]
+		return readArchiveFile(io.NewSectionReader(file, section.offset, section.size), section.size)
+	}
+	return nil
+}
+
+// readArchiveFile reads the data of a file that its header says is size bytes long,
+// without reading more than that from r.
+func readArchiveFile(r io.Reader, size int64) ([]byte, error) {
+	if size > MaxArchiveFileBytes {
+		return nil, ErrArchiveFileTooLarge
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, size+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != size {
+		return nil, errArchiveFileSize
+	}
+	return data, nil
+}
+
+// tarSection is the location of a file's data in an uncompressed tar archive.
+type tarSection struct {
+	offset, size int64
+}
+
+// countingReader counts the bytes read from Reader.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	r.n += int64(n)
+	return
+}
+
+// addEntry adds an entry to its parent directory, creating any parent directories
+// that don't have their own entries in the archive.
+func (fs *ArchiveFileSystem) addEntry(name string, info os.FileInfo) {
+	if name == "" {
+		return
+	}
+
+	if info.IsDir() {
+		if _, found := fs.dirs[name]; found {
+			// Already added implicitly by a child.
+			return
+		}
+		fs.dirs[name] = nil
+	} else {
+		fs.files[name] = struct{}{}
+	}
+
+	parent := path.Dir(name)
+	if parent == "." {
+		parent = ""
+	}
+	if _, found := fs.dirs[parent]; !found {
+		fs.addEntry(parent, archiveDirInfo(path.Base(parent)))
+	}
+	fs.dirs[parent] = append(fs.dirs[parent], info)
+}
+
+func (fs *ArchiveFileSystem) ReadDirEntries(dirPath string) ([]os.FileInfo, error) {
+	entries, found := fs.dirs[cleanArchivePath(dirPath)]
+	if !found {
+		return nil, &os.PathError{Op: "readdir", Path: dirPath, Err: os.ErrNotExist}
+	}
+	return entries, nil
+}
+
+// Open reads the entire file into memory, since archive entries can't be seeked.
+func (fs *ArchiveFileSystem) Open(name string) (ReadSeekerCloser, error) {
+	cleanName := cleanArchivePath(name)
+	if !fs.files.Contains(cleanName) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	data, err := fs.readFile(cleanName)
+	if err != nil {
+		return nil, err
+	}
+	return nopCloser{bytes.NewReader(data)}, nil
+}
+
+func (fs *ArchiveFileSystem) Close() error {
+	if fs.closer != nil {
+		return fs.closer.Close()
+	}
+	return nil
+}
+
+// cleanArchivePath converts name to a slash-separated path relative to the archive root,
+// which is represented by the empty string.
+func cleanArchivePath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+}
+
+// archiveDirInfo describes a directory that only exists implicitly in an archive.
+type archiveDirInfo string
+
+func (d archiveDirInfo) Name() string       { return string(d) }
+func (d archiveDirInfo) Size() int64        { return 0 }
+func (d archiveDirInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (d archiveDirInfo) ModTime() time.Time { return time.Time{} }
+func (d archiveDirInfo) IsDir() bool        { return true }
+func (d archiveDirInfo) Sys() interface{}   { return nil }
+
+// NewArchiveMemepository creates a FileServingMemepository that loads images out of the archive
+// at archivePath. config.Path is interpreted relative to the root of the archive, and
+// config.FileSystem is ignored.
+func NewArchiveMemepository(archivePath string, config FileServingMemepositoryConfig) (*FileServingMemepository, error) {
+	fs, err := OpenArchiveFileSystem(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	config.FileSystem = fs
+	return NewFileServingMemepository(config), nil
+}
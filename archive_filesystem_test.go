@@ -0,0 +1,257 @@
+package memebot
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testArchiveModTime = time.Date(2015, 11, 1, 12, 0, 0, 0, time.UTC)
+
+var testArchiveFiles = []struct {
+	name string
+	data string
+}{
+	{"foo, bar.jpg", "foo data"},
+	{"pack/baz.GIF", "baz data"},
+	{"notes.txt", "not an image"},
+}
+
+func writeTestZip(t *testing.T, archivePath string) {
+	file, err := os.Create(archivePath)
+	require.NoError(t, err)
+	defer file.Close()
+
+	writer := zip.NewWriter(file)
+	for _, f := range testArchiveFiles {
+		header := &zip.FileHeader{Name: f.name, Method: zip.Deflate}
+		header.SetModTime(testArchiveModTime)
+		w, err := writer.CreateHeader(header)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(f.data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+}
+
+func writeTestTar(t *testing.T, archivePath string) {
+	file, err := os.Create(archivePath)
+	require.NoError(t, err)
+	defer file.Close()
+	writeTestTarEntries(t, file)
+}
+
+func writeTestTarGz(t *testing.T, archivePath string) {
+	file, err := os.Create(archivePath)
+	require.NoError(t, err)
+	defer file.Close()
+
+	gzipWriter := gzip.NewWriter(file)
+	writeTestTarEntries(t, gzipWriter)
+	require.NoError(t, gzipWriter.Close())
+}
+
+func writeTestTarEntries(t *testing.T, w io.Writer) {
+	writer := tar.NewWriter(w)
+	require.NoError(t, writer.WriteHeader(&tar.Header{
+		Name: "pack/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: testArchiveModTime,
+	}))
+	for _, f := range testArchiveFiles {
+		require.NoError(t, writer.WriteHeader(&tar.Header{
+			Name: f.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(f.data)), ModTime: testArchiveModTime,
+		}))
+		_, err := writer.Write([]byte(f.data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+}
+
+func TestArchiveFileSystem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memebot-archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for name, write := range map[string]func(*testing.T, string){
+		"memes.zip":    writeTestZip,
+		"memes.tar":    writeTestTar,
+		"memes.tar.gz": writeTestTarGz,
+	} {
+		archivePath := filepath.Join(dir, name)
+		write(t, archivePath)
+
+		fs, err := OpenArchiveFileSystem(archivePath)
+		require.NoError(t, err, name)
+
+		entries, err := fs.ReadDirEntries("")
+		require.NoError(t, err, name)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		assert.Len(t, names, 3, name)
+		assert.Contains(t, names, "foo, bar.jpg", name)
+		assert.Contains(t, names, "pack", name)
+
+		entries, err = fs.ReadDirEntries("pack")
+		require.NoError(t, err, name)
+		require.Len(t, entries, 1, name)
+		assert.Equal(t, "baz.GIF", entries[0].Name(), name)
+		assert.Equal(t, int64(len("baz data")), entries[0].Size(), name)
+		assert.True(t, testArchiveModTime.Equal(entries[0].ModTime()), name)
+
+		// Read in reverse, so files aren't only read in archive order.
+		for i := len(testArchiveFiles) - 1; i >= 0; i-- {
+			f := testArchiveFiles[i]
+			data, err := fs.Open(f.name)
+			require.NoError(t, err, name)
+			contents, err := ioutil.ReadAll(data)
+			require.NoError(t, err, name)
+			assert.Equal(t, f.data, string(contents), name)
+		}
+
+		_, err = fs.Open("missing.jpg")
+		assert.True(t, os.IsNotExist(err), name)
+		_, err = fs.ReadDirEntries("missing")
+		assert.True(t, os.IsNotExist(err), name)
+
+		assert.NoError(t, fs.Close(), name)
+	}
+}
+
+func TestArchiveFileSystemLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memebot-archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	large := make([]byte, MaxArchiveFileBytes+1)
+	for _, name := range []string{"large.zip", "large.tar", "large.tar.gz"} {
+		archivePath := filepath.Join(dir, name)
+		writeTestArchive(t, archivePath, map[string][]byte{"large.jpg": large, "small.jpg": []byte("small data")})
+
+		fs, err := OpenArchiveFileSystem(archivePath)
+		require.NoError(t, err, name)
+		_, err = fs.Open("large.jpg")
+		assert.Equal(t, ErrArchiveFileTooLarge, err, name)
+		_, err = fs.Open("small.jpg")
+		assert.NoError(t, err, name)
+		assert.NoError(t, fs.Close(), name)
+	}
+
+	// Compressed archives are kept in memory.
+	files := make(map[string][]byte)
+	for i := int64(0); i <= MaxCompressedTarBytes/MaxArchiveFileBytes; i++ {
+		files[fmt.Sprintf("%d.jpg", i)] = large[:MaxArchiveFileBytes]
+	}
+	archivePath := filepath.Join(dir, "huge.tar.gz")
+	writeTestArchive(t, archivePath, files)
+	_, err = OpenArchiveFileSystem(archivePath)
+	assert.Equal(t, ErrArchiveTooLarge, err)
+}
+
+// writeTestArchive writes files to an archive of the type given by the extension of
+// archivePath.
+func writeTestArchive(t *testing.T, archivePath string, files map[string][]byte) {
+	file, err := os.Create(archivePath)
+	require.NoError(t, err)
+	defer file.Close()
+
+	isZip, gzipped, err := archiveFormat(archivePath)
+	require.NoError(t, err)
+	if isZip {
+		writer := zip.NewWriter(file)
+		for name, data := range files {
+			w, err := writer.Create(name)
+			require.NoError(t, err)
+			_, err = w.Write(data)
+			require.NoError(t, err)
+		}
+		require.NoError(t, writer.Close())
+		return
+	}
+
+	var w io.Writer = file
+	if gzipped {
+		gzipWriter := gzip.NewWriter(file)
+		defer func() { require.NoError(t, gzipWriter.Close()) }()
+		w = gzipWriter
+	}
+	writer := tar.NewWriter(w)
+	for name, data := range files {
+		require.NoError(t, writer.WriteHeader(&tar.Header{
+			Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data)), ModTime: testArchiveModTime,
+		}))
+		_, err := writer.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+}
+
+func TestOpenArchiveFileSystemUnsupported(t *testing.T) {
+	_, err := OpenArchiveFileSystem("memes.rar")
+	assert.Equal(t, ErrUnsupportedArchive, err)
+	assert.True(t, IsArchive("memes.TGZ"))
+	assert.False(t, IsArchive("memes"))
+}
+
+func TestArchiveMemepositoryMatchesExtractedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memebot-archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	archivePath := filepath.Join(dir, "memes.zip")
+	writeTestZip(t, archivePath)
+
+	extractedPath := filepath.Join(dir, "extracted")
+	require.NoError(t, os.Mkdir(extractedPath, 0755))
+	for _, f := range testArchiveFiles {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(extractedPath, f.name)), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(extractedPath, f.name), []byte(f.data), 0644))
+	}
+
+	for _, subdir := range []string{"", "pack"} {
+		router := mux.NewRouter()
+		archived, err := NewArchiveMemepository(archivePath, FileServingMemepositoryConfig{
+			Path:            subdir,
+			ImageExtensions: MakeSet("jpg", "gif"),
+			Router:          router,
+		})
+		require.NoError(t, err)
+		extracted := NewFileServingMemepository(FileServingMemepositoryConfig{
+			Path:            filepath.Join(extractedPath, subdir),
+			ImageExtensions: MakeSet("jpg", "gif"),
+			Router:          mux.NewRouter(),
+		})
+
+		archivedMemes, err := archived.Load()
+		require.NoError(t, err)
+		extractedMemes, err := extracted.Load()
+		require.NoError(t, err)
+
+		require.Equal(t, 1, archivedMemes.Len())
+		assert.Equal(t, extractedMemes.Keywords(), archivedMemes.Keywords())
+		meme := archivedMemes.Memes()[0].(*FileMeme)
+		assert.Equal(t, extractedMemes.Memes()[0].(*FileMeme).id, meme.id)
+		assert.Equal(t, meme.URL().String(), extractedMemes.Memes()[0].URL().String())
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", meme.URL().String(), nil)
+		require.NoError(t, err)
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, testArchiveModTime.Format(http.TimeFormat), resp.Header().Get("Last-Modified"))
+		assert.Equal(t, meme.Size(), int64(resp.Body.Len()))
+	}
+}
@@ -0,0 +1,305 @@
+package memebot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+)
+
+// MaxGeneratorSourceBytes is the largest meme that will be downloaded to generate a new image from.
+const MaxGeneratorSourceBytes = 20 << 20
+
+const (
+	captionGlyphWidth  = 5
+	captionGlyphHeight = 7
+
+	// Cells include space between glyphs and lines.
+	captionCellWidth  = captionGlyphWidth + 1
+	captionCellHeight = captionGlyphHeight + 2
+)
+
+var ErrSourceTooLarge = errors.New("source image too large")
+
+// Captioner draws captions on memes.
+type Captioner interface {
+	// Memes that aren't served by this bot are downloaded under ctx.
+	Caption(ctx context.Context, meme Meme, caption Caption) (Meme, error)
+}
+
+type ImageCaptionerConfig struct {
+	// Where captioned images are stored and served from.
+	Store *GeneratedObjectStore
+
+	// Used to download memes that aren't served by this bot. Defaults to DefaultHTTPClient.
+	Client *http.Client
+
+	// Memes whose type isn't Captionable aren't captioned. Defaults to DefaultMediaTypes.
+	MediaTypes MediaTypes
+}
+
+// ImageCaptioner draws classic meme captions: outlined, all-caps text at the top and
+// bottom of the image, sized to fit. Animated GIFs are captioned using their first frame.
+type ImageCaptioner struct {
+	ImageCaptionerConfig
+}
+
+var _ Captioner = &ImageCaptioner{}
+
+func NewImageCaptioner(config ImageCaptionerConfig) (*ImageCaptioner, error) {
+	if config.Store == nil {
+		return nil, errors.New("Store must be specified")
+	}
+	if config.Client == nil {
+		config.Client = DefaultHTTPClient
+	}
+	return &ImageCaptioner{config}, nil
+}
+
+func (c *ImageCaptioner) Caption(ctx context.Context, meme Meme, caption Caption) (Meme, error) {
+	img, format, err := decodeMemeImage(ctx, meme, c.Client, c.MediaTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := "png"
+	if format == "jpeg" {
+		ext = "jpg"
+	} else {
+		format = "png"
+	}
+
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, drawCaption(img, caption), format); err != nil {
+		return nil, err
+	}
+
+	id, err := c.Store.Add(buf.Bytes(), ext)
+	if err != nil {
+		return nil, err
+	}
+	return &GeneratedMeme{id, c.Store, meme.Keywords()}, nil
+}
+
+// decodeMemeImage reads and decodes a meme's image. Only the first frame of animated
+// GIFs is decoded.
+// Returns ErrMediaTypeNotSupported if the meme's type isn't Captionable.
+func decodeMemeImage(ctx context.Context, meme Meme, client *http.Client, mediaTypes MediaTypes) (image.Image, string, error) {
+	if !mediaTypes.captionable(memeExtension(meme)) {
+		return nil, "", ErrMediaTypeNotSupported
+	}
+
+	data, err := readMemeData(ctx, meme, client)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if _, _, err := decodeImageConfig(data); err != nil {
+		return nil, "", err
+	}
+	return image.Decode(bytes.NewReader(data))
+}
+
+// readMemeData reads a meme's image, either directly if it's served by this bot, or by
+// downloading it under ctx, so downloads don't outlive the reply they're for.
+func readMemeData(ctx context.Context, meme Meme, client *http.Client) ([]byte, error) {
+	if object, ok := memeObject(meme); ok {
+		if object.Size() > MaxGeneratorSourceBytes {
+			return nil, ErrSourceTooLarge
+		}
+
+		file, err := object.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return ioutil.ReadAll(file)
+	}
+
+	req, err := http.NewRequest("GET", meme.URL().String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error downloading %s: %s", meme.URL(), resp.Status)
+	}
+
+	// Read one extra byte to detect memes that are too large.
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(io.LimitReader(resp.Body, MaxGeneratorSourceBytes+1)); err != nil {
+		return nil, err
+	}
+	if buf.Len() > MaxGeneratorSourceBytes {
+		return nil, ErrSourceTooLarge
+	}
+	return buf.Bytes(), nil
+}
+
+// memeWrapper is implemented by memes that wrap other memes to override some methods.
+type memeWrapper interface {
+	unwrap() Meme
+}
+
+// memeObject returns the Object that serves meme, if it's served by this bot.
+func memeObject(meme Meme) (Object, bool) {
+	for {
+		if object, ok := meme.(Object); ok {
+			return object, true
+		}
+		wrapper, ok := meme.(memeWrapper)
+		if !ok {
+			return nil, false
+		}
+		meme = wrapper.unwrap()
+	}
+}
+
+// drawCaption returns a copy of img with the caption drawn on it.
+func drawCaption(img image.Image, caption Caption) *image.RGBA {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+
+	drawCaptionText(dst, caption.Top, true)
+	drawCaptionText(dst, caption.Bottom, false)
+	return dst
+}
+
+// drawCaptionText draws text centered at the top or bottom of dst, as large as will fit
+// in a third of the image.
+func drawCaptionText(dst *image.RGBA, text string, top bool) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+
+	width, height := dst.Bounds().Dx(), dst.Bounds().Dy()
+	margin := height / 40
+	maxScale := maxInt(1, height/(6*captionCellHeight))
+	lines, scale := layoutCaption(text, width-2*margin, height/3-margin, maxScale)
+
+	lineHeight := captionCellHeight * scale
+	textHeight := len(lines)*lineHeight - (captionCellHeight-captionGlyphHeight)*scale
+	y := margin
+	if !top {
+		y = height - margin - textHeight
+	}
+
+	outline := maxInt(1, scale/2)
+	black := &image.Uniform{color.Black}
+	white := &image.Uniform{color.White}
+
+	// Draw the outline for all lines first so it doesn't overlap neighbouring lines' text.
+	for _, fill := range []struct {
+		src   image.Image
+		inset int
+	}{{black, -outline}, {white, 0}} {
+		for i, line := range lines {
+			x := (width - captionLineWidth(line, scale)) / 2
+			drawCaptionLine(dst, line, image.Pt(x, y+i*lineHeight), scale, fill.inset, fill.src)
+		}
+	}
+}
+
+func drawCaptionLine(dst *image.RGBA, line string, origin image.Point, scale, inset int, src image.Image) {
+	for _, r := range line {
+		glyph := captionGlyph(r)
+		for gy, row := range glyph {
+			for gx, pixel := range row {
+				if pixel != '#' {
+					continue
+				}
+				rect := image.Rect(0, 0, scale, scale).
+					Add(origin).
+					Add(image.Pt(gx*scale, gy*scale)).
+					Inset(inset)
+				draw.Draw(dst, rect.Intersect(dst.Bounds()), src, image.ZP, draw.Src)
+			}
+		}
+		origin.X += captionCellWidth * scale
+	}
+}
+
+// layoutCaption wraps text at the largest scale (up to maxScale) at which it fits in
+// maxWidth×maxHeight pixels. If it won't fit even at the smallest scale, the text will
+// be clipped.
+func layoutCaption(text string, maxWidth, maxHeight, maxScale int) (lines []string, scale int) {
+	words := strings.Fields(strings.ToUpper(text))
+
+	for scale = maxScale; scale > 1; scale-- {
+		lines = wrapWords(words, (maxWidth+scale)/(captionCellWidth*scale))
+		if len(lines) > 0 && len(lines)*captionCellHeight*scale-scale*2 <= maxHeight {
+			return
+		}
+	}
+	return wrapWords(words, maxInt(1, (maxWidth+1)/captionCellWidth)), 1
+}
+
+// wrapWords greedily fills lines of at most maxChars characters. Words that are longer
+// than a line are split. Returns nil if maxChars < 1.
+func wrapWords(words []string, maxChars int) (lines []string) {
+	if maxChars < 1 {
+		return nil
+	}
+
+	var line string
+	for _, word := range words {
+		for utf8.RuneCountInString(word) > maxChars {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			runes := []rune(word)
+			lines = append(lines, string(runes[:maxChars]))
+			word = string(runes[maxChars:])
+		}
+
+		switch {
+		case line == "":
+			line = word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= maxChars:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return
+}
+
+func captionLineWidth(line string, scale int) int {
+	return (utf8.RuneCountInString(line)*captionCellWidth - 1) * scale
+}
+
+// captionGlyph returns the glyph for r, substituting similar characters for ones
+// missing from captionFont.
+func captionGlyph(r rune) [captionGlyphHeight]string {
+	switch r {
+	case '‘', '’':
+		r = '\''
+	case '“', '”':
+		r = '"'
+	}
+	if glyph, found := captionFont[r]; found {
+		return glyph
+	}
+	return captionFont['?']
+}
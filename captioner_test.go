@@ -0,0 +1,167 @@
+package memebot
+
+import (
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestCaptionFont(t *testing.T) {
+	for _, r := range "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 !?.,'\"-:" {
+		glyph, found := captionFont[r]
+		require.True(t, found, "%q", r)
+		for _, row := range glyph {
+			assert.Len(t, row, captionGlyphWidth, "%q", r)
+		}
+	}
+	assert.Equal(t, captionFont['\''], captionGlyph('’'))
+	assert.Equal(t, captionFont['?'], captionGlyph('☃'))
+}
+
+func TestWrapWords(t *testing.T) {
+	words := []string{"I", "HAD", "FUN", "ONCE"}
+	assert.Equal(t, []string{"I HAD FUN ONCE"}, wrapWords(words, 20))
+	assert.Equal(t, []string{"I HAD", "FUN", "ONCE"}, wrapWords(words, 5))
+	assert.Equal(t, []string{"I", "HA", "D", "FU", "N", "ON", "CE"}, wrapWords(words, 2))
+	assert.Nil(t, wrapWords(words, 0))
+}
+
+func TestLayoutCaption(t *testing.T) {
+	lines, scale := layoutCaption("hi", 1000, 1000, 4)
+	assert.Equal(t, []string{"HI"}, lines)
+	assert.Equal(t, 4, scale)
+
+	// Too wide for one line at the maximum scale.
+	lines, scale = layoutCaption("i had fun once", 200, 100, 4)
+	assert.Equal(t, 4, scale)
+	assert.Equal(t, []string{"I HAD", "FUN ONCE"}, lines)
+	for _, line := range lines {
+		assert.True(t, captionLineWidth(line, scale) <= 200)
+	}
+
+	// Too tall for two lines, so shrinks to fit on one.
+	lines, scale = layoutCaption("i had fun once", 200, 40, 4)
+	assert.Equal(t, 2, scale)
+	assert.Equal(t, []string{"I HAD FUN ONCE"}, lines)
+}
+
+func TestDrawCaption(t *testing.T) {
+	gray := color.RGBA{128, 128, 128, 255}
+	img := image.NewRGBA(image.Rect(0, 0, 200, 120))
+	for i := range img.Pix {
+		img.Pix[i] = 128
+	}
+
+	captioned := drawCaption(img, Caption{Top: "top"})
+	assert.Equal(t, img.Bounds(), captioned.Bounds())
+	assert.Equal(t, uint8(128), img.Pix[0], "source image should not be modified")
+
+	countColors := func(rect image.Rectangle) (white, black, other int) {
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				switch captioned.RGBAAt(x, y) {
+				case color.RGBA{255, 255, 255, 255}:
+					white++
+				case color.RGBA{0, 0, 0, 255}:
+					black++
+				case gray:
+					other++
+				}
+			}
+		}
+		return
+	}
+
+	white, black, _ := countColors(image.Rect(0, 0, 200, 40))
+	assert.True(t, white > 0)
+	assert.True(t, black > 0)
+
+	white, black, _ = countColors(image.Rect(0, 40, 200, 120))
+	assert.Equal(t, 0, white)
+	assert.Equal(t, 0, black)
+}
+
+func newTestCaptioner(t *testing.T) (*ImageCaptioner, *mux.Router) {
+	router := mux.NewRouter()
+	captioner, err := NewImageCaptioner(ImageCaptionerConfig{
+		Store: NewGeneratedObjectStore(GeneratedObjectStoreConfig{Router: router}),
+	})
+	require.NoError(t, err)
+	return captioner, router
+}
+
+func TestImageCaptioner(t *testing.T) {
+	memepository, _, dir := newTestFileServingMemepository(t,
+		map[string]string{"grumpy.jpg": string(encodeTestImage(t, "jpeg", 120, 90))}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "grumpy")
+	captioner, router := newTestCaptioner(t)
+
+	captioned, err := captioner.Caption(context.Background(), &sourcedMeme{meme, "local"}, Caption{"I had fun once", "it was awful"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"grumpy"}, captioned.Keywords())
+	assert.Contains(t, captioned.URL().Path, ".jpg")
+
+	resp := serveTestRequest(t, router, captioned.URL().String(), nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "image/jpeg", resp.Header().Get("Content-Type"))
+	config, format := decodeTestImageConfig(t, resp.Body.Bytes())
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, 120, config.Width)
+	assert.Equal(t, 90, config.Height)
+}
+
+func TestImageCaptionerDownloadsRemoteMemes(t *testing.T) {
+	gifData := encodeTestGIF(t, 40, 30, 3)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(gifData)
+	}))
+	defer server.Close()
+	captioner, router := newTestCaptioner(t)
+
+	captioned, err := captioner.Caption(context.Background(), NewMockMeme(server.URL+"/party.gif", "party"), Caption{Bottom: "party"})
+	require.NoError(t, err)
+
+	// GIFs are captioned as PNGs.
+	resp := serveTestRequest(t, router, captioned.URL().String(), nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	config, format := decodeTestImageConfig(t, resp.Body.Bytes())
+	assert.Equal(t, "png", format)
+	assert.Equal(t, 40, config.Width)
+}
+
+func TestImageCaptionerDownloadTimesOut(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-unblock
+	}))
+	defer server.Close()
+	defer close(unblock)
+	captioner, _ := newTestCaptioner(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := captioner.Caption(ctx, NewMockMeme(server.URL+"/party.gif", "party"), Caption{Bottom: "party"})
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestImageCaptionerInvalidImage(t *testing.T) {
+	memepository, _, dir := newTestFileServingMemepository(t,
+		map[string]string{"grumpy.jpg": "not an image"}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+	captioner, _ := newTestCaptioner(t)
+
+	_, err := captioner.Caption(context.Background(), findTestFileMeme(t, memepository, "grumpy"), Caption{Top: "top"})
+	assert.Error(t, err)
+}
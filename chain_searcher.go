@@ -0,0 +1,163 @@
+package memebot
+
+// SearchOptions customize a single search, e.g. with per-channel settings.
+type SearchOptions struct {
+	// If true, searchers marked as external are skipped.
+	DisableExternal bool
+
+	// If false, memes flagged as NSFW aren't found.
+	AllowNSFW bool
+}
+
+// OptionsSearcher is implemented by MemeSearchers that support SearchOptions.
+type OptionsSearcher interface {
+	MemeSearcher
+	FindMemeWithOptions(keyword string, options SearchOptions) (Meme, error)
+}
+
+// MultiSearcher is implemented by MemeSearchers that can find multiple distinct memes at once.
+type MultiSearcher interface {
+	MemeSearcher
+
+	// Returns up to count distinct memes, or ErrNoMemeFound if none could be found.
+	FindMemes(keyword string, count int, options SearchOptions) ([]Meme, error)
+}
+
+// SourcedMeme is implemented by memes that know the name of the searcher that found them.
+type SourcedMeme interface {
+	Meme
+	Source() string
+}
+
+type ChainedSearcher struct {
+	// Used to tell users which source found a meme. If empty, the source isn't mentioned.
+	Name string
+	MemeSearcher
+
+	// External searchers query services outside the bot, and can be disabled per channel.
+	External bool
+}
+
+// ChainSearcher is a MemeSearcher that tries each of its searchers in order, and returns
+// the first meme found.
+type ChainSearcher struct {
+	Searchers []ChainedSearcher
+
+	Log *Logger // Search errors are written here. Defaults to DefaultLogger.
+}
+
+var (
+	_ OptionsSearcher = &ChainSearcher{}
+	_ MultiSearcher   = &ChainSearcher{}
+)
+
+func (s *ChainSearcher) FindMeme(keyword string) (Meme, error) {
+	return s.FindMemeWithOptions(keyword, SearchOptions{})
+}
+
+// FindMemeWithOptions returns ErrNoMemeFound if no searcher found a meme. If a searcher
+// returns any other error, it's logged and the next searcher is tried. If no meme is found,
+// the first such error is returned.
+func (s *ChainSearcher) FindMemeWithOptions(keyword string, options SearchOptions) (Meme, error) {
+	var firstErr error
+
+	for _, searcher := range s.Searchers {
+		if searcher.External && options.DisableExternal {
+			continue
+		}
+
+		meme, err := findMemeWithOptions(searcher.MemeSearcher, keyword, options)
+		if err == nil {
+			if searcher.Name != "" {
+				meme = &sourcedMeme{meme, searcher.Name}
+			}
+			return meme, nil
+		}
+
+		if err != ErrNoMemeFound {
+			s.Log.Error("error searching", "searcher", searcher.Name, "err", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, ErrNoMemeFound
+}
+
+// FindMemes returns the memes found by the first searcher that finds any. Errors are
+// handled like FindMemeWithOptions.
+func (s *ChainSearcher) FindMemes(keyword string, count int, options SearchOptions) ([]Meme, error) {
+	var firstErr error
+
+	for _, searcher := range s.Searchers {
+		if searcher.External && options.DisableExternal {
+			continue
+		}
+
+		memes, err := findMemes(searcher.MemeSearcher, keyword, count, options)
+		if err == nil {
+			if searcher.Name != "" {
+				for i, meme := range memes {
+					memes[i] = &sourcedMeme{meme, searcher.Name}
+				}
+			}
+			return memes, nil
+		}
+
+		if err != ErrNoMemeFound {
+			s.Log.Error("error searching", "searcher", searcher.Name, "err", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, ErrNoMemeFound
+}
+
+// findMemes finds up to count memes, or a single meme if searcher isn't a MultiSearcher.
+func findMemes(searcher MemeSearcher, keyword string, count int, options SearchOptions) ([]Meme, error) {
+	if multiSearcher, ok := searcher.(MultiSearcher); ok {
+		return multiSearcher.FindMemes(keyword, count, options)
+	}
+
+	meme, err := findMemeWithOptions(searcher, keyword, options)
+	if err != nil {
+		return nil, err
+	}
+	return []Meme{meme}, nil
+}
+
+func findMemeWithOptions(searcher MemeSearcher, keyword string, options SearchOptions) (Meme, error) {
+	if optionsSearcher, ok := searcher.(OptionsSearcher); ok {
+		return optionsSearcher.FindMemeWithOptions(keyword, options)
+	}
+	return searcher.FindMeme(keyword)
+}
+
+type sourcedMeme struct {
+	Meme
+	source string
+}
+
+func (m *sourcedMeme) Source() string {
+	return m.source
+}
+
+func (m *sourcedMeme) unwrap() Meme {
+	return m.Meme
+}
+
+func memeSource(meme Meme) string {
+	if sourced, ok := meme.(SourcedMeme); ok {
+		return sourced.Source()
+	}
+	return ""
+}
@@ -0,0 +1,102 @@
+package memebot
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainSearcherTriesSearchersInOrder(t *testing.T) {
+	first := new(MockSearcher)
+	first.On("FindMeme", "foo").Return(nil, ErrNoMemeFound)
+	second := new(MockSearcher)
+	second.On("FindMeme", "foo").Return(NewMockMeme("http://foo.com"), nil)
+	third := new(MockSearcher)
+
+	searcher := &ChainSearcher{Searchers: []ChainedSearcher{
+		{Name: "first", MemeSearcher: first},
+		{Name: "second", MemeSearcher: second},
+		{Name: "third", MemeSearcher: third},
+	}}
+
+	meme, err := searcher.FindMeme("foo")
+	require.NoError(t, err)
+	assert.Equal(t, "foo.com", meme.URL().Host)
+	assert.Equal(t, "second", memeSource(meme))
+	first.AssertExpectations(t)
+	third.AssertNotCalled(t, "FindMeme", "foo")
+}
+
+func TestChainSearcherUnnamedSource(t *testing.T) {
+	local := new(MockSearcher)
+	local.On("FindMeme", "foo").Return(NewMockMeme("http://foo.com"), nil)
+
+	searcher := &ChainSearcher{Searchers: []ChainedSearcher{{MemeSearcher: local}}}
+
+	meme, err := searcher.FindMeme("foo")
+	require.NoError(t, err)
+	assert.Equal(t, "", memeSource(meme))
+}
+
+func TestChainSearcherSkipsErrors(t *testing.T) {
+	searchErr := errors.New("service unavailable")
+	broken := new(MockSearcher)
+	broken.On("FindMeme", "foo").Return(nil, searchErr)
+	empty := new(MockSearcher)
+	empty.On("FindMeme", "foo").Return(nil, ErrNoMemeFound)
+
+	searcher := &ChainSearcher{Searchers: []ChainedSearcher{
+		{Name: "broken", MemeSearcher: broken},
+		{Name: "empty", MemeSearcher: empty},
+	}}
+
+	_, err := searcher.FindMeme("foo")
+	assert.Equal(t, searchErr, err)
+	empty.AssertExpectations(t)
+}
+
+func TestChainSearcherNoMemeFound(t *testing.T) {
+	_, err := (&ChainSearcher{}).FindMeme("foo")
+	assert.Equal(t, ErrNoMemeFound, err)
+}
+
+func TestChainSearcherDisableExternal(t *testing.T) {
+	external := new(MockSearcher)
+	searcher := &ChainSearcher{Searchers: []ChainedSearcher{
+		{Name: "external", MemeSearcher: external, External: true},
+	}}
+
+	_, err := searcher.FindMemeWithOptions("foo", SearchOptions{DisableExternal: true})
+	assert.Equal(t, ErrNoMemeFound, err)
+	external.AssertNotCalled(t, "FindMeme", "foo")
+}
+
+func TestChainSearcherFindMemes(t *testing.T) {
+	empty := &MemepositorySearcher{&MockMemepository{NewMemeIndex()}}
+	local := &MemepositorySearcher{&MockMemepository{NewTestMemeIndex(
+		NewMockMeme("http://1.com", "cat"),
+		NewMockMeme("http://2.com", "cat"),
+	)}}
+	external := new(MockSearcher)
+
+	searcher := &ChainSearcher{Searchers: []ChainedSearcher{
+		{MemeSearcher: empty},
+		{Name: "local", MemeSearcher: local},
+		{Name: "external", MemeSearcher: external},
+	}}
+
+	memes, err := searcher.FindMemes("cat", 4, SearchOptions{})
+	require.NoError(t, err)
+	assert.Len(t, memes, 2)
+	assert.Equal(t, "local", memeSource(memes[0]))
+	external.AssertNotCalled(t, "FindMeme", "cat")
+
+	// Searchers that can only find one meme at a time.
+	external.On("FindMeme", "dog").Return(NewMockMeme("http://dog.com"), nil)
+	memes, err = searcher.FindMemes("dog", 4, SearchOptions{})
+	require.NoError(t, err)
+	require.Len(t, memes, 1)
+	assert.Equal(t, "external", memeSource(memes[0]))
+}
@@ -3,13 +3,17 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
+	"io"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"golang.org/x/net/context"
@@ -22,14 +26,99 @@ const (
 
 	SlackTokenVar = "SLACK_TOKEN"
 
+	// Token required to use the admin API.
+	AdminTokenVar = "ADMIN_TOKEN"
+
+	// Comma-separated list of id:secret pairs used to sign image URLs.
+	URLSigningKeysVar = "URL_SIGNING_KEYS"
+
+	// Token required to load the exported index, and sent when loading -remote indexes.
+	FederationTokenVar = "FEDERATION_TOKEN"
+
+	AwsAccessKeyIdVar     = "AWS_ACCESS_KEY_ID"
+	AwsSecretAccessKeyVar = "AWS_SECRET_ACCESS_KEY"
+
+	DefaultS3Endpoint = "https://s3.amazonaws.com"
+
+	DefaultRemoteRefreshInterval = 5 * time.Minute
+	DefaultS3RefreshInterval     = 5 * time.Minute
+
+	// Path prefix of images generated by the bot, e.g. captioned memes.
+	GeneratedObjectsPath = "/generated/"
+
+	// Path of the meme index exported for other memebot instances to load with -remote.
+	IndexExportPath = "/index.json"
+
+	// Path prefix of the HTML gallery of all memes.
+	GalleryPath = "/gallery"
+
+	// Path prefix of the read-only JSON API.
+	APIPath = "/api"
+
+	// Path prefixes of the admin UI and API, only served if AdminTokenVar is set.
+	AdminPath    = "/admin"
+	AdminAPIPath = "/admin/api"
+
+	// Path of metrics in the Prometheus text format.
+	MetricsPath = "/metrics"
+
 	DefaultKeywordPattern = `(\w+)$`
-)
 
-var ImageExtensions = []string{"jpg", "png", "gif"}
+	DefaultMediaTypeList = "jpg,png,gif"
+)
 
 var (
-	ImagesDir = flag.String("images", "",
-		"path of `directory` containing images named like keyword1[,keyword2,...].")
+	ImagesDirs StringListFlag
+
+	RemoteIndexURLs StringListFlag
+
+	S3Bucket = flag.String("s3-bucket", "",
+		"`bucket` to load images from. Credentials are read from "+AwsAccessKeyIdVar+" and "+AwsSecretAccessKeyVar+".")
+
+	S3Prefix = flag.String("s3-prefix", "",
+		"only load images whose keys start with `prefix` from the S3 bucket.")
+
+	S3Endpoint = flag.String("s3-endpoint", DefaultS3Endpoint,
+		"base `url` of the S3-compatible service.")
+
+	S3Region = flag.String("s3-region", DefaultS3Region,
+		"`region` of the S3 bucket, used for signing requests.")
+
+	S3PresignExpiry = flag.Duration("s3-presign-expiry", 0,
+		"if set, links point directly to the bucket with presigned URLs valid for this `duration` instead of being served by memebot.")
+
+	S3RefreshInterval = flag.Duration("s3-refresh", DefaultS3RefreshInterval,
+		"how often to check the S3 bucket for changes. 0 to only load it at startup.")
+
+	RemoteRefreshInterval = flag.Duration("remote-refresh", DefaultRemoteRefreshInterval,
+		"how often to check -remote indexes for changes. 0 to only load them at startup.")
+
+	PreferS3 = flag.Bool("prefer-s3", false,
+		"if true, the S3 bucket takes precedence over image directories when they contain the same image.")
+
+	MergeDuplicateKeywordsMode = flag.Bool("merge-duplicate-keywords", false,
+		"if true, identical images found in multiple sources get the keywords from all of them. Otherwise only the keywords from the source with the highest precedence are used.")
+
+	MergeSimilarKeywordsMode = flag.Bool("merge-similar-keywords", false,
+		"if true, images that look the same (e.g. re-encoded or resized copies) are listed once, with the keywords of all of them.")
+
+	SimilarityThreshold = flag.Int("similarity-threshold", DefaultSimilarityThreshold,
+		"number of `bits` by which the perceptual hashes of two images can differ for them to be considered the same image.")
+
+	ValidateImages = flag.Bool("validate-images", true,
+		"if true, images whose content doesn't match their extension, or that are truncated or corrupt, are skipped. Otherwise they're loaded, and only logged.")
+
+	StripMetadata = flag.Bool("strip-metadata", false,
+		"if true, JPEG and PNG images are served without metadata such as EXIF data, which can contain GPS coordinates.")
+
+	MediaTypeList = flag.String("media-types", DefaultMediaTypeList,
+		"comma-separated `extensions` of files to load as memes. Known types are "+strings.Join(DefaultMediaTypes.SortedExtensions(), ", ")+".")
+
+	NoResizeTypes = flag.String("no-resize-types", "",
+		"comma-separated `extensions` of media types that shouldn't be resized or transformed.")
+
+	NoCaptionTypes = flag.String("no-caption-types", "",
+		"comma-separated `extensions` of media types that shouldn't be captioned or included in collages.")
 
 	KeywordPattern = flag.String("keyword-pattern", DefaultKeywordPattern,
 		"case-insensitive `regex` with capture groups used to extract keywords from messages.")
@@ -43,6 +132,54 @@ var (
 	ImageServerDisplayPort = flag.Int("serve-display-port", 0,
 		"`port` use in image links. Maybe be different from -serve-port if your load balancer forwards 80 to 5000, e.g. Defaults to serve-port.")
 
+	ExternalSearchURL = flag.String("external-search-url", "",
+		"`url` of a JSON GIF-search API to use when no meme is found, with "+KeywordPlaceholder+" in place of the search term.")
+
+	ExternalSearchResultPath = flag.String("external-search-result-path", "",
+		"dot-separated `path` to the image URL in -external-search-url responses, e.g. data.0.images.original.url.")
+
+	ExternalSearchName = flag.String("external-search-name", "the internet",
+		"`name` of the external search service, shown in replies.")
+
+	NoExternalSearchChannels = flag.String("no-external-search-channels", "",
+		"comma-separated `list` of channel names that should only get memes from the library.")
+
+	NSFWChannels = flag.String("nsfw-channels", "",
+		"comma-separated `list` of channel names that may get memes flagged as NSFW.")
+
+	CacheMaxAge = flag.Duration("cache-max-age", DefaultCacheMaxAge,
+		"how long browsers and proxies may cache images.")
+
+	SignedURLExpiry = flag.Duration("signed-url-expiry", DefaultSignedURLExpiry,
+		"how long signed image links are valid for. Only used if "+URLSigningKeysVar+" is set.")
+
+	UnsignedUserAgents = flag.String("unsigned-user-agents", "",
+		"comma-separated `list` of User-Agent substrings that may fetch images without a signature, e.g. Slackbot-LinkExpanding. Weakens signing, since User-Agents can be spoofed; unsigned requests can't transform images.")
+
+	PublicGallery = flag.Bool("public-gallery", false,
+		"if true, the gallery and API are served even if "+URLSigningKeysVar+" is set, so anyone who can open them gets signed links to every meme.")
+
+	DefaultImageWidth = flag.Int("default-width", 0,
+		"if set, images posted by the bot are downscaled to this `width` in pixels.")
+
+	ResizeCacheSize = flag.Int64("resize-cache-mb", DefaultObjectCacheBytes>>20,
+		"maximum `megabytes` of resized images to keep in memory.")
+
+	Captions = flag.Bool("captions", true,
+		"if true, messages like \"@memebot keyword: top text | bottom text\" are replied to with captioned memes.")
+
+	Collages = flag.Bool("collages", true,
+		"if true, messages like \"@memebot 4 cats\" are replied to with a grid of up to that many memes.")
+
+	CollageCellSize = flag.Int("collage-cell-size", DefaultCollageCellSize,
+		"`size` in pixels of each meme in collages.")
+
+	Modifiers = flag.Bool("modifiers", true,
+		"if true, messages like \"@memebot party parrot reversed\" are replied to with modified GIFs.")
+
+	GeneratedCacheSize = flag.Int64("generated-cache-mb", DefaultObjectCacheBytes>>20,
+		"maximum `megabytes` of generated images, e.g. captioned memes, to keep in memory.")
+
 	OnlyReplyToMentions = flag.Bool("require-mention", true,
 		"if true, messages that don't mention bot will be ignored. If you set this, make sure to specify keyword-pattern!")
 
@@ -52,35 +189,107 @@ var (
 	ListMemesMode = flag.Bool("list-memes", false,
 		"lists all memes' URLs")
 
+	FindDuplicatesMode = flag.Bool("find-duplicates", false,
+		"lists groups of images that look the same, with their keywords")
+
+	StripMetadataMode = flag.Bool("strip-files", false,
+		"if true, lists images in -images directories that contain metadata such as EXIF data, then exits. Pass -write to rewrite them without it.")
+
+	WriteStrippedFiles = flag.Bool("write", false,
+		"if true, -strip-files rewrites images in place. Rewritten images get new IDs, so links already posted to them, and their usage stats, stop working.")
+
+	MaxUploadSize = flag.Int64("max-upload-mb", DefaultMaxUploadBytes>>20,
+		"maximum `megabytes` of images uploaded through the admin API.")
+
+	AuditLogPath = flag.String("audit-log", "",
+		"`file` to append a line to for each change made through the admin API, instead of the main log.")
+
+	StatsFilePath = flag.String("stats-file", "",
+		"`file` to append a line to for each meme requested, used for usage stats. If not set, stats are only kept in memory.")
+
+	ShowStatsWindow = flag.Duration("show-stats", 0,
+		"if set, prints usage stats from -stats-file for this `duration` back, e.g. 168h, then exits.")
+
+	WishlistPath = flag.String("wishlist-file", "",
+		"`file` to save keywords that users wished for memes for. If not set, wishes are only kept in memory.")
+
+	NotifyWishesByDM = flag.Bool("wishlist-dm", false,
+		"if true, users are told about memes added for their wishes in direct messages, instead of in the channel they wished from.")
+
 	ServeOnlyMode = flag.Bool("serve-only", false,
 		"runs the image server without the bot for debugging.")
+
+	LogLevelName = flag.String("log-level", LevelInfo.String(),
+		"only log messages at this `level` or above: debug, info, warn or error.")
+
+	RedactLogs = flag.Bool("redact-logs", false,
+		"if true, text written by Slack users, e.g. keywords, is never logged or written to -events-file and -events-webhook.")
+
+	EventsFilePath = flag.String("events-file", "",
+		"`file` to append a JSON line to for each bot event, e.g. memes posted, misses and reloads.")
+
+	EventsWebhookURL = flag.String("events-webhook", "",
+		"`url` to POST each bot event to as JSON. Failed requests are retried.")
+
+	// Reported by the bot, object servers and memepository, and served on MetricsPath.
+	metrics = NewMetrics()
+
+	// Configured from -log-level and -redact-logs, and passed to everything that logs.
+	logger *Logger
+
+	// Published to by the bot and memepository, with sinks from -events-file and -events-webhook.
+	events = NewEventBus()
+
+	// Closes the sinks subscribed to events. Called by main and by logger.Fatal before exiting.
+	closeEventSinks = func() {}
 )
 
 func init() {
+	flag.Var(&ImagesDirs, "images",
+		"path of `directory` or .zip/.tar/.tar.gz archive containing images named like keyword1[,keyword2,...]. May be repeated, earlier directories take precedence.")
+
+	flag.Var(&RemoteIndexURLs, "remote",
+		"`url` of another memebot's "+IndexExportPath+" to load memes from. May be repeated. Remote memes have the lowest precedence.")
+
 	flag.Usage = func() {
 		name := filepath.Base(os.Args[0])
 		fmt.Fprintln(os.Stderr, "Usage:")
-		fmt.Fprintln(os.Stderr, name, "-images path [options...]")
+		fmt.Fprintln(os.Stderr, name, "-images path [-images path...] [-s3-bucket bucket] [-remote url...] [options...]")
 		fmt.Fprintln(os.Stderr, name, "-list-keywords")
 		fmt.Fprintln(os.Stderr, name, "-list-memes")
+		fmt.Fprintln(os.Stderr, name, "-find-duplicates")
+		fmt.Fprintln(os.Stderr, name, "-stats-file file -show-stats duration")
 		fmt.Fprintln(os.Stderr)
 		flag.PrintDefaults()
-		fmt.Fprintln(os.Stderr, "The images directory is required, everything else is optional.")
+		fmt.Fprintln(os.Stderr, "At least one images directory, S3 bucket or remote is required, everything else is optional.")
 	}
 }
 
 func main() {
 	flag.Parse()
+	logger = createLogger()
+	closeEventSinks = subscribeEventSinks()
+	defer closeEventSinks()
+
+	if *ShowStatsWindow > 0 {
+		showStats(*ShowStatsWindow)
+		os.Exit(0)
+	}
 
-	if *ImagesDir == "" {
+	if len(ImagesDirs) == 0 && *S3Bucket == "" && len(RemoteIndexURLs) == 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	if *StripMetadataMode {
+		stripMetadataFromFiles(ImagesDirs, *WriteStrippedFiles)
+		os.Exit(0)
+	}
+
 	if *ImageServerHostname == "" {
 		host, err := os.Hostname()
 		if err != nil {
-			log.Fatal("error getting hostname:", err)
+			logger.Fatal("error getting hostname", "err", err)
 		}
 		*ImageServerHostname = host
 	}
@@ -90,16 +299,48 @@ func main() {
 	}
 
 	router := initRouter(*ImageServerHostname, *ImageServerDisplayPort)
+	router.Handle(MetricsPath, metrics)
 	rootRoute := router.PathPrefix("/memes/")
-	memepository := NewFileServingMemepository(FileServingMemepositoryConfig{
-		Path:            *ImagesDir,
-		ImageExtensions: MakeSet(ImageExtensions...),
-		Router:          rootRoute.Subrouter(),
+	memepository, localMemepository, writableMemepository := createMemepository(rootRoute)
+	health := CreateHealthCheck(router, HealthConfig{
+		Memepository: memepository,
+		RequireBot:   !*ServeOnlyMode,
+	})
+
+	// The index, gallery and API list links to every meme, which would make signing them pointless.
+	federationToken := os.Getenv(FederationTokenVar)
+	if os.Getenv(URLSigningKeysVar) == "" || *PublicGallery || federationToken != "" {
+		// Only export local memes to avoid loops between instances that load each other.
+		router.Handle(IndexExportPath, NewIndexExportHandler(IndexExportConfig{
+			Memepository: localMemepository,
+			Token:        federationToken,
+			MaxAge:       exportedLinksMaxAge(),
+			Log:          logger,
+		}))
+	} else {
+		logger.Info("index export disabled since image links are signed, set " + FederationTokenVar + " to serve it")
+	}
+
+	if os.Getenv(URLSigningKeysVar) == "" || *PublicGallery {
+		initGallery(router, memepository)
+	} else {
+		logger.Info("gallery and api disabled since image links are signed, pass -public-gallery to serve them")
+	}
+
+	stats := createStatsStore()
+	if adminToken := os.Getenv(AdminTokenVar); adminToken != "" {
+		initAdmin(router, writableMemepository, stats, adminToken)
+	}
+
+	generatedObjects := NewGeneratedObjectStore(GeneratedObjectStoreConfig{
+		Router:   router.PathPrefix(GeneratedObjectsPath).Subrouter(),
+		MaxBytes: *GeneratedCacheSize << 20,
+		Server:   createObjectServerConfig(),
 	})
 
 	memes, err := memepository.Load()
 	if err != nil {
-		log.Fatal("error loading memes:", err)
+		logger.Fatal("error loading memes", "err", err)
 	}
 
 	if *ListKeywordsMode {
@@ -116,83 +357,349 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *FindDuplicatesMode {
+		clusters := FindSimilarMemes(memes.Memes(), *SimilarityThreshold)
+		for i, cluster := range clusters {
+			fmt.Printf("duplicates %d:\n", i+1)
+			for _, meme := range cluster {
+				fmt.Printf("  %s (%s)\n", meme.URL(), strings.Join(meme.Keywords(), ","))
+			}
+		}
+		fmt.Printf("found %d groups of duplicates\n", len(clusters))
+		os.Exit(0)
+	}
+
 	port := ":" + strconv.Itoa(*ImageServerPort)
 	listener, err := net.Listen("tcp", port)
 	if err != nil {
-		log.Fatal("image server error:", err)
+		logger.Fatal("image server error", "err", err)
 	}
 	rootUrl, _ := rootRoute.URL()
-	log.Println("image server listening on port", port)
-	log.Println("serving images on", rootUrl)
+	logger.Info("image server listening", "port", *ImageServerPort, "url", rootUrl)
 
 	defer func() {
-		log.Println("exiting...")
+		logger.Info("exiting")
 	}()
 
-	if *ServeOnlyMode {
-		err = http.Serve(listener, router)
+	go func() {
+		err := http.Serve(listener, router)
 		if err != nil {
-			log.Fatal("image server error:", err)
+			logger.Fatal("image server error", "err", err)
 		}
-	} else {
-		go func() {
-			err := http.Serve(listener, router)
-			if err != nil {
-				log.Fatal("image server error:", err)
-			}
-		}()
+	}()
 
-		startBot(memepository)
+	ctx := exitContext()
+	if *ServeOnlyMode {
+		<-ctx.Done()
+	} else {
+		startBot(ctx, memepository, generatedObjects, stats, health)
 	}
 }
 
 func initRouter(hostname string, displayPort int) *mux.Router {
 	routerAddr := fmt.Sprintf("%s:%d", hostname, displayPort)
-	router := mux.NewRouter().Host(routerAddr).Subrouter()
+	return mux.NewRouter().Host(routerAddr).Subrouter()
+}
 
-	// Basic "are you alive?" check.
-	router.HandleFunc("/health", func(w http.ResponseWriter, req *http.Request) {
-		log.Printf("health check from %s (X-Forwarded-For: %s)", req.RemoteAddr, req.Header["X-Forwarded-For"])
-		fmt.Fprintln(w, "Everything looks good!")
-		return
-	})
+func initGallery(router *mux.Router, memepository Memepository) {
+	CreateGallery(router.PathPrefix(GalleryPath).Subrouter(), memepository, createMediaTypes(), logger)
+	router.Handle(GalleryPath, http.RedirectHandler(GalleryPath+"/", http.StatusMovedPermanently))
+
+	if _, err := CreateAPI(router.PathPrefix(APIPath).Subrouter(), APIConfig{
+		Memepository: memepository,
+		Searcher:     createSearcher(memepository),
+		// Anyone can search, so they mustn't use up the external search API's quota.
+		SearchOptions: SearchOptions{DisableExternal: true},
+		Log:           logger,
+	}); err != nil {
+		logger.Fatal("error creating api", "err", err)
+	}
+}
+
+func initAdmin(router *mux.Router, memepository *FileServingMemepository, stats StatsStore, token string) {
+	if memepository == nil {
+		logger.Fatal(AdminTokenVar + " is set, but there's no -images directory to upload memes to")
+	}
 
-	return router
+	auditLog := logger.With("audit", true)
+	if *AuditLogPath != "" {
+		file, err := os.OpenFile(*AuditLogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			logger.Fatal("error opening audit log", "err", err)
+		}
+		auditLog = NewLogger(LoggerConfig{Output: file})
+	}
+
+	// The API must be routed first, since its prefix is under the UI's.
+	if _, err := CreateAdminAPI(router.PathPrefix(AdminAPIPath).Subrouter(), AdminAPIConfig{
+		Memepository:   memepository,
+		Token:          token,
+		MaxUploadBytes: *MaxUploadSize << 20,
+		AuditLog:       auditLog,
+		Log:            logger,
+	}); err != nil {
+		logger.Fatal("error creating admin api", "err", err)
+	}
+
+	if _, err := CreateAdminUI(router.PathPrefix(AdminPath).Subrouter(), AdminUIConfig{
+		Memepository: memepository,
+		Token:        token,
+		Stats:        stats,
+		MediaTypes:   createMediaTypes(),
+		AuditLog:     auditLog,
+		Log:          logger,
+	}); err != nil {
+		logger.Fatal("error creating admin ui", "err", err)
+	}
+	router.Handle(AdminPath, http.RedirectHandler(AdminPath+"/", http.StatusMovedPermanently))
+
+	logger.Info("admin enabled", "path", memepository.Path)
 }
 
-func startBot(memepository Memepository) {
+func startBot(ctx context.Context, memepository Memepository, generatedObjects *GeneratedObjectStore, stats StatsStore, health *Health) {
 	slackToken := os.Getenv(SlackTokenVar)
 	if slackToken == "" {
-		log.Fatal("Slack token not found. Set ", SlackTokenVar)
+		logger.Fatal("slack token not found, set " + SlackTokenVar)
 	}
 
 	// Load the set of keywords for the sample generator.
 	memeIndex, err := memepository.Load()
 	if err != nil {
-		log.Fatalln("error loading keywords:", err)
+		logger.Fatal("error loading keywords", "err", err)
 	}
 	parser, err := NewRegexpKeywordParser(*KeywordPattern, memeIndex.Keywords())
 	if err != nil {
-		log.Fatalf("error compiling keyword pattern '%s': %s", *KeywordPattern, err)
+		logger.Fatal("error compiling keyword pattern", "pattern", *KeywordPattern, "err", err)
 	}
 
 	if !*OnlyReplyToMentions {
-		log.Println("WARNING: filtering by mentions is disabled. may be spammy.")
+		logger.Warn("filtering by mentions is disabled, may be spammy")
 	}
 
-	log.Println("connecting to slack...")
+	logger.Info("connecting to slack")
 	bot, err := NewMemeBot(slackToken, MemeBotConfig{
-		Parser:           MessageParser{KeywordParser: parser},
-		Searcher:         &MemepositorySearcher{memepository},
+		Parser: MessageParser{
+			KeywordParser:  parser,
+			ParseCaptions:  *Captions,
+			ParseCounts:    *Collages,
+			ParseModifiers: *Modifiers,
+			ParseStats:     true,
+			ParseWishes:    true,
+		},
+		Searcher:         createSearcher(memepository),
 		ParseAllMessages: !*OnlyReplyToMentions,
-		Log:              log.New(os.Stderr, "", log.LstdFlags),
+		Log:              logger,
+		ChannelSettings:  createChannelSettings(),
+		Captioner:        createCaptioner(generatedObjects),
+		Collager:         createCollager(generatedObjects),
+		MediaTypes:       createMediaTypes(),
+		Metrics:          metrics,
+		Events:           events,
+		Stats:            stats,
+		Wishlist:         createWishlist(),
+		Memepository:     memepository,
+		NotifyWishesByDM: *NotifyWishesByDM,
 	})
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal("error starting bot", "err", err)
+	}
+	health.SetBot(bot)
+
+	logger.Info("memebot ready (^c to exit)", "name", "@"+bot.Name(), "parser", parser)
+
+	bot.Run(ctx)
+}
+
+func createStatsStore() StatsStore {
+	if *StatsFilePath == "" {
+		return NewMemoryStatsStore()
 	}
 
-	log.Print("memebot ready as @", bot.Name(), " (^c to exit)")
-	log.Println("matching keywords on", parser)
+	store, err := OpenFileStatsStore(*StatsFilePath)
+	if err != nil {
+		logger.Fatal("error opening stats file", "err", err)
+	}
+	store.Log = logger
+	return store
+}
+
+func createWishlist() *Wishlist {
+	if *WishlistPath == "" {
+		return NewWishlist()
+	}
+
+	wishlist, err := LoadWishlist(*WishlistPath)
+	if err != nil {
+		logger.Fatal("error loading wishlist", "err", err)
+	}
+	return wishlist
+}
+
+func createLogger() *Logger {
+	level, err := ParseLogLevel(*LogLevelName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	return NewLogger(LoggerConfig{
+		Level:          level,
+		RedactUserText: *RedactLogs,
+		// Deferred calls don't run when Fatal exits.
+		BeforeExit: func() { closeEventSinks() },
+	})
+}
+
+// subscribeEventSinks subscribes the sinks configured by flags to events, and returns a
+// function that closes them, which waits for queued events to be sent. It only closes them
+// once, since the logger calls it when exiting on a fatal error, maybe while main is.
+func subscribeEventSinks() (closeSinks func()) {
+	var sinks []io.Closer
+	if *EventsFilePath != "" {
+		sink, err := OpenFileEventSink(*EventsFilePath)
+		if err != nil {
+			logger.Fatal("error opening events file", "err", err)
+		}
+		sink.Log = logger
+		sink.RedactKeywords = *RedactLogs
+		events.Subscribe(sink)
+		sinks = append(sinks, sink)
+	}
+
+	if *EventsWebhookURL != "" {
+		sink, err := NewWebhookEventSink(WebhookEventSinkConfig{
+			URL:            *EventsWebhookURL,
+			RedactKeywords: *RedactLogs,
+			Log:            logger,
+		})
+		if err != nil {
+			logger.Fatal("error creating events webhook", "err", err)
+		}
+		events.Subscribe(sink)
+		sinks = append(sinks, sink)
+	}
 
-	bot.Run(context.Background())
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			for _, sink := range sinks {
+				if err := sink.Close(); err != nil {
+					logger.Error("error closing event sink", "err", err)
+				}
+			}
+		})
+	}
+}
+
+// exitContext returns a context that's cancelled when the process is interrupted or
+// terminated, so it can finish sending events before exiting.
+func exitContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		logger.Info("received signal", "signal", <-signals)
+		cancel()
+	}()
+	return ctx
+}
+
+func showStats(window time.Duration) {
+	if *StatsFilePath == "" {
+		logger.Fatal("-show-stats requires -stats-file")
+	}
+
+	store, err := OpenFileStatsStore(*StatsFilePath)
+	if err != nil {
+		logger.Fatal("error opening stats file", "err", err)
+	}
+	store.Log = logger
+	defer store.Close()
+
+	stats, err := LoadUsageStats(store, "", window, time.Now(), DefaultStatsTopCount)
+	if err != nil {
+		logger.Fatal("error loading stats", "err", err)
+	}
+	fmt.Println(stats)
+}
+
+func createCaptioner(generatedObjects *GeneratedObjectStore) Captioner {
+	if !*Captions {
+		return nil
+	}
+
+	captioner, err := NewImageCaptioner(ImageCaptionerConfig{
+		Store:      generatedObjects,
+		MediaTypes: createMediaTypes(),
+	})
+	if err != nil {
+		logger.Fatal("error creating captioner", "err", err)
+	}
+	return captioner
+}
+
+func createCollager(generatedObjects *GeneratedObjectStore) Collager {
+	if !*Collages {
+		return nil
+	}
+
+	collager, err := NewImageCollager(ImageCollagerConfig{
+		Store:      generatedObjects,
+		CellSize:   *CollageCellSize,
+		MediaTypes: createMediaTypes(),
+	})
+	if err != nil {
+		logger.Fatal("error creating collager", "err", err)
+	}
+	return collager
+}
+
+func createSearcher(memepository Memepository) MemeSearcher {
+	searcher := &ChainSearcher{
+		Searchers: []ChainedSearcher{
+			{MemeSearcher: &MemepositorySearcher{Memepository: memepository}},
+		},
+		Log: logger,
+	}
+
+	if *ExternalSearchURL != "" {
+		external, err := NewHTTPSearcher(HTTPSearcherConfig{
+			URLTemplate: *ExternalSearchURL,
+			ResultPath:  *ExternalSearchResultPath,
+		})
+		if err != nil {
+			logger.Fatal("error configuring external search", "err", err)
+		}
+		searcher.Searchers = append(searcher.Searchers, ChainedSearcher{
+			Name:         *ExternalSearchName,
+			MemeSearcher: external,
+			External:     true,
+		})
+	}
+
+	return searcher
+}
+
+func createChannelSettings() map[string]ChannelSettings {
+	settings := make(map[string]ChannelSettings)
+	for _, name := range splitChannelNames(*NoExternalSearchChannels) {
+		channel := settings[name]
+		channel.DisableExternalSearch = true
+		settings[name] = channel
+	}
+	for _, name := range splitChannelNames(*NSFWChannels) {
+		channel := settings[name]
+		channel.AllowNSFW = true
+		settings[name] = channel
+	}
+	return settings
+}
+
+func splitChannelNames(list string) (names []string) {
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimPrefix(strings.TrimSpace(name), "#")
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return
 }
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	. "github.com/zach-klippenstein/memebot"
+)
+
+// StringListFlag is a flag.Value that collects every occurrence of a repeated flag.
+type StringListFlag []string
+
+func (f *StringListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *StringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// createMemepository creates a Memepository for all the configured image sources,
+// and one for only the sources served by this instance.
+// If there's only a single local source, its memes are served directly from rootRoute,
+// otherwise each source is served from a numbered sub-path.
+// createMemepository also returns the first -images directory, which memes are uploaded to,
+// or nil if there isn't one.
+func createMemepository(rootRoute *mux.Route) (all, local *CompositeMemepository, writable *FileServingMemepository) {
+	var sources []MemepositorySource
+	serverConfig := createObjectServerConfig()
+
+	for _, dir := range ImagesDirs {
+		config := FileServingMemepositoryConfig{
+			Path:            dir,
+			ImageExtensions: createMediaTypes().Extensions(),
+			ValidateImages:  *ValidateImages,
+			Router:          sourceRouter(rootRoute, len(sources)),
+			Server:          serverConfig,
+			Log:             logger,
+		}
+
+		if IsArchive(dir) {
+			config.Path = ""
+			memepository, err := NewArchiveMemepository(dir, config)
+			if err != nil {
+				logger.Fatal("error opening archive", "path", dir, "err", err)
+			}
+			sources = append(sources, MemepositorySource{Name: "archive:" + dir, Memepository: memepository})
+		} else {
+			memepository := NewFileServingMemepository(config)
+			if writable == nil {
+				writable = memepository
+			}
+			sources = append(sources, MemepositorySource{Name: "dir:" + dir, Memepository: memepository})
+		}
+	}
+
+	if *S3Bucket != "" {
+		s3Source := MemepositorySource{
+			Name:         fmt.Sprintf("s3:%s/%s", *S3Bucket, *S3Prefix),
+			Memepository: createS3Memepository(sourceRouter(rootRoute, len(sources)), serverConfig),
+		}
+
+		if *PreferS3 {
+			sources = append([]MemepositorySource{s3Source}, sources...)
+		} else {
+			sources = append(sources, s3Source)
+		}
+	}
+
+	local = &CompositeMemepository{
+		Sources: sources,
+		Log:     logger,
+	}
+
+	for _, remoteURL := range RemoteIndexURLs {
+		sources = append(sources, MemepositorySource{
+			Name: "remote:" + remoteURL,
+			Memepository: NewRemoteMemepository(RemoteMemepositoryConfig{
+				URL:             remoteURL,
+				RefreshInterval: *RemoteRefreshInterval,
+				Token:           os.Getenv(FederationTokenVar),
+				Log:             logger,
+			}),
+		})
+	}
+
+	all = &CompositeMemepository{
+		Sources: sources,
+		Metrics: metrics,
+		Log:     logger,
+		Events:  events,
+	}
+	if *MergeSimilarKeywordsMode && !*FindDuplicatesMode {
+		local.DuplicatePolicy = MergeSimilarKeywords
+		all.DuplicatePolicy = MergeSimilarKeywords
+	} else if *MergeDuplicateKeywordsMode {
+		local.DuplicatePolicy = MergeDuplicateKeywords
+		all.DuplicatePolicy = MergeDuplicateKeywords
+	}
+	local.SimilarityThreshold = *SimilarityThreshold
+	all.SimilarityThreshold = *SimilarityThreshold
+	return
+}
+
+func sourceRouter(rootRoute *mux.Route, index int) *mux.Router {
+	if index == 0 {
+		return rootRoute.Subrouter()
+	}
+	return rootRoute.Subrouter().PathPrefix(fmt.Sprintf("/%d/", index)).Subrouter()
+}
+
+// exportedLinksMaxAge returns how long other instances may keep the links in the exported
+// index, or 0 if they don't expire. That's half as long as the links are guaranteed to work.
+func exportedLinksMaxAge() (maxAge time.Duration) {
+	if os.Getenv(URLSigningKeysVar) != "" {
+		// Signed links are valid for at least half the expiry period.
+		maxAge = *SignedURLExpiry / 4
+	}
+	if *S3Bucket != "" && *S3PresignExpiry > 0 && (maxAge == 0 || *S3PresignExpiry/2 < maxAge) {
+		maxAge = *S3PresignExpiry / 2
+	}
+	return
+}
+
+func createObjectServerConfig() ObjectServerConfig {
+	signingKeys, err := ParseSigningKeys(os.Getenv(URLSigningKeysVar))
+	if err != nil {
+		logger.Fatal("error parsing "+URLSigningKeysVar, "err", err)
+	}
+
+	var unsignedUserAgents []string
+	for _, userAgent := range strings.Split(*UnsignedUserAgents, ",") {
+		if userAgent = strings.TrimSpace(userAgent); userAgent != "" {
+			unsignedUserAgents = append(unsignedUserAgents, userAgent)
+		}
+	}
+
+	return ObjectServerConfig{
+		CacheMaxAge:         *CacheMaxAge,
+		SigningKeys:         signingKeys,
+		SignedURLExpiry:     *SignedURLExpiry,
+		UnsignedUserAgents:  unsignedUserAgents,
+		DefaultWidth:        *DefaultImageWidth,
+		TransformCacheBytes: *ResizeCacheSize << 20,
+		StripMetadata:       *StripMetadata,
+		MediaTypes:          createMediaTypes(),
+		Metrics:             metrics,
+		Log:                 logger,
+	}
+}
+
+// createMediaTypes returns the types enabled by -media-types, with the features disabled
+// by -no-resize-types and -no-caption-types.
+func createMediaTypes() MediaTypes {
+	mediaTypes := make(MediaTypes)
+	for _, ext := range splitExtensions(*MediaTypeList) {
+		mediaType, found := DefaultMediaTypes[ext]
+		if !found {
+			logger.Fatal("unknown media type", "type", ext,
+				"known", strings.Join(DefaultMediaTypes.SortedExtensions(), ","))
+		}
+		mediaTypes[ext] = mediaType
+	}
+
+	for _, ext := range splitExtensions(*NoResizeTypes) {
+		if mediaType, found := mediaTypes[ext]; found {
+			mediaType.Resizable = false
+			mediaTypes[ext] = mediaType
+		}
+	}
+	for _, ext := range splitExtensions(*NoCaptionTypes) {
+		if mediaType, found := mediaTypes[ext]; found {
+			mediaType.Captionable = false
+			mediaTypes[ext] = mediaType
+		}
+	}
+	return mediaTypes
+}
+
+func splitExtensions(list string) (extensions []string) {
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext != "" {
+			extensions = append(extensions, ext)
+		}
+	}
+	return
+}
+
+func createS3Memepository(router *mux.Router, serverConfig ObjectServerConfig) *S3Memepository {
+	memepository, err := NewS3Memepository(S3MemepositoryConfig{
+		Endpoint:        *S3Endpoint,
+		Region:          *S3Region,
+		Bucket:          *S3Bucket,
+		Prefix:          *S3Prefix,
+		AccessKeyID:     os.Getenv(AwsAccessKeyIdVar),
+		SecretAccessKey: os.Getenv(AwsSecretAccessKeyVar),
+		ImageExtensions: createMediaTypes().Extensions(),
+		ValidateImages:  *ValidateImages,
+		RefreshInterval: *S3RefreshInterval,
+		PresignExpiry:   *S3PresignExpiry,
+		Router:          router,
+		Server:          serverConfig,
+		Log:             logger,
+	})
+	if err != nil {
+		logger.Fatal("error configuring s3", "err", err)
+	}
+	return memepository
+}
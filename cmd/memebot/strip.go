@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	. "github.com/zach-klippenstein/memebot"
+)
+
+// stripMetadataFromFiles reports the metadata in the images in dirs, and if write is
+// true, rewrites the images without it. Archives can't be rewritten, so they're skipped.
+// Rewritten images get new IDs: their flags are moved, but links to the old IDs break.
+func stripMetadataFromFiles(dirs []string, write bool) {
+	var checked, withMetadata int
+	for _, dir := range dirs {
+		if IsArchive(dir) {
+			logger.Info("skipping archive", "path", dir)
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			logger.Fatal("error reading directory", "path", dir, "err", err)
+		}
+
+		for _, entry := range entries {
+			if !entry.Mode().IsRegular() {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				logger.Fatal("error reading image", "path", path, "err", err)
+			}
+			result, err := StripImageMetadata(data, ext)
+			if err == ErrMetadataNotSupported {
+				continue
+			} else if err != nil {
+				logger.Warn("skipping image", "path", path, "err", err)
+				continue
+			}
+
+			checked++
+			if len(result.Removed) == 0 {
+				continue
+			}
+			withMetadata++
+
+			note := ""
+			if result.Reencoded {
+				note = ", re-encoded to apply orientation"
+			}
+			fmt.Printf("%s: %s (%d -> %d bytes%s)\n", path, strings.Join(result.Removed, ", "),
+				len(data), len(result.Data), note)
+
+			if write {
+				if err := WriteFileAtomically(path, result.Data, entry.Mode()); err != nil {
+					logger.Fatal("error rewriting image", "path", path, "err", err)
+				}
+				// IDs are content hashes, so the image's ID just changed.
+				if err := MoveMemeFlags(dir, data, result.Data); err != nil {
+					logger.Fatal("error moving meme flags", "path", path, "err", err)
+				}
+			}
+		}
+	}
+
+	if write {
+		fmt.Printf("stripped metadata from %d of %d images\n", withMetadata, checked)
+	} else if withMetadata > 0 {
+		fmt.Printf("found metadata in %d of %d images, run with -write to strip it\n", withMetadata, checked)
+	} else {
+		fmt.Printf("found no metadata in %d images\n", checked)
+	}
+}
@@ -0,0 +1,163 @@
+package memebot
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"math"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// MaxCollageMemes is the most memes that will be combined into a single collage.
+	MaxCollageMemes = 9
+
+	// DefaultCollageCellSize is the default width and height of each meme in a collage.
+	DefaultCollageCellSize = 320
+)
+
+// Collager combines multiple memes into one.
+type Collager interface {
+	// Memes that aren't served by this bot are downloaded under ctx.
+	Collage(ctx context.Context, memes []Meme) (Meme, error)
+}
+
+type ImageCollagerConfig struct {
+	// Where collages are stored and served from.
+	Store *GeneratedObjectStore
+
+	// Used to download memes that aren't served by this bot. Defaults to DefaultHTTPClient.
+	Client *http.Client
+
+	// Memes are scaled down to fit in squares of this size. Defaults to DefaultCollageCellSize.
+	CellSize int
+
+	// Memes whose type isn't Captionable are left out of collages. Defaults to DefaultMediaTypes.
+	MediaTypes MediaTypes
+}
+
+// ImageCollager arranges memes in a grid. Animated GIFs are included using their first
+// frame. Collages are JPEGs if all the memes are JPEGs, and PNGs otherwise.
+type ImageCollager struct {
+	ImageCollagerConfig
+}
+
+var _ Collager = &ImageCollager{}
+
+func NewImageCollager(config ImageCollagerConfig) (*ImageCollager, error) {
+	if config.Store == nil {
+		return nil, errors.New("Store must be specified")
+	}
+	if config.Client == nil {
+		config.Client = DefaultHTTPClient
+	}
+	if config.CellSize <= 0 {
+		config.CellSize = DefaultCollageCellSize
+	}
+	return &ImageCollager{config}, nil
+}
+
+func (c *ImageCollager) Collage(ctx context.Context, memes []Meme) (Meme, error) {
+	if len(memes) == 0 {
+		return nil, errors.New("no memes to collage")
+	}
+	var supported []Meme
+	for _, meme := range memes {
+		if c.MediaTypes.captionable(memeExtension(meme)) {
+			supported = append(supported, meme)
+		}
+	}
+	if len(supported) == 0 {
+		return nil, ErrMediaTypeNotSupported
+	}
+	memes = supported
+
+	if len(memes) > MaxCollageMemes {
+		memes = memes[:MaxCollageMemes]
+	}
+
+	scaled, formats, err := c.scaleMemes(ctx, memes)
+	if err != nil {
+		return nil, err
+	}
+
+	cols, rows := collageGrid(len(memes))
+	dst := image.NewRGBA(image.Rect(0, 0, cols*c.CellSize, rows*c.CellSize))
+	format := "jpeg"
+	var keywords []string
+
+	for i, meme := range memes {
+		if formats[i] != "jpeg" {
+			format = "png"
+		}
+		keywords = mergeKeywords(keywords, meme.Keywords())
+
+		// Center the meme in its cell.
+		bounds := scaled[i].Bounds()
+		cell := image.Rect(0, 0, c.CellSize, c.CellSize).Add(image.Pt(i%cols*c.CellSize, i/cols*c.CellSize))
+		offset := image.Pt((c.CellSize-bounds.Dx())/2, (c.CellSize-bounds.Dy())/2)
+		draw.Draw(dst, bounds.Add(cell.Min).Add(offset), scaled[i], image.ZP, draw.Src)
+	}
+
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, dst, format); err != nil {
+		return nil, err
+	}
+
+	ext := "png"
+	if format == "jpeg" {
+		ext = "jpg"
+	}
+	id, err := c.Store.Add(buf.Bytes(), ext)
+	if err != nil {
+		return nil, err
+	}
+	return &GeneratedMeme{id, c.Store, keywords}, nil
+}
+
+// scaleMemes reads memes concurrently, so slow downloads don't add up, and scales them to
+// fit in a cell. If any of them fails, the rest are cancelled and the first error is returned.
+func (c *ImageCollager) scaleMemes(ctx context.Context, memes []Meme) (scaled []image.Image, formats []string, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	scaled = make([]image.Image, len(memes))
+	formats = make([]string, len(memes))
+	var errOnce sync.Once
+	var wg sync.WaitGroup
+	for i, meme := range memes {
+		wg.Add(1)
+		go func(i int, meme Meme) {
+			defer wg.Done()
+			img, format, decodeErr := decodeMemeImage(ctx, meme, c.Client, c.MediaTypes)
+			if decodeErr != nil {
+				errOnce.Do(func() {
+					err = decodeErr
+					cancel()
+				})
+				return
+			}
+
+			bounds := img.Bounds()
+			crop, w, h := (&resizeTransform{c.CellSize, c.CellSize, FitContain}).geometry(bounds.Dx(), bounds.Dy())
+			scaled[i], formats[i] = scaleImage(img, crop, w, h), format
+		}(i, meme)
+	}
+	wg.Wait()
+
+	if err != nil {
+		return nil, nil, err
+	}
+	return scaled, formats, nil
+}
+
+// collageGrid returns the smallest, squarest grid that fits n memes.
+func collageGrid(n int) (cols, rows int) {
+	cols = int(math.Ceil(math.Sqrt(float64(n))))
+	rows = (n + cols - 1) / cols
+	return
+}
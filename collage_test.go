@@ -0,0 +1,141 @@
+package memebot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestCollageGrid(t *testing.T) {
+	for n, expected := range map[int][2]int{
+		1: {1, 1},
+		2: {2, 1},
+		3: {2, 2},
+		4: {2, 2},
+		5: {3, 2},
+		9: {3, 3},
+	} {
+		cols, rows := collageGrid(n)
+		assert.Equal(t, expected, [2]int{cols, rows}, "%d", n)
+	}
+}
+
+func newTestCollager(t *testing.T) (*ImageCollager, *mux.Router) {
+	router := mux.NewRouter()
+	collager, err := NewImageCollager(ImageCollagerConfig{
+		Store:    NewGeneratedObjectStore(GeneratedObjectStoreConfig{Router: router}),
+		CellSize: 50,
+	})
+	require.NoError(t, err)
+	return collager, router
+}
+
+func TestImageCollager(t *testing.T) {
+	memepository, _, dir := newTestFileServingMemepository(t, map[string]string{
+		"cat1.jpg": string(encodeTestImage(t, "jpeg", 100, 60)),
+		"cat2.jpg": string(encodeTestImage(t, "jpeg", 30, 30)),
+		"cat3.jpg": string(encodeTestImage(t, "jpeg", 60, 100)),
+	}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+	collager, router := newTestCollager(t)
+
+	memes := []Meme{
+		findTestFileMeme(t, memepository, "cat1"),
+		findTestFileMeme(t, memepository, "cat2"),
+		findTestFileMeme(t, memepository, "cat3"),
+	}
+	collage, err := collager.Collage(context.Background(), memes)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cat1", "cat2", "cat3"}, collage.Keywords())
+
+	resp := serveTestRequest(t, router, collage.URL().String(), nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	config, format := decodeTestImageConfig(t, resp.Body.Bytes())
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, 100, config.Width)
+	assert.Equal(t, 100, config.Height)
+
+	// The same memes give the same collage.
+	again, err := collager.Collage(context.Background(), memes)
+	require.NoError(t, err)
+	assert.Equal(t, collage.URL(), again.URL())
+}
+
+func TestImageCollagerGIFs(t *testing.T) {
+	memepository, _, dir := newTestFileServingMemepository(t, map[string]string{
+		"cat1.jpg": string(encodeTestImage(t, "jpeg", 100, 60)),
+		"cat2.gif": string(encodeTestGIF(t, 40, 40, 3)),
+	}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+	collager, router := newTestCollager(t)
+
+	collage, err := collager.Collage(context.Background(), []Meme{
+		findTestFileMeme(t, memepository, "cat1"),
+		findTestFileMeme(t, memepository, "cat2"),
+	})
+	require.NoError(t, err)
+
+	resp := serveTestRequest(t, router, collage.URL().String(), nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	config, format := decodeTestImageConfig(t, resp.Body.Bytes())
+	assert.Equal(t, "png", format)
+	assert.Equal(t, 100, config.Width)
+	assert.Equal(t, 50, config.Height)
+}
+
+func TestImageCollagerDownloadsConcurrently(t *testing.T) {
+	imageData := encodeTestImage(t, "jpeg", 40, 40)
+	var lock sync.Mutex
+	downloads := 0
+	// Closed once both memes are being downloaded, so they only finish if they're concurrent.
+	bothStarted := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/missing.jpg":
+			http.NotFound(w, req)
+		case "/slow.jpg":
+			<-req.Context().Done()
+		default:
+			lock.Lock()
+			if downloads++; downloads == 2 {
+				close(bothStarted)
+			}
+			lock.Unlock()
+
+			select {
+			case <-bothStarted:
+				w.Write(imageData)
+			case <-req.Context().Done():
+			}
+		}
+	}))
+	defer server.Close()
+	collager, _ := newTestCollager(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	collage, err := collager.Collage(ctx, []Meme{
+		NewMockMeme(server.URL+"/cat1.jpg", "cat1"),
+		NewMockMeme(server.URL+"/cat2.jpg", "cat2"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cat1", "cat2"}, collage.Keywords())
+
+	// The other downloads are cancelled when one fails.
+	start := time.Now()
+	_, err = collager.Collage(ctx, []Meme{
+		NewMockMeme(server.URL+"/slow.jpg", "slow"),
+		NewMockMeme(server.URL+"/missing.jpg", "missing"),
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+	assert.True(t, time.Since(start) < time.Second)
+}
@@ -0,0 +1,321 @@
+package memebot
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentHasher is implemented by memes that know a hash of their image data.
+// Memes with the same content hash are considered duplicates.
+type ContentHasher interface {
+	ContentHash() string
+}
+
+// DuplicatePolicy determines what happens when multiple sources contain the same image.
+// In all cases, the meme from the source with the highest precedence is kept.
+type DuplicatePolicy int
+
+const (
+	// KeepHighestPrecedence keeps only the keywords of the highest-precedence meme.
+	KeepHighestPrecedence DuplicatePolicy = iota
+
+	// MergeDuplicateKeywords keeps the keywords of all duplicates.
+	MergeDuplicateKeywords
+
+	// MergeSimilarKeywords is like MergeDuplicateKeywords, but also treats images whose
+	// perceptual hashes are within SimilarityThreshold of each other as duplicates.
+	MergeSimilarKeywords
+)
+
+type MemepositorySource struct {
+	Name string
+	Memepository
+}
+
+type SourceStatus struct {
+	Name  string
+	Memes int
+	Err   error
+}
+
+// ErrAllSourcesFailed is returned from CompositeMemepository.Load if no source could be loaded.
+var ErrAllSourcesFailed = errors.New("all meme sources failed to load")
+
+// DefaultSourceReloadInterval is how often a CompositeMemepository loads its sources
+// again by default.
+const DefaultSourceReloadInterval = 5 * time.Second
+
+// CompositeMemepository is a Memepository that merges the memes from multiple sources.
+// Sources are loaded concurrently, and a source that fails to load is skipped.
+// Sources are loaded again by Load at most once per ReloadInterval, and their memes are
+// merged again if any of them returned a different index, so sources that refresh
+// themselves are picked up. If every source fails after memes were loaded, the memes
+// from before are kept.
+type CompositeMemepository struct {
+	// In order of decreasing precedence.
+	Sources         []MemepositorySource
+	DuplicatePolicy DuplicatePolicy
+
+	// Used by MergeSimilarKeywords. Defaults to DefaultSimilarityThreshold.
+	SimilarityThreshold int
+
+	// Defaults to DefaultSourceReloadInterval.
+	ReloadInterval time.Duration
+
+	// If not nil, the time taken to load sources that changed and the number of memes
+	// are reported to Metrics.
+	Metrics *Metrics
+
+	Log *Logger // Defaults to DefaultLogger.
+
+	// If not nil, an EventReload is published every time sources that changed are merged.
+	Events *EventBus
+
+	lock      sync.Mutex
+	indices   []*MemeIndex
+	memes     *MemeIndex
+	statuses  []SourceStatus
+	loadErr   error
+	loadedAt  time.Time
+	checkedAt time.Time
+
+	now func() time.Time // Defaults to time.Now.
+}
+
+func (m *CompositeMemepository) Load() (*MemeIndex, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now
+	if m.now != nil {
+		now = m.now
+	}
+	interval := m.ReloadInterval
+	if interval <= 0 {
+		interval = DefaultSourceReloadInterval
+	}
+	loaded := m.memes != nil || m.loadErr != nil
+	if loaded && now().Sub(m.checkedAt) < interval {
+		return m.memes, m.loadErr
+	}
+
+	start := time.Now()
+	m.checkedAt = now()
+	indices, errs := m.loadSources()
+	if loaded && !m.sourcesChanged(indices, errs) {
+		return m.memes, m.loadErr
+	}
+
+	err := m.merge(indices, errs)
+	count := 0
+	if m.memes != nil {
+		count = m.memes.Len()
+	}
+	if m.Metrics != nil {
+		m.Metrics.memesLoaded(time.Since(start), count)
+	}
+	m.Events.memesReloaded(count, err)
+	return m.memes, m.loadErr
+}
+
+// SourceStatuses returns the result of loading each source, in order of precedence.
+func (m *CompositeMemepository) SourceStatuses() []SourceStatus {
+	m.Load()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.statuses
+}
+
+// LoadedAt returns the last time the memes from the sources were merged, i.e. when
+// a source was first loaded or last changed.
+func (m *CompositeMemepository) LoadedAt() time.Time {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.loadedAt
+}
+
+func (m *CompositeMemepository) loadSources() (indices []*MemeIndex, errs []error) {
+	indices = make([]*MemeIndex, len(m.Sources))
+	errs = make([]error, len(m.Sources))
+
+	var wg sync.WaitGroup
+	for i, source := range m.Sources {
+		wg.Add(1)
+		go func(i int, source MemepositorySource) {
+			defer wg.Done()
+			indices[i], errs[i] = source.Load()
+		}(i, source)
+	}
+	wg.Wait()
+	return
+}
+
+func (m *CompositeMemepository) sourcesChanged(indices []*MemeIndex, errs []error) bool {
+	for i := range m.Sources {
+		if indices[i] != m.indices[i] || !sameError(errs[i], m.statuses[i].Err) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameError compares errors by message, since sources may return a new error every time
+// they fail.
+func sameError(a, b error) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Error() == b.Error()
+}
+
+// merge returns ErrAllSourcesFailed if no source loaded, even if the previous memes are kept.
+func (m *CompositeMemepository) merge(indices []*MemeIndex, errs []error) error {
+	statuses := make([]SourceStatus, len(m.Sources))
+
+	var loaded []*MemeIndex
+	for i, source := range m.Sources {
+		status := &statuses[i]
+		status.Name = source.Name
+		status.Err = errs[i]
+
+		if status.Err != nil {
+			m.Log.Error("error loading memes from source", "source", source.Name, "err", status.Err)
+			continue
+		}
+		status.Memes = indices[i].Len()
+		loaded = append(loaded, indices[i])
+	}
+
+	m.indices = indices
+	m.statuses = statuses
+
+	if len(loaded) == 0 && len(m.Sources) > 0 {
+		if m.memes != nil {
+			// The errors are reported by SourceStatuses.
+			m.Log.Error("all meme sources failed to load, keeping previous memes", "count", m.memes.Len())
+		} else {
+			m.loadErr = ErrAllSourcesFailed
+		}
+		return ErrAllSourcesFailed
+	}
+
+	threshold := m.SimilarityThreshold
+	if threshold <= 0 {
+		threshold = DefaultSimilarityThreshold
+	}
+	m.memes = mergeMemeIndices(loaded, m.DuplicatePolicy, threshold)
+	m.loadErr = nil
+	m.loadedAt = time.Now()
+	m.Log.Info("merged memes", "count", m.memes.Len(), "sources", len(loaded))
+	return nil
+}
+
+func mergeMemeIndices(indices []*MemeIndex, policy DuplicatePolicy, similarityThreshold int) *MemeIndex {
+	var memes []Meme
+	byHash := make(map[string]int)
+	extraKeywords := make(map[int][]string)
+
+	for _, index := range indices {
+		for _, meme := range index.Memes() {
+			hash := contentHash(meme)
+			if i, found := byHash[hash]; found && hash != "" {
+				if policy != KeepHighestPrecedence {
+					extraKeywords[i] = append(extraKeywords[i], meme.Keywords()...)
+				}
+				continue
+			}
+
+			byHash[hash] = len(memes)
+			memes = append(memes, meme)
+		}
+	}
+
+	for i, meme := range memes {
+		if keywords, found := extraKeywords[i]; found {
+			memes[i] = &mergedMeme{meme, mergeKeywords(meme.Keywords(), keywords)}
+		}
+	}
+	if policy == MergeSimilarKeywords {
+		memes = mergeSimilarMemes(memes, similarityThreshold)
+	}
+
+	merged := NewMemeIndex()
+	for _, meme := range memes {
+		merged.Add(meme)
+	}
+	return merged
+}
+
+// mergeSimilarMemes replaces each group of similar memes with the first meme in the
+// group, with the keywords of all of them.
+func mergeSimilarMemes(memes []Meme, threshold int) []Meme {
+	removed := make(map[int]bool)
+	for _, cluster := range similarMemeClusters(memes, threshold) {
+		first := memes[cluster[0]]
+		keywords := first.Keywords()
+		for _, i := range cluster[1:] {
+			keywords = mergeKeywords(keywords, memes[i].Keywords())
+			removed[i] = true
+		}
+		memes[cluster[0]] = &mergedMeme{first, keywords}
+	}
+
+	var kept []Meme
+	for i, meme := range memes {
+		if !removed[i] {
+			kept = append(kept, meme)
+		}
+	}
+	return kept
+}
+
+func contentHash(meme Meme) string {
+	if hasher, ok := meme.(ContentHasher); ok {
+		return hasher.ContentHash()
+	}
+	return ""
+}
+
+// mergeKeywords appends the keywords from extra that aren't already in keywords,
+// ignoring case.
+func mergeKeywords(keywords []string, extra []string) (merged []string) {
+	seen := make(StringSet)
+	for _, keyword := range append(append([]string(nil), keywords...), extra...) {
+		normalized := normalizeKeyword(keyword)
+		if !seen.Contains(normalized) {
+			seen[normalized] = struct{}{}
+			merged = append(merged, keyword)
+		}
+	}
+	return
+}
+
+// mergedMeme overrides the keywords of a meme that was found in multiple sources.
+type mergedMeme struct {
+	Meme
+	keywords []string
+}
+
+func (m *mergedMeme) Keywords() []string {
+	return m.keywords
+}
+
+func (m *mergedMeme) ContentHash() string {
+	return contentHash(m.Meme)
+}
+
+func (m *mergedMeme) unwrap() Meme {
+	return m.Meme
+}
+
+// idContentHash returns the hash part of an object ID generated from a content hash
+// and a file extension.
+func idContentHash(id string) string {
+	if i := strings.Index(id, "."); i >= 0 {
+		return id[:i]
+	}
+	return id
+}
@@ -0,0 +1,260 @@
+package memebot
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type FailingMemepository struct {
+	err error
+}
+
+func (m FailingMemepository) Load() (*MemeIndex, error) {
+	return nil, m.err
+}
+
+// CountingMemepository counts the times it's loaded.
+type CountingMemepository struct {
+	Memepository
+	loads int
+}
+
+func (m *CountingMemepository) Load() (*MemeIndex, error) {
+	m.loads++
+	return m.Memepository.Load()
+}
+
+// setTestCompositeClock makes m read the time from now.
+func setTestCompositeClock(m *CompositeMemepository, now *time.Time) {
+	m.now = func() time.Time { return *now }
+}
+
+type MockHashedMeme struct {
+	MockMeme
+	hash string
+}
+
+func NewMockHashedMeme(url, hash string, keywords ...string) Meme {
+	return MockHashedMeme{MockMeme{mustParseURL(url), keywords}, hash}
+}
+
+func (m MockHashedMeme) ContentHash() string {
+	return m.hash
+}
+
+func TestCompositeMemepositoryMergesSources(t *testing.T) {
+	composite := &CompositeMemepository{
+		Sources: []MemepositorySource{
+			{"a", &MockMemepository{NewTestMemeIndex(NewMockMeme("http://a.com", "foo"))}},
+			{"b", &MockMemepository{NewTestMemeIndex(NewMockMeme("http://b.com", "foo", "bar"))}},
+		},
+	}
+
+	memes, err := composite.Load()
+	require.NoError(t, err)
+	assert.Equal(t, 2, memes.Len())
+	assert.Equal(t, []string{"bar", "foo"}, memes.Keywords())
+	assert.Len(t, memes.FindByKeyword("foo"), 2)
+}
+
+func TestCompositeMemepositoryDeduplicatesByPrecedence(t *testing.T) {
+	composite := &CompositeMemepository{
+		Sources: []MemepositorySource{
+			{"a", &MockMemepository{NewTestMemeIndex(NewMockHashedMeme("http://a.com", "hash", "foo"))}},
+			{"b", &MockMemepository{NewTestMemeIndex(NewMockHashedMeme("http://b.com", "hash", "bar"))}},
+		},
+	}
+
+	memes, err := composite.Load()
+	require.NoError(t, err)
+	require.Equal(t, 1, memes.Len())
+	assert.Equal(t, "a.com", memes.Memes()[0].URL().Host)
+	assert.Equal(t, []string{"foo"}, memes.Keywords())
+}
+
+func TestCompositeMemepositoryMergesDuplicateKeywords(t *testing.T) {
+	composite := &CompositeMemepository{
+		Sources: []MemepositorySource{
+			{"a", &MockMemepository{NewTestMemeIndex(NewMockHashedMeme("http://a.com", "hash", "foo"))}},
+			{"b", &MockMemepository{NewTestMemeIndex(NewMockHashedMeme("http://b.com", "hash", "FOO", "bar"))}},
+		},
+		DuplicatePolicy: MergeDuplicateKeywords,
+	}
+
+	memes, err := composite.Load()
+	require.NoError(t, err)
+	require.Equal(t, 1, memes.Len())
+	assert.Equal(t, "a.com", memes.FindByKeyword("bar")[0].URL().Host)
+	assert.Equal(t, []string{"foo", "bar"}, memes.Memes()[0].Keywords())
+	assert.Equal(t, "hash", contentHash(memes.Memes()[0]))
+}
+
+func TestCompositeMemepositorySkipsFailedSources(t *testing.T) {
+	loadErr := errors.New("bucket on fire")
+	composite := &CompositeMemepository{
+		Sources: []MemepositorySource{
+			{"a", FailingMemepository{loadErr}},
+			{"b", &MockMemepository{NewTestMemeIndex(NewMockMeme("http://b.com", "foo"))}},
+		},
+	}
+
+	memes, err := composite.Load()
+	require.NoError(t, err)
+	assert.Equal(t, 1, memes.Len())
+	assert.Equal(t, []SourceStatus{
+		{Name: "a", Err: loadErr},
+		{Name: "b", Memes: 1},
+	}, composite.SourceStatuses())
+}
+
+func TestCompositeMemepositoryAllSourcesFailed(t *testing.T) {
+	composite := &CompositeMemepository{
+		Sources: []MemepositorySource{
+			{"a", FailingMemepository{errors.New("a")}},
+			{"b", FailingMemepository{errors.New("b")}},
+		},
+	}
+
+	_, err := composite.Load()
+	assert.Equal(t, ErrAllSourcesFailed, err)
+}
+
+func TestCompositeMemepositoryKeepsMemesWhenAllSourcesFail(t *testing.T) {
+	source := &MockMemepository{NewTestMemeIndex(NewMockMeme("http://a.com", "foo"))}
+	composite := &CompositeMemepository{
+		Sources: []MemepositorySource{{"a", source}},
+	}
+	now := time.Now()
+	setTestCompositeClock(composite, &now)
+
+	memes, err := composite.Load()
+	require.NoError(t, err)
+	loadedAt := composite.LoadedAt()
+
+	loadErr := errors.New("bucket on fire")
+	composite.Sources = []MemepositorySource{{"a", FailingMemepository{loadErr}}}
+	now = now.Add(DefaultSourceReloadInterval)
+	kept, err := composite.Load()
+	require.NoError(t, err)
+	assert.True(t, memes == kept)
+	assert.Equal(t, loadedAt, composite.LoadedAt())
+	assert.Equal(t, []SourceStatus{{Name: "a", Err: loadErr}}, composite.SourceStatuses())
+}
+
+func TestCompositeMemepositoryThrottlesReloads(t *testing.T) {
+	source := &CountingMemepository{Memepository: &MockMemepository{NewTestMemeIndex(NewMockMeme("http://a.com", "foo"))}}
+	composite := &CompositeMemepository{
+		Sources:        []MemepositorySource{{"a", source}},
+		ReloadInterval: time.Minute,
+	}
+	now := time.Now()
+	setTestCompositeClock(composite, &now)
+
+	composite.Load()
+	composite.Load()
+	composite.SourceStatuses()
+	assert.Equal(t, 1, source.loads)
+
+	now = now.Add(time.Minute)
+	composite.Load()
+	assert.Equal(t, 2, source.loads)
+}
+
+func TestCompositeMemepositoryComparesErrorsByMessage(t *testing.T) {
+	failures := 0
+	composite := &CompositeMemepository{
+		Sources: []MemepositorySource{
+			{"a", loaderFunc(func() (*MemeIndex, error) {
+				failures++
+				return nil, errors.New("bucket on fire")
+			})},
+			{"b", &MockMemepository{NewTestMemeIndex(NewMockMeme("http://b.com", "foo"))}},
+		},
+		Metrics: NewMetrics(),
+	}
+	now := time.Now()
+	setTestCompositeClock(composite, &now)
+
+	composite.Load()
+	now = now.Add(DefaultSourceReloadInterval)
+	composite.Load()
+	assert.Equal(t, 2, failures)
+	assert.Equal(t, uint64(1), composite.Metrics.LoadDuration.Count())
+}
+
+type loaderFunc func() (*MemeIndex, error)
+
+func (f loaderFunc) Load() (*MemeIndex, error) {
+	return f()
+}
+
+func TestIdContentHash(t *testing.T) {
+	assert.Equal(t, "abc", idContentHash("abc.jpg"))
+	assert.Equal(t, "abc", idContentHash("abc"))
+}
+
+func TestCompositeMemepositoryRemergesChangedSources(t *testing.T) {
+	source := &MockMemepository{NewTestMemeIndex(NewMockMeme("http://a.com", "foo"))}
+	composite := &CompositeMemepository{
+		Sources: []MemepositorySource{
+			{"a", source},
+			{"b", &MockMemepository{NewTestMemeIndex(NewMockMeme("http://b.com", "bar"))}},
+		},
+	}
+	now := time.Now()
+	setTestCompositeClock(composite, &now)
+
+	memes, err := composite.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bar", "foo"}, memes.Keywords())
+
+	now = now.Add(DefaultSourceReloadInterval)
+	unchanged, err := composite.Load()
+	require.NoError(t, err)
+	assert.True(t, memes == unchanged)
+
+	source.index = NewTestMemeIndex(NewMockMeme("http://a.com", "baz"))
+	now = now.Add(DefaultSourceReloadInterval)
+	memes, err = composite.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bar", "baz"}, memes.Keywords())
+}
+
+func TestCompositeMemepositoryMetrics(t *testing.T) {
+	source := &MockMemepository{NewTestMemeIndex(NewMockMeme("http://a.com", "foo"))}
+	composite := &CompositeMemepository{
+		Sources: []MemepositorySource{
+			{"a", source},
+			{"b", &MockMemepository{NewTestMemeIndex(NewMockMeme("http://b.com", "bar"))}},
+		},
+		Metrics: NewMetrics(),
+	}
+	now := time.Now()
+	setTestCompositeClock(composite, &now)
+
+	_, err := composite.Load()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), composite.Metrics.LoadDuration.Count())
+	assert.Equal(t, 2.0, composite.Metrics.Memes.Value())
+
+	// Only loads that change the memes are measured.
+	now = now.Add(DefaultSourceReloadInterval)
+	composite.Load()
+	assert.Equal(t, uint64(1), composite.Metrics.LoadDuration.Count())
+
+	source.index = NewTestMemeIndex()
+	now = now.Add(DefaultSourceReloadInterval)
+	composite.Load()
+	assert.Equal(t, uint64(2), composite.Metrics.LoadDuration.Count())
+	assert.Equal(t, 1.0, composite.Metrics.Memes.Value())
+
+	// The previous memes are still served if every source fails.
+	composite.Sources = []MemepositorySource{{"a", FailingMemepository{errors.New("a")}}}
+	now = now.Add(DefaultSourceReloadInterval)
+	composite.Load()
+	assert.Equal(t, 1.0, composite.Metrics.Memes.Value())
+}
@@ -0,0 +1,394 @@
+package memebot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// EventType identifies what happened in an Event.
+type EventType string
+
+const (
+	// A keyword was found in a message, and searched for.
+	EventMessageMatched EventType = "message_matched"
+
+	// A meme was found for a message, and the reply with it was sent.
+	EventMemePosted EventType = "meme_posted"
+
+	// No meme was found for a keyword the bot was asked for.
+	EventMiss EventType = "miss"
+
+	// Searching for or replying with a meme failed.
+	EventError EventType = "error"
+
+	// Memes were reloaded because a source changed.
+	EventReload EventType = "reload"
+
+	// The connection to Slack changed state.
+	EventConnection EventType = "connection"
+)
+
+// Event describes something the bot did. Only the fields relevant to Type are set.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+
+	Channel string `json:"channel,omitempty"`
+	Keyword string `json:"keyword,omitempty"`
+
+	// URL of the meme posted, before captions or modifiers were applied.
+	Meme string `json:"meme,omitempty"`
+
+	// Number of memes loaded by a reload.
+	Memes int `json:"memes,omitempty"`
+
+	// New state of a connection change.
+	State ConnectionState `json:"state,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// EventSink receives the events published on an EventBus it's subscribed to.
+// HandleEvent is called synchronously by the publisher, so it must not block for long.
+type EventSink interface {
+	HandleEvent(event Event)
+}
+
+// EventBus publishes events to every subscribed sink, in the order they were subscribed.
+// Publishing to a nil *EventBus does nothing, so components can publish without checking
+// if a bus was configured.
+type EventBus struct {
+	lock  sync.RWMutex
+	sinks []EventSink
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+func (b *EventBus) Subscribe(sink EventSink) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.sinks = append(b.sinks, sink)
+}
+
+// Publish sends event to all sinks. If event.Time is zero, it's set to the current time.
+func (b *EventBus) Publish(event Event) {
+	if b == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for _, sink := range b.sinks {
+		sink.HandleEvent(event)
+	}
+}
+
+// memeRequested publishes a search for keyword, and its outcome if it failed with err.
+// Memes found are published by the reply that posts them, once it's been sent.
+func (b *EventBus) memeRequested(channel, keyword string, err error) {
+	if b == nil {
+		return
+	}
+	b.Publish(Event{Type: EventMessageMatched, Channel: channel, Keyword: keyword})
+
+	switch err {
+	case nil:
+	case ErrNoMemeFound:
+		b.Publish(Event{Type: EventMiss, Channel: channel, Keyword: keyword})
+	default:
+		b.Publish(Event{Type: EventError, Channel: channel, Keyword: keyword, Error: err.Error()})
+	}
+}
+
+func (b *EventBus) memesReloaded(count int, err error) {
+	event := Event{Type: EventReload, Memes: count}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	b.Publish(event)
+}
+
+// redactKeyword returns event with its keyword replaced by RedactedText, for sinks that
+// mustn't store text written by users.
+func (e Event) redactKeyword() Event {
+	if e.Keyword != "" {
+		e.Keyword = RedactedText
+	}
+	return e
+}
+
+// MemoryEventSink keeps every event it handles in memory. Useful for tests.
+type MemoryEventSink struct {
+	lock   sync.Mutex
+	events []Event
+}
+
+func NewMemoryEventSink() *MemoryEventSink {
+	return &MemoryEventSink{}
+}
+
+func (s *MemoryEventSink) HandleEvent(event Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.events = append(s.events, event)
+}
+
+// Events returns the events handled so far, in the order they were published.
+func (s *MemoryEventSink) Events() []Event {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// FileEventSink appends events to a file, one JSON object per line.
+type FileEventSink struct {
+	Path string
+	Log  *Logger // Write errors are logged here. Defaults to DefaultLogger.
+
+	// If true, keywords are written as RedactedText, like UserText by redacting loggers.
+	RedactKeywords bool
+
+	lock sync.Mutex
+	file *os.File
+}
+
+// OpenFileEventSink opens the sink at path, creating the file if it doesn't exist.
+func OpenFileEventSink(path string) (*FileEventSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileEventSink{
+		Path: path,
+		file: file,
+	}, nil
+}
+
+func (s *FileEventSink) HandleEvent(event Event) {
+	if s.RedactKeywords {
+		event = event.redactKeyword()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		s.Log.Error("error encoding event", "type", event.Type, "err", err)
+		return
+	}
+	data = append(data, '\n')
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err := s.file.Write(data); err != nil {
+		s.Log.Error("error writing event", "path", s.Path, "err", err)
+	}
+}
+
+func (s *FileEventSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
+
+const (
+	DefaultWebhookQueueSize    = 100
+	DefaultWebhookMaxAttempts  = 3
+	DefaultWebhookRetryDelay   = time.Second
+	DefaultWebhookCloseTimeout = 5 * time.Second
+)
+
+type WebhookEventSinkConfig struct {
+	// Each event is POSTed to this URL as a JSON object.
+	URL string
+
+	// Maximum number of events waiting to be sent. Events published while the queue is
+	// full are dropped. Defaults to DefaultWebhookQueueSize.
+	QueueSize int
+
+	// Number of times to try sending an event before dropping it. Requests are retried if
+	// they fail, or the response status is 429 or 5xx. Defaults to DefaultWebhookMaxAttempts.
+	MaxAttempts int
+
+	// How long to wait before the first retry. The delay doubles after every attempt.
+	// Defaults to DefaultWebhookRetryDelay.
+	RetryDelay time.Duration
+
+	// How long Close waits for queued events to be sent. Events that haven't been sent by
+	// then are dropped. Defaults to DefaultWebhookCloseTimeout.
+	CloseTimeout time.Duration
+
+	// If true, keywords are sent as RedactedText, like UserText by redacting loggers.
+	RedactKeywords bool
+
+	Client *http.Client // Defaults to DefaultHTTPClient.
+	Log    *Logger      // Dropped events are logged here. Defaults to DefaultLogger.
+}
+
+// WebhookEventSink sends events to an HTTP endpoint in the background, one request per
+// event, in the order they were published.
+type WebhookEventSink struct {
+	WebhookEventSinkConfig
+
+	lock   sync.Mutex
+	queue  chan Event
+	closed bool
+	done   chan struct{}
+
+	// Cancelled when Close times out, to stop sending events.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Number of events dropped because Close timed out. Only set once done is closed.
+	dropped int
+}
+
+func NewWebhookEventSink(config WebhookEventSinkConfig) (*WebhookEventSink, error) {
+	if config.URL == "" {
+		return nil, errors.New("URL must be specified")
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultWebhookQueueSize
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = DefaultWebhookMaxAttempts
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = DefaultWebhookRetryDelay
+	}
+	if config.CloseTimeout <= 0 {
+		config.CloseTimeout = DefaultWebhookCloseTimeout
+	}
+	if config.Client == nil {
+		config.Client = DefaultHTTPClient
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := &WebhookEventSink{
+		WebhookEventSinkConfig: config,
+		queue:                  make(chan Event, config.QueueSize),
+		done:                   make(chan struct{}),
+		ctx:                    ctx,
+		cancel:                 cancel,
+	}
+	go sink.run()
+	return sink, nil
+}
+
+// HandleEvent queues event to be sent, or drops it if the queue is full or the sink is closed.
+func (s *WebhookEventSink) HandleEvent(event Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	if s.RedactKeywords {
+		event = event.redactKeyword()
+	}
+
+	select {
+	case s.queue <- event:
+	default:
+		s.Log.Warn("webhook queue full, dropping event", "type", event.Type)
+	}
+}
+
+// Close stops accepting events, and waits up to CloseTimeout for the queued events to be
+// sent. Returns an error if any were dropped because it timed out.
+func (s *WebhookEventSink) Close() error {
+	s.lock.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.lock.Unlock()
+
+	timer := time.NewTimer(s.CloseTimeout)
+	defer timer.Stop()
+	select {
+	case <-s.done:
+	case <-timer.C:
+		s.cancel()
+		<-s.done
+	}
+	s.cancel() // Releases the context if everything was sent.
+
+	if s.dropped > 0 {
+		return fmt.Errorf("timed out sending events to webhook, dropped %d", s.dropped)
+	}
+	return nil
+}
+
+func (s *WebhookEventSink) run() {
+	defer close(s.done)
+	for event := range s.queue {
+		if s.ctx.Err() != nil {
+			s.dropped++
+			continue
+		}
+		if err := s.send(event); err != nil {
+			if s.ctx.Err() != nil {
+				s.dropped++
+				continue
+			}
+			s.Log.Error("error sending event to webhook, dropping it", "type", event.Type,
+				"attempts", s.MaxAttempts, "err", err)
+		}
+	}
+}
+
+// send tries to post event up to MaxAttempts times, and returns the last error.
+func (s *WebhookEventSink) send(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	delay := s.RetryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(data)
+		if err == nil || !retry || attempt == s.MaxAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-s.ctx.Done():
+			timer.Stop()
+			return s.ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// post returns true if a failed request should be retried.
+func (s *WebhookEventSink) post(data []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(req.WithContext(s.ctx))
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return false, fmt.Errorf("unexpected response: %s", resp.Status)
+}
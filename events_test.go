@@ -0,0 +1,272 @@
+package memebot
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// eventTypes returns the types of events, ignoring their other fields.
+func eventTypes(events []Event) []EventType {
+	var types []EventType
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func newTestEventBus() (*EventBus, *MemoryEventSink) {
+	bus := NewEventBus()
+	sink := NewMemoryEventSink()
+	bus.Subscribe(sink)
+	return bus, sink
+}
+
+func TestEventBusPublishesToAllSinks(t *testing.T) {
+	bus, first := newTestEventBus()
+	second := NewMemoryEventSink()
+	bus.Subscribe(second)
+
+	at := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	bus.Publish(Event{Type: EventReload, Time: at, Memes: 3})
+	bus.Publish(Event{Type: EventMiss})
+
+	assert.Equal(t, first.Events(), second.Events())
+	events := first.Events()
+	require.Len(t, events, 2)
+	assert.Equal(t, Event{Type: EventReload, Time: at, Memes: 3}, events[0])
+	assert.False(t, events[1].Time.IsZero())
+
+	// Publishing without a bus does nothing.
+	var nilBus *EventBus
+	nilBus.Publish(Event{Type: EventMiss})
+}
+
+func TestHandleMessagePublishesEvents(t *testing.T) {
+	meme := NewMockMeme("http://keyword.jpg")
+
+	searcher, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, false, "name do keyword")
+	searcher.On("FindMeme", "keyword").Return(meme, nil)
+	bus, sink := newTestEventBus()
+	config.Events = bus
+	msg.Channel = "C123"
+	resp := respondToMessage(context.Background(), user, config, ChannelSettings{}, msg)
+
+	// Posted memes are published by the bot once the reply has been sent.
+	assert.Equal(t, []EventType{EventMessageMatched}, eventTypes(sink.Events()))
+	require.NotNil(t, resp.Posted)
+	assert.Equal(t, Event{Type: EventMemePosted, Channel: "C123", Keyword: "keyword", Meme: "http://keyword.jpg"},
+		*resp.Posted)
+
+	searcher, user, config, msg = CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, false, "name do keyword")
+	searcher.On("FindMeme", "keyword").Return(nil, ErrNoMemeFound)
+	bus, sink = newTestEventBus()
+	config.Events = bus
+	handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Equal(t, []EventType{EventMessageMatched, EventMiss}, eventTypes(sink.Events()))
+
+	searcher, user, config, msg = CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, false, "name do keyword")
+	searcher.On("FindMeme", "keyword").Return(nil, errors.New("search on fire"))
+	bus, sink = newTestEventBus()
+	config.Events = bus
+	handleMessage(user, config, ChannelSettings{}, msg)
+	events := sink.Events()
+	assert.Equal(t, []EventType{EventMessageMatched, EventError}, eventTypes(events))
+	assert.Equal(t, "search on fire", events[1].Error)
+
+	// Misses without a mention aren't published, like they aren't logged.
+	searcher, user, config, msg = CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, true, "do keyword")
+	searcher.On("FindMeme", "keyword").Return(nil, ErrNoMemeFound)
+	bus, sink = newTestEventBus()
+	config.Events = bus
+	handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Empty(t, sink.Events())
+}
+
+func TestMemeBotPublishesConnectionChanges(t *testing.T) {
+	bus, sink := newTestEventBus()
+	bot := MemeBot{config: MemeBotConfig{Events: bus}}
+
+	bot.updateStatus(&slack.ConnectingEvent{})
+	bot.updateStatus(&slack.ConnectedEvent{})
+	bot.updateStatus(&slack.LatencyReport{Value: time.Second})
+	bot.updateStatus(&slack.ConnectedEvent{})
+	bot.updateStatus(&slack.DisconnectedEvent{})
+
+	var states []ConnectionState
+	for _, event := range sink.Events() {
+		assert.Equal(t, EventConnection, event.Type)
+		states = append(states, event.State)
+	}
+	assert.Equal(t, []ConnectionState{StateConnecting, StateConnected, StateDisconnected}, states)
+}
+
+func TestCompositeMemepositoryPublishesReloads(t *testing.T) {
+	bus, sink := newTestEventBus()
+	source := &MockMemepository{NewTestMemeIndex(NewMockMeme("http://a.com", "foo"))}
+	m := &CompositeMemepository{
+		Sources: []MemepositorySource{{"a", source}},
+		Events:  bus,
+	}
+
+	m.Load()
+	m.Load()
+	events := sink.Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, EventReload, events[0].Type)
+	assert.Equal(t, 1, events[0].Memes)
+
+	m = &CompositeMemepository{
+		Sources: []MemepositorySource{{"a", FailingMemepository{errors.New("bucket on fire")}}},
+		Events:  bus,
+	}
+	m.Load()
+	events = sink.Events()
+	require.Len(t, events, 2)
+	assert.Equal(t, ErrAllSourcesFailed.Error(), events[1].Error)
+}
+
+func TestFileEventSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memebot-events")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.jsonl")
+	sink, err := OpenFileEventSink(path)
+	require.NoError(t, err)
+	at := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	sink.HandleEvent(Event{Type: EventMiss, Time: at, Channel: "C123", Keyword: "cat"})
+	sink.HandleEvent(Event{Type: EventConnection, Time: at, State: StateConnected})
+	sink.RedactKeywords = true
+	sink.HandleEvent(Event{Type: EventMiss, Time: at, Channel: "C123", Keyword: "secret plans"})
+	require.NoError(t, sink.Close())
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `{"type":"miss","time":"2016-01-02T03:04:05Z","channel":"C123","keyword":"cat"}
+{"type":"connection","time":"2016-01-02T03:04:05Z","state":"connected"}
+{"type":"miss","time":"2016-01-02T03:04:05Z","channel":"C123","keyword":"[redacted]"}
+`, string(data))
+}
+
+func TestWebhookEventSinkRetries(t *testing.T) {
+	var lock sync.Mutex
+	var attempts int
+	var received []Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		assert.Equal(t, "POST", req.Method)
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+
+		attempts++
+		if attempts == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		var event Event
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&event))
+		received = append(received, event)
+	}))
+	defer server.Close()
+
+	sink, err := NewWebhookEventSink(WebhookEventSinkConfig{
+		URL:            server.URL,
+		RetryDelay:     time.Millisecond,
+		RedactKeywords: true,
+		Log:            NewDiscardLogger(),
+	})
+	require.NoError(t, err)
+	sink.HandleEvent(Event{Type: EventMiss, Keyword: "cat"})
+	sink.HandleEvent(Event{Type: EventReload, Memes: 2})
+	require.NoError(t, sink.Close())
+
+	// Events after closing are dropped.
+	sink.HandleEvent(Event{Type: EventMiss})
+
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, []EventType{EventMiss, EventReload}, eventTypes(received))
+	assert.Equal(t, RedactedText, received[0].Keyword)
+}
+
+func TestWebhookEventSinkGivesUp(t *testing.T) {
+	var lock sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		paths = append(paths, req.URL.Path)
+		if strings.HasSuffix(req.URL.Path, "/bad") {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "on fire", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	// Server errors are retried up to MaxAttempts times.
+	sink, err := NewWebhookEventSink(WebhookEventSinkConfig{
+		URL:         server.URL + "/fire",
+		MaxAttempts: 2,
+		RetryDelay:  time.Millisecond,
+		Log:         NewDiscardLogger(),
+	})
+	require.NoError(t, err)
+	sink.HandleEvent(Event{Type: EventMiss})
+	require.NoError(t, sink.Close())
+	assert.Equal(t, []string{"/fire", "/fire"}, paths)
+
+	// Client errors aren't retried.
+	paths = nil
+	sink, err = NewWebhookEventSink(WebhookEventSinkConfig{
+		URL:        server.URL + "/bad",
+		RetryDelay: time.Millisecond,
+		Log:        NewDiscardLogger(),
+	})
+	require.NoError(t, err)
+	sink.HandleEvent(Event{Type: EventMiss})
+	require.NoError(t, sink.Close())
+	assert.Equal(t, []string{"/bad"}, paths)
+
+	_, err = NewWebhookEventSink(WebhookEventSinkConfig{})
+	assert.Error(t, err)
+}
+
+func TestWebhookEventSinkCloseTimesOut(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-unblock:
+		case <-req.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(unblock)
+
+	sink, err := NewWebhookEventSink(WebhookEventSinkConfig{
+		URL:          server.URL,
+		CloseTimeout: 50 * time.Millisecond,
+		Log:          NewDiscardLogger(),
+	})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		sink.HandleEvent(Event{Type: EventMiss})
+	}
+
+	start := time.Now()
+	assert.EqualError(t, sink.Close(), "timed out sending events to webhook, dropped 3")
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
@@ -0,0 +1,342 @@
+package memebot
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExportedMemeIndex is the JSON representation of a MemeIndex served by NewIndexExportHandler
+// and loaded by RemoteMemepository.
+type ExportedMemeIndex struct {
+	Memes []ExportedMeme `json:"memes"`
+}
+
+type ExportedMeme struct {
+	URL      string   `json:"url"`
+	Keywords []string `json:"keywords"`
+
+	// Hash of the image data, if known. Used to deduplicate memes from multiple instances.
+	Hash string `json:"hash,omitempty"`
+
+	// If true, instances that load the index only post the meme in channels that allow NSFW memes.
+	NSFW bool `json:"nsfw,omitempty"`
+}
+
+// ExportMemeIndex returns the exported representation of memes. Retired memes are left out.
+func ExportMemeIndex(memes *MemeIndex) ExportedMemeIndex {
+	exported := ExportedMemeIndex{
+		Memes: make([]ExportedMeme, 0, memes.Len()),
+	}
+	for _, meme := range memes.Memes() {
+		flags := memeFlags(meme)
+		if flags.Retired {
+			continue
+		}
+		exported.Memes = append(exported.Memes, ExportedMeme{
+			URL:      meme.URL().String(),
+			Keywords: meme.Keywords(),
+			Hash:     contentHash(meme),
+			NSFW:     flags.NSFW,
+		})
+	}
+	return exported
+}
+
+type IndexExportConfig struct {
+	Memepository Memepository
+
+	// If not empty, requests must have an "Authorization: Bearer <Token>" header.
+	// Required to keep signed links private, since the index links to every meme.
+	Token string
+
+	// If non-zero, sent as the max-age of the index, so RemoteMemepositories download it
+	// again to get new links. Should leave time to refresh before the links expire.
+	MaxAge time.Duration
+
+	Log *Logger // Defaults to DefaultLogger.
+}
+
+// NewIndexExportHandler returns a handler that serves the index of a memepository as JSON,
+// so it can be loaded by a RemoteMemepository on another memebot instance.
+// Responses are tagged with an ETag so clients only need to download the index when it changes.
+// The ETag ignores link signatures, so signing links again doesn't count as a change.
+func NewIndexExportHandler(config IndexExportConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if config.Token != "" {
+			expected := "Bearer " + config.Token
+			if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte(expected)) != 1 {
+				config.Log.Warn("unauthorized index export request", "remote", req.RemoteAddr)
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		memes, err := config.Memepository.Load()
+		if err != nil {
+			config.Log.Error("error loading memes for export", "err", err)
+			http.Error(w, "error loading memes", http.StatusInternalServerError)
+			return
+		}
+
+		exported := ExportMemeIndex(memes)
+		data, err := json.Marshal(exported)
+		if err != nil {
+			config.Log.Error("error encoding memes for export", "err", err)
+			http.Error(w, "error encoding memes", http.StatusInternalServerError)
+			return
+		}
+
+		etag := exportedIndexETag(exported)
+		w.Header().Set("ETag", etag)
+		if config.MaxAge > 0 {
+			w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int64(config.MaxAge/time.Second)))
+		}
+
+		if etagMatches(req.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+}
+
+// exportedIndexETag returns a weak ETag for the memes in exported, ignoring the parameters
+// that sign their links.
+func exportedIndexETag(exported ExportedMemeIndex) string {
+	hash := sha1.New()
+	for _, meme := range exported.Memes {
+		fmt.Fprintf(hash, "%s %q %s %t\n", unsignedURL(meme.URL), meme.Keywords, meme.Hash, meme.NSFW)
+	}
+	return `W/"` + hex.EncodeToString(hash.Sum(nil)) + `"`
+}
+
+// unsignedURL removes the parameters added by ObjectServer and S3 presigning from rawURL.
+func unsignedURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := parsed.Query()
+	for name := range query {
+		if name == signatureExpiresParam || name == signatureKeyIdParam || name == signatureParam ||
+			strings.HasPrefix(name, "X-Amz-") {
+			query.Del(name)
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+type RemoteMemepositoryConfig struct {
+	URL string // URL of another memebot's index export.
+
+	// How often to check for changes to the remote index. If zero, the index is only loaded once.
+	RefreshInterval time.Duration
+
+	// Sent as a bearer token, if not empty, for instances that require one to export their index.
+	Token string
+
+	Client *http.Client // Defaults to DefaultHTTPClient.
+
+	Log *Logger // Defaults to DefaultLogger.
+}
+
+// RemoteMemepository is a Memepository that loads the index exported by another memebot instance.
+// Memes keep their remote URLs, so they are served by the other instance.
+type RemoteMemepository struct {
+	RemoteMemepositoryConfig
+
+	loadOnce sync.Once
+	stopOnce sync.Once
+	stop     chan struct{}
+
+	lock  sync.RWMutex
+	memes *MemeIndex
+	etag  string
+	// When the links in memes expire, if they do.
+	expires time.Time
+	loadErr error
+}
+
+func NewRemoteMemepository(config RemoteMemepositoryConfig) *RemoteMemepository {
+	if config.Client == nil {
+		config.Client = DefaultHTTPClient
+	}
+	return &RemoteMemepository{
+		RemoteMemepositoryConfig: config,
+		stop:                     make(chan struct{}),
+	}
+}
+
+// Load returns the most recently loaded index. The first call loads the index and
+// starts refreshing it in the background.
+// Once an index has been loaded, refresh errors are logged but not returned.
+func (m *RemoteMemepository) Load() (*MemeIndex, error) {
+	m.loadOnce.Do(func() {
+		m.Refresh()
+		if m.RefreshInterval > 0 {
+			go m.refreshPeriodically()
+		}
+	})
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.memes != nil {
+		return m.memes, nil
+	}
+	return nil, m.loadErr
+}
+
+// Stop stops refreshing the index in the background. It's safe to call more than once.
+func (m *RemoteMemepository) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+func (m *RemoteMemepository) refreshPeriodically() {
+	ticker := time.NewTicker(m.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.Refresh()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Refresh loads the remote index if it has changed since it was last loaded.
+func (m *RemoteMemepository) Refresh() error {
+	m.lock.RLock()
+	etag := m.etag
+	if !m.expires.IsZero() && !time.Now().Before(m.expires) {
+		// Download the index again to get new links, even if it hasn't changed.
+		etag = ""
+	}
+	m.lock.RUnlock()
+
+	memes, newEtag, expires, err := m.fetch(etag)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.loadErr = err
+	if err != nil {
+		m.Log.Error("error loading remote memes", "url", m.URL, "err", err)
+		return err
+	}
+	if memes != nil {
+		m.Log.Info("loaded remote memes", "url", m.URL, "count", memes.Len())
+		m.memes = memes
+		m.etag = newEtag
+		m.expires = expires
+	}
+	return nil
+}
+
+// fetch returns a nil index if the remote index hasn't changed since etag.
+// If the index has a max-age, expires is when its links expire.
+func (m *RemoteMemepository) fetch(etag string) (memes *MemeIndex, newEtag string, expires time.Time, err error) {
+	req, err := http.NewRequest("GET", m.URL, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Accept", "application/json")
+	if m.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+m.Token)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return
+	case http.StatusOK:
+	default:
+		err = fmt.Errorf("unexpected response: %s", resp.Status)
+		return
+	}
+
+	var exported ExportedMemeIndex
+	if err = json.NewDecoder(resp.Body).Decode(&exported); err != nil {
+		err = errors.New("error decoding index: " + err.Error())
+		return
+	}
+
+	memes = NewMemeIndex()
+	for _, exportedMeme := range exported.Memes {
+		memeURL, err := url.Parse(exportedMeme.URL)
+		if err != nil || !memeURL.IsAbs() {
+			m.Log.Warn("ignoring remote meme with invalid url", "url", m.URL, "meme", exportedMeme.URL)
+			continue
+		}
+		memes.Add(&RemoteMeme{memeURL, exportedMeme.Keywords, exportedMeme.Hash, MemeFlags{NSFW: exportedMeme.NSFW}})
+	}
+
+	newEtag = resp.Header.Get("ETag")
+	if maxAge, ok := parseMaxAge(resp.Header.Get("Cache-Control")); ok {
+		expires = time.Now().Add(maxAge)
+	}
+	return
+}
+
+// parseMaxAge returns the max-age directive of a Cache-Control header, if it has one.
+func parseMaxAge(cacheControl string) (time.Duration, bool) {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		if !strings.HasPrefix(directive, "max-age=") {
+			continue
+		}
+		seconds, err := strconv.ParseInt(strings.TrimPrefix(directive, "max-age="), 10, 64)
+		if err != nil || seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, false
+}
+
+type RemoteMeme struct {
+	url      *url.URL
+	keywords []string
+	hash     string
+	flags    MemeFlags
+}
+
+var _ FlaggedMeme = &RemoteMeme{}
+
+func (m *RemoteMeme) URL() *url.URL {
+	return m.url
+}
+
+func (m *RemoteMeme) Keywords() []string {
+	return m.keywords
+}
+
+func (m *RemoteMeme) ContentHash() string {
+	return m.hash
+}
+
+func (m *RemoteMeme) Flags() MemeFlags {
+	return m.flags
+}
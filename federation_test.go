@@ -0,0 +1,242 @@
+package memebot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexExportHandler(t *testing.T) {
+	handler := NewIndexExportHandler(IndexExportConfig{
+		Memepository: &MockMemepository{NewTestMemeIndex(
+			NewMockHashedMeme("http://foo.com/foo.jpg", "hash", "foo", "bar"),
+			NewMockMeme("http://bar.com/bar.jpg", "bar"),
+			NewMockFlaggedMeme("http://baz.com/nsfw.jpg", MemeFlags{NSFW: true}, "baz"),
+			NewMockFlaggedMeme("http://baz.com/retired.jpg", MemeFlags{Retired: true}, "baz"),
+		)},
+		Log: NewDiscardLogger(),
+	})
+
+	req, err := http.NewRequest("GET", "/index.json", nil)
+	require.NoError(t, err)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+	etag := resp.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	var exported ExportedMemeIndex
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &exported))
+	assert.Equal(t, ExportedMemeIndex{Memes: []ExportedMeme{
+		{URL: "http://foo.com/foo.jpg", Keywords: []string{"foo", "bar"}, Hash: "hash"},
+		{URL: "http://bar.com/bar.jpg", Keywords: []string{"bar"}},
+		{URL: "http://baz.com/nsfw.jpg", Keywords: []string{"baz"}, NSFW: true},
+	}}, exported)
+
+	for _, ifNoneMatch := range []string{etag, `"other", ` + etag, "*"} {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		resp = httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusNotModified, resp.Code, ifNoneMatch)
+		assert.Equal(t, 0, resp.Body.Len(), ifNoneMatch)
+	}
+}
+
+func TestRemoteMemepository(t *testing.T) {
+	exported := &MockMemepository{NewTestMemeIndex(
+		NewMockHashedMeme("http://foo.com/foo.jpg", "hash", "foo"),
+	)}
+	handler := NewIndexExportHandler(IndexExportConfig{Memepository: exported, Log: NewDiscardLogger()})
+
+	var requests, conditionalRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		if req.Header.Get("If-None-Match") != "" {
+			conditionalRequests++
+		}
+		handler.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	remote := NewRemoteMemepository(RemoteMemepositoryConfig{URL: server.URL})
+	memes, err := remote.Load()
+	require.NoError(t, err)
+	require.Equal(t, 1, memes.Len())
+	meme := memes.FindByKeyword("foo")[0]
+	assert.Equal(t, "http://foo.com/foo.jpg", meme.URL().String())
+	assert.Equal(t, "hash", contentHash(meme))
+
+	// Unchanged index.
+	require.NoError(t, remote.Refresh())
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, 1, conditionalRequests)
+	unchanged, err := remote.Load()
+	require.NoError(t, err)
+	assert.True(t, memes == unchanged)
+
+	exported.index = NewTestMemeIndex(NewMockMeme("http://bar.com/bar.jpg", "bar"))
+	require.NoError(t, remote.Refresh())
+	memes, err = remote.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bar"}, memes.Keywords())
+
+	remote.Stop()
+	remote.Stop()
+}
+
+func TestRemoteMemepositoryNSFW(t *testing.T) {
+	server := httptest.NewServer(NewIndexExportHandler(IndexExportConfig{
+		Memepository: &MockMemepository{NewTestMemeIndex(
+			NewMockFlaggedMeme("http://foo.com/foo.jpg", MemeFlags{NSFW: true}, "keyword"),
+		)},
+		Log: NewDiscardLogger(),
+	}))
+	defer server.Close()
+
+	_, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, false, "name do keyword")
+	config.Searcher = &MemepositorySearcher{NewRemoteMemepository(RemoteMemepositoryConfig{URL: server.URL})}
+
+	reply := handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Equal(t, "Sorry, I couldn't find a meme for “keyword”.", reply)
+
+	reply = handleMessage(user, config, ChannelSettings{AllowNSFW: true}, msg)
+	assert.Equal(t, "http://foo.com/foo.jpg", reply)
+}
+
+func TestRemoteMemepositoryKeepsIndexOnError(t *testing.T) {
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if fail {
+			http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"memes": [{"url": "http://foo.com/foo.jpg", "keywords": ["foo"]}, {"url": "relative.jpg"}]}`))
+	}))
+	defer server.Close()
+
+	remote := NewRemoteMemepository(RemoteMemepositoryConfig{URL: server.URL})
+	memes, err := remote.Load()
+	require.NoError(t, err)
+	assert.Equal(t, 1, memes.Len())
+
+	fail = true
+	assert.Error(t, remote.Refresh())
+	stale, err := remote.Load()
+	assert.NoError(t, err)
+	assert.True(t, memes == stale)
+}
+
+func TestRemoteMemepositoryLoadError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	_, err := NewRemoteMemepository(RemoteMemepositoryConfig{URL: server.URL}).Load()
+	assert.Error(t, err)
+}
+
+func TestIndexExportSignedLinksRequireToken(t *testing.T) {
+	memepository, router, dir := newTestFileServingMemepository(t,
+		map[string]string{"foo.jpg": "foo data"}, ObjectServerConfig{
+			SigningKeys: []SigningKey{{"key", []byte("secret")}},
+		})
+	defer os.RemoveAll(dir)
+	handler := NewIndexExportHandler(IndexExportConfig{
+		Memepository: memepository,
+		Token:        "token",
+		Log:          NewDiscardLogger(),
+	})
+
+	resp := serveTestRequest(t, handler, "/index.json", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = serveTestRequest(t, handler, "/index.json", http.Header{"Authorization": {"Bearer wrong"}})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.NotContains(t, resp.Body.String(), "sig=")
+
+	resp = serveTestRequest(t, handler, "/index.json", http.Header{"Authorization": {"Bearer token"}})
+	require.Equal(t, http.StatusOK, resp.Code)
+	var exported ExportedMemeIndex
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &exported))
+	require.Len(t, exported.Memes, 1)
+	assert.Equal(t, http.StatusOK, serveTestRequest(t, router, exported.Memes[0].URL, nil).Code)
+
+	// Remote instances send the token.
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	_, err := NewRemoteMemepository(RemoteMemepositoryConfig{URL: server.URL}).Load()
+	assert.EqualError(t, err, "unexpected response: 401 Unauthorized")
+	_, err = NewRemoteMemepository(RemoteMemepositoryConfig{URL: server.URL, Token: "token"}).Load()
+	assert.NoError(t, err)
+}
+
+func TestIndexExportETagIgnoresSignatures(t *testing.T) {
+	memepository, _, dir := newTestFileServingMemepository(t,
+		map[string]string{"foo.jpg": "foo data"}, ObjectServerConfig{
+			SigningKeys:     []SigningKey{{"key", []byte("secret")}},
+			SignedURLExpiry: time.Hour,
+		})
+	defer os.RemoveAll(dir)
+	handler := NewIndexExportHandler(IndexExportConfig{
+		Memepository: memepository,
+		MaxAge:       15 * time.Minute,
+		Log:          NewDiscardLogger(),
+	})
+
+	now := time.Unix(1500000000, 0)
+	memepository.server.signer.now = func() time.Time { return now }
+	resp := serveTestRequest(t, handler, "/index.json", nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "private, max-age=900", resp.Header().Get("Cache-Control"))
+	etag := resp.Header().Get("ETag")
+	body := resp.Body.String()
+
+	// Links are signed with a new expiry time, but the index is the same.
+	now = now.Add(time.Hour)
+	resp = serveTestRequest(t, handler, "/index.json", nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.NotEqual(t, body, resp.Body.String())
+	assert.Equal(t, etag, resp.Header().Get("ETag"))
+
+	require.NoError(t, os.Rename(filepath.Join(dir, "foo.jpg"), filepath.Join(dir, "bar.jpg")))
+	require.NoError(t, memepository.Reload())
+	resp = serveTestRequest(t, handler, "/index.json", nil)
+	assert.NotEqual(t, etag, resp.Header().Get("ETag"))
+}
+
+func TestRemoteMemepositoryDownloadsExpiredIndex(t *testing.T) {
+	var maxAge string
+	var requests, conditionalRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		if req.Header.Get("If-None-Match") != "" {
+			conditionalRequests++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Cache-Control", maxAge)
+		w.Write([]byte(`{"memes": [{"url": "http://foo.com/foo.jpg", "keywords": ["foo"]}]}`))
+	}))
+	defer server.Close()
+
+	maxAge = "private, max-age=3600"
+	remote := NewRemoteMemepository(RemoteMemepositoryConfig{URL: server.URL})
+	require.NoError(t, remote.Refresh())
+	require.NoError(t, remote.Refresh())
+	assert.Equal(t, 1, conditionalRequests)
+
+	maxAge = "private, max-age=0"
+	remote = NewRemoteMemepository(RemoteMemepositoryConfig{URL: server.URL})
+	require.NoError(t, remote.Refresh())
+	require.NoError(t, remote.Refresh())
+	assert.Equal(t, 1, conditionalRequests)
+	assert.Equal(t, 4, requests)
+}
@@ -0,0 +1,223 @@
+package memebot
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// GalleryThumbnailSize is the width and height of the thumbnails in the gallery.
+const GalleryThumbnailSize = 240
+
+// Gallery serves HTML pages that show every meme with its keywords. Memes are loaded from
+// the memepository on every request, so the pages are always up to date.
+type Gallery struct {
+	memepository Memepository
+	mediaTypes   MediaTypes
+	indexRoute   *mux.Route
+	tagRoute     *mux.Route
+	log          *Logger
+}
+
+// CreateGallery serves the gallery of memepository from router: all memes at /, optionally
+// filtered with ?q=, and memes with a single keyword at /tag/{keyword}. mediaTypes is used
+// to recognize videos, and defaults to DefaultMediaTypes. Errors are written to log, or
+// DefaultLogger if nil.
+func CreateGallery(router *mux.Router, memepository Memepository, mediaTypes MediaTypes, log *Logger) *Gallery {
+	gallery := &Gallery{
+		memepository: memepository,
+		mediaTypes:   mediaTypes,
+		log:          log,
+	}
+	gallery.indexRoute = router.Path("/").HandlerFunc(gallery.serveIndex)
+	gallery.tagRoute = router.Path("/tag/{keyword:.+}").HandlerFunc(gallery.serveTag)
+	return gallery
+}
+
+type galleryPage struct {
+	Title    string
+	IndexURL string
+	Query    string
+
+	Memes []galleryMeme
+
+	// Only listed on the index page.
+	Keywords []galleryKeyword
+}
+
+type galleryMeme struct {
+	URL          string
+	ThumbnailURL string
+	Video        bool
+	NSFW         bool
+	Keywords     []galleryKeyword
+}
+
+type galleryKeyword struct {
+	Keyword string
+	URL     string
+	Count   int
+}
+
+func (g *Gallery) serveIndex(w http.ResponseWriter, req *http.Request) {
+	memes, ok := g.loadMemes(w)
+	if !ok {
+		return
+	}
+
+	query := strings.TrimSpace(req.URL.Query().Get("q"))
+	page := galleryPage{
+		Title: "All memes",
+		Query: query,
+	}
+	if query == "" {
+		for _, keyword := range memes.Keywords() {
+			page.Keywords = append(page.Keywords, g.keyword(keyword, len(memes.FindByKeyword(keyword))))
+		}
+		page.Memes = g.galleryMemes(memes.Memes())
+	} else {
+		page.Title = "Memes matching “" + query + "”"
+		page.Memes = g.galleryMemes(filterMemesByKeyword(memes.Memes(), query))
+	}
+	g.render(w, page)
+}
+
+func (g *Gallery) serveTag(w http.ResponseWriter, req *http.Request) {
+	memes, ok := g.loadMemes(w)
+	if !ok {
+		return
+	}
+
+	keyword := mux.Vars(req)["keyword"]
+	found := memes.FindByKeyword(keyword)
+	if len(found) == 0 {
+		http.NotFound(w, req)
+		return
+	}
+
+	g.render(w, galleryPage{
+		Title: "Memes tagged “" + keyword + "”",
+		Memes: g.galleryMemes(found),
+	})
+}
+
+func (g *Gallery) loadMemes(w http.ResponseWriter) (*MemeIndex, bool) {
+	memes, err := g.memepository.Load()
+	if err != nil {
+		g.log.Error("error loading memes for gallery", "err", err)
+		http.Error(w, "error loading memes", http.StatusInternalServerError)
+		return nil, false
+	}
+	return memes, true
+}
+
+func (g *Gallery) render(w http.ResponseWriter, page galleryPage) {
+	indexURL, err := g.indexRoute.URL()
+	if err != nil {
+		panic(err)
+	}
+	page.IndexURL = indexURL.String()
+
+	// Render to a buffer so errors can still be reported with a status code.
+	var buf bytes.Buffer
+	if err := galleryTemplate.Execute(&buf, page); err != nil {
+		g.log.Error("error rendering gallery", "err", err)
+		http.Error(w, "error rendering gallery", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+func (g *Gallery) galleryMemes(memes []Meme) []galleryMeme {
+	result := make([]galleryMeme, len(memes))
+	for i, meme := range memes {
+		result[i] = galleryMeme{
+			URL:          meme.URL().String(),
+			ThumbnailURL: thumbnailURL(meme).String(),
+			Video:        g.mediaTypes.isVideo(meme),
+			NSFW:         memeFlags(meme).NSFW,
+		}
+		for _, keyword := range meme.Keywords() {
+			result[i].Keywords = append(result[i].Keywords, g.keyword(keyword, 0))
+		}
+	}
+	return result
+}
+
+func (g *Gallery) keyword(keyword string, count int) galleryKeyword {
+	tagURL, err := g.tagRoute.URL("keyword", keyword)
+	if err != nil {
+		panic(err)
+	}
+	return galleryKeyword{keyword, tagURL.String(), count}
+}
+
+// thumbnailURL returns the URL of a small version of meme, if it can be resized, or the
+// meme's own URL otherwise.
+func thumbnailURL(meme Meme) *url.URL {
+	if transformable, ok := transformableMeme(meme); ok {
+		size := strconv.Itoa(GalleryThumbnailSize)
+		params := url.Values{"w": {size}, "h": {size}, "fit": {string(FitCover)}}
+		if thumbnail, err := transformable.TransformedURL(params); err == nil {
+			return thumbnail
+		}
+	}
+	return meme.URL()
+}
+
+// filterMemesByKeyword returns the memes with a keyword containing query, ignoring case.
+func filterMemesByKeyword(memes []Meme, query string) (filtered []Meme) {
+	query = normalizeKeyword(query)
+	for _, meme := range memes {
+		for _, keyword := range meme.Keywords() {
+			if strings.Contains(normalizeKeyword(keyword), query) {
+				filtered = append(filtered, meme)
+				break
+			}
+		}
+	}
+	return
+}
+
+var galleryTemplate = template.Must(template.New("gallery").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} – memebot</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+form { margin-bottom: 1em; }
+.keywords a { margin-right: 0.5em; }
+.grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(240px, 1fr)); gap: 1em; }
+.meme img, .meme video { width: 100%; height: 240px; object-fit: cover; background: #eee; }
+.nsfw img, .nsfw video { filter: blur(24px); }
+.nsfw:hover img, .nsfw:hover video { filter: none; }
+</style>
+</head>
+<body>
+<h1><a href="{{.IndexURL}}">memebot</a>: {{.Title}}</h1>
+<form action="{{.IndexURL}}" method="get">
+<input type="search" name="q" value="{{.Query}}" placeholder="Search keywords">
+<button type="submit">Search</button>
+</form>
+{{with .Keywords}}<details>
+<summary>{{len .}} keywords</summary>
+<p class="keywords">{{range .}}<a href="{{.URL}}">{{.Keyword}}</a>({{.Count}}) {{end}}</p>
+</details>
+{{end}}<p>{{len .Memes}} memes</p>
+<div class="grid">
+{{range .Memes}}<div class="meme{{if .NSFW}} nsfw{{end}}">
+{{if .Video}}<video src="{{.URL}}" preload="none" controls muted></video>{{else}}<a href="{{.URL}}"><img src="{{.ThumbnailURL}}" loading="lazy" alt=""></a>{{end}}
+<div class="keywords">{{range .Keywords}}<a href="{{.URL}}">{{.Keyword}}</a>{{end}}</div>
+</div>
+{{end}}</div>
+</body>
+</html>
+`))
@@ -0,0 +1,116 @@
+package memebot
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestGallery(memepository Memepository) *mux.Router {
+	router := mux.NewRouter()
+	CreateGallery(router.PathPrefix("/gallery").Subrouter(), memepository, nil, NewDiscardLogger())
+	return router
+}
+
+func TestGalleryIndex(t *testing.T) {
+	memepository := &MockMemepository{NewTestMemeIndex(
+		NewMockMeme("http://example.com/grumpy.jpg", "grumpy", "cat"),
+		NewMockMeme("http://example.com/dance.mp4", "dance"),
+		NewMockMeme("http://example.com/longcat.png", "cat"),
+	)}
+	router := newTestGallery(memepository)
+
+	resp := serveTestRequest(t, router, "/gallery/", nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header().Get("Content-Type"))
+	body := resp.Body.String()
+
+	assert.Contains(t, body, "3 memes")
+	assert.Contains(t, body, `<img src="http://example.com/grumpy.jpg" loading="lazy"`)
+	assert.Contains(t, body, `<video src="http://example.com/dance.mp4"`)
+	assert.Contains(t, body, `<a href="/gallery/tag/cat">cat</a>(2)`)
+	assert.Contains(t, body, `<a href="/gallery/tag/dance">dance</a>(1)`)
+}
+
+func TestGallerySearch(t *testing.T) {
+	memepository := &MockMemepository{NewTestMemeIndex(
+		NewMockMeme("http://example.com/grumpy.jpg", "Grumpy Cat"),
+		NewMockMeme("http://example.com/dance.gif", "dance"),
+	)}
+	router := newTestGallery(memepository)
+
+	resp := serveTestRequest(t, router, "/gallery/?q=CAT", nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	body := resp.Body.String()
+	assert.Contains(t, body, "1 memes")
+	assert.Contains(t, body, "grumpy.jpg")
+	assert.NotContains(t, body, "dance.gif")
+	assert.Contains(t, body, `value="CAT"`)
+}
+
+func TestGalleryTag(t *testing.T) {
+	memepository := &MockMemepository{NewTestMemeIndex(
+		NewMockMeme("http://example.com/grumpy.jpg", "party parrot"),
+		NewMockMeme("http://example.com/dance.gif", "dance"),
+	)}
+	router := newTestGallery(memepository)
+
+	resp := serveTestRequest(t, router, "/gallery/", nil)
+	assert.Contains(t, resp.Body.String(), `href="/gallery/tag/party%20parrot"`)
+
+	resp = serveTestRequest(t, router, "/gallery/tag/Party%20Parrot", nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	body := resp.Body.String()
+	assert.Contains(t, body, "Memes tagged “Party Parrot”")
+	assert.Contains(t, body, "grumpy.jpg")
+	assert.NotContains(t, body, "dance.gif")
+
+	resp = serveTestRequest(t, router, "/gallery/tag/nope", nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestGalleryEscapesKeywords(t *testing.T) {
+	memepository := &MockMemepository{NewTestMemeIndex(
+		NewMockMeme("http://example.com/x.jpg", "<script>alert(1)</script>"),
+	)}
+	router := newTestGallery(memepository)
+
+	resp := serveTestRequest(t, router, "/gallery/", nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.NotContains(t, resp.Body.String(), "<script>")
+}
+
+func TestGalleryThumbnails(t *testing.T) {
+	memepository, _, dir := newTestFileServingMemepository(t,
+		map[string]string{"grumpy.jpg": string(encodeTestImage(t, "jpeg", 100, 60))}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+	router := newTestGallery(memepository)
+
+	resp := serveTestRequest(t, router, "/gallery/", nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), "?fit=cover&amp;h=240&amp;w=240")
+}
+
+func TestGalleryStaysInSync(t *testing.T) {
+	memepository := &MockMemepository{NewTestMemeIndex(
+		NewMockMeme("http://example.com/grumpy.jpg", "grumpy"),
+	)}
+	router := newTestGallery(memepository)
+
+	resp := serveTestRequest(t, router, "/gallery/", nil)
+	assert.Contains(t, resp.Body.String(), "1 memes")
+
+	// Reloaded.
+	memepository.index = NewTestMemeIndex(
+		NewMockMeme("http://example.com/grumpy.jpg", "grumpy"),
+		NewMockMeme("http://example.com/dance.gif", "dance"),
+	)
+	resp = serveTestRequest(t, router, "/gallery/", nil)
+	body := resp.Body.String()
+	assert.Contains(t, body, "2 memes")
+	assert.Contains(t, body, "dance.gif")
+}
@@ -0,0 +1,111 @@
+package memebot
+
+import (
+	"bytes"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// GeneratedObjectStoreConfig configures a GeneratedObjectStore.
+type GeneratedObjectStoreConfig struct {
+	// Router to serve objects from.
+	Router *mux.Router
+
+	// Maximum total size of generated objects to keep. Defaults to DefaultObjectCacheBytes.
+	MaxBytes int64
+
+	Server ObjectServerConfig
+}
+
+// GeneratedObjectStore keeps images generated by the bot, e.g. captioned memes, in
+// memory and serves them through an ObjectServer. Objects are identified by a hash of
+// their content, and the least-recently-used objects are evicted once the store is full.
+type GeneratedObjectStore struct {
+	cache   *objectCache
+	server  *ObjectServer
+	created time.Time
+}
+
+var _ ObjectRepository = &GeneratedObjectStore{}
+
+func NewGeneratedObjectStore(config GeneratedObjectStoreConfig) *GeneratedObjectStore {
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = DefaultObjectCacheBytes
+	}
+
+	store := &GeneratedObjectStore{
+		cache:   newObjectCache(config.MaxBytes),
+		created: time.Now(),
+	}
+	store.server = CreateObjectServerWithConfig(config.Router, store, config.Server)
+	return store
+}
+
+// Add stores data, which must be in the format given by ext, and returns its ID.
+func (s *GeneratedObjectStore) Add(data []byte, ext string) (id string, err error) {
+	hash, err := generateSha1Base64Hash(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	id = hash + "." + ext
+	s.cache.Add(id, data)
+	return id, nil
+}
+
+func (s *GeneratedObjectStore) URL(id string) *url.URL {
+	return s.server.URL(id)
+}
+
+func (s *GeneratedObjectStore) FindObject(id string) (Object, bool) {
+	data, found := s.cache.Get(id)
+	if !found {
+		return nil, false
+	}
+	return &generatedObject{data, s.created}, true
+}
+
+type generatedObject struct {
+	data []byte
+
+	// Generated objects are immutable, so they're all considered to be modified when the
+	// store was created.
+	lastModified time.Time
+}
+
+func (o *generatedObject) Open() (ReadSeekerCloser, error) {
+	return nopCloser{bytes.NewReader(o.data)}, nil
+}
+
+func (o *generatedObject) LastModified() time.Time {
+	return o.lastModified
+}
+
+func (o *generatedObject) Size() int64 {
+	return int64(len(o.data))
+}
+
+// GeneratedMeme is a meme whose image was generated from other memes.
+type GeneratedMeme struct {
+	id       string
+	store    *GeneratedObjectStore
+	keywords []string
+}
+
+func (m *GeneratedMeme) URL() *url.URL {
+	return m.store.URL(m.id)
+}
+
+func (m *GeneratedMeme) TransformedURL(params url.Values) (*url.URL, error) {
+	return m.store.server.TransformedURL(m.id, params)
+}
+
+func (m *GeneratedMeme) Keywords() []string {
+	return m.keywords
+}
+
+func (m *GeneratedMeme) ContentHash() string {
+	return idContentHash(m.id)
+}
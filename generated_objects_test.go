@@ -0,0 +1,46 @@
+package memebot
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratedObjectStore(t *testing.T) {
+	router := mux.NewRouter()
+	store := NewGeneratedObjectStore(GeneratedObjectStoreConfig{Router: router})
+
+	id, err := store.Add([]byte("generated"), "png")
+	require.NoError(t, err)
+	assert.Regexp(t, `^[\w-]+=*\.png$`, id)
+
+	sameId, err := store.Add([]byte("generated"), "png")
+	require.NoError(t, err)
+	assert.Equal(t, id, sameId)
+
+	resp := serveTestRequest(t, router, store.URL(id).String(), nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "generated", resp.Body.String())
+	assert.Equal(t, "image/png", resp.Header().Get("Content-Type"))
+
+	resp = serveTestRequest(t, router, "/missing.png", nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestGeneratedObjectStoreEvicts(t *testing.T) {
+	store := NewGeneratedObjectStore(GeneratedObjectStoreConfig{Router: mux.NewRouter(), MaxBytes: 10})
+
+	first, err := store.Add([]byte("123456"), "png")
+	require.NoError(t, err)
+	second, err := store.Add([]byte("abcdef"), "png")
+	require.NoError(t, err)
+
+	_, found := store.FindObject(first)
+	assert.False(t, found)
+	object, found := store.FindObject(second)
+	require.True(t, found)
+	assert.Equal(t, int64(6), object.Size())
+}
@@ -0,0 +1,274 @@
+package memebot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxGIFFrames is the most frames a GIF may have to be transformed.
+	MaxGIFFrames = 500
+
+	// MaxGIFTotalPixels limits the total size of all the frames of a GIF that will be
+	// transformed, since frames may need to be expanded to the full size of the image.
+	// Each pixel takes a byte once decoded, and up to MaxConcurrentTransforms GIFs may be
+	// decoded at once.
+	MaxGIFTotalPixels = 25 * 1000 * 1000
+
+	// MaxTransformedGIFBytes is the largest GIF that transforms may generate.
+	MaxTransformedGIFBytes = 16 << 20
+
+	MinGIFSpeed = 0.1
+	MaxGIFSpeed = 10.0
+
+	// Browsers play frames with delays (in 100ths of a second) shorter than
+	// minGIFDelay as if they were defaultGIFDelay.
+	minGIFDelay     = 2
+	defaultGIFDelay = 10
+)
+
+var errMalformedGIF = errors.New("gif: malformed data")
+
+// gifTransform changes the playback of animated GIFs.
+type gifTransform struct {
+	reverse    bool
+	speed      float64
+	firstFrame bool
+}
+
+// parseGIFTransform parses the reverse, speed and frame query parameters.
+// Returns nil if none were given.
+func parseGIFTransform(query url.Values) (objectTransform, error) {
+	t := &gifTransform{speed: 1}
+
+	switch query.Get("reverse") {
+	case "", "0", "false":
+	case "1", "true":
+		t.reverse = true
+	default:
+		return nil, badTransformError("reverse must be 1 or 0")
+	}
+
+	if value := query.Get("speed"); value != "" {
+		speed, err := strconv.ParseFloat(value, 64)
+		if err != nil || !(speed >= MinGIFSpeed && speed <= MaxGIFSpeed) {
+			return nil, badTransformError(fmt.Sprintf("speed must be between %g and %g", MinGIFSpeed, MaxGIFSpeed))
+		}
+		t.speed = speed
+	}
+
+	switch query.Get("frame") {
+	case "":
+	case "first":
+		t.firstFrame = true
+	default:
+		return nil, badTransformError(`frame must be "first"`)
+	}
+
+	if !t.reverse && t.speed == 1 && !t.firstFrame {
+		return nil, nil
+	}
+	return t, nil
+}
+
+func (t *gifTransform) Key() string {
+	var parts []string
+	if t.firstFrame {
+		parts = append(parts, "first")
+	}
+	if t.reverse {
+		parts = append(parts, "reverse")
+	}
+	if t.speed != 1 {
+		parts = append(parts, "speed"+strconv.FormatFloat(t.speed, 'g', -1, 64))
+	}
+	return strings.Join(parts, "-")
+}
+
+func (t *gifTransform) Supports(ext string) bool {
+	return ext == "gif"
+}
+
+func (t *gifTransform) Apply(data []byte, ext string) ([]byte, error) {
+	if !t.Supports(ext) {
+		return nil, errTransformNotSupported
+	}
+
+	g, err := decodeGIFLimited(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.firstFrame {
+		g.Image = g.Image[:1]
+		g.Delay = g.Delay[:1]
+		if len(g.Disposal) > 1 {
+			g.Disposal = g.Disposal[:1]
+		}
+		g.LoopCount = -1
+	}
+
+	if t.reverse {
+		coalesceGIF(g)
+		for i, j := 0, len(g.Image)-1; i < j; i, j = i+1, j-1 {
+			g.Image[i], g.Image[j] = g.Image[j], g.Image[i]
+			g.Delay[i], g.Delay[j] = g.Delay[j], g.Delay[i]
+		}
+	}
+
+	if t.speed != 1 {
+		for i, delay := range g.Delay {
+			if delay < minGIFDelay {
+				delay = defaultGIFDelay
+			}
+			g.Delay[i] = maxInt(minGIFDelay, roundInt(float64(delay)/t.speed))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		return nil, err
+	}
+	if buf.Len() > MaxTransformedGIFBytes {
+		return nil, ErrImageTooLarge
+	}
+	return buf.Bytes(), nil
+}
+
+// coalesceGIF replaces the frames of g with full-size frames that don't depend on the
+// frames before them, so they can be reordered.
+func coalesceGIF(g *gif.GIF) {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	canvas := image.NewRGBA(bounds)
+	// Reused for every frame that restores the previous canvas.
+	var previous *image.RGBA
+
+	for i, frame := range g.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+			g.Disposal[i] = gif.DisposalNone
+		}
+
+		if disposal == gif.DisposalPrevious {
+			if previous == nil {
+				previous = image.NewRGBA(bounds)
+			}
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		full := image.NewPaletted(bounds, frame.Palette)
+		draw.Draw(full, bounds, canvas, bounds.Min, draw.Src)
+		g.Image[i] = full
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.ZP, draw.Src)
+		case gif.DisposalPrevious:
+			canvas, previous = previous, canvas
+		}
+	}
+}
+
+// decodeGIFLimited decodes every frame of a GIF, or returns ErrImageTooLarge if it has more
+// than MaxGIFFrames frames or MaxGIFTotalPixels pixels. The limits are checked before
+// decoding, since all the frames are kept in memory at once.
+func decodeGIFLimited(data []byte) (*gif.GIF, error) {
+	config, _, err := decodeImageConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	frames, err := countGIFFrames(data)
+	if err != nil {
+		return nil, err
+	}
+	if frames > MaxGIFFrames || int64(frames)*int64(config.Width)*int64(config.Height) > MaxGIFTotalPixels {
+		return nil, ErrImageTooLarge
+	}
+	return gif.DecodeAll(bytes.NewReader(data))
+}
+
+// countGIFFrames counts the frames in a GIF without decoding them.
+func countGIFFrames(data []byte) (frames int, err error) {
+	const headerSize = 6 + 7
+	if len(data) < headerSize {
+		return 0, errMalformedGIF
+	}
+	pos := headerSize
+	if flags := data[10]; flags&0x80 != 0 {
+		// Global color table.
+		pos += 3 << ((flags & 0x07) + 1)
+	}
+
+	for pos < len(data) {
+		block := data[pos]
+		pos++
+
+		switch block {
+		case 0x21: // Extension: label, then data sub-blocks.
+			if pos, err = skipGIFSubBlocks(data, pos+1); err != nil {
+				return 0, err
+			}
+
+		case 0x2c: // Image descriptor.
+			frames++
+			if pos+9 > len(data) {
+				return 0, errMalformedGIF
+			}
+			if flags := data[pos+8]; flags&0x80 != 0 {
+				// Local color table.
+				pos += 3 << ((flags & 0x07) + 1)
+			}
+			// Skip the descriptor and LZW code size, then the image data sub-blocks.
+			if pos, err = skipGIFSubBlocks(data, pos+9+1); err != nil {
+				return 0, err
+			}
+
+		case 0x3b: // Trailer.
+			return frames, nil
+
+		default:
+			return 0, errMalformedGIF
+		}
+	}
+	return 0, errMalformedGIF
+}
+
+func skipGIFSubBlocks(data []byte, pos int) (int, error) {
+	for {
+		if pos >= len(data) {
+			return 0, errMalformedGIF
+		}
+		size := int(data[pos])
+		pos += 1 + size
+		if size == 0 {
+			return pos, nil
+		}
+	}
+}
+
+// modifierTransformParams returns the object transform parameters that apply modifiers.
+func modifierTransformParams(modifiers []Modifier) url.Values {
+	params := make(url.Values)
+	for _, modifier := range modifiers {
+		switch modifier {
+		case ModifierReversed:
+			params.Set("reverse", "1")
+		case ModifierFast:
+			params.Set("speed", "2")
+		case ModifierSlow:
+			params.Set("speed", "0.5")
+		case ModifierStill:
+			params.Set("frame", "first")
+		}
+	}
+	return params
+}
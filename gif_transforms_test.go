@@ -0,0 +1,134 @@
+package memebot
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGIFTransform(t *testing.T) {
+	transform, err := parseGIFTransform(url.Values{})
+	assert.NoError(t, err)
+	assert.Nil(t, transform)
+
+	transform, err = parseGIFTransform(url.Values{"reverse": {"0"}, "speed": {"1"}})
+	assert.NoError(t, err)
+	assert.Nil(t, transform)
+
+	transform, err = parseGIFTransform(url.Values{"reverse": {"1"}, "speed": {"2"}, "frame": {"first"}})
+	require.NoError(t, err)
+	assert.Equal(t, &gifTransform{true, 2, true}, transform)
+	assert.Equal(t, "first-reverse-speed2", transform.Key())
+
+	for _, query := range []url.Values{
+		{"reverse": {"yes"}},
+		{"speed": {"0"}},
+		{"speed": {"100"}},
+		{"speed": {"fast"}},
+		{"frame": {"last"}},
+	} {
+		_, err := parseGIFTransform(query)
+		assert.IsType(t, badTransformError(""), err, "%v", query)
+	}
+}
+
+func TestCountGIFFrames(t *testing.T) {
+	for _, frames := range []int{1, 3, 20} {
+		count, err := countGIFFrames(encodeTestGIF(t, 20, 10, frames))
+		assert.NoError(t, err)
+		assert.Equal(t, frames, count)
+	}
+
+	data := encodeTestGIF(t, 20, 10, 3)
+	_, err := countGIFFrames(data[:len(data)-10])
+	assert.Equal(t, errMalformedGIF, err)
+	_, err = countGIFFrames([]byte("GIF"))
+	assert.Equal(t, errMalformedGIF, err)
+}
+
+func applyTestGIFTransform(t *testing.T, transform *gifTransform, data []byte) *gif.GIF {
+	transformed, err := transform.Apply(data, "gif")
+	require.NoError(t, err)
+	g, err := gif.DecodeAll(bytes.NewReader(transformed))
+	require.NoError(t, err)
+	return g
+}
+
+func TestGIFTransformReverse(t *testing.T) {
+	g := applyTestGIFTransform(t, &gifTransform{reverse: true, speed: 1}, encodeTestGIF(t, 20, 10, 3))
+
+	assert.Len(t, g.Image, 3)
+	assert.Equal(t, []int{30, 20, 10}, g.Delay)
+	// Each frame of the test GIF sets the pixel at x = frame index.
+	for i, frame := range g.Image {
+		assert.Equal(t, uint8(1), frame.ColorIndexAt(2-i, 0), "frame %d", i)
+	}
+}
+
+func TestGIFTransformSpeed(t *testing.T) {
+	g := applyTestGIFTransform(t, &gifTransform{speed: 2}, encodeTestGIF(t, 20, 10, 3))
+	assert.Equal(t, []int{5, 10, 15}, g.Delay)
+
+	g = applyTestGIFTransform(t, &gifTransform{speed: 10}, encodeTestGIF(t, 20, 10, 3))
+	assert.Equal(t, []int{minGIFDelay, minGIFDelay, 3}, g.Delay)
+}
+
+func TestGIFTransformFirstFrame(t *testing.T) {
+	g := applyTestGIFTransform(t, &gifTransform{speed: 1, firstFrame: true}, encodeTestGIF(t, 20, 10, 3))
+	assert.Len(t, g.Image, 1)
+	assert.Equal(t, uint8(1), g.Image[0].ColorIndexAt(0, 0))
+}
+
+func TestGIFTransformLimits(t *testing.T) {
+	transform := &gifTransform{reverse: true, speed: 1}
+
+	_, err := transform.Apply(encodeTestGIF(t, 1, 1, MaxGIFFrames+1), "gif")
+	assert.Equal(t, ErrImageTooLarge, err)
+
+	// Under the frame limit, but too many pixels.
+	_, err = transform.Apply(encodeTestGIF(t, 1000, 1000, MaxGIFTotalPixels/(1000*1000)+1), "gif")
+	assert.Equal(t, ErrImageTooLarge, err)
+
+	_, err = transform.Apply(encodeTestImage(t, "png", 10, 10), "png")
+	assert.Equal(t, errTransformNotSupported, err)
+}
+
+func TestCoalesceGIFDisposePrevious(t *testing.T) {
+	palette := color.Palette{color.Transparent, color.Black, color.White}
+	background := image.NewPaletted(image.Rect(0, 0, 4, 1), palette)
+	for x := 0; x < 4; x++ {
+		background.SetColorIndex(x, 0, 1)
+	}
+	overlay := func(x int) *image.Paletted {
+		frame := image.NewPaletted(image.Rect(x, 0, x+1, 1), palette)
+		frame.SetColorIndex(x, 0, 2)
+		return frame
+	}
+	g := &gif.GIF{
+		Image:    []*image.Paletted{background, overlay(1), overlay(2), overlay(3)},
+		Delay:    []int{10, 10, 10, 10},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalPrevious, gif.DisposalPrevious, gif.DisposalNone},
+		Config:   image.Config{Width: 4, Height: 1},
+	}
+
+	coalesceGIF(g)
+	rows := make([][]uint8, len(g.Image))
+	for i, frame := range g.Image {
+		for x := 0; x < 4; x++ {
+			rows[i] = append(rows[i], frame.ColorIndexAt(x, 0))
+		}
+	}
+	assert.Equal(t, [][]uint8{{1, 1, 1, 1}, {1, 2, 1, 1}, {1, 1, 2, 1}, {1, 1, 1, 2}}, rows)
+}
+
+func TestModifierTransformParams(t *testing.T) {
+	assert.Equal(t, url.Values{"reverse": {"1"}, "speed": {"0.5"}},
+		modifierTransformParams([]Modifier{ModifierReversed, ModifierSlow}))
+	assert.Equal(t, url.Values{"frame": {"first"}}, modifierTransformParams([]Modifier{ModifierStill}))
+}
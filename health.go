@@ -0,0 +1,198 @@
+package memebot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// BotStatusReporter is implemented by MemeBot.
+type BotStatusReporter interface {
+	Status() BotStatus
+}
+
+type HealthConfig struct {
+	Memepository Memepository
+
+	// If true, the server isn't ready until a bot has been set with SetBot and is connected.
+	RequireBot bool
+}
+
+// Health serves JSON health reports:
+//
+//	GET /healthz  Always 200 while the server is running. Also served at /health.
+//	GET /readyz   503 if the bot isn't connected to Slack, or no memes are loaded.
+type Health struct {
+	HealthConfig
+
+	started time.Time
+
+	lock sync.Mutex
+	bot  BotStatusReporter
+}
+
+// HealthReport is the body of health responses.
+type HealthReport struct {
+	Ready bool `json:"ready"`
+
+	// Reasons the server isn't ready.
+	Problems []string `json:"problems,omitempty"`
+
+	Uptime        string  `json:"uptime"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+
+	// Only set if a bot is required.
+	Slack *SlackHealth `json:"slack,omitempty"`
+
+	Memes MemesHealth `json:"memes"`
+}
+
+type SlackHealth struct {
+	State ConnectionState `json:"state"`
+
+	ConnectedAt       *time.Time `json:"connected_at,omitempty"`
+	LatencyMillis     float64    `json:"latency_ms,omitempty"`
+	LatencyReportedAt *time.Time `json:"latency_reported_at,omitempty"`
+}
+
+type MemesHealth struct {
+	Count    int            `json:"count"`
+	LoadedAt *time.Time     `json:"loaded_at,omitempty"`
+	Error    string         `json:"error,omitempty"`
+	Sources  []SourceHealth `json:"sources,omitempty"`
+}
+
+type SourceHealth struct {
+	Name  string `json:"name"`
+	Memes int    `json:"memes"`
+	Error string `json:"error,omitempty"`
+}
+
+func CreateHealthCheck(router *mux.Router, config HealthConfig) *Health {
+	health := &Health{
+		HealthConfig: config,
+		started:      time.Now(),
+	}
+	router.Path("/healthz").Methods("GET", "HEAD").HandlerFunc(health.serveLiveness)
+	router.Path("/health").Methods("GET", "HEAD").HandlerFunc(health.serveLiveness)
+	router.Path("/readyz").Methods("GET", "HEAD").HandlerFunc(health.serveReadiness)
+	return health
+}
+
+// SetBot sets the bot whose connection is reported, once it has been created.
+func (h *Health) SetBot(bot BotStatusReporter) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.bot = bot
+}
+
+func (h *Health) serveLiveness(w http.ResponseWriter, req *http.Request) {
+	writeHealthReport(w, http.StatusOK, h.Report())
+}
+
+func (h *Health) serveReadiness(w http.ResponseWriter, req *http.Request) {
+	report := h.Report()
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealthReport(w, status, report)
+}
+
+func writeHealthReport(w http.ResponseWriter, status int, report HealthReport) {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// Report returns the current health of the server.
+func (h *Health) Report() HealthReport {
+	uptime := time.Since(h.started)
+	report := HealthReport{
+		Uptime:        (uptime / time.Second * time.Second).String(),
+		UptimeSeconds: uptime.Seconds(),
+	}
+
+	if h.RequireBot {
+		report.Slack = h.slackHealth()
+		if report.Slack.State != StateConnected {
+			report.Problems = append(report.Problems, fmt.Sprintf("slack is %s", report.Slack.State))
+		}
+	}
+
+	report.Memes = h.memesHealth()
+	if report.Memes.Error != "" {
+		report.Problems = append(report.Problems, "error loading memes: "+report.Memes.Error)
+	} else if report.Memes.Count == 0 {
+		report.Problems = append(report.Problems, "no memes loaded")
+	}
+
+	report.Ready = len(report.Problems) == 0
+	return report
+}
+
+func (h *Health) slackHealth() *SlackHealth {
+	h.lock.Lock()
+	bot := h.bot
+	h.lock.Unlock()
+
+	if bot == nil {
+		return &SlackHealth{State: StateConnecting}
+	}
+
+	status := bot.Status()
+	health := &SlackHealth{
+		State:             status.State,
+		ConnectedAt:       optionalTime(status.ConnectedAt),
+		LatencyReportedAt: optionalTime(status.LatencyReportedAt),
+	}
+	if !status.LatencyReportedAt.IsZero() {
+		health.LatencyMillis = status.Latency.Seconds() * 1000
+	}
+	return health
+}
+
+func (h *Health) memesHealth() (health MemesHealth) {
+	memes, err := h.Memepository.Load()
+	if err != nil {
+		health.Error = err.Error()
+	} else if memes != nil {
+		health.Count = memes.Len()
+	}
+
+	if loaded, ok := h.Memepository.(interface {
+		LoadedAt() time.Time
+	}); ok {
+		health.LoadedAt = optionalTime(loaded.LoadedAt())
+	}
+
+	if composite, ok := h.Memepository.(*CompositeMemepository); ok {
+		for _, status := range composite.SourceStatuses() {
+			source := SourceHealth{Name: status.Name, Memes: status.Memes}
+			if status.Err != nil {
+				source.Error = status.Err.Error()
+			}
+			health.Sources = append(health.Sources, source)
+		}
+	}
+	return
+}
+
+// optionalTime returns nil for the zero time, so it's omitted from JSON.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
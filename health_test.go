@@ -0,0 +1,139 @@
+package memebot
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type MockBotStatusReporter struct {
+	status BotStatus
+}
+
+func (r *MockBotStatusReporter) Status() BotStatus {
+	return r.status
+}
+
+func newTestHealth(memepository Memepository, requireBot bool) (*Health, *mux.Router) {
+	router := mux.NewRouter()
+	health := CreateHealthCheck(router, HealthConfig{
+		Memepository: memepository,
+		RequireBot:   requireBot,
+	})
+	return health, router
+}
+
+func serveTestHealthRequest(t *testing.T, router http.Handler, url string) (int, HealthReport) {
+	resp := serveTestRequest(t, router, url, nil)
+	assert.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+
+	var report HealthReport
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &report))
+	return resp.Code, report
+}
+
+func TestHealthReady(t *testing.T) {
+	health, router := newTestHealth(&MockMemepository{NewTestMemeIndex(
+		NewMockMeme("http://example.com/cat.jpg", "cat"),
+	)}, true)
+	connectedAt := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	health.SetBot(&MockBotStatusReporter{BotStatus{
+		State:             StateConnected,
+		ConnectedAt:       connectedAt,
+		Latency:           250 * time.Millisecond,
+		LatencyReportedAt: connectedAt.Add(time.Minute),
+	}})
+
+	code, report := serveTestHealthRequest(t, router, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, report.Ready)
+	assert.Empty(t, report.Problems)
+	assert.Equal(t, 1, report.Memes.Count)
+	require.NotNil(t, report.Slack)
+	assert.Equal(t, StateConnected, report.Slack.State)
+	assert.Equal(t, connectedAt, *report.Slack.ConnectedAt)
+	assert.Equal(t, 250.0, report.Slack.LatencyMillis)
+}
+
+func TestHealthNotReadyWithoutBot(t *testing.T) {
+	health, router := newTestHealth(&MockMemepository{NewTestMemeIndex(
+		NewMockMeme("http://example.com/cat.jpg", "cat"),
+	)}, true)
+
+	code, report := serveTestHealthRequest(t, router, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, report.Ready)
+	assert.Equal(t, []string{"slack is connecting"}, report.Problems)
+
+	health.SetBot(&MockBotStatusReporter{BotStatus{State: StateDisconnected}})
+	code, report = serveTestHealthRequest(t, router, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []string{"slack is disconnected"}, report.Problems)
+
+	// Liveness doesn't depend on readiness.
+	code, report = serveTestHealthRequest(t, router, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, report.Ready)
+	code, _ = serveTestHealthRequest(t, router, "/health")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestHealthNotReadyWithoutMemes(t *testing.T) {
+	_, router := newTestHealth(&MockMemepository{NewTestMemeIndex()}, false)
+	code, report := serveTestHealthRequest(t, router, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []string{"no memes loaded"}, report.Problems)
+	assert.Nil(t, report.Slack)
+
+	_, router = newTestHealth(FailingMemepository{errors.New("bucket on fire")}, false)
+	code, report = serveTestHealthRequest(t, router, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []string{"error loading memes: bucket on fire"}, report.Problems)
+	assert.Equal(t, "bucket on fire", report.Memes.Error)
+}
+
+func TestHealthReportsSources(t *testing.T) {
+	composite := &CompositeMemepository{
+		Sources: []MemepositorySource{
+			{"a", FailingMemepository{errors.New("bucket on fire")}},
+			{"b", &MockMemepository{NewTestMemeIndex(NewMockMeme("http://b.com", "foo"))}},
+		},
+	}
+	health, _ := newTestHealth(composite, false)
+
+	report := health.Report()
+	assert.True(t, report.Ready)
+	assert.Equal(t, 1, report.Memes.Count)
+	assert.NotNil(t, report.Memes.LoadedAt)
+	assert.Equal(t, []SourceHealth{
+		{Name: "a", Error: "bucket on fire"},
+		{Name: "b", Memes: 1},
+	}, report.Memes.Sources)
+}
+
+func TestMemeBotUpdateStatus(t *testing.T) {
+	var bot MemeBot
+	bot.updateStatus(&slack.ConnectingEvent{})
+	assert.Equal(t, StateConnecting, bot.Status().State)
+
+	bot.updateStatus(&slack.ConnectedEvent{})
+	assert.Equal(t, StateConnected, bot.Status().State)
+	assert.False(t, bot.Status().ConnectedAt.IsZero())
+
+	bot.updateStatus(&slack.LatencyReport{Value: time.Second})
+	assert.Equal(t, time.Second, bot.Status().Latency)
+	assert.Equal(t, StateConnected, bot.Status().State)
+
+	bot.updateStatus(&slack.MessageEvent{})
+	assert.Equal(t, StateConnected, bot.Status().State)
+
+	bot.updateStatus(&slack.DisconnectedEvent{})
+	assert.Equal(t, StateDisconnected, bot.Status().State)
+}
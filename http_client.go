@@ -0,0 +1,13 @@
+package memebot
+
+import (
+	"net/http"
+	"time"
+)
+
+// DefaultHTTPTimeout limits the requests made with DefaultHTTPClient.
+const DefaultHTTPTimeout = 30 * time.Second
+
+// DefaultHTTPClient is used by components that weren't given a client. Unlike
+// http.DefaultClient it times out, so a slow server can't stall reloads or replies.
+var DefaultHTTPClient = &http.Client{Timeout: DefaultHTTPTimeout}
@@ -0,0 +1,127 @@
+package memebot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// KeywordPlaceholder is replaced with the query-escaped keyword in HTTPSearcherConfig.URLTemplate.
+const KeywordPlaceholder = "{keyword}"
+
+type HTTPSearcherConfig struct {
+	// URL of a search API that returns JSON, containing KeywordPlaceholder.
+	// E.g. https://api.giphy.com/v1/gifs/search?api_key=xxx&limit=1&q={keyword}
+	URLTemplate string
+
+	// Dot-separated path to the image URL in the response. Array elements are
+	// selected by index. E.g. data.0.images.original.url
+	ResultPath string
+
+	Client *http.Client // Defaults to DefaultHTTPClient.
+}
+
+// HTTPSearcher is a MemeSearcher that queries a generic HTTP GIF-search API.
+type HTTPSearcher struct {
+	HTTPSearcherConfig
+	resultPath []string
+}
+
+var _ MemeSearcher = &HTTPSearcher{}
+
+func NewHTTPSearcher(config HTTPSearcherConfig) (*HTTPSearcher, error) {
+	if !strings.Contains(config.URLTemplate, KeywordPlaceholder) {
+		return nil, fmt.Errorf("URL template must contain %s: %s", KeywordPlaceholder, config.URLTemplate)
+	}
+	if _, err := url.Parse(strings.Replace(config.URLTemplate, KeywordPlaceholder, "", -1)); err != nil {
+		return nil, err
+	}
+	if config.ResultPath == "" {
+		return nil, errors.New("ResultPath must be specified")
+	}
+	if config.Client == nil {
+		config.Client = DefaultHTTPClient
+	}
+
+	return &HTTPSearcher{
+		HTTPSearcherConfig: config,
+		resultPath:         strings.Split(config.ResultPath, "."),
+	}, nil
+}
+
+func (s *HTTPSearcher) FindMeme(keyword string) (Meme, error) {
+	searchURL := strings.Replace(s.URLTemplate, KeywordPlaceholder, url.QueryEscape(keyword), -1)
+
+	resp, err := s.Client.Get(searchURL)
+	if urlErr, ok := err.(*url.Error); ok {
+		// The URL contains the keyword, which mustn't be logged if user text is redacted.
+		return nil, fmt.Errorf("search request failed: %s", urlErr.Err)
+	} else if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("search failed: %s", resp.Status)
+	}
+
+	var result interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, errors.New("error decoding search results: " + err.Error())
+	}
+
+	value, found := lookupJSONPath(result, s.resultPath)
+	if !found {
+		return nil, ErrNoMemeFound
+	}
+	rawURL, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("search result at %s is not a string: %v", s.ResultPath, value)
+	}
+
+	memeURL, err := url.Parse(rawURL)
+	if err != nil || !memeURL.IsAbs() {
+		return nil, fmt.Errorf("search result is not a valid URL: %q", rawURL)
+	}
+	return &HTTPMeme{memeURL, []string{keyword}}, nil
+}
+
+// lookupJSONPath walks a value decoded from JSON, using path elements as object keys
+// or array indices.
+func lookupJSONPath(value interface{}, path []string) (interface{}, bool) {
+	for _, element := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var found bool
+			if value, found = v[element]; !found {
+				return nil, false
+			}
+		case []interface{}:
+			index, err := strconv.Atoi(element)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, false
+			}
+			value = v[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, value != nil
+}
+
+type HTTPMeme struct {
+	url      *url.URL
+	keywords []string
+}
+
+func (m *HTTPMeme) URL() *url.URL {
+	return m.url
+}
+
+func (m *HTTPMeme) Keywords() []string {
+	return m.keywords
+}
@@ -0,0 +1,98 @@
+package memebot
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func newTestSearchServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Query().Get("q") {
+		case "party parrot":
+			fmt.Fprint(w, `{"data": [{"images": {"original": {"url": "http://gifs.com/parrot.gif"}}}]}`)
+		case "nothing":
+			fmt.Fprint(w, `{"data": []}`)
+		case "broken":
+			fmt.Fprint(w, `{"data": [{"images": {"original": {"url": 42}}}]}`)
+		default:
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+		}
+	}))
+}
+
+func newTestHTTPSearcher(t *testing.T, server *httptest.Server) *HTTPSearcher {
+	searcher, err := NewHTTPSearcher(HTTPSearcherConfig{
+		URLTemplate: server.URL + "/search?limit=1&q={keyword}",
+		ResultPath:  "data.0.images.original.url",
+	})
+	require.NoError(t, err)
+	return searcher
+}
+
+func TestHTTPSearcher(t *testing.T) {
+	server := newTestSearchServer(t)
+	defer server.Close()
+	searcher := newTestHTTPSearcher(t, server)
+
+	meme, err := searcher.FindMeme("party parrot")
+	require.NoError(t, err)
+	assert.Equal(t, "http://gifs.com/parrot.gif", meme.URL().String())
+	assert.Equal(t, []string{"party parrot"}, meme.Keywords())
+
+	_, err = searcher.FindMeme("nothing")
+	assert.Equal(t, ErrNoMemeFound, err)
+
+	_, err = searcher.FindMeme("broken")
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrNoMemeFound, err)
+
+	_, err = searcher.FindMeme("too many")
+	assert.EqualError(t, err, "search failed: 429 Too Many Requests")
+}
+
+func TestHTTPSearcherErrorsAreRedacted(t *testing.T) {
+	server := newTestSearchServer(t)
+	searcher := newTestHTTPSearcher(t, server)
+	server.Close()
+
+	logger, buf := newTestLogger(LoggerConfig{RedactUserText: true})
+	_, user, config, msg := CreateArgsForHandleMessage(t, `^do (.+)$`, []string{}, false, "name do secret plans")
+	config.Searcher = &ChainSearcher{
+		Searchers: []ChainedSearcher{{Name: "giphy", MemeSearcher: searcher, External: true}},
+		Log:       logger,
+	}
+	config.Log = logger
+
+	respondToMessage(context.Background(), user, config, ChannelSettings{}, msg)
+	assert.Contains(t, buf.String(), "search request failed")
+	assert.NotContains(t, buf.String(), "secret")
+}
+
+func TestNewHTTPSearcherValidatesConfig(t *testing.T) {
+	_, err := NewHTTPSearcher(HTTPSearcherConfig{URLTemplate: "http://foo.com/search", ResultPath: "url"})
+	assert.Error(t, err)
+
+	_, err = NewHTTPSearcher(HTTPSearcherConfig{URLTemplate: "http://foo.com/search?q={keyword}"})
+	assert.Error(t, err)
+}
+
+func TestLookupJSONPath(t *testing.T) {
+	value := map[string]interface{}{
+		"a": []interface{}{"b", map[string]interface{}{"c": "d"}},
+	}
+
+	result, found := lookupJSONPath(value, []string{"a", "1", "c"})
+	assert.True(t, found)
+	assert.Equal(t, "d", result)
+
+	for _, path := range [][]string{{"b"}, {"a", "2"}, {"a", "x"}, {"a", "0", "c"}} {
+		_, found := lookupJSONPath(value, path)
+		assert.False(t, found, "%v", path)
+	}
+}
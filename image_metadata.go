@@ -0,0 +1,331 @@
+package memebot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+)
+
+var (
+	ErrMetadataNotSupported = errors.New("can't strip metadata from this type of image")
+
+	errMalformedJPEG = errors.New("jpeg: malformed data")
+	errMalformedPNG  = errors.New("png: malformed data")
+)
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// PNG chunks that hold metadata rather than image data.
+var pngMetadataChunks = MakeSet("tEXt", "zTXt", "iTXt", "eXIf", "tIME")
+
+// StrippedImage is the result of StripImageMetadata.
+type StrippedImage struct {
+	Data []byte
+
+	// Describes each kind of metadata that was removed, e.g. "EXIF". Empty if the image
+	// had no metadata, in which case Data is the original data.
+	Removed []string
+
+	// True if the image was rotated or flipped to apply its EXIF orientation, which means
+	// it had to be re-encoded.
+	Reencoded bool
+}
+
+// StripImageMetadata removes metadata that may identify where, or on what, an image was
+// created, e.g. EXIF, XMP and comments, from JPEG and PNG images. Image data is copied as
+// is, unless the EXIF orientation needs to be applied. Color profiles are kept.
+// Returns ErrMetadataNotSupported for other types of images.
+func StripImageMetadata(data []byte, ext string) (StrippedImage, error) {
+	switch ext {
+	case "jpg", "jpeg":
+		return stripJPEGMetadata(data)
+	case "png":
+		return stripPNGMetadata(data)
+	}
+	return StrippedImage{}, ErrMetadataNotSupported
+}
+
+func stripJPEGMetadata(data []byte) (result StrippedImage, err error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return result, errMalformedJPEG
+	}
+
+	var stripped bytes.Buffer
+	stripped.Write(data[:2])
+	orientation := 1
+
+	pos := 2
+	for {
+		if pos+2 > len(data) || data[pos] != 0xff {
+			return result, errMalformedJPEG
+		}
+		marker := data[pos+1]
+
+		switch {
+		case marker == 0xff:
+			// Fill byte.
+			pos++
+			continue
+
+		case marker == 0xd9: // End of image.
+			stripped.Write(data[pos : pos+2])
+			if pos+2 < len(data) {
+				// E.g. extra images from phone cameras, which have their own metadata.
+				result.Removed = appendUnique(result.Removed, "trailing data")
+			}
+			return finishJPEG(data, stripped.Bytes(), orientation, result)
+
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd7:
+			// Markers without segments.
+			stripped.Write(data[pos : pos+2])
+			pos += 2
+			continue
+		}
+
+		if pos+4 > len(data) {
+			return result, errMalformedJPEG
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end > len(data) || end < pos+4 {
+			return result, errMalformedJPEG
+		}
+		payload := data[pos+4 : end]
+
+		if removed := jpegMetadataSegment(marker, payload); removed != "" {
+			result.Removed = appendUnique(result.Removed, removed)
+			if removed == "EXIF" {
+				orientation = exifOrientation(payload[len("Exif\x00\x00"):])
+			}
+		} else {
+			stripped.Write(data[pos:end])
+		}
+		pos = end
+
+		if marker == 0xda {
+			// Start of scan: copy the entropy-coded data up to the next marker.
+			scanEnd := jpegScanEnd(data, pos)
+			stripped.Write(data[pos:scanEnd])
+			pos = scanEnd
+		}
+	}
+}
+
+// jpegMetadataSegment returns a description of the segment if it only holds metadata,
+// or empty if it should be kept.
+func jpegMetadataSegment(marker byte, payload []byte) string {
+	switch {
+	case marker == 0xe1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")):
+		return "EXIF"
+	case marker == 0xe1 && bytes.HasPrefix(payload, []byte("http://ns.adobe.com/xap/1.0/")):
+		return "XMP"
+	case marker == 0xe2 && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00")):
+		// Color profile, needed to display the image correctly.
+		return ""
+	case marker == 0xed:
+		return "IPTC"
+	case marker == 0xfe:
+		return "comment"
+	case marker == 0xe0 || marker == 0xee:
+		// JFIF and Adobe segments describe how to decode the image.
+		return ""
+	case marker >= 0xe1 && marker <= 0xef:
+		return fmt.Sprintf("APP%d", marker-0xe0)
+	}
+	return ""
+}
+
+// jpegScanEnd returns the position of the first marker at or after pos that isn't part
+// of entropy-coded data.
+func jpegScanEnd(data []byte, pos int) int {
+	for ; pos+1 < len(data); pos++ {
+		if data[pos] == 0xff {
+			next := data[pos+1]
+			// Stuffed zero bytes and restart markers are part of the scan.
+			if next != 0x00 && !(next >= 0xd0 && next <= 0xd7) {
+				return pos
+			}
+		}
+	}
+	return len(data)
+}
+
+func finishJPEG(original, stripped []byte, orientation int, result StrippedImage) (StrippedImage, error) {
+	if len(result.Removed) == 0 {
+		result.Data = original
+		return result, nil
+	}
+	result.Data = stripped
+
+	if orientation != 1 {
+		// Browsers won't know to rotate the image without its EXIF data.
+		img, err := jpeg.Decode(bytes.NewReader(stripped))
+		if err != nil {
+			return result, err
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, orientImage(img, orientation), &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return result, err
+		}
+		result.Data = buf.Bytes()
+		result.Reencoded = true
+	}
+	return result, nil
+}
+
+// exifOrientation returns the orientation tag from EXIF data, from 1 to 8, or 1 if it
+// isn't set or can't be read.
+func exifOrientation(exif []byte) int {
+	if len(exif) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(exif[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(exif[4:]))
+	if ifd < 8 || ifd+2 > len(exif) {
+		return 1
+	}
+	entries := int(order.Uint16(exif[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(exif) {
+			return 1
+		}
+		const orientationTag, shortType = 0x0112, 3
+		if order.Uint16(exif[entry:]) == orientationTag && order.Uint16(exif[entry+2:]) == shortType {
+			if orientation := int(order.Uint16(exif[entry+8:])); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orientImage rotates and flips img so it's displayed correctly without its EXIF orientation.
+func orientImage(img image.Image, orientation int) *image.RGBA {
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	w, h := bounds.Dx(), bounds.Dy()
+
+	if orientation >= 5 {
+		// Rotated by 90 degrees.
+		w, h = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // Flipped horizontally.
+				sx, sy = srcW-1-x, y
+			case 3: // Rotated 180 degrees.
+				sx, sy = srcW-1-x, srcH-1-y
+			case 4: // Flipped vertically.
+				sx, sy = x, srcH-1-y
+			case 5: // Transposed.
+				sx, sy = y, x
+			case 6: // Rotated 90 degrees clockwise to display.
+				sx, sy = y, srcH-1-x
+			case 7: // Transversed.
+				sx, sy = srcW-1-y, srcH-1-x
+			case 8: // Rotated 90 degrees counter-clockwise to display.
+				sx, sy = srcW-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+func stripPNGMetadata(data []byte) (result StrippedImage, err error) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return result, errMalformedPNG
+	}
+
+	var stripped bytes.Buffer
+	stripped.WriteString(pngSignature)
+
+	// Chunks are a length, type, data and CRC.
+	for pos := len(pngSignature); ; {
+		if pos+8 > len(data) {
+			return result, errMalformedPNG
+		}
+		length := int64(binary.BigEndian.Uint32(data[pos:]))
+		chunkType := string(data[pos+4 : pos+8])
+		if length > int64(len(data)-pos-12) {
+			return result, errMalformedPNG
+		}
+		end := pos + 12 + int(length)
+
+		if pngMetadataChunks.Contains(chunkType) {
+			result.Removed = appendUnique(result.Removed, chunkType)
+		} else {
+			stripped.Write(data[pos:end])
+		}
+		pos = end
+
+		if chunkType == "IEND" {
+			if pos < len(data) {
+				result.Removed = appendUnique(result.Removed, "trailing data")
+			}
+			break
+		}
+	}
+
+	if len(result.Removed) == 0 {
+		result.Data = data
+	} else {
+		result.Data = stripped.Bytes()
+	}
+	return result, nil
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// stripMetadataTransform removes metadata from images served by an ObjectServer.
+type stripMetadataTransform struct{}
+
+func (stripMetadataTransform) Key() string {
+	return "nometa"
+}
+
+func (stripMetadataTransform) Supports(ext string) bool {
+	return ext == "jpg" || ext == "jpeg" || ext == "png"
+}
+
+func (stripMetadataTransform) Apply(data []byte, ext string) ([]byte, error) {
+	result, err := StripImageMetadata(data, ext)
+	switch err {
+	case nil:
+		return result.Data, nil
+	case ErrMetadataNotSupported:
+		return nil, errTransformNotSupported
+	}
+	// Images the parser rejects may still display, but they can't be served without
+	// knowing what metadata they contain.
+	return nil, err
+}
@@ -0,0 +1,225 @@
+package memebot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// jpegSegment encodes a JPEG marker segment.
+func jpegSegment(marker byte, payload string) []byte {
+	segment := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// exifPayload returns an APP1 EXIF payload with only an orientation tag.
+func exifPayload(order binary.ByteOrder, orientation uint16) string {
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+	return "Exif\x00\x00" + string(tiff)
+}
+
+// insertAfter returns data with inserted added at offset.
+func insertAfter(data []byte, offset int, inserted ...[]byte) []byte {
+	result := append([]byte(nil), data[:offset]...)
+	for _, insert := range inserted {
+		result = append(result, insert...)
+	}
+	return append(result, data[offset:]...)
+}
+
+func pngChunk(chunkType, data string) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], chunkType)
+	chunk = append(chunk, data...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	return append(chunk, crc...)
+}
+
+func TestStripJPEGMetadata(t *testing.T) {
+	_, original, _ := encodeTestImages(t)
+	withMetadata := insertAfter(original, 2,
+		jpegSegment(0xe1, exifPayload(binary.BigEndian, 1)),
+		jpegSegment(0xe1, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"),
+		jpegSegment(0xe2, "ICC_PROFILE\x00\x01\x01"),
+		jpegSegment(0xfe, "shot on my phone"))
+	withMetadata = append(withMetadata, "trailing"...)
+
+	result, err := StripImageMetadata(withMetadata, "jpg")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"EXIF", "XMP", "comment", "trailing data"}, result.Removed)
+	assert.False(t, result.Reencoded)
+
+	// The color profile is kept, and the image data is copied as is.
+	assert.Equal(t, insertAfter(original, 2, jpegSegment(0xe2, "ICC_PROFILE\x00\x01\x01")), result.Data)
+}
+
+func TestStripJPEGMetadataOrientation(t *testing.T) {
+	_, original, _ := encodeTestImages(t)
+	withMetadata := insertAfter(original, 2, jpegSegment(0xe1, exifPayload(binary.LittleEndian, 6)))
+
+	result, err := StripImageMetadata(withMetadata, "jpeg")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"EXIF"}, result.Removed)
+	assert.True(t, result.Reencoded)
+
+	config, err := jpeg.DecodeConfig(bytes.NewReader(result.Data))
+	require.NoError(t, err)
+	assert.Equal(t, 30, config.Width)
+	assert.Equal(t, 40, config.Height)
+}
+
+func TestStripPNGMetadata(t *testing.T) {
+	original, _, _ := encodeTestImages(t)
+
+	// After the signature and IHDR chunk.
+	const ihdrEnd = 8 + 12 + 13
+	withMetadata := insertAfter(original, ihdrEnd,
+		pngChunk("tEXt", "Author\x00me"),
+		pngChunk("eXIf", exifPayload(binary.BigEndian, 1)[6:]),
+		pngChunk("tIME", "\x07\xe0\x01\x01\x00\x00\x00"))
+
+	result, err := StripImageMetadata(withMetadata, "png")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tEXt", "eXIf", "tIME"}, result.Removed)
+	assert.Equal(t, original, result.Data)
+}
+
+func TestStripImageMetadataWithoutMetadata(t *testing.T) {
+	pngData, jpegData, _ := encodeTestImages(t)
+
+	for _, test := range []struct {
+		data []byte
+		ext  string
+	}{
+		{pngData, "png"},
+		{jpegData, "jpg"},
+	} {
+		result, err := StripImageMetadata(test.data, test.ext)
+		require.NoError(t, err, test.ext)
+		assert.Empty(t, result.Removed, test.ext)
+		assert.Equal(t, test.data, result.Data, test.ext)
+	}
+}
+
+func TestStripImageMetadataErrors(t *testing.T) {
+	_, _, gifData := encodeTestImages(t)
+
+	_, err := StripImageMetadata(gifData, "gif")
+	assert.Equal(t, ErrMetadataNotSupported, err)
+
+	_, err = StripImageMetadata([]byte("not a jpeg"), "jpg")
+	assert.Equal(t, errMalformedJPEG, err)
+
+	_, err = StripImageMetadata([]byte(pngSignature+"\x00\x00\x00\x0dIHDR"), "png")
+	assert.Equal(t, errMalformedPNG, err)
+}
+
+func TestExifOrientation(t *testing.T) {
+	assert.Equal(t, 6, exifOrientation([]byte(exifPayload(binary.BigEndian, 6)[6:])))
+	assert.Equal(t, 8, exifOrientation([]byte(exifPayload(binary.LittleEndian, 8)[6:])))
+	assert.Equal(t, 1, exifOrientation([]byte(exifPayload(binary.LittleEndian, 9)[6:])))
+	assert.Equal(t, 1, exifOrientation([]byte("MM\x00")))
+}
+
+func TestOrientImage(t *testing.T) {
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+
+	// Red on the left, blue on the right.
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, red)
+	img.Set(1, 0, blue)
+
+	for _, test := range []struct {
+		orientation int
+		size        image.Point
+		first       color.RGBA
+	}{
+		{1, image.Pt(2, 1), red},
+		{2, image.Pt(2, 1), blue},
+		{3, image.Pt(2, 1), blue},
+		{4, image.Pt(2, 1), red},
+		{5, image.Pt(1, 2), red},
+		{6, image.Pt(1, 2), red},
+		{7, image.Pt(1, 2), blue},
+		{8, image.Pt(1, 2), blue},
+	} {
+		oriented := orientImage(img, test.orientation)
+		assert.Equal(t, test.size, oriented.Bounds().Size(), "orientation %d", test.orientation)
+		assert.Equal(t, test.first, oriented.RGBAAt(0, 0), "orientation %d", test.orientation)
+	}
+}
+
+func TestObjectServerStripMetadata(t *testing.T) {
+	_, original, _ := encodeTestImages(t)
+	withMetadata := insertAfter(original, 2, jpegSegment(0xfe, "secret"))
+
+	memepository, router, dir := newTestFileServingMemepository(t,
+		map[string]string{"foo.jpg": string(withMetadata)}, ObjectServerConfig{StripMetadata: true, ResizeSizes: []int{20}})
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "foo")
+
+	resp := serveTestRequest(t, router, meme.URL().String(), nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, original, resp.Body.Bytes())
+	assert.Equal(t, `"`+meme.id+`-nometa"`, resp.Header().Get("ETag"))
+	assert.Equal(t, "image/jpeg", resp.Header().Get("Content-Type"))
+
+	// Resizing strips metadata first.
+	resp = serveTestRequest(t, router, meme.URL().String()+"?w=20", nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, `"`+meme.id+`-nometa-w20-h0-contain"`, resp.Header().Get("ETag"))
+}
+
+func TestObjectServerStripMetadataUnsupported(t *testing.T) {
+	memepository, router, dir := newTestFileServingMemepository(t,
+		map[string]string{"foo.gif": "gif data"}, ObjectServerConfig{StripMetadata: true})
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "foo")
+
+	resp := serveTestRequest(t, router, meme.URL().String(), nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "gif data", resp.Body.String())
+}
+
+func TestObjectServerStripMetadataMalformed(t *testing.T) {
+	files := map[string]string{"foo.jpg": "not a jpeg", "bar.png": "not a png"}
+	memepository, router, dir := newTestFileServingMemepository(t, files, ObjectServerConfig{
+		StripMetadata: true,
+	})
+	defer os.RemoveAll(dir)
+
+	// Images the parser rejects aren't served, since they may still contain metadata.
+	for name, data := range files {
+		meme := findTestFileMeme(t, memepository, strings.TrimSuffix(name, filepath.Ext(name)))
+		resp := serveTestRequest(t, router, meme.URL().String(), nil)
+		require.Equal(t, http.StatusInternalServerError, resp.Code, name)
+		assert.NotContains(t, resp.Body.String(), data, name)
+		assert.Equal(t, "no-store", resp.Header().Get("Cache-Control"), name)
+	}
+}
@@ -0,0 +1,338 @@
+package memebot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+)
+
+type FitMode string
+
+const (
+	// FitContain scales the image to fit within the requested size, preserving its aspect ratio.
+	FitContain FitMode = "contain"
+	// FitCover scales the image to cover the requested size, cropping the center.
+	FitCover FitMode = "cover"
+	// FitFill stretches the image to the requested size.
+	FitFill FitMode = "fill"
+)
+
+const (
+	// MaxResizeDimension is the largest width or height that may be requested.
+	MaxResizeDimension = 8192
+
+	// MaxSourcePixels is the largest image that will be decoded, to protect against
+	// images that decompress to huge sizes.
+	MaxSourcePixels = 50 * 1000 * 1000
+
+	jpegQuality = 85
+)
+
+var ErrImageTooLarge = errors.New("image too large")
+
+// resizeTransform downscales images. Images are never scaled up.
+type resizeTransform struct {
+	width  int
+	height int
+	fit    FitMode
+}
+
+// parseResizeTransform parses the w, h and fit query parameters.
+// Returns nil if no resizing was requested.
+func parseResizeTransform(query url.Values) (objectTransform, error) {
+	t := &resizeTransform{fit: FitMode(query.Get("fit"))}
+
+	var err error
+	if t.width, err = parseDimension(query, "w"); err != nil {
+		return nil, err
+	}
+	if t.height, err = parseDimension(query, "h"); err != nil {
+		return nil, err
+	}
+
+	switch t.fit {
+	case "":
+		t.fit = FitContain
+	case FitContain, FitCover, FitFill:
+	default:
+		return nil, badTransformError(fmt.Sprintf("invalid fit: %q", t.fit))
+	}
+
+	if t.width == 0 && t.height == 0 {
+		if query.Get("fit") != "" {
+			return nil, badTransformError("fit requires w or h")
+		}
+		return nil, nil
+	}
+	return t, nil
+}
+
+func parseDimension(query url.Values, name string) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	dimension, err := strconv.Atoi(value)
+	if err != nil || dimension < 1 || dimension > MaxResizeDimension {
+		return 0, badTransformError(fmt.Sprintf("%s must be between 1 and %d", name, MaxResizeDimension))
+	}
+	return dimension, nil
+}
+
+// snapResizeTransforms rounds the sizes of the resize transforms in transforms up to the
+// nearest of sizes, or down to the largest, so only a few variants of each image can be
+// requested.
+func snapResizeTransforms(transforms []objectTransform, sizes []int) {
+	for _, transform := range transforms {
+		if t, ok := transform.(*resizeTransform); ok {
+			t.width = snapDimension(t.width, sizes)
+			t.height = snapDimension(t.height, sizes)
+		}
+	}
+}
+
+func snapDimension(dimension int, sizes []int) int {
+	if dimension == 0 {
+		return 0
+	}
+	snapped, largest := 0, 0
+	for _, size := range sizes {
+		if size >= dimension && (snapped == 0 || size < snapped) {
+			snapped = size
+		}
+		largest = maxInt(largest, size)
+	}
+	if snapped == 0 {
+		return largest
+	}
+	return snapped
+}
+
+func (t *resizeTransform) Key() string {
+	return fmt.Sprintf("w%d-h%d-%s", t.width, t.height, t.fit)
+}
+
+func (t *resizeTransform) Supports(ext string) bool {
+	return resizableExtensions.Contains(ext)
+}
+
+func (t *resizeTransform) Apply(data []byte, ext string) ([]byte, error) {
+	if !t.Supports(ext) {
+		return nil, errTransformNotSupported
+	}
+
+	config, format, err := decodeImageConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	crop, dstW, dstH := t.geometry(config.Width, config.Height)
+	if dstW == config.Width && dstH == config.Height && crop == image.Rect(0, 0, config.Width, config.Height) {
+		// Already small enough, don't recompress.
+		return data, nil
+	}
+
+	if format == "gif" {
+		return resizeGIF(data, crop, dstW, dstH)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, scaleImage(img, crop, dstW, dstH), format); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// geometry returns the region of the source image to use, and the size to scale it to.
+func (t *resizeTransform) geometry(srcW, srcH int) (crop image.Rectangle, dstW, dstH int) {
+	crop = image.Rect(0, 0, srcW, srcH)
+	w, h := t.width, t.height
+
+	switch {
+	case t.fit == FitFill && w > 0 && h > 0:
+		return crop, minInt(w, srcW), minInt(h, srcH)
+
+	case t.fit == FitCover && w > 0 && h > 0:
+		scale := math.Min(1, math.Max(float64(w)/float64(srcW), float64(h)/float64(srcH)))
+		dstW = minInt(w, roundInt(float64(srcW)*scale))
+		dstH = minInt(h, roundInt(float64(srcH)*scale))
+
+		cropW := minInt(srcW, roundInt(float64(dstW)/scale))
+		cropH := minInt(srcH, roundInt(float64(dstH)/scale))
+		crop = image.Rect(0, 0, cropW, cropH).Add(image.Pt((srcW-cropW)/2, (srcH-cropH)/2))
+		return
+
+	default:
+		scale := 1.0
+		if w > 0 {
+			scale = math.Min(scale, float64(w)/float64(srcW))
+		}
+		if h > 0 {
+			scale = math.Min(scale, float64(h)/float64(srcH))
+		}
+		return crop, maxInt(1, roundInt(float64(srcW)*scale)), maxInt(1, roundInt(float64(srcH)*scale))
+	}
+}
+
+// decodeImageConfig returns the size and format of an image, and checks that it's
+// small enough to decode.
+func decodeImageConfig(data []byte) (image.Config, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return config, format, err
+	}
+	if int64(config.Width)*int64(config.Height) > MaxSourcePixels {
+		return config, format, ErrImageTooLarge
+	}
+	return config, format, nil
+}
+
+func encodeImage(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	case "gif":
+		return gif.Encode(w, img, nil)
+	default:
+		return png.Encode(w, img)
+	}
+}
+
+// scaleImage scales the crop region of src to dstW×dstH, averaging the source pixels
+// that cover each destination pixel.
+func scaleImage(src image.Image, crop image.Rectangle, dstW, dstH int) *image.RGBA {
+	rgba, ok := src.(*image.RGBA)
+	if !ok {
+		bounds := src.Bounds()
+		rgba = image.NewRGBA(bounds)
+		draw.Draw(rgba, bounds, src, bounds.Min, draw.Src)
+	}
+	crop = crop.Add(rgba.Bounds().Min)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	cropW, cropH := crop.Dx(), crop.Dy()
+
+	for y := 0; y < dstH; y++ {
+		sy0 := crop.Min.Y + y*cropH/dstH
+		sy1 := maxInt(sy0+1, crop.Min.Y+(y+1)*cropH/dstH)
+
+		for x := 0; x < dstW; x++ {
+			sx0 := crop.Min.X + x*cropW/dstW
+			sx1 := maxInt(sx0+1, crop.Min.X+(x+1)*cropW/dstW)
+
+			var r, g, b, a, n uint32
+			for sy := sy0; sy < sy1; sy++ {
+				offset := rgba.PixOffset(sx0, sy)
+				for sx := sx0; sx < sx1; sx++ {
+					r += uint32(rgba.Pix[offset])
+					g += uint32(rgba.Pix[offset+1])
+					b += uint32(rgba.Pix[offset+2])
+					a += uint32(rgba.Pix[offset+3])
+					offset += 4
+					n++
+				}
+			}
+
+			offset := dst.PixOffset(x, y)
+			dst.Pix[offset] = uint8(r / n)
+			dst.Pix[offset+1] = uint8(g / n)
+			dst.Pix[offset+2] = uint8(b / n)
+			dst.Pix[offset+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// resizeGIF scales every frame of an animated GIF. Frames keep their palettes, so
+// pixels are sampled instead of averaged.
+func resizeGIF(data []byte, crop image.Rectangle, dstW, dstH int) ([]byte, error) {
+	g, err := decodeGIFLimited(data)
+	if err != nil {
+		return nil, err
+	}
+
+	scaleX := float64(dstW) / float64(crop.Dx())
+	scaleY := float64(dstH) / float64(crop.Dy())
+
+	for i, frame := range g.Image {
+		visible := frame.Bounds().Intersect(crop)
+		if visible.Empty() {
+			// Keep a single transparent pixel to preserve the frame's timing.
+			visible = image.Rect(crop.Min.X, crop.Min.Y, crop.Min.X+1, crop.Min.Y+1)
+		}
+
+		bounds := image.Rect(
+			int(float64(visible.Min.X-crop.Min.X)*scaleX),
+			int(float64(visible.Min.Y-crop.Min.Y)*scaleY),
+			maxInt(1, roundInt(float64(visible.Max.X-crop.Min.X)*scaleX)),
+			maxInt(1, roundInt(float64(visible.Max.Y-crop.Min.Y)*scaleY)),
+		).Intersect(image.Rect(0, 0, dstW, dstH))
+		if bounds.Empty() {
+			bounds = image.Rect(0, 0, 1, 1)
+		}
+		scaled := image.NewPaletted(bounds, frame.Palette)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			sy := crop.Min.Y + int((float64(y)+0.5)/scaleY)
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				sx := crop.Min.X + int((float64(x)+0.5)/scaleX)
+				if (image.Point{sx, sy}).In(frame.Bounds()) {
+					scaled.SetColorIndex(x, y, frame.ColorIndexAt(sx, sy))
+				} else if transparent, ok := transparentIndex(frame.Palette); ok {
+					scaled.SetColorIndex(x, y, transparent)
+				}
+			}
+		}
+		g.Image[i] = scaled
+	}
+	g.Config.Width = dstW
+	g.Config.Height = dstH
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func transparentIndex(palette []color.Color) (uint8, bool) {
+	for i, c := range palette {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			return uint8(i), true
+		}
+	}
+	return 0, false
+}
+
+func roundInt(f float64) int {
+	return int(math.Floor(f + 0.5))
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
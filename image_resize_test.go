@@ -0,0 +1,151 @@
+package memebot
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeTestImage(t *testing.T, format string, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = png.Encode(&buf, img)
+	}
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+func encodeTestGIF(t *testing.T, width, height, frames int) []byte {
+	g := &gif.GIF{}
+	palette := color.Palette{color.Transparent, color.Black, color.White}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+		frame.SetColorIndex(i%width, 0, 1)
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10*(i+1))
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, gif.EncodeAll(&buf, g))
+	return buf.Bytes()
+}
+
+func decodeTestImageConfig(t *testing.T, data []byte) (image.Config, string) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	return config, format
+}
+
+func TestParseResizeTransform(t *testing.T) {
+	transform, err := parseResizeTransform(url.Values{})
+	assert.NoError(t, err)
+	assert.Nil(t, transform)
+
+	transform, err = parseResizeTransform(url.Values{"w": {"480"}})
+	assert.NoError(t, err)
+	assert.Equal(t, &resizeTransform{480, 0, FitContain}, transform)
+
+	transform, err = parseResizeTransform(url.Values{"w": {"10"}, "h": {"20"}, "fit": {"cover"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "w10-h20-cover", transform.Key())
+
+	for _, query := range []url.Values{
+		{"w": {"0"}},
+		{"w": {"huge"}},
+		{"h": {"100000"}},
+		{"w": {"10"}, "fit": {"squish"}},
+		{"fit": {"cover"}},
+	} {
+		_, err := parseResizeTransform(query)
+		assert.IsType(t, badTransformError(""), err, "%v", query)
+	}
+}
+
+func TestResizeTransformGeometry(t *testing.T) {
+	for _, test := range []struct {
+		transform  resizeTransform
+		crop       image.Rectangle
+		dstW, dstH int
+	}{
+		{resizeTransform{480, 0, FitContain}, image.Rect(0, 0, 4000, 2000), 480, 240},
+		{resizeTransform{0, 100, FitContain}, image.Rect(0, 0, 4000, 2000), 200, 100},
+		{resizeTransform{480, 100, FitContain}, image.Rect(0, 0, 4000, 2000), 200, 100},
+		{resizeTransform{8000, 0, FitContain}, image.Rect(0, 0, 4000, 2000), 4000, 2000},
+		{resizeTransform{100, 100, FitCover}, image.Rect(1000, 0, 3000, 2000), 100, 100},
+		{resizeTransform{100, 100, FitFill}, image.Rect(0, 0, 4000, 2000), 100, 100},
+		{resizeTransform{100, 0, FitFill}, image.Rect(0, 0, 4000, 2000), 100, 50},
+	} {
+		crop, dstW, dstH := test.transform.geometry(4000, 2000)
+		assert.Equal(t, test.crop, crop, "%+v", test.transform)
+		assert.Equal(t, test.dstW, dstW, "%+v", test.transform)
+		assert.Equal(t, test.dstH, dstH, "%+v", test.transform)
+	}
+}
+
+func TestResizeTransformApply(t *testing.T) {
+	transform := &resizeTransform{width: 20, fit: FitContain}
+
+	for ext, format := range map[string]string{"png": "png", "jpg": "jpeg", "gif": "gif"} {
+		resized, err := transform.Apply(encodeTestImage(t, format, 40, 30), ext)
+		require.NoError(t, err, ext)
+
+		config, resizedFormat := decodeTestImageConfig(t, resized)
+		assert.Equal(t, format, resizedFormat, ext)
+		assert.Equal(t, 20, config.Width, ext)
+		assert.Equal(t, 15, config.Height, ext)
+	}
+}
+
+func TestResizeTransformKeepsSmallImages(t *testing.T) {
+	original := encodeTestImage(t, "png", 10, 10)
+	resized, err := (&resizeTransform{width: 20, fit: FitContain}).Apply(original, "png")
+	require.NoError(t, err)
+	assert.Equal(t, original, resized)
+}
+
+func TestResizeTransformAnimatedGIF(t *testing.T) {
+	resized, err := (&resizeTransform{width: 10, fit: FitContain}).Apply(encodeTestGIF(t, 20, 20, 3), "gif")
+	require.NoError(t, err)
+
+	g, err := gif.DecodeAll(bytes.NewReader(resized))
+	require.NoError(t, err)
+	assert.Len(t, g.Image, 3)
+	assert.Equal(t, []int{10, 20, 30}, g.Delay)
+	assert.Equal(t, 10, g.Config.Width)
+	assert.Equal(t, image.Rect(0, 0, 10, 10), g.Image[0].Bounds())
+}
+
+func TestResizeTransformUnsupported(t *testing.T) {
+	_, err := (&resizeTransform{width: 10, fit: FitContain}).Apply([]byte("data"), "txt")
+	assert.Equal(t, errTransformNotSupported, err)
+}
+
+func TestScaleImageAveragesPixels(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.RGBA{0, 0, 0, 255})
+	src.Set(1, 0, color.RGBA{200, 100, 50, 255})
+
+	dst := scaleImage(src, src.Bounds(), 1, 1)
+	assert.Equal(t, color.RGBA{100, 50, 25, 255}, dst.At(0, 0))
+}
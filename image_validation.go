@@ -0,0 +1,162 @@
+package memebot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrContentTypeMismatch = errors.New("content doesn't match extension")
+	ErrTruncatedImage      = errors.New("image is truncated")
+	ErrUndecodableImage    = errors.New("image can't be decoded")
+)
+
+// ImageInfo describes an image, as detected from its data.
+type ImageInfo struct {
+	ContentType string
+
+	// Zero if unknown.
+	Width  int
+	Height int
+}
+
+// ImageDescriber is implemented by memes that inspected their image when they were loaded.
+type ImageDescriber interface {
+	ImageInfo() ImageInfo
+}
+
+// imageAnalysis is the result of inspecting an image file when it's loaded.
+type imageAnalysis struct {
+	info              ImageInfo
+	perceptualHash    uint64
+	hasPerceptualHash bool
+
+	// Why the image is invalid, or nil if it's valid or couldn't be checked.
+	invalid error
+}
+
+// analyzeImage detects the content type and size of an image, and checks that it
+// matches ext and can be decoded completely. Types that can't be decoded, e.g. videos,
+// are only checked against the type expected for ext in mediaTypes, which defaults to
+// DefaultMediaTypes if nil.
+func analyzeImage(data []byte, ext string, mediaTypes MediaTypes) (a imageAnalysis) {
+	a.info.ContentType = http.DetectContentType(data)
+
+	mediaType, known := mediaTypes.mediaType(ext)
+	if !known {
+		return
+	}
+	decodable := resizableExtensions.Contains(ext)
+	if a.info.ContentType != mediaType.ContentType {
+		// Some variants of videos aren't recognized by the sniffer.
+		if decodable || a.info.ContentType != "application/octet-stream" {
+			a.invalid = ErrContentTypeMismatch
+		}
+		return
+	}
+	if !decodable {
+		return
+	}
+
+	config, format, err := decodeImageConfig(data)
+	if err != nil && err != ErrImageTooLarge {
+		a.invalid = ErrUndecodableImage
+		return
+	}
+	a.info.Width, a.info.Height = config.Width, config.Height
+
+	if isTruncated(data, format) {
+		a.invalid = ErrTruncatedImage
+		return
+	}
+	if err == ErrImageTooLarge {
+		// Too large to decode, but the header is fine.
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		a.invalid = ErrUndecodableImage
+		return
+	}
+	a.perceptualHash, a.hasPerceptualHash = differenceHash(img), true
+
+	// Only the first frame of GIFs has been decoded so far. GIFs with too many frames to
+	// decode are still valid, like other images that are too large.
+	if format == "gif" {
+		release := acquireTransformSlot()
+		_, err := decodeGIFLimited(data)
+		release()
+		if err != nil && err != ErrImageTooLarge {
+			a.invalid = ErrUndecodableImage
+		}
+	}
+	return
+}
+
+// isTruncated returns true if data is missing the marker that ends images in format.
+// Decoders don't report truncation consistently, so it's checked separately.
+func isTruncated(data []byte, format string) bool {
+	switch format {
+	case "jpeg":
+		// End of image must follow the last start of scan.
+		return bytes.LastIndex(data, []byte{0xff, 0xd9}) < bytes.LastIndex(data, []byte{0xff, 0xda})
+	case "png":
+		return bytes.LastIndex(data, []byte("IEND")) < bytes.LastIndex(data, []byte("IDAT"))
+	case "gif":
+		_, err := countGIFFrames(data)
+		return err != nil
+	}
+	return false
+}
+
+func (a imageAnalysis) ImageInfo() ImageInfo {
+	return a.info
+}
+
+func (a imageAnalysis) PerceptualHash() (uint64, bool) {
+	return a.perceptualHash, a.hasPerceptualHash
+}
+
+// ContentType returns the detected content type if it's an image or video type. Otherwise,
+// e.g. for types that aren't sniffed, the type should be inferred from the extension.
+func (a imageAnalysis) ContentType() string {
+	if strings.HasPrefix(a.info.ContentType, "image/") || strings.HasPrefix(a.info.ContentType, "video/") {
+		return a.info.ContentType
+	}
+	return ""
+}
+
+// invalidImageCounts counts invalid images by why they're invalid, for logging.
+type invalidImageCounts map[error]int
+
+func (c invalidImageCounts) Total() (total int) {
+	for _, count := range c {
+		total += count
+	}
+	return
+}
+
+func (c invalidImageCounts) String() string {
+	var reasons []string
+	for reason, count := range c {
+		reasons = append(reasons, fmt.Sprintf("%d %s", count, reason))
+	}
+	sort.Strings(reasons)
+	return strings.Join(reasons, ", ")
+}
+
+func logInvalidImages(log *Logger, invalid invalidImageCounts, rejected bool) {
+	if total := invalid.Total(); total > 0 {
+		if rejected {
+			log.Warn("rejected invalid images", "count", total, "reasons", invalid)
+		} else {
+			log.Warn("loaded invalid images", "count", total, "reasons", invalid)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package memebot
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeTestImages(t *testing.T) (pngData, jpegData, gifData []byte) {
+	img := newTestPattern(40, 30, 1)
+
+	var pngBuf, jpegBuf, gifBuf bytes.Buffer
+	require.NoError(t, png.Encode(&pngBuf, img))
+	require.NoError(t, jpeg.Encode(&jpegBuf, img, nil))
+	require.NoError(t, gif.Encode(&gifBuf, img, nil))
+	return pngBuf.Bytes(), jpegBuf.Bytes(), gifBuf.Bytes()
+}
+
+func TestAnalyzeImageValid(t *testing.T) {
+	pngData, jpegData, gifData := encodeTestImages(t)
+
+	for _, test := range []struct {
+		data        []byte
+		ext         string
+		contentType string
+	}{
+		{pngData, "png", "image/png"},
+		{jpegData, "jpg", "image/jpeg"},
+		{jpegData, "jpeg", "image/jpeg"},
+		{gifData, "gif", "image/gif"},
+	} {
+		analysis := analyzeImage(test.data, test.ext, nil)
+		assert.NoError(t, analysis.invalid, test.ext)
+		assert.Equal(t, ImageInfo{test.contentType, 40, 30}, analysis.ImageInfo(), test.ext)
+		assert.Equal(t, test.contentType, analysis.ContentType(), test.ext)
+		_, ok := analysis.PerceptualHash()
+		assert.True(t, ok, test.ext)
+	}
+}
+
+func TestAnalyzeImageMismatchedExtension(t *testing.T) {
+	pngData, _, _ := encodeTestImages(t)
+
+	analysis := analyzeImage(pngData, "jpg", nil)
+	assert.Equal(t, ErrContentTypeMismatch, analysis.invalid)
+	assert.Equal(t, "image/png", analysis.ContentType())
+
+	analysis = analyzeImage([]byte("not an image"), "gif", nil)
+	assert.Equal(t, ErrContentTypeMismatch, analysis.invalid)
+	assert.Equal(t, "", analysis.ContentType())
+}
+
+func TestAnalyzeImageTruncated(t *testing.T) {
+	pngData, jpegData, gifData := encodeTestImages(t)
+
+	for _, test := range []struct {
+		data []byte
+		ext  string
+	}{
+		{pngData, "png"},
+		{jpegData, "jpg"},
+		{gifData, "gif"},
+	} {
+		analysis := analyzeImage(test.data[:len(test.data)-20], test.ext, nil)
+		assert.Equal(t, ErrTruncatedImage, analysis.invalid, test.ext)
+		assert.Equal(t, 40, analysis.ImageInfo().Width, test.ext)
+	}
+}
+
+func TestAnalyzeImageUndecodable(t *testing.T) {
+	pngData, _, _ := encodeTestImages(t)
+
+	// Corrupt the image data, leaving the header intact.
+	corrupt := append([]byte(nil), pngData...)
+	for i := 60; i < len(corrupt)-20; i++ {
+		corrupt[i] ^= 0x55
+	}
+
+	analysis := analyzeImage(corrupt, "png", nil)
+	assert.Equal(t, ErrUndecodableImage, analysis.invalid)
+	_, ok := analysis.PerceptualHash()
+	assert.False(t, ok)
+}
+
+func TestAnalyzeImageUnknownExtension(t *testing.T) {
+	pngData, _, _ := encodeTestImages(t)
+
+	// Types without an expected content type are only sniffed.
+	analysis := analyzeImage(pngData, "bmp", nil)
+	assert.NoError(t, analysis.invalid)
+	assert.Equal(t, ImageInfo{ContentType: "image/png"}, analysis.ImageInfo())
+}
+
+func TestAnalyzeImageConfiguredTypes(t *testing.T) {
+	pngData, _, _ := encodeTestImages(t)
+
+	// Types that aren't configured are only sniffed, even if there's a default for them.
+	analysis := analyzeImage(pngData, "jpg", MediaTypes{"png": DefaultMediaTypes["png"]})
+	assert.NoError(t, analysis.invalid)
+
+	analysis = analyzeImage(pngData, "img", MediaTypes{"img": {ContentType: "image/jpeg"}})
+	assert.Equal(t, ErrContentTypeMismatch, analysis.invalid)
+}
+
+func TestAnalyzeImageLargeGIF(t *testing.T) {
+	analysis := analyzeImage(encodeTestGIF(t, 2, 2, MaxGIFFrames+1), "gif", nil)
+	assert.NoError(t, analysis.invalid)
+	assert.Equal(t, 2, analysis.ImageInfo().Width)
+}
+
+func TestInvalidImageCounts(t *testing.T) {
+	counts := invalidImageCounts{
+		ErrTruncatedImage:      2,
+		ErrContentTypeMismatch: 1,
+	}
+	assert.Equal(t, 3, counts.Total())
+	assert.Equal(t, "1 content doesn't match extension, 2 image is truncated", counts.String())
+}
+
+func TestFileServingMemepositoryValidateImages(t *testing.T) {
+	pngData, jpegData, _ := encodeTestImages(t)
+
+	dir, err := ioutil.TempDir("", "memebot-validate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for name, data := range map[string][]byte{
+		"valid.png":     pngData,
+		"mismatch.jpg":  pngData,
+		"truncated.jpg": jpegData[:len(jpegData)/2],
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), data, 0644))
+	}
+
+	for _, validate := range []bool{true, false} {
+		router := mux.NewRouter()
+		memepository := NewFileServingMemepository(FileServingMemepositoryConfig{
+			Path:            dir,
+			ImageExtensions: MakeSet("jpg", "png"),
+			Router:          router,
+			ValidateImages:  validate,
+		})
+		memes, err := memepository.Load()
+		require.NoError(t, err)
+
+		if validate {
+			assert.Equal(t, 1, memes.Len())
+			assert.Empty(t, memes.FindByKeyword("mismatch"))
+			assert.Empty(t, memes.FindByKeyword("truncated"))
+		} else {
+			assert.Equal(t, 3, memes.Len())
+
+			// Served with the type of its content, not its extension.
+			meme := findTestFileMeme(t, memepository, "mismatch")
+			resp := serveTestRequest(t, router, meme.URL().String(), nil)
+			require.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, "image/png", resp.Header().Get("Content-Type"))
+		}
+
+		meme := findTestFileMeme(t, memepository, "valid")
+		assert.Equal(t, image.Pt(40, 30), image.Pt(meme.ImageInfo().Width, meme.ImageInfo().Height))
+	}
+}
@@ -0,0 +1,246 @@
+package memebot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// LogLevel is the severity of a log message.
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota - 1
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = map[LogLevel]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l LogLevel) String() string {
+	if name, found := logLevelNames[l]; found {
+		return name
+	}
+	return strconv.Itoa(int(l))
+}
+
+// ParseLogLevel parses the name of a level, e.g. "debug" or "warn".
+func ParseLogLevel(name string) (LogLevel, error) {
+	for level, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level: %q", name)
+}
+
+// UserText marks a log field value as text written by a Slack user, e.g. a message or a
+// keyword from one. It's replaced with RedactedText by loggers that redact user text.
+type UserText string
+
+// RedactedText replaces UserText in the output of loggers that redact it.
+const RedactedText = "[redacted]"
+
+type LoggerConfig struct {
+	// Defaults to os.Stderr.
+	Output io.Writer
+
+	// Messages below this level aren't written. Defaults to LevelInfo.
+	Level LogLevel
+
+	// If true, UserText values are never written.
+	RedactUserText bool
+
+	// If not nil, called by Fatal before the process exits, e.g. to send buffered events.
+	BeforeExit func()
+}
+
+// Logger writes levelled messages with key/value fields, one line per message, like:
+//
+//	time=2016-01-02T15:04:05Z level=info msg="loaded memes" count=42 path=/var/memes
+//
+// Loggers are safe to use concurrently. A nil *Logger writes to DefaultLogger.
+type Logger struct {
+	out    *logOutput
+	fields []interface{}
+}
+
+// logOutput is shared by a logger and the loggers created from it with With.
+type logOutput struct {
+	lock       sync.Mutex
+	w          io.Writer
+	level      LogLevel
+	redact     bool
+	now        func() time.Time
+	beforeExit func()
+}
+
+// DefaultLogger is used by components that weren't given a logger.
+var DefaultLogger = NewLogger(LoggerConfig{})
+
+func NewLogger(config LoggerConfig) *Logger {
+	if config.Output == nil {
+		config.Output = os.Stderr
+	}
+	return &Logger{
+		out: &logOutput{
+			w:          config.Output,
+			level:      config.Level,
+			redact:     config.RedactUserText,
+			now:        time.Now,
+			beforeExit: config.BeforeExit,
+		},
+	}
+}
+
+// NewDiscardLogger returns a logger that doesn't write anything.
+func NewDiscardLogger() *Logger {
+	return NewLogger(LoggerConfig{Output: discardWriter{}, Level: LevelError + 1})
+}
+
+type discardWriter struct{}
+
+func (discardWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// With returns a logger that adds keyvals, alternating keys and values, to every message.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	l = l.orDefault()
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	return &Logger{out: l.out, fields: fields}
+}
+
+// Enabled returns true if messages at level are written.
+func (l *Logger) Enabled(level LogLevel) bool {
+	return level >= l.orDefault().out.level
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.Log(LevelDebug, msg, keyvals...)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.Log(LevelInfo, msg, keyvals...)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.Log(LevelWarn, msg, keyvals...)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.Log(LevelError, msg, keyvals...)
+}
+
+// Fatal logs msg at LevelError, calls the BeforeExit function if any, and exits the process.
+func (l *Logger) Fatal(msg string, keyvals ...interface{}) {
+	l.Log(LevelError, msg, keyvals...)
+	if beforeExit := l.orDefault().out.beforeExit; beforeExit != nil {
+		beforeExit()
+	}
+	os.Exit(1)
+}
+
+// Log writes msg at level with keyvals, alternating keys and values, after the
+// logger's own fields.
+func (l *Logger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	l = l.orDefault()
+	if !l.Enabled(level) {
+		return
+	}
+
+	var buf bytes.Buffer
+	out := l.out
+	writeLogField(&buf, "time", out.now().UTC().Format(time.RFC3339))
+	writeLogField(&buf, "level", level.String())
+	writeLogField(&buf, "msg", msg)
+	writeLogFields(&buf, l.fields, out.redact)
+	writeLogFields(&buf, keyvals, out.redact)
+	buf.WriteByte('\n')
+
+	out.lock.Lock()
+	defer out.lock.Unlock()
+	out.w.Write(buf.Bytes())
+}
+
+func (l *Logger) orDefault() *Logger {
+	if l == nil {
+		return DefaultLogger
+	}
+	return l
+}
+
+func writeLogFields(buf *bytes.Buffer, keyvals []interface{}, redact bool) {
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		if i+1 == len(keyvals) {
+			writeLogField(buf, key, "(missing)")
+			break
+		}
+
+		value := keyvals[i+1]
+		if text, ok := value.(UserText); ok {
+			if redact {
+				writeLogField(buf, key, RedactedText)
+				continue
+			}
+			value = string(text)
+		}
+		writeLogField(buf, key, formatLogValue(value))
+	}
+}
+
+func formatLogValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "nil"
+	case error:
+		return value.Error()
+	case fmt.Stringer:
+		return value.String()
+	case time.Time:
+		return value.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprint(value)
+}
+
+// writeLogField writes " key=value", quoting value if necessary. The space is omitted
+// before the first field.
+func writeLogField(buf *bytes.Buffer, key, value string) {
+	if buf.Len() > 0 {
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(key)
+	buf.WriteByte('=')
+	if needsLogQuoting(value) {
+		buf.WriteString(strconv.Quote(value))
+	} else {
+		buf.WriteString(value)
+	}
+}
+
+func needsLogQuoting(value string) bool {
+	if value == "" {
+		return true
+	}
+	for _, r := range value {
+		if unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package memebot
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger(config LoggerConfig) (*Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	config.Output = &buf
+	logger := NewLogger(config)
+	logger.out.now = func() time.Time {
+		return time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)
+	}
+	return logger, &buf
+}
+
+func TestLoggerFormat(t *testing.T) {
+	logger, buf := newTestLogger(LoggerConfig{})
+
+	logger.Info("loaded memes", "count", 42, "path", "/var/memes", "err", errors.New("bucket on fire"),
+		"empty", "", "quote", `say "cheese"`, "eq", "a=b")
+	assert.Equal(t, `time=2016-01-02T15:04:05Z level=info msg="loaded memes" count=42 path=/var/memes `+
+		`err="bucket on fire" empty="" quote="say \"cheese\"" eq="a=b"`+"\n", buf.String())
+
+	buf.Reset()
+	logger.Warn("odd", "key")
+	assert.Equal(t, "time=2016-01-02T15:04:05Z level=warn msg=odd key=(missing)\n", buf.String())
+}
+
+func TestLoggerLevels(t *testing.T) {
+	logger, buf := newTestLogger(LoggerConfig{Level: LevelWarn})
+	assert.False(t, logger.Enabled(LevelInfo))
+	assert.True(t, logger.Enabled(LevelError))
+
+	logger.Debug("debug")
+	logger.Info("info")
+	assert.Empty(t, buf.String())
+
+	logger.Warn("warn")
+	logger.Error("error")
+	assert.Equal(t, "time=2016-01-02T15:04:05Z level=warn msg=warn\n"+
+		"time=2016-01-02T15:04:05Z level=error msg=error\n", buf.String())
+}
+
+func TestLoggerWith(t *testing.T) {
+	logger, buf := newTestLogger(LoggerConfig{})
+	child := logger.With("component", "bot")
+
+	child.Info("hello", "n", 1)
+	logger.Info("hello")
+	assert.Equal(t, "time=2016-01-02T15:04:05Z level=info msg=hello component=bot n=1\n"+
+		"time=2016-01-02T15:04:05Z level=info msg=hello\n", buf.String())
+}
+
+func TestLoggerRedactsUserText(t *testing.T) {
+	logger, buf := newTestLogger(LoggerConfig{RedactUserText: true})
+	logger.Info("no meme found", "channel", "C123", "keyword", UserText("secret plans"))
+	assert.Equal(t, "time=2016-01-02T15:04:05Z level=info msg=\"no meme found\" channel=C123 keyword=[redacted]\n",
+		buf.String())
+
+	logger, buf = newTestLogger(LoggerConfig{})
+	logger.Info("no meme found", "keyword", UserText("secret plans"))
+	assert.Contains(t, buf.String(), `keyword="secret plans"`)
+}
+
+func TestNilLoggerUsesDefault(t *testing.T) {
+	defaultLogger := DefaultLogger
+	defer func() { DefaultLogger = defaultLogger }()
+
+	var buf *bytes.Buffer
+	DefaultLogger, buf = newTestLogger(LoggerConfig{})
+
+	var logger *Logger
+	logger.Info("hello")
+	logger.With("a", 1).Info("hello")
+	assert.Equal(t, "time=2016-01-02T15:04:05Z level=info msg=hello\n"+
+		"time=2016-01-02T15:04:05Z level=info msg=hello a=1\n", buf.String())
+}
+
+func TestParseLogLevel(t *testing.T) {
+	level, err := ParseLogLevel("WARN")
+	require.NoError(t, err)
+	assert.Equal(t, LevelWarn, level)
+
+	level, err = ParseLogLevel("debug")
+	require.NoError(t, err)
+	assert.Equal(t, LevelDebug, level)
+
+	_, err = ParseLogLevel("loud")
+	assert.Error(t, err)
+}
@@ -0,0 +1,89 @@
+package memebot
+
+import (
+	"errors"
+	"sort"
+)
+
+var ErrMediaTypeNotSupported = errors.New("not supported for this type of meme")
+
+// MediaType describes a type of file that memes can be.
+type MediaType struct {
+	ContentType string
+
+	// Slack doesn't display videos inline, so video memes are posted as attachments.
+	Video bool
+
+	// Whether memes of this type can be resized and transformed by an ObjectServer.
+	Resizable bool
+
+	// Whether memes of this type can be captioned and included in collages.
+	Captionable bool
+}
+
+// MediaTypes maps normalized extensions to media types.
+type MediaTypes map[string]MediaType
+
+// DefaultMediaTypes are all the types of memes that can be served. Only images that can be
+// decoded can be resized or captioned.
+var DefaultMediaTypes = MediaTypes{
+	"jpg":  {ContentType: "image/jpeg", Resizable: true, Captionable: true},
+	"jpeg": {ContentType: "image/jpeg", Resizable: true, Captionable: true},
+	"png":  {ContentType: "image/png", Resizable: true, Captionable: true},
+	"gif":  {ContentType: "image/gif", Resizable: true, Captionable: true},
+	"webp": {ContentType: "image/webp"},
+	"mp4":  {ContentType: "video/mp4", Video: true},
+	"webm": {ContentType: "video/webm", Video: true},
+}
+
+// Extensions returns the extensions of all the types.
+func (t MediaTypes) Extensions() StringSet {
+	extensions := make(StringSet)
+	for ext := range t {
+		extensions[ext] = struct{}{}
+	}
+	return extensions
+}
+
+// SortedExtensions is like Extensions, but returns a sorted slice.
+func (t MediaTypes) SortedExtensions() (extensions []string) {
+	for ext := range t {
+		extensions = append(extensions, ext)
+	}
+	sort.Strings(extensions)
+	return
+}
+
+// mediaType returns the type of files with extension ext, falling back to
+// DefaultMediaTypes if t is nil.
+func (t MediaTypes) mediaType(ext string) (MediaType, bool) {
+	if t == nil {
+		t = DefaultMediaTypes
+	}
+	mediaType, found := t[ext]
+	return mediaType, found
+}
+
+// resizable returns false if files with extension ext have a known type that can't be
+// resized. Transforms decide for themselves whether they support unknown types.
+func (t MediaTypes) resizable(ext string) bool {
+	mediaType, found := t.mediaType(ext)
+	return !found || mediaType.Resizable
+}
+
+// captionable is like resizable, but for captions and collages.
+func (t MediaTypes) captionable(ext string) bool {
+	mediaType, found := t.mediaType(ext)
+	return !found || mediaType.Captionable
+}
+
+// isVideo returns true if the file at meme's URL has a known video type.
+func (t MediaTypes) isVideo(meme Meme) bool {
+	mediaType, found := t.mediaType(memeExtension(meme))
+	return found && mediaType.Video
+}
+
+// memeExtension returns the normalized extension of the file a meme links to.
+func memeExtension(meme Meme) string {
+	return getNormalizedExtensionWithoutDot(meme.URL().Path)
+}
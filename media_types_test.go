@@ -0,0 +1,127 @@
+package memebot
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// testMP4Data is the start of an MP4 file, which is enough to sniff its type.
+const testMP4Data = "\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom" + "\x00\x00\x00\x08free"
+
+func TestMediaTypes(t *testing.T) {
+	mediaTypes := MediaTypes{
+		"jpg": {ContentType: "image/jpeg", Resizable: true, Captionable: true},
+		"gif": {ContentType: "image/gif", Captionable: true},
+		"mp4": {ContentType: "video/mp4", Video: true},
+	}
+
+	assert.Equal(t, MakeSet("jpg", "gif", "mp4"), mediaTypes.Extensions())
+	assert.Equal(t, []string{"gif", "jpg", "mp4"}, mediaTypes.SortedExtensions())
+
+	assert.True(t, mediaTypes.resizable("jpg"))
+	assert.False(t, mediaTypes.resizable("gif"))
+	assert.True(t, mediaTypes.captionable("gif"))
+	assert.False(t, mediaTypes.captionable("mp4"))
+
+	// Unknown types are left to transforms to decide.
+	assert.True(t, mediaTypes.resizable("bmp"))
+
+	assert.True(t, mediaTypes.isVideo(NewMockMeme("http://example.com/cat.MP4?w=100")))
+	assert.False(t, mediaTypes.isVideo(NewMockMeme("http://example.com/cat.jpg")))
+
+	// Nil uses the defaults.
+	assert.True(t, MediaTypes(nil).isVideo(NewMockMeme("http://example.com/cat.webm")))
+	assert.False(t, MediaTypes(nil).resizable("webp"))
+}
+
+func TestAnalyzeImageVideo(t *testing.T) {
+	analysis := analyzeImage([]byte(testMP4Data), "mp4", nil)
+	assert.NoError(t, analysis.invalid)
+	assert.Equal(t, "video/mp4", analysis.ContentType())
+
+	// Unrecognized variants are given the benefit of the doubt.
+	analysis = analyzeImage([]byte("\x00\x00\x00\x14ftypqt  "), "mp4", nil)
+	assert.NoError(t, analysis.invalid)
+
+	pngData, _, _ := encodeTestImages(t)
+	analysis = analyzeImage(pngData, "webm", nil)
+	assert.Equal(t, ErrContentTypeMismatch, analysis.invalid)
+}
+
+func TestObjectServerVideo(t *testing.T) {
+	memepository, router, dir := newTestFileServingMemepositoryWithTypes(t,
+		map[string]string{"cat.mp4": testMP4Data}, ObjectServerConfig{DefaultWidth: 100}, DefaultMediaTypes)
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "cat")
+
+	// Videos can't be resized.
+	assert.Empty(t, meme.URL().RawQuery)
+	_, err := meme.TransformedURL(url.Values{"w": {"50"}})
+	assert.Equal(t, errTransformNotSupported, err)
+
+	resp := serveTestRequest(t, router, meme.URL().String(), nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "video/mp4", resp.Header().Get("Content-Type"))
+	assert.Equal(t, testMP4Data, resp.Body.String())
+
+	resp = serveTestRequest(t, router, meme.URL().String(), http.Header{"Range": {"bytes=4-7"}})
+	require.Equal(t, http.StatusPartialContent, resp.Code)
+	assert.Equal(t, "video/mp4", resp.Header().Get("Content-Type"))
+	assert.Equal(t, "ftyp", resp.Body.String())
+
+	resp = serveTestRequest(t, router, meme.URL().String()+"?w=50", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestObjectServerDisabledResizing(t *testing.T) {
+	mediaTypes := MediaTypes{"gif": {ContentType: "image/gif", Captionable: true}}
+	memepository, router, dir := newTestFileServingMemepositoryWithTypes(t,
+		map[string]string{"party.gif": string(encodeTestGIF(t, 40, 30, 2))},
+		ObjectServerConfig{DefaultWidth: 20, MediaTypes: mediaTypes}, mediaTypes)
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "party")
+
+	assert.Empty(t, meme.URL().RawQuery)
+	_, err := meme.TransformedURL(url.Values{"reverse": {"1"}})
+	assert.Equal(t, errTransformNotSupported, err)
+
+	resp := serveTestRequest(t, router, meme.URL().String()+"?reverse=1", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestImageCaptionerUnsupportedType(t *testing.T) {
+	captioner, _ := newTestCaptioner(t)
+
+	_, err := captioner.Caption(context.Background(), NewMockMeme("http://example.com/cat.mp4", "cat"), Caption{Top: "top"})
+	assert.Equal(t, ErrMediaTypeNotSupported, err)
+
+	captioner.MediaTypes = MediaTypes{"jpg": {ContentType: "image/jpeg"}}
+	_, err = captioner.Caption(context.Background(), NewMockMeme("http://example.com/cat.jpg", "cat"), Caption{Top: "top"})
+	assert.Equal(t, ErrMediaTypeNotSupported, err)
+}
+
+func TestImageCollagerSkipsUnsupportedTypes(t *testing.T) {
+	memepository, _, dir := newTestFileServingMemepository(t, map[string]string{
+		"cat1.jpg": string(encodeTestImage(t, "jpeg", 100, 60)),
+		"cat2.jpg": string(encodeTestImage(t, "jpeg", 30, 30)),
+	}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+	collager, _ := newTestCollager(t)
+
+	collage, err := collager.Collage(context.Background(), []Meme{
+		findTestFileMeme(t, memepository, "cat1"),
+		NewMockMeme("http://example.com/cat3.mp4", "cat3"),
+		findTestFileMeme(t, memepository, "cat2"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cat1", "cat2"}, collage.Keywords())
+
+	_, err = collager.Collage(context.Background(), []Meme{NewMockMeme("http://example.com/cat3.mp4", "cat3")})
+	assert.Equal(t, ErrMediaTypeNotSupported, err)
+}
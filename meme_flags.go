@@ -0,0 +1,171 @@
+package memebot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// MemeMetadataFileName is the name of the file in an images directory that stores flags
+// for the memes in it. It's keyed by content hash, so flags are kept when memes are renamed.
+const MemeMetadataFileName = ".memebot.json"
+
+// MemeFlags are set by curators.
+type MemeFlags struct {
+	// NSFW memes are only posted in channels that allow them.
+	NSFW bool `json:"nsfw,omitempty"`
+
+	// Retired memes are never posted or listed, but are still served so old links keep working.
+	Retired bool `json:"retired,omitempty"`
+}
+
+func (f MemeFlags) IsZero() bool {
+	return f == MemeFlags{}
+}
+
+// FlaggedMeme is implemented by memes that can have flags.
+type FlaggedMeme interface {
+	Meme
+	Flags() MemeFlags
+}
+
+// memeFlags returns the flags of meme, or of the meme it wraps.
+func memeFlags(meme Meme) MemeFlags {
+	for {
+		if flagged, ok := meme.(FlaggedMeme); ok {
+			return flagged.Flags()
+		}
+		wrapper, ok := meme.(memeWrapper)
+		if !ok {
+			return MemeFlags{}
+		}
+		meme = wrapper.unwrap()
+	}
+}
+
+// filterNSFW returns the memes that may be posted with options.
+func filterNSFW(memes []Meme, options SearchOptions) []Meme {
+	if options.AllowNSFW {
+		return memes
+	}
+
+	var filtered []Meme
+	for _, meme := range memes {
+		if !memeFlags(meme).NSFW {
+			filtered = append(filtered, meme)
+		}
+	}
+	return filtered
+}
+
+// readMemeMetadata returns the flags stored in dir, keyed by content hash.
+func readMemeMetadata(fs FileSystem, dir string) (map[string]MemeFlags, error) {
+	metadata := make(map[string]MemeFlags)
+
+	file, err := fs.Open(filepath.Join(dir, MemeMetadataFileName))
+	if os.IsNotExist(err) {
+		return metadata, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %s", MemeMetadataFileName, err)
+	}
+	return metadata, nil
+}
+
+func writeMemeMetadata(dir string, metadata map[string]MemeFlags) error {
+	for hash, flags := range metadata {
+		if flags.IsZero() {
+			delete(metadata, hash)
+		}
+	}
+
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomically(filepath.Join(dir, MemeMetadataFileName), append(data, '\n'), 0644)
+}
+
+// MoveMemeFlags moves the flags of the meme in dir whose content was oldData to the content
+// hash of newData, so they're kept when a file is rewritten, e.g. to strip its metadata.
+func MoveMemeFlags(dir string, oldData, newData []byte) error {
+	oldHash, err := generateSha1Base64Hash(bytes.NewReader(oldData))
+	if err != nil {
+		return err
+	}
+	newHash, err := generateSha1Base64Hash(bytes.NewReader(newData))
+	if err != nil {
+		return err
+	}
+
+	metadata, err := readMemeMetadata(defaultFileSystem{}, dir)
+	if err != nil {
+		return err
+	}
+	flags, found := metadata[oldHash]
+	if !found {
+		return nil
+	}
+	delete(metadata, oldHash)
+	metadata[newHash] = flags
+	return writeMemeMetadata(dir, metadata)
+}
+
+// SetFlags stores the flags of the meme with the content hash hash, and reloads the index.
+func (m *FileServingMemepository) SetFlags(hash string, flags MemeFlags) (*FileMeme, error) {
+	if err := m.writable(); err != nil {
+		return nil, err
+	}
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+
+	if _, found := m.FindByContentHash(hash); !found {
+		return nil, ErrMemeNotFound
+	}
+	if err := m.updateMetadata(hash, flags); err != nil {
+		return nil, err
+	}
+	return m.reloadAndFind(hash)
+}
+
+// updateMetadata must be called with writeLock held.
+func (m *FileServingMemepository) updateMetadata(hash string, flags MemeFlags) error {
+	metadata, err := readMemeMetadata(m.FileSystem, m.Path)
+	if err != nil {
+		return err
+	}
+	if metadata[hash] == flags {
+		return nil
+	}
+	metadata[hash] = flags
+	return writeMemeMetadata(m.Path, metadata)
+}
+
+// AllMemes returns all the memes in the directory, including retired ones, sorted by file name.
+func (m *FileServingMemepository) AllMemes() []*FileMeme {
+	if _, err := m.Load(); err != nil {
+		return nil
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	memes := make([]*FileMeme, 0, len(m.memesById))
+	for _, meme := range m.memesById {
+		memes = append(memes, meme)
+	}
+	sort.Sort(fileMemesByPath(memes))
+	return memes
+}
+
+type fileMemesByPath []*FileMeme
+
+func (s fileMemesByPath) Len() int           { return len(s) }
+func (s fileMemesByPath) Less(i, j int) bool { return s[i].path < s[j].path }
+func (s fileMemesByPath) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
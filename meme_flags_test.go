@@ -0,0 +1,165 @@
+package memebot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type MockFlaggedMeme struct {
+	MockMeme
+	flags MemeFlags
+}
+
+func (m MockFlaggedMeme) Flags() MemeFlags {
+	return m.flags
+}
+
+func NewMockFlaggedMeme(url string, flags MemeFlags, keywords ...string) Meme {
+	return MockFlaggedMeme{MockMeme{mustParseURL(url), keywords}, flags}
+}
+
+func writeTestMemeMetadata(t *testing.T, dir string, metadata map[string]MemeFlags) {
+	data, err := json.Marshal(metadata)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, MemeMetadataFileName), data, 0644))
+}
+
+func readTestMemeMetadata(t *testing.T, dir string) map[string]MemeFlags {
+	metadata, err := readMemeMetadata(defaultFileSystem{}, dir)
+	require.NoError(t, err)
+	return metadata
+}
+
+func TestFileServingMemepositoryLoadsFlags(t *testing.T) {
+	memepository, router, dir := newTestFileServingMemepository(t, map[string]string{
+		"cat.jpg": "foo data",
+		"dog.jpg": "bar data",
+	}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+	writeTestMemeMetadata(t, dir, map[string]MemeFlags{
+		testContentHash(t, "foo data"): {NSFW: true},
+		testContentHash(t, "bar data"): {Retired: true},
+	})
+	require.NoError(t, memepository.Reload())
+
+	memes, err := memepository.Load()
+	require.NoError(t, err)
+	require.Equal(t, 1, memes.Len())
+	assert.Equal(t, MemeFlags{NSFW: true}, memeFlags(memes.Memes()[0]))
+
+	// Retired memes aren't listed, but are still served.
+	all := memepository.AllMemes()
+	require.Len(t, all, 2)
+	assert.Equal(t, []string{"dog"}, all[1].Keywords())
+	assert.True(t, all[1].Flags().Retired)
+	resp := serveTestRequest(t, router, all[1].URL().String(), nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestFileServingMemepositoryInvalidMetadata(t *testing.T) {
+	memepository, _, dir := newTestFileServingMemepository(t, map[string]string{
+		"cat.jpg": "foo data",
+		"dog.jpg": "bar data",
+	}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+	writeTestMemeMetadata(t, dir, map[string]MemeFlags{
+		testContentHash(t, "foo data"): {NSFW: true},
+		testContentHash(t, "bar data"): {Retired: true},
+	})
+	require.NoError(t, memepository.Reload())
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, MemeMetadataFileName), []byte("{"), 0644))
+
+	// The memes loaded before are kept, with their flags.
+	assert.Error(t, memepository.Reload())
+	memes, err := memepository.Load()
+	require.NoError(t, err)
+	require.Equal(t, 1, memes.Len())
+	assert.Equal(t, MemeFlags{NSFW: true}, memeFlags(memes.Memes()[0]))
+	assert.Empty(t, memes.FindByKeyword("dog"))
+}
+
+func TestFileServingMemepositorySetFlags(t *testing.T) {
+	memepository, _, dir := newTestFileServingMemepository(t, map[string]string{
+		"cat.jpg": "foo data",
+		"dog.jpg": "bar data",
+	}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+	id := testContentHash(t, "foo data")
+
+	meme, err := memepository.SetFlags(id, MemeFlags{Retired: true})
+	require.NoError(t, err)
+	assert.True(t, meme.Flags().Retired)
+	assert.Equal(t, map[string]MemeFlags{id: {Retired: true}}, readTestMemeMetadata(t, dir))
+	memes, _ := memepository.Load()
+	assert.Empty(t, memes.FindByKeyword("cat"))
+
+	// Flags are kept when memes are renamed.
+	meme, err = memepository.SetKeywords(id, []string{"grumpy"})
+	require.NoError(t, err)
+	assert.True(t, meme.Flags().Retired)
+
+	_, err = memepository.SetFlags(id, MemeFlags{})
+	require.NoError(t, err)
+	assert.Empty(t, readTestMemeMetadata(t, dir))
+	memes, _ = memepository.Load()
+	assert.Len(t, memes.FindByKeyword("grumpy"), 1)
+
+	_, err = memepository.SetFlags("nope", MemeFlags{NSFW: true})
+	assert.Equal(t, ErrMemeNotFound, err)
+}
+
+func TestFileServingMemepositoryDeleteRemovesFlags(t *testing.T) {
+	memepository, _, dir := newTestFileServingMemepository(t, map[string]string{"cat.jpg": "foo data"}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+	id := testContentHash(t, "foo data")
+
+	_, err := memepository.SetFlags(id, MemeFlags{NSFW: true})
+	require.NoError(t, err)
+	_, err = memepository.DeleteMeme(id)
+	require.NoError(t, err)
+	assert.Empty(t, readTestMemeMetadata(t, dir))
+}
+
+func TestMoveMemeFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memebot-flags")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeTestMemeMetadata(t, dir, map[string]MemeFlags{
+		testContentHash(t, "foo data"): {NSFW: true},
+	})
+
+	require.NoError(t, MoveMemeFlags(dir, []byte("foo data"), []byte("stripped foo")))
+	require.NoError(t, MoveMemeFlags(dir, []byte("bar data"), []byte("stripped bar")))
+	assert.Equal(t, map[string]MemeFlags{
+		testContentHash(t, "stripped foo"): {NSFW: true},
+	}, readTestMemeMetadata(t, dir))
+}
+
+func TestMemepositorySearcherNSFW(t *testing.T) {
+	searcher := &MemepositorySearcher{&MockMemepository{NewTestMemeIndex(
+		NewMockFlaggedMeme("http://example.com/cat.jpg", MemeFlags{NSFW: true}, "cat"),
+		NewMockMeme("http://example.com/dog.jpg", "dog"),
+	)}}
+
+	_, err := searcher.FindMeme("cat")
+	assert.Equal(t, ErrNoMemeFound, err)
+
+	meme, err := searcher.FindMemeWithOptions("cat", SearchOptions{AllowNSFW: true})
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/cat.jpg", meme.URL().String())
+
+	// Plurals fall back to the singular, which is still filtered.
+	_, err = searcher.FindMemes("cats", 2, SearchOptions{})
+	assert.Equal(t, ErrNoMemeFound, err)
+
+	memes, err := searcher.FindMemes("cats", 2, SearchOptions{AllowNSFW: true})
+	require.NoError(t, err)
+	assert.Len(t, memes, 1)
+}
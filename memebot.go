@@ -3,8 +3,8 @@ package memebot
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/nlopes/slack"
@@ -25,12 +25,23 @@ type ErrorHandler interface {
 	OnHelp(sample string) (reply string)
 }
 
+// WishErrorHandler is implemented by ErrorHandlers that customize the reply when no meme
+// is found and the keyword can be added to the wishlist.
+type WishErrorHandler interface {
+	OnNoMemeFoundWish(keyword string) (reply string)
+}
+
 type DefaultErrorHandler struct{}
 
 func (h DefaultErrorHandler) OnNoMemeFound(keyword string) string {
 	return fmt.Sprintf("Sorry, I couldn't find a meme for “%s”.", keyword)
 }
 
+func (h DefaultErrorHandler) OnNoMemeFoundWish(keyword string) string {
+	return fmt.Sprintf("%s React with :%s: to wish for one, and I'll let you know when there is.",
+		h.OnNoMemeFound(keyword), WishReaction)
+}
+
 func (h DefaultErrorHandler) OnPhraseNotUnderstood(phrase, sample string) string {
 	return fmt.Sprintf("Sorry, I'm not sure what you mean by:\n> %s\n%s", phrase, h.OnHelp(sample))
 }
@@ -39,6 +50,22 @@ func (DefaultErrorHandler) OnHelp(sample string) string {
 	return fmt.Sprintf("Try something like “%s”", sample)
 }
 
+// ChannelSettings customize the bot's behavior in a single channel.
+type ChannelSettings struct {
+	// If true, searchers marked as external in a ChainSearcher are skipped.
+	DisableExternalSearch bool
+
+	// If true, memes flagged as NSFW may be posted.
+	AllowNSFW bool
+}
+
+func (s ChannelSettings) searchOptions() SearchOptions {
+	return SearchOptions{
+		DisableExternal: s.DisableExternalSearch,
+		AllowNSFW:       s.AllowNSFW,
+	}
+}
+
 type MemeBotConfig struct {
 	Parser   MessageParser
 	Searcher MemeSearcher
@@ -47,7 +74,7 @@ type MemeBotConfig struct {
 	ErrorHandler ErrorHandler
 
 	// Default will not print any log messages.
-	Log *log.Logger
+	Log *Logger
 
 	// If a message hasn't been replied to in this time, don't reply.
 	// Prevents the bot from replying to messages too late and not making sense.
@@ -58,6 +85,44 @@ type MemeBotConfig struct {
 	// The ErrorHandler's OnPhraseNotUnderstood will still only be called if the
 	// bot was mentioned.
 	ParseAllMessages bool
+
+	// Settings for specific channels, keyed by channel ID or name (without the #).
+	ChannelSettings map[string]ChannelSettings
+
+	// If not nil, captions parsed by Parser are drawn on memes.
+	Captioner Captioner
+
+	// If not nil, requests for multiple memes are replied to with a collage of them.
+	Collager Collager
+
+	// Used to recognize video memes. Defaults to DefaultMediaTypes.
+	MediaTypes MediaTypes
+
+	// If not nil, messages, searches and connection changes are reported to Metrics.
+	Metrics *Metrics
+
+	// If not nil, matched messages, their outcomes, reply errors and connection changes
+	// are published to Events.
+	Events *EventBus
+
+	// If not nil, every request for a meme is recorded in Stats, and usage statistics
+	// can be asked for if Parser.ParseStats is set.
+	Stats StatsStore
+
+	// If not nil, replies to keywords without memes offer to add them to Wishlist when
+	// reacted to with WishReaction, and the wishlist can be asked for if
+	// Parser.ParseWishes is set. Requesters are notified when Memepository has a meme
+	// for their wish, which must be set if Wishlist is.
+	Wishlist     *Wishlist
+	Memepository Memepository
+
+	// How often Memepository is checked for memes that fulfill wishes. Defaults to
+	// DefaultWishCheckInterval.
+	WishCheckInterval time.Duration
+
+	// If true, requesters are notified of fulfilled wishes in direct messages, instead
+	// of being mentioned in the channel they wished from.
+	NotifyWishesByDM bool
 }
 
 func (c *MemeBotConfig) Validate() error {
@@ -69,7 +134,13 @@ func (c *MemeBotConfig) Validate() error {
 		c.MaxReplyTimeout = DefaultReplyTimeout
 	}
 	if c.Log == nil {
-		c.Log = log.New(ioutil.Discard, "", 0)
+		c.Log = NewDiscardLogger()
+	}
+	if c.WishCheckInterval <= 0 {
+		c.WishCheckInterval = DefaultWishCheckInterval
+	}
+	if c.Wishlist != nil && c.Memepository == nil {
+		return errors.New("Memepository must be specified with Wishlist")
 	}
 
 	if err := c.Parser.Validate(); err != nil {
@@ -94,6 +165,31 @@ type MemeBot struct {
 
 	// Map of channel ID to channel.
 	channelsById map[string]*slack.Channel
+
+	statusLock sync.Mutex
+	status     BotStatus
+
+	wishOffers *wishOffers
+}
+
+type ConnectionState string
+
+const (
+	StateConnecting   ConnectionState = "connecting"
+	StateConnected    ConnectionState = "connected"
+	StateDisconnected ConnectionState = "disconnected"
+)
+
+// BotStatus describes the bot's connection to Slack.
+type BotStatus struct {
+	State ConnectionState
+
+	// Since the connection was last established.
+	ConnectedAt time.Time
+
+	// The last latency reported by Slack, and when it was reported.
+	Latency           time.Duration
+	LatencyReportedAt time.Time
 }
 
 var (
@@ -116,6 +212,8 @@ func NewMemeBot(authToken string, config MemeBotConfig) (bot *MemeBot, err error
 	bot = &MemeBot{
 		config:       config,
 		channelsById: make(map[string]*slack.Channel),
+		status:       BotStatus{State: StateConnecting},
+		wishOffers:   newWishOffers(),
 	}
 	err = bot.dial(authToken)
 	return
@@ -139,11 +237,11 @@ func (b *MemeBot) dial(authToken string) error {
 func (b *MemeBot) waitForConnection() error {
 	for {
 		rawEvent := <-b.rtm.IncomingEvents
-		b.config.Log.Println("[slack]", rawEvent.Type)
+		b.config.Log.Debug("slack event", "type", rawEvent.Type)
 		switch event := rawEvent.Data.(type) {
 
 		case *slack.ConnectionErrorEvent:
-			b.config.Log.Println("[slack]", event.Attempt, "errors connecting:", event)
+			b.config.Log.Warn("error connecting to slack", "attempt", event.Attempt, "err", event.ErrorObj)
 			if event.Attempt > 3 {
 				return ErrConnectionFailed
 			}
@@ -152,27 +250,81 @@ func (b *MemeBot) waitForConnection() error {
 			return ErrInvalidAuthToken
 
 		case *slack.ConnectedEvent:
+			b.updateStatus(event)
 			b.slackInfo = event.Info
 			for _, ch := range event.Info.Channels {
 				b.addChannel(&ch)
 			}
 			return nil
+
+		default:
+			b.updateStatus(event)
 		}
 	}
 }
 
+// Status returns the current state of the connection to Slack.
+func (b *MemeBot) Status() BotStatus {
+	b.statusLock.Lock()
+	defer b.statusLock.Unlock()
+	return b.status
+}
+
+// updateStatus updates the connection status if event is a connection event.
+func (b *MemeBot) updateStatus(event interface{}) {
+	b.statusLock.Lock()
+	oldState := b.status.State
+	b.setStatus(event)
+	newState := b.status.State
+	b.statusLock.Unlock()
+
+	if newState != oldState {
+		b.config.Events.Publish(Event{Type: EventConnection, State: newState})
+	}
+}
+
+// setStatus must be called with statusLock held.
+func (b *MemeBot) setStatus(event interface{}) {
+	switch event := event.(type) {
+	case *slack.ConnectingEvent:
+		b.status.State = StateConnecting
+	case *slack.ConnectedEvent:
+		if !b.status.ConnectedAt.IsZero() {
+			b.config.Metrics.slackReconnected()
+		}
+		b.status.State = StateConnected
+		b.status.ConnectedAt = time.Now()
+	case *slack.DisconnectedEvent:
+		b.status.State = StateDisconnected
+	case *slack.LatencyReport:
+		b.status.Latency = event.Value
+		b.status.LatencyReportedAt = time.Now()
+	}
+}
+
 func (b *MemeBot) addChannel(ch *slack.Channel) {
-	b.config.Log.Print("[slack] joined channel #", ch.Name)
+	b.config.Log.Info("joined channel", "channel", ch.Name)
 	b.channelsById[ch.ID] = ch
 }
 
 func (b *MemeBot) removeChannel(id string) {
 	if ch, found := b.channelsById[id]; found {
-		b.config.Log.Print("[slack] left channel #", ch.Name)
+		b.config.Log.Info("left channel", "channel", ch.Name)
 		delete(b.channelsById, id)
 	}
 }
 
+// channelSettings must only be called from the Run goroutine.
+func (b *MemeBot) channelSettings(channelId string) ChannelSettings {
+	if settings, found := b.config.ChannelSettings[channelId]; found {
+		return settings
+	}
+	if ch, found := b.channelsById[channelId]; found {
+		return b.config.ChannelSettings[ch.Name]
+	}
+	return ChannelSettings{}
+}
+
 func (b *MemeBot) Name() string {
 	return b.slackInfo.User.Name
 }
@@ -180,6 +332,18 @@ func (b *MemeBot) Name() string {
 func (b *MemeBot) Run(ctx context.Context) {
 	defer b.rtm.Disconnect()
 
+	var checkWishes <-chan time.Time
+	var lastMemes *MemeIndex
+	// Memes are loaded in the background, since loading may be slow, then wishes are
+	// fulfilled here, since channelSettings must be called from this goroutine.
+	loadedMemes := make(chan *MemeIndex, 1)
+	loading := false
+	if b.config.Wishlist != nil {
+		ticker := time.NewTicker(b.config.WishCheckInterval)
+		defer ticker.Stop()
+		checkWishes = ticker.C
+	}
+
 	for {
 		select {
 
@@ -187,78 +351,355 @@ func (b *MemeBot) Run(ctx context.Context) {
 			switch event := rawEvent.Data.(type) {
 
 			case *slack.MessageEvent:
-				go b.handleMessage(ctx, (*slack.Message)(event))
+				go b.handleMessage(ctx, (*slack.Message)(event), b.channelSettings(event.Channel))
 			case *slack.ChannelJoinedEvent:
 				b.addChannel(&event.Channel)
 			case *slack.ChannelLeftEvent:
 				b.removeChannel(event.Channel)
 			case *slack.RTMError:
-				b.config.Log.Println("[slack] RTM error:", rawEvent.Type)
+				b.config.Log.Error("slack rtm error", "err", event)
 			case *slack.LatencyReport:
-				b.config.Log.Println("[slack] current latency:", event.Value)
+				b.config.Log.Debug("slack latency", "latency", event.Value)
+				b.updateStatus(event)
+			case *slack.ConnectingEvent, *slack.ConnectedEvent, *slack.DisconnectedEvent:
+				b.updateStatus(event)
+			case *slack.ReactionAddedEvent:
+				if offer, found := b.wishForReaction(event, time.Now()); found {
+					go b.addWish(offer, event.User)
+				}
+			}
+
+		case <-checkWishes:
+			if loading {
+				break
+			}
+			loading = true
+			go func() {
+				memes, err := b.config.Memepository.Load()
+				if err != nil {
+					memes = nil
+				}
+				loadedMemes <- memes
+			}()
+
+		case memes := <-loadedMemes:
+			loading = false
+			if memes == nil || memes == lastMemes {
+				break
+			}
+			lastMemes = memes
+
+			fulfilled, err := b.config.Wishlist.Fulfill(memes, func(channel string) SearchOptions {
+				return b.channelSettings(channel).searchOptions()
+			})
+			if err != nil {
+				b.config.Log.Error("error saving wishlist", "err", err)
+			}
+			if len(fulfilled) > 0 {
+				go b.notifyWishes(fulfilled)
 			}
 
 		case <-ctx.Done():
-			b.config.Log.Println("context done, stopping bot...")
+			b.config.Log.Info("context done, stopping bot")
 			return
 		}
 	}
 }
 
-func (b *MemeBot) handleMessage(ctx context.Context, m *slack.Message) {
+func (b *MemeBot) handleMessage(ctx context.Context, m *slack.Message, settings ChannelSettings) {
 	ctx, cancel := context.WithTimeout(ctx, b.config.MaxReplyTimeout)
 	defer cancel()
 
-	replyText := handleMessage(b.slackInfo.User, b.config, m)
-	if replyText != "" {
-		b.replyTo(ctx, m, replyText)
+	resp := respondToMessage(ctx, b.slackInfo.User, b.config, settings, m)
+	if resp.Text != "" {
+		b.replyTo(ctx, m, resp)
 	}
 }
 
-func handleMessage(self *slack.UserDetails, config MemeBotConfig, m *slack.Message) string {
-	keyword, mentioned, help := config.Parser.ParseMessage(self.Name, self.ID, m.Text)
+// response is the bot's reply to a message.
+type response struct {
+	Text string
 
-	if !mentioned && !config.ParseAllMessages {
+	// If not empty, the response is posted with the web API, since messages sent over RTM
+	// can't have attachments.
+	Attachments []slack.Attachment
+
+	// If not empty, reacting to the response with WishReaction adds Wish to the wishlist.
+	// Also posted with the web API, to find out the response's timestamp.
+	Wish string
+
+	// If not nil, published once the response has been sent.
+	Posted *Event
+}
+
+// handleMessage returns the text of the reply to m, or empty if it shouldn't be replied to.
+func handleMessage(self *slack.UserDetails, config MemeBotConfig, settings ChannelSettings, m *slack.Message) string {
+	return respondToMessage(context.Background(), self, config, settings, m).Text
+}
+
+// respondToMessage returns the reply to m. Memes are downloaded to generate new images
+// under ctx, which should be cancelled when the reply is no longer wanted.
+// commandsHelp lists the enabled commands, since their names can't be searched for as keywords.
+func commandsHelp(config MemeBotConfig, userName string) string {
+	var commands []string
+	if config.Parser.ParseStats && config.Stats != nil {
+		commands = append(commands, fmt.Sprintf("“@%s stats” or “@%s stats 30d” for usage stats", userName, userName))
+	}
+	if config.Parser.ParseWishes && config.Wishlist != nil {
+		commands = append(commands, fmt.Sprintf("“@%s wishlist” or “@%s wishes” for the most wished-for memes", userName, userName))
+	}
+	if len(commands) == 0 {
 		return ""
 	}
+	return "\nAsk for " + strings.Join(commands, ", ") + ". These aren't searched for as keywords."
+}
+
+func respondToMessage(ctx context.Context, self *slack.UserDetails, config MemeBotConfig, settings ChannelSettings, m *slack.Message) response {
+	parsed := config.Parser.Parse(self.Name, self.ID, m.Text)
+	keyword, mentioned, help := parsed.Keyword, parsed.Mentioned, parsed.Help
+	config.Metrics.messageReceived(mentioned)
+
+	if !mentioned && !config.ParseAllMessages {
+		return response{}
+	}
 
 	if help {
-		return config.ErrorHandler.OnHelp(config.GenerateSample(self.Name))
+		return response{Text: config.ErrorHandler.OnHelp(config.GenerateSample(self.Name)) +
+			commandsHelp(config, self.Name)}
+	}
+
+	if parsed.Stats && config.Stats != nil {
+		return response{Text: usageStatsReply(config, m.Channel, parsed.StatsWindow)}
+	}
+
+	if parsed.Wishes && config.Wishlist != nil {
+		// Only list the wishes made in the channel, like usage stats.
+		return response{Text: formatWishlist(config.Wishlist.Wishes(m.Channel), DefaultWishlistTopCount)}
 	}
 
 	if keyword == "" {
 		if mentioned {
-			return config.ErrorHandler.OnPhraseNotUnderstood(m.Text,
-				config.GenerateSample(self.Name))
+			return response{Text: config.ErrorHandler.OnPhraseNotUnderstood(m.Text,
+				config.GenerateSample(self.Name))}
 		}
-		return ""
+		return response{}
+	}
+
+	options := settings.searchOptions()
+	if !mentioned {
+		// Otherwise every message would be sent to external services under ParseAllMessages.
+		options.DisableExternal = true
 	}
 
-	meme, err := config.Searcher.FindMeme(keyword)
+	var meme Meme
+	var found []Meme
+	var err error
+	if parsed.Count > 1 && config.Collager != nil {
+		meme, found, err = findCollage(ctx, config, keyword, parsed.Count, options)
+	} else {
+		meme, err = findMemeWithOptions(config.Searcher, keyword, options)
+		if err == nil {
+			found = []Meme{meme}
+		}
+	}
+	config.Metrics.memeRequested(err)
+	// Misses and errors are only logged, recorded and published if the bot was mentioned,
+	// so messages that weren't meant for it aren't leaked.
+	if mentioned || err == nil {
+		config.Events.memeRequested(m.Channel, keyword, err)
+		if config.Stats != nil {
+			if err := config.Stats.Record(newUsageRecord(m.Channel, keyword, found, err)); err != nil {
+				config.Log.Error("error recording usage", "err", err)
+			}
+		}
+	}
 	if err == ErrNoMemeFound {
 		if mentioned {
 			// Only log if the bot was mentioned to prevent possibly leaking
 			// sensitive messages to logs.
-			config.Log.Println("no meme found for keyword:", keyword)
-			return config.ErrorHandler.OnNoMemeFound(keyword)
+			config.Log.Info("no meme found", "channel", m.Channel, "keyword", UserText(keyword))
+			if wishHandler, ok := config.ErrorHandler.(WishErrorHandler); ok && config.Wishlist != nil {
+				return response{Text: wishHandler.OnNoMemeFoundWish(keyword), Wish: keyword}
+			}
+			return response{Text: config.ErrorHandler.OnNoMemeFound(keyword)}
 		}
-		return ""
+		return response{}
 	} else if err != nil {
 		if mentioned {
-			config.Log.Printf("error searching for '%s': %s", keyword, err)
-			return config.ErrorHandler.OnNoMemeFound(keyword)
+			config.Log.Error("error searching for meme", "channel", m.Channel, "keyword", UserText(keyword), "err", err)
+			return response{Text: config.ErrorHandler.OnNoMemeFound(keyword)}
 		}
-		return ""
+		return response{}
+	}
+
+	posted := &Event{Type: EventMemePosted, Channel: m.Channel, Keyword: keyword, Meme: meme.URL().String()}
+	source := memeSource(meme)
+	if !parsed.Caption.IsEmpty() && config.Captioner != nil {
+		if captioned, err := config.Captioner.Caption(ctx, meme, parsed.Caption); err != nil {
+			config.Log.Error("error captioning meme", "meme", meme.URL(), "err", err)
+		} else {
+			meme = captioned
+		}
+	}
+
+	if len(parsed.Modifiers) > 0 {
+		meme = modifyMeme(config, meme, parsed.Modifiers)
+	}
+
+	r := response{Text: meme.URL().String(), Posted: posted}
+	if source != "" {
+		r.Text = fmt.Sprintf("%s (from %s)", meme.URL(), source)
+	}
+	if config.MediaTypes.isVideo(meme) {
+		r.Attachments = []slack.Attachment{videoAttachment(config.MediaTypes, meme, source)}
+	}
+	return r
+}
+
+// videoAttachment describes a video meme, since Slack doesn't play linked videos inline.
+func videoAttachment(mediaTypes MediaTypes, meme Meme, source string) slack.Attachment {
+	url := meme.URL().String()
+	mediaType, _ := mediaTypes.mediaType(memeExtension(meme))
+
+	attachment := slack.Attachment{
+		Fallback:  url,
+		Title:     strings.Join(meme.Keywords(), ", "),
+		TitleLink: url,
+		Text:      url,
+		Fields: []slack.AttachmentField{
+			{Title: "Type", Value: mediaType.ContentType, Short: true},
+		},
+	}
+	if source != "" {
+		attachment.Fields = append(attachment.Fields, slack.AttachmentField{Title: "Source", Value: source, Short: true})
+	}
+	return attachment
+}
+
+// findCollage returns a collage of up to count memes for keyword, and the memes in it.
+// If only one meme is found, or the collage can't be generated, a single meme is returned.
+func findCollage(ctx context.Context, config MemeBotConfig, keyword string, count int, options SearchOptions) (Meme, []Meme, error) {
+	memes, err := findMemes(config.Searcher, keyword, minInt(count, MaxCollageMemes), options)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(memes) == 1 {
+		return memes[0], memes, nil
+	}
+
+	collage, err := config.Collager.Collage(ctx, memes)
+	if err != nil {
+		config.Log.Error("error creating collage", "memes", len(memes), "err", err)
+		return memes[0], memes[:1], nil
+	}
+	if source := memeSource(memes[0]); source != "" {
+		collage = &sourcedMeme{collage, source}
 	}
+	return collage, memes, nil
+}
 
-	return meme.URL().String()
+// usageStatsReply returns a summary of the usage in channel over window, or
+// DefaultStatsWindow if 0. Other channels aren't included, so keywords requested in
+// private channels and DMs aren't shared.
+func usageStatsReply(config MemeBotConfig, channel string, window time.Duration) string {
+	if window <= 0 {
+		window = DefaultStatsWindow
+	}
+	stats, err := LoadUsageStats(config.Stats, channel, window, time.Now(), DefaultStatsTopCount)
+	if err != nil {
+		config.Log.Error("error loading usage stats", "err", err)
+		return "Sorry, I couldn't load the stats."
+	}
+	if config.Memepository != nil {
+		if memes, err := config.Memepository.Load(); err == nil {
+			stats.linkMemes(memes)
+		}
+	}
+	return stats.slackString()
 }
 
-func (b *MemeBot) replyTo(ctx context.Context, msg *slack.Message, replyText string) {
+// modifyMeme returns a variant of meme with modifiers applied, or meme itself if they
+// can't be applied.
+func modifyMeme(config MemeBotConfig, meme Meme, modifiers []Modifier) Meme {
+	transformable, ok := transformableMeme(meme)
+	if !ok {
+		config.Log.Warn("meme can't be modified", "meme", meme.URL(), "modifiers", modifiers)
+		return meme
+	}
+
+	modifiedURL, err := transformable.TransformedURL(modifierTransformParams(modifiers))
+	if err != nil {
+		config.Log.Warn("meme can't be modified", "meme", meme.URL(), "modifiers", modifiers, "err", err)
+		return meme
+	}
+	return &transformedMeme{meme, modifiedURL}
+}
+
+func (b *MemeBot) replyTo(ctx context.Context, msg *slack.Message, reply response) {
 	select {
 	case <-ctx.Done():
-		b.config.Log.Print("context done, not sending reply:", ctx.Err(), "\n\t", msg)
+		// Only identify the message, since its text could be sensitive.
+		b.config.Log.Warn("context done, not sending reply", "channel", msg.Channel, "ts", msg.Timestamp, "err", ctx.Err())
+		b.config.Metrics.replyDropped()
 	default:
-		b.rtm.SendMessage(b.rtm.NewOutgoingMessage(replyText, msg.Channel))
+		if len(reply.Attachments) == 0 && reply.Wish == "" {
+			b.rtm.SendMessage(b.rtm.NewOutgoingMessage(reply.Text, msg.Channel))
+			b.replySent(reply)
+			return
+		}
+
+		params := slack.NewPostMessageParameters()
+		params.AsUser = true
+		params.Attachments = reply.Attachments
+		channel, timestamp, err := b.rtm.PostMessage(msg.Channel, reply.Text, params)
+		if err != nil {
+			b.config.Log.Error("error posting reply", "channel", msg.Channel, "err", err)
+			b.config.Events.Publish(Event{Type: EventError, Channel: msg.Channel, Error: err.Error()})
+			return
+		}
+		if reply.Wish != "" {
+			b.wishOffers.Add(channel, timestamp, reply.Wish, time.Now())
+		}
+		b.replySent(reply)
+	}
+}
+
+func (b *MemeBot) replySent(reply response) {
+	if reply.Posted != nil {
+		b.config.Events.Publish(*reply.Posted)
+	}
+}
+
+// wishForReaction returns the wish offered by the message event reacted to, if it's a
+// WishReaction to an offer that hasn't expired.
+func (b *MemeBot) wishForReaction(event *slack.ReactionAddedEvent, now time.Time) (wishOffer, bool) {
+	if event.Reaction != WishReaction || event.Item.Type != slack.TYPE_MESSAGE {
+		return wishOffer{}, false
+	}
+	return b.wishOffers.Find(event.Item.Channel, event.Item.Timestamp, now)
+}
+
+func (b *MemeBot) addWish(offer wishOffer, user string) {
+	added, err := b.config.Wishlist.Add(offer.Keyword, user, offer.Channel)
+	if err != nil {
+		b.config.Log.Error("error saving wishlist", "err", err)
+	}
+	if added {
+		b.config.Log.Info("added wish", "channel", offer.Channel, "keyword", UserText(offer.Keyword))
+	}
+}
+
+func (b *MemeBot) notifyWishes(fulfilled []FulfilledWish) {
+	for _, notification := range wishNotifications(fulfilled, b.config.NotifyWishesByDM) {
+		channel := notification.Channel
+		if notification.User != "" {
+			_, _, dmChannel, err := b.rtm.OpenIMChannel(notification.User)
+			if err != nil {
+				b.config.Log.Error("error opening direct message to notify of wish", "user", notification.User, "err", err)
+				continue
+			}
+			channel = dmChannel
+		}
+		b.rtm.SendMessage(b.rtm.NewOutgoingMessage(notification.Text, channel))
 	}
 }
@@ -1,11 +1,17 @@
 package memebot
 
 import (
+	"bytes"
+	"errors"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/nlopes/slack"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
 )
 
 func TestHandleMessage_ParseAllMessages_NoMention(t *testing.T) {
@@ -13,18 +19,18 @@ func TestHandleMessage_ParseAllMessages_NoMention(t *testing.T) {
 
 	searcher, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, true, "do keyword")
 	searcher.On("FindMeme", "keyword").Return(meme, nil)
-	reply := handleMessage(user, config, msg)
+	reply := handleMessage(user, config, ChannelSettings{}, msg)
 	assert.Equal(t, "http://keyword.jpg", reply)
 
 	searcher, user, config, msg = CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, true, "do keyword")
 	searcher.On("FindMeme", "keyword").Return(nil, ErrNoMemeFound)
-	reply = handleMessage(user, config, msg)
+	reply = handleMessage(user, config, ChannelSettings{}, msg)
 	// No mention, don't reply with an error.
 	assert.Equal(t, "", reply)
 
 	searcher, user, config, msg = CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, true, "keyword")
 	searcher.On("FindMeme", "keyword").Return(meme, nil)
-	reply = handleMessage(user, config, msg)
+	reply = handleMessage(user, config, ChannelSettings{}, msg)
 	assert.Equal(t, "", reply)
 }
 
@@ -33,24 +39,24 @@ func TestHandleMessage_ParseAllMessages_Mention(t *testing.T) {
 
 	searcher, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, true, "name do keyword")
 	searcher.On("FindMeme", "keyword").Return(meme, nil)
-	reply := handleMessage(user, config, msg)
+	reply := handleMessage(user, config, ChannelSettings{}, msg)
 	assert.Equal(t, "http://keyword.jpg", reply)
 
 	searcher, user, config, msg = CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, true, "name do keyword")
 	searcher.On("FindMeme", "keyword").Return(nil, ErrNoMemeFound)
-	reply = handleMessage(user, config, msg)
+	reply = handleMessage(user, config, ChannelSettings{}, msg)
 	assert.Equal(t, "Sorry, I couldn't find a meme for “keyword”.", reply)
 
 	// Sample without mention.
 	searcher, user, config, msg = CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{"keyword"}, true, "name keyword")
-	reply = handleMessage(user, config, msg)
+	reply = handleMessage(user, config, ChannelSettings{}, msg)
 	assert.Equal(t, `Sorry, I'm not sure what you mean by:
 > name keyword
 Try something like “do keyword”`, reply)
 
 	// Sample with mention.
 	searcher, user, config, msg = CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{"keyword"}, false, "name keyword")
-	reply = handleMessage(user, config, msg)
+	reply = handleMessage(user, config, ChannelSettings{}, msg)
 	assert.Equal(t, `Sorry, I'm not sure what you mean by:
 > name keyword
 Try something like “@name do keyword”`, reply)
@@ -60,13 +66,13 @@ func TestHandleMessage_RequireMention(t *testing.T) {
 	searcher, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, false, "name do keyword")
 	meme := NewMockMeme("http://keyword.jpg")
 	searcher.On("FindMeme", "keyword").Return(meme, nil)
-	reply := handleMessage(user, config, msg)
+	reply := handleMessage(user, config, ChannelSettings{}, msg)
 	assert.Equal(t, "http://keyword.jpg", reply)
 
 	searcher, user, config, msg = CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, false, "do keyword")
 	meme = NewMockMeme("http://keyword.jpg")
 	searcher.On("FindMeme", "keyword").Return(meme, nil)
-	reply = handleMessage(user, config, msg)
+	reply = handleMessage(user, config, ChannelSettings{}, msg)
 	assert.Equal(t, "", reply)
 }
 
@@ -96,3 +102,339 @@ func CreateArgsForHandleMessage(t *testing.T, keywordPattern string, keywords []
 	}
 	return
 }
+
+func TestHandleMessage_ReplyMentionsSource(t *testing.T) {
+	_, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, false, "name do keyword")
+	config.Searcher = &ChainSearcher{Searchers: []ChainedSearcher{
+		{Name: "giphy", MemeSearcher: &MemepositorySearcher{&MockMemepository{NewTestMemeIndex(
+			NewMockMeme("http://keyword.gif", "keyword"),
+		)}}},
+	}}
+
+	reply := handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Equal(t, "http://keyword.gif (from giphy)", reply)
+}
+
+func TestHandleMessage_ChannelDisablesExternalSearch(t *testing.T) {
+	_, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, false, "name do keyword")
+	external := new(MockSearcher)
+	config.Searcher = &ChainSearcher{Searchers: []ChainedSearcher{
+		{Name: "giphy", MemeSearcher: external, External: true},
+	}}
+
+	reply := handleMessage(user, config, ChannelSettings{DisableExternalSearch: true}, msg)
+	assert.Equal(t, "Sorry, I couldn't find a meme for “keyword”.", reply)
+	external.AssertNotCalled(t, "FindMeme", "keyword")
+}
+
+func TestHandleMessage_ExternalSearchRequiresMention(t *testing.T) {
+	_, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, true, "do keyword")
+	external := new(MockSearcher)
+	config.Searcher = &ChainSearcher{Searchers: []ChainedSearcher{
+		{Name: "giphy", MemeSearcher: external, External: true},
+	}}
+
+	reply := handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Equal(t, "", reply)
+	external.AssertNotCalled(t, "FindMeme", "keyword")
+
+	external.On("FindMeme", "keyword").Return(NewMockMeme("http://keyword.gif"), nil)
+	msg.Text = "name do keyword"
+	reply = handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Equal(t, "http://keyword.gif (from giphy)", reply)
+}
+
+func TestHandleMessage_ChannelAllowsNSFW(t *testing.T) {
+	_, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, false, "name do keyword")
+	config.Searcher = &MemepositorySearcher{&MockMemepository{NewTestMemeIndex(
+		NewMockFlaggedMeme("http://keyword.gif", MemeFlags{NSFW: true}, "keyword"),
+	)}}
+
+	reply := handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Equal(t, "Sorry, I couldn't find a meme for “keyword”.", reply)
+
+	reply = handleMessage(user, config, ChannelSettings{AllowNSFW: true}, msg)
+	assert.Equal(t, "http://keyword.gif", reply)
+}
+
+func TestHandleMessage_Caption(t *testing.T) {
+	searcher, user, config, msg := CreateArgsForHandleMessage(t, `^(\w+)$`, []string{}, false, "name grumpy: top | bottom")
+	meme := NewMockMeme("http://grumpy.jpg")
+	searcher.On("FindMeme", "grumpy").Return(meme, nil)
+	captioner := new(MockCaptioner)
+	captioner.On("Caption", meme, Caption{"top", "bottom"}).Return(NewMockMeme("http://captioned.jpg"), nil)
+	config.Parser.ParseCaptions = true
+	config.Captioner = captioner
+
+	reply := handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Equal(t, "http://captioned.jpg", reply)
+	captioner.AssertExpectations(t)
+}
+
+func TestHandleMessage_CaptionError(t *testing.T) {
+	searcher, user, config, msg := CreateArgsForHandleMessage(t, `^(\w+)$`, []string{}, false, "name grumpy: top")
+	meme := NewMockMeme("http://grumpy.jpg")
+	searcher.On("FindMeme", "grumpy").Return(meme, nil)
+	captioner := new(MockCaptioner)
+	captioner.On("Caption", meme, Caption{Top: "top"}).Return(nil, ErrImageTooLarge)
+	config.Parser.ParseCaptions = true
+	config.Captioner = captioner
+
+	// Falls back to the uncaptioned meme.
+	reply := handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Equal(t, "http://grumpy.jpg", reply)
+}
+
+func TestHandleMessage_Collage(t *testing.T) {
+	_, user, config, msg := CreateArgsForHandleMessage(t, `^(\w+)$`, []string{}, false, "name 4 cats")
+	cat := NewMockMeme("http://cat.jpg", "cat")
+	config.Searcher = &MemepositorySearcher{&MockMemepository{NewTestMemeIndex(cat, cat)}}
+	collager := new(MockCollager)
+	collager.On("Collage", []Meme{cat, cat}).Return(NewMockMeme("http://collage.jpg"), nil)
+	config.Parser.ParseCounts = true
+	config.Collager = collager
+
+	reply := handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Equal(t, "http://collage.jpg", reply)
+	collager.AssertExpectations(t)
+}
+
+func TestHandleMessage_CollageSingleMeme(t *testing.T) {
+	_, user, config, msg := CreateArgsForHandleMessage(t, `^(\w+)$`, []string{}, false, "name 4 cats")
+	config.Searcher = &MemepositorySearcher{&MockMemepository{NewTestMemeIndex(NewMockMeme("http://cat.jpg", "cat"))}}
+	collager := new(MockCollager)
+	config.Parser.ParseCounts = true
+	config.Collager = collager
+
+	reply := handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Equal(t, "http://cat.jpg", reply)
+	collager.AssertNotCalled(t, "Collage", mock.Anything)
+}
+
+func TestHandleMessage_Modifiers(t *testing.T) {
+	memepository, _, dir := newTestFileServingMemepository(t, map[string]string{
+		"party.gif": string(encodeTestGIF(t, 20, 10, 3)),
+		"foo.jpg":   "foo data",
+	}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+
+	_, user, config, msg := CreateArgsForHandleMessage(t, `^(\w+)$`, []string{}, false, "name party reversed")
+	config.Searcher = &MemepositorySearcher{memepository}
+	config.Parser.ParseModifiers = true
+
+	reply := handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Contains(t, reply, "reverse=1")
+
+	// Can't reverse a JPEG.
+	msg.Text = "name foo reversed"
+	reply = handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Equal(t, findTestFileMeme(t, memepository, "foo").URL().String(), reply)
+}
+
+func TestRespondToMessage_Video(t *testing.T) {
+	searcher, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, false, "name do keyword")
+	searcher.On("FindMeme", "keyword").Return(NewMockMeme("http://example.com/keyword.mp4", "keyword", "dance"), nil)
+
+	response := respondToMessage(context.Background(), user, config, ChannelSettings{}, msg)
+	assert.Equal(t, "http://example.com/keyword.mp4", response.Text)
+	require.Len(t, response.Attachments, 1)
+	attachment := response.Attachments[0]
+	assert.Equal(t, "keyword, dance", attachment.Title)
+	assert.Equal(t, "http://example.com/keyword.mp4", attachment.TitleLink)
+	assert.Equal(t, []slack.AttachmentField{{Title: "Type", Value: "video/mp4", Short: true}}, attachment.Fields)
+
+	// Images are unfurled by Slack.
+	searcher, user, config, msg = CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, false, "name do keyword")
+	searcher.On("FindMeme", "keyword").Return(NewMockMeme("http://example.com/keyword.jpg"), nil)
+	response = respondToMessage(context.Background(), user, config, ChannelSettings{}, msg)
+	assert.Empty(t, response.Attachments)
+}
+
+func TestRespondToMessage_Metrics(t *testing.T) {
+	_, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, false, "name do keyword")
+	config.Searcher = &MemepositorySearcher{&MockMemepository{NewTestMemeIndex(
+		NewMockMeme("http://example.com/keyword.jpg", "keyword"),
+	)}}
+	config.Metrics = NewMetrics()
+
+	respondToMessage(context.Background(), user, config, ChannelSettings{}, msg)
+	msg.Text = "name do nothing"
+	respondToMessage(context.Background(), user, config, ChannelSettings{}, msg)
+	msg.Text = "do keyword"
+	respondToMessage(context.Background(), user, config, ChannelSettings{}, msg)
+
+	config.Searcher = &MemepositorySearcher{FailingMemepository{errors.New("bucket on fire")}}
+	msg.Text = "name do keyword"
+	respondToMessage(context.Background(), user, config, ChannelSettings{}, msg)
+
+	assert.Equal(t, 4.0, config.Metrics.Messages.Value())
+	assert.Equal(t, 3.0, config.Metrics.Mentions.Value())
+	assert.Equal(t, 1.0, config.Metrics.MemeRequests.Value(RequestHit))
+	assert.Equal(t, 1.0, config.Metrics.MemeRequests.Value(RequestMiss))
+	assert.Equal(t, 1.0, config.Metrics.MemeRequests.Value(RequestError))
+}
+
+func TestReplyTo_CountsDroppedReplies(t *testing.T) {
+	bot := &MemeBot{config: MemeBotConfig{Metrics: NewMetrics()}}
+	bot.config.Validate()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	bot.replyTo(ctx, &slack.Message{}, response{Text: "too late"})
+	assert.Equal(t, 1.0, bot.config.Metrics.RepliesDropped.Value())
+}
+
+func TestMemeBotUpdateStatus_CountsReconnects(t *testing.T) {
+	bot := &MemeBot{config: MemeBotConfig{Metrics: NewMetrics()}}
+
+	// The first connection isn't a reconnect.
+	bot.updateStatus(&slack.ConnectedEvent{})
+	assert.Equal(t, 0.0, bot.config.Metrics.SlackReconnects.Value())
+
+	bot.updateStatus(&slack.DisconnectedEvent{})
+	bot.updateStatus(&slack.ConnectingEvent{})
+	bot.updateStatus(&slack.ConnectedEvent{})
+	assert.Equal(t, 1.0, bot.config.Metrics.SlackReconnects.Value())
+}
+
+func TestRespondToMessage_RecordsUsage(t *testing.T) {
+	_, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, true, "name do keyword")
+	config.Searcher = &MemepositorySearcher{&MockMemepository{NewTestMemeIndex(
+		NewMockMeme("http://example.com/keyword.jpg", "keyword"),
+	)}}
+	config.Stats = NewMemoryStatsStore()
+	msg.Channel = "C1"
+
+	respondToMessage(context.Background(), user, config, ChannelSettings{}, msg)
+	msg.Text = "name do nothing"
+	respondToMessage(context.Background(), user, config, ChannelSettings{}, msg)
+	// Misses are only recorded when the bot is mentioned.
+	msg.Text = "do nothing"
+	respondToMessage(context.Background(), user, config, ChannelSettings{}, msg)
+
+	records, err := config.Stats.Records(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "keyword", records[0].Keyword)
+	assert.Equal(t, "C1", records[0].Channel)
+	assert.Equal(t, []UsageMeme{{
+		ID:  apiMemeID(NewMockMeme("http://example.com/keyword.jpg")),
+		URL: "http://example.com/keyword.jpg",
+	}}, records[0].Memes)
+	assert.Equal(t, "nothing", records[1].Keyword)
+	assert.True(t, records[1].Miss())
+}
+
+func TestRespondToMessage_StatsLinkServedMemes(t *testing.T) {
+	memepository, _, dir := newTestFileServingMemepository(t, map[string]string{"cat.jpg": "foo data"}, ObjectServerConfig{
+		SigningKeys: []SigningKey{{"key", []byte("secret")}},
+	})
+	defer os.RemoveAll(dir)
+	cat := findTestFileMeme(t, memepository, "cat")
+
+	_, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, false, "name do cat")
+	config.Parser.ParseStats = true
+	config.Searcher = &MemepositorySearcher{memepository}
+	config.Memepository = memepository
+	config.Stats = NewMemoryStatsStore()
+	respondToMessage(context.Background(), user, config, ChannelSettings{}, msg)
+
+	// Signed URLs expire, so only the ID is recorded.
+	records, err := config.Stats.Records(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, []UsageMeme{{ID: cat.ContentHash()}}, records[0].Memes)
+
+	msg.Text = "name stats"
+	reply := handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Contains(t, reply, "<"+cat.URL().String()+"|cat> ×1")
+}
+
+func TestRespondToMessage_Stats(t *testing.T) {
+	_, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, false, "name stats")
+	config.Parser.ParseStats = true
+	config.Stats = NewMemoryStatsStore()
+	config.Stats.Record(UsageRecord{Time: time.Now().Add(-2 * time.Hour), Channel: "C1", Keyword: "cat"})
+	config.Stats.Record(UsageRecord{Time: time.Now().Add(-10 * 24 * time.Hour), Channel: "C1", Keyword: "dog"})
+	config.Stats.Record(UsageRecord{Time: time.Now().Add(-time.Hour), Channel: "D2", Keyword: "secret"})
+	msg.Channel = "C1"
+
+	reply := handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Contains(t, reply, "Usage in the last 7 days: 1 requests, 1 without a meme.")
+	assert.Contains(t, reply, "cat ×1")
+	assert.NotContains(t, reply, "dog")
+	assert.NotContains(t, reply, "secret")
+
+	msg.Text = "name stats 1h"
+	reply = handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Contains(t, reply, "Usage in the last 1h0m0s: 0 requests")
+}
+
+func TestRespondToMessage_HelpListsStats(t *testing.T) {
+	_, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{"keyword"}, false, "name help")
+	reply := handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Equal(t, "Try something like “@name do keyword”", reply)
+
+	config.Parser.ParseStats = true
+	config.Stats = NewMemoryStatsStore()
+	reply = handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Equal(t, `Try something like “@name do keyword”
+Ask for “@name stats” or “@name stats 30d” for usage stats. These aren't searched for as keywords.`, reply)
+}
+
+func TestRespondToMessage_HelpListsWishlist(t *testing.T) {
+	_, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{"keyword"}, false, "name help")
+	config.Parser.ParseStats = true
+	config.Parser.ParseWishes = true
+	config.Stats = NewMemoryStatsStore()
+	config.Wishlist = NewWishlist()
+
+	reply := handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Equal(t, `Try something like “@name do keyword”
+Ask for “@name stats” or “@name stats 30d” for usage stats, “@name wishlist” or “@name wishes” for the most wished-for memes. These aren't searched for as keywords.`, reply)
+}
+
+func TestRespondToMessage_OffersWish(t *testing.T) {
+	searcher, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, false, "name do unicorn")
+	searcher.On("FindMeme", "unicorn").Return(nil, ErrNoMemeFound)
+
+	response := respondToMessage(context.Background(), user, config, ChannelSettings{}, msg)
+	assert.Equal(t, "Sorry, I couldn't find a meme for “unicorn”.", response.Text)
+	assert.Empty(t, response.Wish)
+
+	config.Wishlist = NewWishlist()
+	config.Memepository = &MockMemepository{NewTestMemeIndex()}
+	response = respondToMessage(context.Background(), user, config, ChannelSettings{}, msg)
+	assert.Equal(t, "Sorry, I couldn't find a meme for “unicorn”. React with :pray: to wish for one, and I'll let you know when there is.", response.Text)
+	assert.Equal(t, "unicorn", response.Wish)
+}
+
+func TestRespondToMessage_Wishlist(t *testing.T) {
+	_, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, false, "name wishlist")
+	config.Parser.ParseWishes = true
+	config.Wishlist = NewWishlist()
+	config.Memepository = &MockMemepository{NewTestMemeIndex()}
+	require.NoError(t, config.Validate())
+	config.Wishlist.Add("unicorn", "U1", "C1")
+	config.Wishlist.Add("dragon", "U1", "D1")
+	msg.Channel = "C1"
+
+	// Wishes made in other channels aren't listed.
+	reply := handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Equal(t, "Most wished-for memes: unicorn ×1", reply)
+
+	config.Memepository = nil
+	assert.Error(t, config.Validate())
+}
+
+func TestHandleMessage_RedactsLoggedKeywords(t *testing.T) {
+	searcher, user, config, msg := CreateArgsForHandleMessage(t, `^do (\w+)$`, []string{}, false, "name do secret")
+	searcher.On("FindMeme", "secret").Return(nil, ErrNoMemeFound)
+	var buf *bytes.Buffer
+	config.Log, buf = newTestLogger(LoggerConfig{RedactUserText: true})
+	msg.Channel = "C123"
+
+	handleMessage(user, config, ChannelSettings{}, msg)
+	assert.Contains(t, buf.String(), `msg="no meme found" channel=C123 keyword=[redacted]`)
+	assert.NotContains(t, buf.String(), "secret")
+}
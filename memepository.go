@@ -5,7 +5,7 @@ import (
 	"crypto/sha1"
 	"encoding/base64"
 	"io"
-	"log"
+	"io/ioutil"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -53,8 +53,16 @@ type FileServingMemepositoryConfig struct {
 	Path            string      // Path to images directory.
 	ImageExtensions StringSet   // Extensions to recognize as image files.
 	Router          *mux.Router // Root router to serve image IDs from.
+	Server          ObjectServerConfig
+
+	// If true, files whose content doesn't match their extension, or can't be decoded
+	// completely, are skipped. Otherwise they're loaded, and only logged.
+	ValidateImages bool
 
 	FileSystem FileSystem // Injectable os wrapper for testing. Zero value delegates to os.
+
+	// Defaults to DefaultLogger. Also used by the object server if Server.Log is nil.
+	Log *Logger
 }
 
 type defaultFileSystem struct{}
@@ -81,7 +89,12 @@ type FileServingMemepository struct {
 	server *ObjectServer
 
 	// Used to load memes only the first time Load is called.
-	loadOnce  sync.Once
+	loadOnce sync.Once
+
+	// Serializes AddMeme, SetKeywords and DeleteMeme.
+	writeLock sync.Mutex
+
+	lock      sync.RWMutex
 	memes     *MemeIndex
 	memesById map[string]*FileMeme
 	loadErr   error
@@ -96,54 +109,113 @@ func NewFileServingMemepository(config FileServingMemepositoryConfig) *FileServi
 	if config.FileSystem == nil {
 		config.FileSystem = defaultFileSystem{}
 	}
+	if config.Server.Log == nil {
+		config.Server.Log = config.Log
+	}
 
 	memepository := &FileServingMemepository{
 		FileServingMemepositoryConfig: config,
 	}
-	memepository.server = CreateObjectServer(config.Router, memepository)
+	memepository.server = CreateObjectServerWithConfig(config.Router, memepository, config.Server)
 
 	return memepository
 }
 
 func (m *FileServingMemepository) Load() (memes *MemeIndex, err error) {
-	m.loadOnce.Do(m.load)
+	m.loadOnce.Do(func() {
+		m.Reload()
+	})
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 	return m.memes, m.loadErr
 }
 
+// Reload reads the directory again, e.g. after files have been added or removed.
+// Memes are served from the old index until the new one has been loaded, and if loading
+// fails, the old index is kept.
+func (m *FileServingMemepository) Reload() error {
+	m.lock.RLock()
+	previous := m.memesById
+	m.lock.RUnlock()
+
+	memes, memesById, err := m.load(previous)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err != nil && m.memes != nil {
+		m.Log.Warn("keeping previously loaded memes", "path", m.Path, "count", m.memes.Len())
+		return err
+	}
+	m.memes, m.memesById, m.loadErr = memes, memesById, err
+	return err
+}
+
 func (m *FileServingMemepository) FindObject(id string) (Object, bool) {
 	if _, err := m.Load(); err != nil {
 		return nil, false
 	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 	meme, found := m.memesById[id]
 	return meme, found
 }
 
-func (m *FileServingMemepository) load() {
-	log.Println("loading memes from", m.Path)
+// load reuses the IDs and analysis of the memes in previous whose files haven't changed.
+func (m *FileServingMemepository) load(previous map[string]*FileMeme) (memes *MemeIndex, memesById map[string]*FileMeme, err error) {
+	m.Log.Info("loading memes", "path", m.Path)
 
 	entries, err := m.FileSystem.ReadDirEntries(m.Path)
 	if err != nil {
-		log.Println("error reading directory:", err)
-		m.loadErr = err
-		return
+		m.Log.Error("error reading directory", "path", m.Path, "err", err)
+		return nil, nil, err
 	}
 
-	m.memes = NewMemeIndex()
-	m.memesById = make(map[string]*FileMeme)
+	metadata, err := readMemeMetadata(m.FileSystem, m.Path)
+	if err != nil {
+		// Loading memes without their flags would post NSFW and retired memes anywhere.
+		m.Log.Error("error reading meme metadata", "path", m.Path, "err", err)
+		return nil, nil, err
+	}
+
+	unchanged := make(map[string]*FileMeme)
+	for _, meme := range previous {
+		unchanged[meme.path] = meme
+	}
+
+	memes = NewMemeIndex()
+	memesById = make(map[string]*FileMeme)
+	retired := 0
 
+	invalid := make(invalidImageCounts)
 	for _, entry := range entries {
 		if m.isImageFile(entry) {
-			meme, err := newFileMeme(entry, m)
+			meme, err := newFileMeme(entry, m, unchanged[filepath.Join(m.Path, entry.Name())])
 			if err != nil {
-				log.Println("couldn't load", entry.Name(), err)
-			} else {
-				m.memes.Add(meme)
-				m.memesById[meme.id] = meme
+				m.Log.Warn("couldn't load meme", "file", entry.Name(), "err", err)
+				continue
 			}
+			if meme.invalid != nil {
+				invalid[meme.invalid]++
+				m.Log.Warn("invalid image", "file", entry.Name(), "err", meme.invalid, "detected", meme.info.ContentType)
+				if m.ValidateImages {
+					continue
+				}
+			}
+			meme.flags = metadata[meme.ContentHash()]
+			memesById[meme.id] = meme
+			if meme.flags.Retired {
+				retired++
+				continue
+			}
+			memes.Add(meme)
 		}
 	}
 
-	log.Println("loaded", m.memes.Len(), "memes")
+	logInvalidImages(m.Log, invalid, m.ValidateImages)
+	m.Log.Info("loaded memes", "path", m.Path, "count", memes.Len(), "retired", retired)
+	return memes, memesById, nil
 }
 
 func (m *FileServingMemepository) isImageFile(file os.FileInfo) bool {
@@ -163,27 +235,56 @@ type FileMeme struct {
 	lastModified time.Time
 	size         int64
 	keywords     []string
+	flags        MemeFlags
+
+	imageAnalysis
 }
 
-var _ Object = &FileMeme{}
+var (
+	_ Object           = &FileMeme{}
+	_ PerceptualHasher = &FileMeme{}
+	_ ImageDescriber   = &FileMeme{}
+	_ ContentTyper     = &FileMeme{}
+	_ FlaggedMeme      = &FileMeme{}
+)
 
-func newFileMeme(file os.FileInfo, owner *FileServingMemepository) (*FileMeme, error) {
+// newFileMeme reads and analyzes file, unless it has the same size and modification time
+// as previous, the meme loaded from the same path before, if any.
+func newFileMeme(file os.FileInfo, owner *FileServingMemepository, previous *FileMeme) (*FileMeme, error) {
 	path := filepath.Join(owner.Path, file.Name())
+	if previous != nil && previous.size == file.Size() && previous.lastModified.Equal(file.ModTime()) {
+		return &FileMeme{
+			owner:         owner,
+			id:            previous.id,
+			path:          path,
+			lastModified:  previous.lastModified,
+			size:          previous.size,
+			keywords:      previous.keywords,
+			imageAnalysis: previous.imageAnalysis,
+		}, nil
+	}
 
-	id, err := generateHashForFile(owner.FileSystem, path)
+	data, err := readFile(owner.FileSystem, path)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := generateSha1Base64Hash(bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
 	// Append the extension to the ID for content-type detection
-	id = id + "." + getNormalizedExtensionWithoutDot(file.Name())
+	extension := getNormalizedExtensionWithoutDot(file.Name())
+	id = id + "." + extension
 
 	return &FileMeme{
-		owner:        owner,
-		id:           id,
-		path:         path,
-		lastModified: file.ModTime(),
-		size:         file.Size(),
-		keywords:     parseKeywords(file.Name()),
+		owner:         owner,
+		id:            id,
+		path:          path,
+		lastModified:  file.ModTime(),
+		size:          file.Size(),
+		keywords:      parseKeywords(file.Name()),
+		imageAnalysis: analyzeImage(data, extension, owner.Server.MediaTypes),
 	}, nil
 }
 
@@ -205,10 +306,22 @@ func (m *FileMeme) URL() *url.URL {
 	return m.owner.server.URL(m.id)
 }
 
+func (m *FileMeme) TransformedURL(params url.Values) (*url.URL, error) {
+	return m.owner.server.TransformedURL(m.id, params)
+}
+
 func (m *FileMeme) Keywords() []string {
 	return m.keywords
 }
 
+func (m *FileMeme) Flags() MemeFlags {
+	return m.flags
+}
+
+func (m *FileMeme) ContentHash() string {
+	return idContentHash(m.id)
+}
+
 func (m *FileMeme) Open() (ReadSeekerCloser, error) {
 	return m.owner.FileSystem.Open(m.path)
 }
@@ -238,6 +351,16 @@ func generateHashForFile(fs FileSystem, name string) (string, error) {
 	return generateSha1Base64Hash(file)
 }
 
+func readFile(fs FileSystem, name string) ([]byte, error) {
+	file, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ioutil.ReadAll(file)
+}
+
 func generateSha1Base64Hash(r io.Reader) (string, error) {
 	hasher := sha1.New()
 	_, err := io.Copy(hasher, r)
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"regexp"
 	"regexp/syntax"
+	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	regen "github.com/zach-klippenstein/goregen"
@@ -23,6 +25,82 @@ type MessageParser struct {
 
 	// Defaults to DefaultHelpParser.
 	HelpParser func(string) bool
+
+	// If true, messages that mention the bot like "keyword: top text | bottom text"
+	// ask for the meme to be captioned.
+	ParseCaptions bool
+
+	// If true, messages that mention the bot like "4 keyword" ask for multiple memes.
+	ParseCounts bool
+
+	// If true, words from ModifierWords at the end of messages that mention the bot ask
+	// for variants of the meme, e.g. "keyword reversed".
+	ParseModifiers bool
+
+	// Defaults to DefaultModifierWords.
+	ModifierWords map[string]Modifier
+
+	// If true, messages that mention the bot like "stats" or "stats 30d" ask for usage
+	// statistics.
+	ParseStats bool
+
+	// If true, messages that mention the bot like "wishlist" ask for the most wished-for
+	// keywords.
+	ParseWishes bool
+}
+
+// Modifier asks for a variant of a meme, e.g. an animated GIF played in reverse.
+type Modifier string
+
+const (
+	ModifierReversed Modifier = "reversed"
+	ModifierFast     Modifier = "fast"
+	ModifierSlow     Modifier = "slow"
+	ModifierStill    Modifier = "still"
+)
+
+var DefaultModifierWords = map[string]Modifier{
+	"reversed":  ModifierReversed,
+	"reverse":   ModifierReversed,
+	"backwards": ModifierReversed,
+	"fast":      ModifierFast,
+	"faster":    ModifierFast,
+	"slow":      ModifierSlow,
+	"slower":    ModifierSlow,
+	"still":     ModifierStill,
+	"frozen":    ModifierStill,
+}
+
+// ParsedMessage is the result of MessageParser.Parse.
+type ParsedMessage struct {
+	Keyword   string
+	Mentioned bool
+	Help      bool
+
+	// Empty unless the message asked for a captioned meme.
+	Caption Caption
+
+	// Number of memes asked for, or 0 if the message didn't give a number.
+	Count int
+
+	Modifiers []Modifier
+
+	// True if the message asked for usage statistics, over StatsWindow if it's not 0.
+	Stats       bool
+	StatsWindow time.Duration
+
+	// True if the message asked for the wishlist.
+	Wishes bool
+}
+
+// Caption is the text to draw on a meme.
+type Caption struct {
+	Top    string
+	Bottom string
+}
+
+func (c Caption) IsEmpty() bool {
+	return c.Top == "" && c.Bottom == ""
 }
 
 func (p *MessageParser) Validate() error {
@@ -35,30 +113,155 @@ func (p *MessageParser) Validate() error {
 	if p.HelpParser == nil {
 		p.HelpParser = DefaultHelpParser
 	}
+	if p.ModifierWords == nil {
+		p.ModifierWords = DefaultModifierWords
+	}
 	return nil
 }
 
 func (p *MessageParser) ParseMessage(mentionedUser, userId, msg string) (keyword string, mentioned bool, help bool) {
+	parsed := p.Parse(mentionedUser, userId, msg)
+	return parsed.Keyword, parsed.Mentioned, parsed.Help
+}
+
+func (p *MessageParser) Parse(mentionedUser, userId, msg string) (parsed ParsedMessage) {
 	if err := p.Validate(); err != nil {
 		panic(err)
 	}
 
-	msg, mentioned = p.MentionParser.ParseMention(mentionedUser, userId, msg)
+	msg, parsed.Mentioned = p.MentionParser.ParseMention(mentionedUser, userId, msg)
 
-	if p.HelpParser(msg) && mentioned {
+	if p.HelpParser(msg) && parsed.Mentioned {
 		// Only look for help if mentioned.
-		help = true
+		parsed.Help = true
 		return
 	}
 
-	if kw, matched := p.KeywordParser.ParseKeyword(msg); matched {
-		keyword = kw
+	if p.ParseStats && parsed.Mentioned {
+		if window, found := parseStats(msg); found {
+			parsed.Stats = true
+			parsed.StatsWindow = window
+			return
+		}
+	}
+
+	if p.ParseWishes && parsed.Mentioned && isWishlistCommand(msg) {
+		parsed.Wishes = true
 		return
 	}
 
+	if p.ParseCounts && parsed.Mentioned {
+		if count, rest, found := parseCount(msg); found && p.parseKeyword(rest, &parsed) {
+			parsed.Count = count
+			return
+		}
+	}
+
+	p.parseKeyword(msg, &parsed)
 	return
 }
 
+// parseKeyword sets the keyword, and caption if enabled, from msg. Returns false if
+// msg doesn't contain a keyword.
+func (p *MessageParser) parseKeyword(msg string, parsed *ParsedMessage) bool {
+	// Only look for captions if mentioned, since colons are common in normal messages.
+	if p.ParseCaptions && parsed.Mentioned {
+		if keywordMsg, caption, found := parseCaption(msg); found && p.parseKeywordWithModifiers(keywordMsg, parsed) {
+			parsed.Caption = caption
+			return true
+		}
+	}
+	return p.parseKeywordWithModifiers(msg, parsed)
+}
+
+// parseKeywordWithModifiers sets the keyword, and modifiers if enabled, from msg.
+// Returns false if msg doesn't contain a keyword.
+func (p *MessageParser) parseKeywordWithModifiers(msg string, parsed *ParsedMessage) bool {
+	if p.ParseModifiers && parsed.Mentioned {
+		if rest, modifiers := parseModifiers(msg, p.ModifierWords); len(modifiers) > 0 {
+			if kw, matched := p.KeywordParser.ParseKeyword(rest); matched {
+				parsed.Keyword = kw
+				parsed.Modifiers = modifiers
+				return true
+			}
+		}
+	}
+
+	if kw, matched := p.KeywordParser.ParseKeyword(msg); matched {
+		parsed.Keyword = kw
+		return true
+	}
+	return false
+}
+
+// parseModifiers removes modifier words from the end of msg.
+func parseModifiers(msg string, words map[string]Modifier) (rest string, modifiers []Modifier) {
+	fields := strings.Fields(msg)
+	end := len(fields)
+	for end > 0 {
+		modifier, found := words[strings.ToLower(fields[end-1])]
+		if !found {
+			break
+		}
+		modifiers = append([]Modifier{modifier}, modifiers...)
+		end--
+	}
+	return strings.Join(fields[:end], " "), modifiers
+}
+
+// parseCount splits a message like "4 keyword" into the count and the rest of the message.
+func parseCount(msg string) (count int, rest string, found bool) {
+	fields := strings.SplitN(msg, " ", 2)
+	if len(fields) < 2 {
+		return 0, msg, false
+	}
+
+	count, err := strconv.Atoi(fields[0])
+	if err != nil || count < 1 {
+		return 0, msg, false
+	}
+	return count, strings.TrimSpace(fields[1]), true
+}
+
+// parseStats parses a message like "stats" or "stats 7d". window is 0 if none was given.
+func parseStats(msg string) (window time.Duration, found bool) {
+	fields := strings.Fields(msg)
+	if len(fields) == 0 || len(fields) > 2 || strings.ToLower(fields[0]) != "stats" {
+		return 0, false
+	}
+	if len(fields) == 1 {
+		return 0, true
+	}
+
+	window, err := ParseStatsWindow(fields[1])
+	if err != nil {
+		return 0, false
+	}
+	return window, true
+}
+
+func isWishlistCommand(msg string) bool {
+	msg = strings.ToLower(strings.TrimSpace(msg))
+	return msg == "wishlist" || msg == "wishes"
+}
+
+// parseCaption splits a message like "keyword: top text | bottom text" into the
+// keyword part and the caption.
+func parseCaption(msg string) (keywordMsg string, caption Caption, found bool) {
+	i := strings.Index(msg, ":")
+	if i < 0 {
+		return msg, caption, false
+	}
+	keywordMsg = strings.TrimSpace(msg[:i])
+
+	parts := strings.SplitN(msg[i+1:], "|", 2)
+	caption.Top = strings.TrimSpace(parts[0])
+	if len(parts) > 1 {
+		caption.Bottom = strings.TrimSpace(parts[1])
+	}
+	return keywordMsg, caption, keywordMsg != "" && !caption.IsEmpty()
+}
+
 // GenerateSample generates a sample message.
 // If userName is non-empty, formats the message with a mention.
 func (p *MessageParser) GenerateSample(userName string) string {
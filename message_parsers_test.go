@@ -2,6 +2,7 @@ package memebot
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -94,6 +95,151 @@ func TestMessageParser(t *testing.T) {
 	}
 }
 
+func TestMessageParserCaptions(t *testing.T) {
+	kwParser, err := NewRegexpKeywordParser(`^(\w+)$`, []string{})
+	require.NoError(t, err)
+	parser := MessageParser{KeywordParser: kwParser, ParseCaptions: true}
+
+	parsed := parser.Parse("name", "id", "name grumpy: I had fun once | it was awful")
+	assert.Equal(t, "grumpy", parsed.Keyword)
+	assert.True(t, parsed.Mentioned)
+	assert.Equal(t, Caption{"I had fun once", "it was awful"}, parsed.Caption)
+
+	parsed = parser.Parse("name", "id", "name grumpy: no")
+	assert.Equal(t, "grumpy", parsed.Keyword)
+	assert.Equal(t, Caption{Top: "no"}, parsed.Caption)
+
+	parsed = parser.Parse("name", "id", "name grumpy: | bottom only")
+	assert.Equal(t, Caption{Bottom: "bottom only"}, parsed.Caption)
+
+	// Not mentioned.
+	parsed = parser.Parse("name", "id", "grumpy: I had fun once")
+	assert.Equal(t, "", parsed.Keyword)
+	assert.True(t, parsed.Caption.IsEmpty())
+
+	// Keyword doesn't match before the colon.
+	parsed = parser.Parse("name", "id", "name very grumpy: no")
+	assert.Equal(t, "", parsed.Keyword)
+	assert.True(t, parsed.Caption.IsEmpty())
+
+	// Empty caption.
+	parsed = parser.Parse("name", "id", "name grumpy:")
+	assert.True(t, parsed.Caption.IsEmpty())
+
+	parser.ParseCaptions = false
+	parsed = parser.Parse("name", "id", "name grumpy: I had fun once")
+	assert.Equal(t, "", parsed.Keyword)
+	assert.True(t, parsed.Caption.IsEmpty())
+}
+
+func TestMessageParserCounts(t *testing.T) {
+	kwParser, err := NewRegexpKeywordParser(`^(\w+)$`, []string{})
+	require.NoError(t, err)
+	parser := MessageParser{KeywordParser: kwParser, ParseCounts: true, ParseCaptions: true}
+
+	parsed := parser.Parse("name", "id", "name 4 cats")
+	assert.Equal(t, "cats", parsed.Keyword)
+	assert.Equal(t, 4, parsed.Count)
+
+	parsed = parser.Parse("name", "id", "name 2 cats: top")
+	assert.Equal(t, "cats", parsed.Keyword)
+	assert.Equal(t, 2, parsed.Count)
+	assert.Equal(t, Caption{Top: "top"}, parsed.Caption)
+
+	parsed = parser.Parse("name", "id", "name cats")
+	assert.Equal(t, "cats", parsed.Keyword)
+	assert.Equal(t, 0, parsed.Count)
+
+	// A number on its own is a keyword.
+	parsed = parser.Parse("name", "id", "name 42")
+	assert.Equal(t, "42", parsed.Keyword)
+	assert.Equal(t, 0, parsed.Count)
+
+	// Not mentioned.
+	parsed = parser.Parse("name", "id", "4 cats")
+	assert.Equal(t, "", parsed.Keyword)
+
+	parser.ParseCounts = false
+	parsed = parser.Parse("name", "id", "name 4 cats")
+	assert.Equal(t, "", parsed.Keyword)
+	assert.Equal(t, 0, parsed.Count)
+}
+
+func TestMessageParserModifiers(t *testing.T) {
+	kwParser, err := NewRegexpKeywordParser(`^([\w ]+)$`, []string{})
+	require.NoError(t, err)
+	parser := MessageParser{KeywordParser: kwParser, ParseModifiers: true, ParseCaptions: true}
+
+	parsed := parser.Parse("name", "id", "name party parrot reversed")
+	assert.Equal(t, "party parrot", parsed.Keyword)
+	assert.Equal(t, []Modifier{ModifierReversed}, parsed.Modifiers)
+
+	parsed = parser.Parse("name", "id", "name party parrot Backwards slow: so slow")
+	assert.Equal(t, "party parrot", parsed.Keyword)
+	assert.Equal(t, []Modifier{ModifierReversed, ModifierSlow}, parsed.Modifiers)
+	assert.Equal(t, Caption{Top: "so slow"}, parsed.Caption)
+
+	parsed = parser.Parse("name", "id", "name party parrot")
+	assert.Equal(t, "party parrot", parsed.Keyword)
+	assert.Empty(t, parsed.Modifiers)
+
+	// Not mentioned.
+	parsed = parser.Parse("name", "id", "party parrot reversed")
+	assert.Equal(t, "party parrot reversed", parsed.Keyword)
+	assert.Empty(t, parsed.Modifiers)
+
+	parser.ParseModifiers = false
+	parsed = parser.Parse("name", "id", "name party parrot reversed")
+	assert.Equal(t, "party parrot reversed", parsed.Keyword)
+	assert.Empty(t, parsed.Modifiers)
+}
+
+func TestMessageParserStats(t *testing.T) {
+	kwParser, err := NewRegexpKeywordParser(`^([\w ]+)$`, []string{})
+	require.NoError(t, err)
+	parser := MessageParser{KeywordParser: kwParser, ParseStats: true}
+
+	parsed := parser.Parse("name", "id", "name stats")
+	assert.True(t, parsed.Stats)
+	assert.Equal(t, time.Duration(0), parsed.StatsWindow)
+	assert.Equal(t, "", parsed.Keyword)
+
+	parsed = parser.Parse("name", "id", "name Stats 30d")
+	assert.True(t, parsed.Stats)
+	assert.Equal(t, 30*24*time.Hour, parsed.StatsWindow)
+
+	// Anything else is a keyword.
+	parsed = parser.Parse("name", "id", "name stats cat")
+	assert.False(t, parsed.Stats)
+	assert.Equal(t, "stats cat", parsed.Keyword)
+
+	// Not mentioned.
+	parsed = parser.Parse("name", "id", "stats")
+	assert.False(t, parsed.Stats)
+	assert.Equal(t, "stats", parsed.Keyword)
+
+	parser.ParseStats = false
+	parsed = parser.Parse("name", "id", "name stats")
+	assert.False(t, parsed.Stats)
+	assert.Equal(t, "stats", parsed.Keyword)
+}
+
+func TestMessageParserWishes(t *testing.T) {
+	kwParser, err := NewRegexpKeywordParser(`^([\w ]+)$`, []string{})
+	require.NoError(t, err)
+	parser := MessageParser{KeywordParser: kwParser, ParseWishes: true}
+
+	assert.True(t, parser.Parse("name", "id", "name wishlist").Wishes)
+	assert.True(t, parser.Parse("name", "id", "name Wishes").Wishes)
+	assert.False(t, parser.Parse("name", "id", "name wishes granted").Wishes)
+	assert.False(t, parser.Parse("name", "id", "wishlist").Wishes)
+
+	parser.ParseWishes = false
+	parsed := parser.Parse("name", "id", "name wishlist")
+	assert.False(t, parsed.Wishes)
+	assert.Equal(t, "wishlist", parsed.Keyword)
+}
+
 var testSlackPrefixMentionParser = SlackPrefixMentionParser{}
 
 func TestSlackPrefixMentionParser_Name(t *testing.T) {
@@ -0,0 +1,485 @@
+package memebot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsRegistry serves metrics in the Prometheus text exposition format.
+// Metrics are written in the order they were created.
+type MetricsRegistry struct {
+	lock    sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	writeMetric(w io.Writer)
+}
+
+// metricDesc describes a metric and holds its values, one for each set of label values.
+type metricDesc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	lock   sync.Mutex
+	values map[string]*metricValue
+}
+
+type metricValue struct {
+	labelValues []string
+
+	// Used by counters and gauges.
+	value float64
+
+	// Used by histograms. bucketCounts are not cumulative.
+	bucketCounts []uint64
+	count        uint64
+	sum          float64
+}
+
+func newMetricDesc(name, help, kind string, labels []string) metricDesc {
+	return metricDesc{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: make(map[string]*metricValue),
+	}
+}
+
+// value returns the value for labelValues, creating it if necessary.
+// Must be called with lock held.
+func (d *metricDesc) value(labelValues []string, buckets int) *metricValue {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("%s has labels %v, got values %v", d.name, d.labels, labelValues))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	value, found := d.values[key]
+	if !found {
+		value = &metricValue{
+			labelValues:  append([]string(nil), labelValues...),
+			bucketCounts: make([]uint64, buckets),
+		}
+		d.values[key] = value
+	}
+	return value
+}
+
+// find returns the value for labelValues, or a zero value if there isn't one yet.
+// Must be called with lock held.
+func (d *metricDesc) find(labelValues []string) *metricValue {
+	if value, found := d.values[strings.Join(labelValues, "\xff")]; found {
+		return value
+	}
+	return &metricValue{}
+}
+
+// sortedValues must be called with lock held.
+func (d *metricDesc) sortedValues() []*metricValue {
+	keys := make([]string, 0, len(d.values))
+	for key := range d.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make([]*metricValue, len(keys))
+	for i, key := range keys {
+		values[i] = d.values[key]
+	}
+	return values
+}
+
+func (d *metricDesc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeMetricHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+// formatMetricLabels formats names and values like {a="1",b="2"}, or returns empty if there
+// are none.
+func formatMetricLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, `%s="%s"`, name, escapeMetricLabelValue(values[i]))
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+var (
+	metricHelpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	metricLabelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeMetricHelp(help string) string {
+	return metricHelpEscaper.Replace(help)
+}
+
+func escapeMetricLabelValue(value string) string {
+	return metricLabelValueEscaper.Replace(value)
+}
+
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Counter is a value that only goes up, optionally partitioned by labels.
+type Counter struct {
+	metricDesc
+}
+
+// Add adds delta, which must not be negative, to the value for labelValues.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("%s can't be decreased by %v", c.name, delta))
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.value(labelValues, 0).value += delta
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Value returns the current value for labelValues.
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.find(labelValues).value
+}
+
+func (c *Counter) writeMetric(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.writeHeader(w)
+	for _, value := range c.sortedValues() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatMetricLabels(c.labels, value.labelValues), formatMetricValue(value.value))
+	}
+}
+
+// Gauge is a value that can go up and down, optionally partitioned by labels.
+type Gauge struct {
+	metricDesc
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.value(labelValues, 0).value = value
+}
+
+// Value returns the current value for labelValues.
+func (g *Gauge) Value(labelValues ...string) float64 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.find(labelValues).value
+}
+
+func (g *Gauge) writeMetric(w io.Writer) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.writeHeader(w)
+	for _, value := range g.sortedValues() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatMetricLabels(g.labels, value.labelValues), formatMetricValue(value.value))
+	}
+}
+
+// Histogram counts observations in buckets, optionally partitioned by labels.
+type Histogram struct {
+	metricDesc
+
+	// Upper bounds of the buckets, in increasing order, not including +Inf.
+	buckets []float64
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	v := h.value(labelValues, len(h.buckets))
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		v.bucketCounts[i]++
+	}
+	v.count++
+	v.sum += value
+}
+
+// Count returns the number of observations for labelValues.
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.find(labelValues).count
+}
+
+// Sum returns the sum of the observations for labelValues.
+func (h *Histogram) Sum(labelValues ...string) float64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.find(labelValues).sum
+}
+
+func (h *Histogram) writeMetric(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.writeHeader(w)
+	bucketLabels := append(append([]string(nil), h.labels...), "le")
+	for _, value := range h.sortedValues() {
+		bucketValues := append(append([]string(nil), value.labelValues...), "")
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += value.bucketCounts[i]
+			bucketValues[len(bucketValues)-1] = formatMetricValue(bound)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatMetricLabels(bucketLabels, bucketValues), cumulative)
+		}
+		bucketValues[len(bucketValues)-1] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatMetricLabels(bucketLabels, bucketValues), value.count)
+
+		labels := formatMetricLabels(h.labels, value.labelValues)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatMetricValue(value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, value.count)
+	}
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{}
+}
+
+// NewCounter registers a counter. Counters without labels are reported as 0 until
+// they're incremented.
+func (r *MetricsRegistry) NewCounter(name, help string, labels ...string) *Counter {
+	counter := &Counter{newMetricDesc(name, help, "counter", labels)}
+	if len(labels) == 0 {
+		counter.value(nil, 0)
+	}
+	r.register(counter)
+	return counter
+}
+
+// NewGauge registers a gauge. Gauges without labels are reported as 0 until they're set.
+func (r *MetricsRegistry) NewGauge(name, help string, labels ...string) *Gauge {
+	gauge := &Gauge{newMetricDesc(name, help, "gauge", labels)}
+	if len(labels) == 0 {
+		gauge.value(nil, 0)
+	}
+	r.register(gauge)
+	return gauge
+}
+
+// NewHistogram registers a histogram with buckets, which must be in increasing order.
+func (r *MetricsRegistry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("%s buckets aren't sorted: %v", name, buckets))
+	}
+	histogram := &Histogram{newMetricDesc(name, help, "histogram", labels), buckets}
+	if len(labels) == 0 {
+		histogram.value(nil, len(buckets))
+	}
+	r.register(histogram)
+	return histogram
+}
+
+func (r *MetricsRegistry) register(m metric) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteMetrics writes all metrics in the text exposition format.
+func (r *MetricsRegistry) WriteMetrics(w io.Writer) {
+	r.lock.Lock()
+	metrics := r.metrics
+	r.lock.Unlock()
+
+	for _, m := range metrics {
+		m.writeMetric(w)
+	}
+}
+
+func (r *MetricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var buf bytes.Buffer
+	r.WriteMetrics(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	buf.WriteTo(w)
+}
+
+// Outcomes of meme requests, used as the outcome label of memebot_meme_requests_total.
+const (
+	RequestHit   = "hit"
+	RequestMiss  = "miss"
+	RequestError = "error"
+)
+
+// DefaultLoadDurationBuckets are the buckets of memebot_load_duration_seconds.
+var DefaultLoadDurationBuckets = []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 120}
+
+// Metrics are the metrics reported by the bot and the object servers.
+// A nil *Metrics may be passed to anything that reports them to disable reporting.
+type Metrics struct {
+	*MetricsRegistry
+
+	Messages        *Counter
+	Mentions        *Counter
+	MemeRequests    *Counter
+	RepliesDropped  *Counter
+	SlackReconnects *Counter
+
+	ObjectRequests *Counter
+	ObjectBytes    *Counter
+
+	LoadDuration *Histogram
+	Memes        *Gauge
+}
+
+func NewMetrics() *Metrics {
+	r := NewMetricsRegistry()
+	return &Metrics{
+		MetricsRegistry: r,
+
+		Messages: r.NewCounter("memebot_messages_total",
+			"Slack messages seen by the bot."),
+		Mentions: r.NewCounter("memebot_mentions_total",
+			"Slack messages that mentioned the bot."),
+		MemeRequests: r.NewCounter("memebot_meme_requests_total",
+			"Keywords searched for, by whether a meme was found (hit), not found (miss), or the search failed (error).",
+			"outcome"),
+		RepliesDropped: r.NewCounter("memebot_replies_dropped_total",
+			"Replies that weren't sent because they took longer than the reply timeout."),
+		SlackReconnects: r.NewCounter("memebot_slack_reconnects_total",
+			"Times the bot reconnected to Slack after its first connection."),
+
+		ObjectRequests: r.NewCounter("memebot_object_requests_total",
+			"Requests for images, by HTTP status.", "status"),
+		ObjectBytes: r.NewCounter("memebot_object_bytes_total",
+			"Bytes of images served, by HTTP status.", "status"),
+
+		LoadDuration: r.NewHistogram("memebot_load_duration_seconds",
+			"Time taken to load and merge memes from all sources when any of them changed.",
+			DefaultLoadDurationBuckets),
+		Memes: r.NewGauge("memebot_memes",
+			"Number of memes that were last loaded."),
+	}
+}
+
+func (m *Metrics) messageReceived(mentioned bool) {
+	if m == nil {
+		return
+	}
+	m.Messages.Inc()
+	if mentioned {
+		m.Mentions.Inc()
+	}
+}
+
+// memeRequested records the outcome of a search that returned err.
+func (m *Metrics) memeRequested(err error) {
+	if m == nil {
+		return
+	}
+	switch err {
+	case nil:
+		m.MemeRequests.Inc(RequestHit)
+	case ErrNoMemeFound:
+		m.MemeRequests.Inc(RequestMiss)
+	default:
+		m.MemeRequests.Inc(RequestError)
+	}
+}
+
+func (m *Metrics) replyDropped() {
+	if m == nil {
+		return
+	}
+	m.RepliesDropped.Inc()
+}
+
+func (m *Metrics) slackReconnected() {
+	if m == nil {
+		return
+	}
+	m.SlackReconnects.Inc()
+}
+
+func (m *Metrics) memesLoaded(duration time.Duration, count int) {
+	if m == nil {
+		return
+	}
+	m.LoadDuration.Observe(duration.Seconds())
+	m.Memes.Set(float64(count))
+}
+
+// instrumentObjects returns a handler that counts the requests served by handler.
+func (m *Metrics) instrumentObjects(handler http.Handler) http.Handler {
+	if m == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w}
+		handler.ServeHTTP(recorder, req)
+
+		status := strconv.Itoa(recorder.Status())
+		m.ObjectRequests.Inc(status)
+		m.ObjectBytes.Add(float64(recorder.bytes), status)
+	})
+}
+
+// statusRecorder records the status and number of bytes of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Status returns the status written, or 200 if none was written.
+func (r *statusRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
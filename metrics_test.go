@@ -0,0 +1,89 @@
+package memebot
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsRegistryTextFormat(t *testing.T) {
+	registry := NewMetricsRegistry()
+	counter := registry.NewCounter("test_requests_total", "Requests,\nby status.", "status")
+	gauge := registry.NewGauge("test_things", "Things.")
+	histogram := registry.NewHistogram("test_duration_seconds", "Durations.", []float64{0.1, 1})
+
+	counter.Inc("500")
+	counter.Add(2, "200")
+	counter.Inc(`"quoted"`)
+	gauge.Set(1.5)
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(2)
+
+	var buf bytes.Buffer
+	registry.WriteMetrics(&buf)
+	assert.Equal(t, `# HELP test_requests_total Requests,\nby status.
+# TYPE test_requests_total counter
+test_requests_total{status="\"quoted\""} 1
+test_requests_total{status="200"} 2
+test_requests_total{status="500"} 1
+# HELP test_things Things.
+# TYPE test_things gauge
+test_things 1.5
+# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.1"} 1
+test_duration_seconds_bucket{le="1"} 2
+test_duration_seconds_bucket{le="+Inf"} 3
+test_duration_seconds_sum 2.55
+test_duration_seconds_count 3
+`, buf.String())
+}
+
+func TestMetricsRegistryReportsUnlabelledZeros(t *testing.T) {
+	registry := NewMetricsRegistry()
+	registry.NewCounter("test_total", "Test.")
+	registry.NewCounter("test_labelled_total", "Test.", "label")
+
+	var buf bytes.Buffer
+	registry.WriteMetrics(&buf)
+	assert.Contains(t, buf.String(), "\ntest_total 0\n")
+	assert.NotContains(t, buf.String(), "test_labelled_total{")
+}
+
+func TestMetricsLabelValuesMustMatch(t *testing.T) {
+	counter := NewMetricsRegistry().NewCounter("test_total", "Test.", "status")
+	assert.Panics(t, func() { counter.Inc() })
+	assert.Panics(t, func() { counter.Add(-1, "200") })
+}
+
+func TestMetricsServeHTTP(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.Messages.Inc()
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	require.NoError(t, err)
+	metrics.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", resp.Header().Get("Content-Type"))
+	assert.Contains(t, resp.Body.String(), "\nmemebot_messages_total 1\n")
+	assert.Contains(t, resp.Body.String(), "# TYPE memebot_load_duration_seconds histogram\n")
+}
+
+func TestNilMetricsAreDisabled(t *testing.T) {
+	var metrics *Metrics
+	metrics.messageReceived(true)
+	metrics.memeRequested(nil)
+	metrics.replyDropped()
+	metrics.slackReconnected()
+	metrics.memesLoaded(0, 1)
+
+	handler := http.RedirectHandler("/", http.StatusFound)
+	assert.Equal(t, handler, metrics.instrumentObjects(handler))
+}
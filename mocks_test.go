@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/mock"
+	"golang.org/x/net/context"
 )
 
 type MockSearcher struct {
@@ -21,6 +22,32 @@ func (m *MockSearcher) FindMeme(keyword string) (Meme, error) {
 	return nil, args.Error(1)
 }
 
+type MockCaptioner struct {
+	mock.Mock
+}
+
+func (m *MockCaptioner) Caption(ctx context.Context, meme Meme, caption Caption) (Meme, error) {
+	args := m.Called(meme, caption)
+
+	if captioned, ok := args.Get(0).(Meme); ok {
+		return captioned, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+type MockCollager struct {
+	mock.Mock
+}
+
+func (m *MockCollager) Collage(ctx context.Context, memes []Meme) (Meme, error) {
+	args := m.Called(memes)
+
+	if collage, ok := args.Get(0).(Meme); ok {
+		return collage, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
 type MockMemepository struct {
 	index *MemeIndex
 }
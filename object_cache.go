@@ -0,0 +1,79 @@
+package memebot
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultObjectCacheBytes is the default size of the cache of generated objects.
+const DefaultObjectCacheBytes = 64 << 20
+
+// objectCache is a least-recently-used cache of generated object data, bounded by
+// the total size of the data. It's safe to use from multiple goroutines.
+type objectCache struct {
+	maxBytes int64
+
+	lock    sync.Mutex
+	bytes   int64
+	order   *list.List // Of *objectCacheEntry, most recently used at the front.
+	entries map[string]*list.Element
+}
+
+type objectCacheEntry struct {
+	key  string
+	data []byte
+}
+
+func newObjectCache(maxBytes int64) *objectCache {
+	return &objectCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *objectCache) Get(key string) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, found := c.entries[key]
+	if !found {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*objectCacheEntry).data, true
+}
+
+// Add stores data under key, evicting the least-recently-used entries to make space.
+// Data larger than the entire cache is not stored.
+func (c *objectCache) Add(key string, data []byte) {
+	size := int64(len(data))
+	if size > c.maxBytes {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, found := c.entries[key]; found {
+		c.remove(element)
+	}
+	for c.bytes+size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+
+	c.entries[key] = c.order.PushFront(&objectCacheEntry{key, data})
+	c.bytes += size
+}
+
+func (c *objectCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.order.Len()
+}
+
+func (c *objectCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*objectCacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= int64(len(entry.data))
+}
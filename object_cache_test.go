@@ -0,0 +1,48 @@
+package memebot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObjectCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newObjectCache(6)
+	cache.Add("a", []byte("aa"))
+	cache.Add("b", []byte("bb"))
+	cache.Add("c", []byte("cc"))
+
+	// Make b the least recently used.
+	_, found := cache.Get("a")
+	assert.True(t, found)
+
+	cache.Add("d", []byte("dd"))
+	_, found = cache.Get("b")
+	assert.False(t, found)
+	for _, key := range []string{"a", "c", "d"} {
+		_, found := cache.Get(key)
+		assert.True(t, found, key)
+	}
+}
+
+func TestObjectCacheReplacesEntries(t *testing.T) {
+	cache := newObjectCache(4)
+	cache.Add("a", []byte("aa"))
+	cache.Add("a", []byte("aaaa"))
+
+	data, found := cache.Get("a")
+	assert.True(t, found)
+	assert.Equal(t, "aaaa", string(data))
+	assert.Equal(t, 1, cache.Len())
+}
+
+func TestObjectCacheIgnoresLargeEntries(t *testing.T) {
+	cache := newObjectCache(4)
+	cache.Add("a", []byte("aa"))
+	cache.Add("b", []byte("bbbbb"))
+
+	_, found := cache.Get("b")
+	assert.False(t, found)
+	_, found = cache.Get("a")
+	assert.True(t, found)
+}
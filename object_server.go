@@ -1,12 +1,15 @@
 package memebot
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -20,62 +23,380 @@ type ReadSeekerCloser interface {
 	io.Closer
 }
 
+// nopCloser makes an in-memory reader a ReadSeekerCloser.
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
 type Object interface {
 	Open() (ReadSeekerCloser, error)
 	LastModified() time.Time
 	Size() int64
 }
 
+// ContentTyper is implemented by objects that know their content type. Objects that
+// don't, or return an empty type, are served with a type inferred from their ID.
+type ContentTyper interface {
+	ContentType() string
+}
+
 type ObjectRepository interface {
 	FindObject(id string) (Object, bool)
 }
 
+// DefaultCacheMaxAge is how long clients may cache objects by default.
+// Object IDs are derived from their content, so an object at a given URL never changes.
+const DefaultCacheMaxAge = 365 * 24 * time.Hour
+
+type ObjectServerConfig struct {
+	// How long clients may cache objects. Defaults to DefaultCacheMaxAge.
+	CacheMaxAge time.Duration
+
+	// If not empty, URLs are signed with the first key and expire after SignedURLExpiry.
+	// Requests without a valid signature from any of the keys are rejected, so keys can
+	// be rotated by adding a new key at the front, and removing the old key once links
+	// signed by it have expired.
+	SigningKeys     []SigningKey
+	SignedURLExpiry time.Duration // Defaults to DefaultSignedURLExpiry.
+
+	// Requests with a User-Agent containing any of these strings don't need to be signed,
+	// e.g. for link unfurlers that strip query parameters. This weakens signing, since
+	// anyone can send such a User-Agent to fetch any object whose ID they know. Transform
+	// parameters are ignored unless the request is signed, so they can't be used to make
+	// the server resize images.
+	UnsignedUserAgents []string
+
+	// If non-zero, URLs returned by URL request images downscaled to this width.
+	DefaultWidth int
+
+	// Widths and heights that unsigned URLs may resize images to. Other sizes are rounded up
+	// to the nearest one, or down to the largest, so requests can't make the server generate
+	// and cache any number of variants of each image. Sizes in signed URLs aren't changed.
+	// Defaults to DefaultWidth and GalleryThumbnailSize.
+	ResizeSizes []int
+
+	// Maximum total size of resized images to keep in memory. Defaults to DefaultObjectCacheBytes.
+	TransformCacheBytes int64
+
+	// If true, JPEG and PNG images are served without their metadata, e.g. EXIF data with
+	// GPS coordinates. See StripImageMetadata.
+	StripMetadata bool
+
+	// Types of objects that may be served, used for their content types and to disable
+	// transforms. Defaults to DefaultMediaTypes.
+	MediaTypes MediaTypes
+
+	// If not nil, requests and bytes served are reported to Metrics.
+	Metrics *Metrics
+
+	Log *Logger // Defaults to DefaultLogger.
+}
+
 type ObjectServer struct {
+	ObjectServerConfig
 	repository ObjectRepository
 	route      *mux.Route
+
+	// Nil if URLs aren't signed.
+	signer *urlSigner
+
+	transformParsers []transformParser
+	transformCache   *objectCache
+	transformGroup   transformGroup
 }
 
 func CreateObjectServer(router *mux.Router, repository ObjectRepository) *ObjectServer {
+	return CreateObjectServerWithConfig(router, repository, ObjectServerConfig{})
+}
+
+func CreateObjectServerWithConfig(router *mux.Router, repository ObjectRepository, config ObjectServerConfig) *ObjectServer {
+	if config.CacheMaxAge <= 0 {
+		config.CacheMaxAge = DefaultCacheMaxAge
+	}
+	if config.SignedURLExpiry <= 0 {
+		config.SignedURLExpiry = DefaultSignedURLExpiry
+	}
+	if config.TransformCacheBytes <= 0 {
+		config.TransformCacheBytes = DefaultObjectCacheBytes
+	}
+	if config.MediaTypes == nil {
+		config.MediaTypes = DefaultMediaTypes
+	}
+	if len(config.ResizeSizes) == 0 {
+		config.ResizeSizes = []int{GalleryThumbnailSize}
+		if config.DefaultWidth > 0 {
+			config.ResizeSizes = append(config.ResizeSizes, config.DefaultWidth)
+		}
+	}
+
 	server := &ObjectServer{
-		repository: repository,
+		ObjectServerConfig: config,
+		repository:         repository,
+		transformParsers:   []transformParser{parseGIFTransform, parseResizeTransform},
+		transformCache:     newObjectCache(config.TransformCacheBytes),
+	}
+	if len(config.SigningKeys) > 0 {
+		server.signer = &urlSigner{
+			keys:   config.SigningKeys,
+			expiry: config.SignedURLExpiry,
+			now:    time.Now,
+		}
+	}
+	server.route = router.Path("/{id}").Handler(config.Metrics.instrumentObjects(http.HandlerFunc(server.serveObject)))
+	return server
+}
+
+func (s *ObjectServer) serveObject(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id := vars["id"]
+
+	if id == "" {
+		s.Log.Warn("bad object request", "err", "no ID specified")
+		http.Error(w, "no ID specified", http.StatusBadRequest)
+		return
+	}
+
+	query, err := s.verifySignature(id, req)
+	if err != nil {
+		s.Log.Warn("forbidden object request", "id", id, "err", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	object, found := s.repository.FindObject(id)
+	if !found {
+		s.Log.Warn("object not found", "id", id)
+		http.NotFound(w, req)
+		return
+	}
+
+	transforms, err := parseTransforms(query, s.transformParsers)
+	if err != nil {
+		s.Log.Warn("bad object request", "id", id, "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.signer == nil {
+		snapResizeTransforms(transforms, s.ResizeSizes)
+	}
+
+	ext := getNormalizedExtensionWithoutDot(id)
+	if len(transforms) > 0 && !s.MediaTypes.resizable(ext) {
+		s.Log.Warn("bad object request", "id", id, "err", errTransformNotSupported)
+		http.Error(w, errTransformNotSupported.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Transforms preserve the format of the image.
+	if contentType := s.contentType(ext, object); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	if s.StripMetadata && (stripMetadataTransform{}).Supports(ext) {
+		// Stripped first, so other transforms see the image with its orientation applied.
+		transforms = append([]objectTransform{stripMetadataTransform{}}, transforms...)
+	}
+
+	// IDs are content hashes, so they make strong validators.
+	variantId := id
+	if len(transforms) > 0 {
+		variantId += "-" + transformsKey(transforms)
+	}
+	etag := `"` + variantId + `"`
+
+	if etagMatches(req.Header.Get("If-None-Match"), etag) {
+		s.setCacheHeaders(w, req, etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
-	server.route = router.Path("/{id}").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		vars := mux.Vars(req)
-		id := vars["id"]
 
-		if id == "" {
-			log.Println("bad request: no ID specified")
-			http.Error(w, "no ID specified", http.StatusBadRequest)
+	if len(transforms) > 0 {
+		s.serveTransformedObject(w, req, id, variantId, etag, object, transforms)
+		return
+	}
+
+	data, err := object.Open()
+	if err != nil {
+		s.Log.Error("error opening object", "id", id, "err", err)
+		serveUncachedError(w, fmt.Sprintf("error opening object %s: %s", id, err), http.StatusInternalServerError)
+		return
+	}
+	defer data.Close()
+	s.Log.Debug("loaded object", "id", id, "bytes", object.Size())
+
+	s.setCacheHeaders(w, req, etag)
+	http.ServeContent(w, req, id, object.LastModified(), data)
+}
+
+// setCacheHeaders must only be called once the response is known to succeed, so errors
+// aren't cached. Responses to signed URLs may only be cached privately, until the URL
+// expires, so shared caches can't serve them to anyone after that.
+func (s *ObjectServer) setCacheHeaders(w http.ResponseWriter, req *http.Request, etag string) {
+	w.Header().Set("ETag", etag)
+	if s.signer == nil {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", int64(s.CacheMaxAge/time.Second)))
+		return
+	}
+
+	maxAge := s.CacheMaxAge
+	if expires, err := s.signer.Expires(req.URL.Query()); err == nil {
+		maxAge = minDuration(maxAge, expires.Sub(s.signer.now()))
+	} else {
+		// Requests exempt from signing.
+		maxAge = minDuration(maxAge, s.SignedURLExpiry)
+	}
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int64(maxAge/time.Second)))
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// serveUncachedError replies with an error that caches must not store, since it may be
+// transient.
+func serveUncachedError(w http.ResponseWriter, error string, code int) {
+	w.Header().Del("Content-Type")
+	w.Header().Set("Cache-Control", "no-store")
+	http.Error(w, error, code)
+}
+
+func (s *ObjectServer) serveTransformedObject(w http.ResponseWriter, req *http.Request,
+	id, variantId, etag string, object Object, transforms []objectTransform) {
+	data, found := s.transformCache.Get(variantId)
+	if !found {
+		var err error
+		data, err = s.transformGroup.Do(variantId, func() ([]byte, error) {
+			data, err := generateTransformedObject(id, object, transforms)
+			if err == nil {
+				s.transformCache.Add(variantId, data)
+				s.Log.Debug("generated object variant", "id", variantId, "bytes", len(data))
+			}
+			return data, err
+		})
+		if err == errTransformNotSupported || err == ErrImageTooLarge {
+			s.Log.Warn("bad object request", "id", variantId, "err", err)
+			serveUncachedError(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			s.Log.Error("error transforming object", "id", variantId, "err", err)
+			serveUncachedError(w, fmt.Sprintf("error transforming object %s: %s", variantId, err), http.StatusInternalServerError)
 			return
 		}
+	}
 
-		object, found := repository.FindObject(id)
-		if !found {
-			err := fmt.Errorf("id not found: %s", id)
-			log.Printf(err.Error())
-			http.NotFound(w, req)
-			return
+	s.setCacheHeaders(w, req, etag)
+	http.ServeContent(w, req, id, object.LastModified(), bytes.NewReader(data))
+}
+
+// contentType returns the type to serve object with, or empty if it should be inferred
+// from its ID.
+func (s *ObjectServer) contentType(ext string, object Object) string {
+	if typed, ok := object.(ContentTyper); ok && typed.ContentType() != "" {
+		return typed.ContentType()
+	}
+	if mediaType, found := s.MediaTypes[ext]; found {
+		return mediaType.ContentType
+	}
+	return ""
+}
+
+func generateTransformedObject(id string, object Object, transforms []objectTransform) ([]byte, error) {
+	data, err := object.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+
+	original, err := ioutil.ReadAll(data)
+	if err != nil {
+		return nil, err
+	}
+	return applyTransforms(original, getNormalizedExtensionWithoutDot(id), transforms)
+}
+
+// etagMatches returns true if etag is in the list of ETags in an If-None-Match header.
+// Uses weak comparison, as required for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
 		}
+	}
+	return false
+}
 
-		data, err := object.Open()
-		if err != nil {
-			err := fmt.Sprintf("error opening object %s: %s", id, err)
-			log.Printf(err)
-			http.Error(w, err, http.StatusInternalServerError)
-			return
+// verifySignature returns the query parameters of req that are authorized by its
+// signature. Requests exempt from signing that aren't signed get no parameters.
+func (s *ObjectServer) verifySignature(id string, req *http.Request) (url.Values, error) {
+	query := req.URL.Query()
+	if s.signer == nil {
+		return query, nil
+	}
+
+	err := s.signer.Verify(id, query)
+	if err == nil {
+		return query, nil
+	}
+
+	userAgent := req.UserAgent()
+	for _, exempt := range s.UnsignedUserAgents {
+		if exempt != "" && strings.Contains(userAgent, exempt) {
+			return nil, nil
 		}
-		defer data.Close()
-		log.Printf("loaded object id: %s (%d bytes)", id, object.Size())
+	}
+	return nil, err
+}
 
-		http.ServeContent(w, req, id, object.LastModified(), data)
-	})
-	return server
+// TransformedURL returns the URL of id with transform parameters added.
+func (s *ObjectServer) TransformedURL(id string, params url.Values) (*url.URL, error) {
+	transforms, err := parseTransforms(params, s.transformParsers)
+	if err != nil {
+		return nil, err
+	}
+	ext := getNormalizedExtensionWithoutDot(id)
+	if len(transforms) > 0 && !s.MediaTypes.resizable(ext) {
+		return nil, errTransformNotSupported
+	}
+	if err := checkTransformsSupported(transforms, ext); err != nil {
+		return nil, err
+	}
+
+	return s.urlWithParams(id, params), nil
 }
 
 func (s *ObjectServer) URL(id string) *url.URL {
-	url, err := s.route.URL("id", id)
+	return s.urlWithParams(id, nil)
+}
+
+// urlWithParams returns the URL of id with the default width and params added, and signed
+// if URLs are signed.
+func (s *ObjectServer) urlWithParams(id string, params url.Values) *url.URL {
+	objectURL, err := s.route.URL("id", id)
 	if err != nil {
 		panic(fmt.Errorf("error creating URL for id %s: %s", id, err))
 	}
-	return url
+
+	query := make(url.Values)
+	if ext := getNormalizedExtensionWithoutDot(id); s.DefaultWidth > 0 && resizableExtensions.Contains(ext) && s.MediaTypes.resizable(ext) {
+		query.Set("w", strconv.Itoa(s.DefaultWidth))
+	}
+	for key, values := range params {
+		query[key] = values
+	}
+	if s.signer != nil {
+		query = s.signer.Sign(id, query)
+	}
+	objectURL.RawQuery = query.Encode()
+	return objectURL
 }
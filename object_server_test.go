@@ -0,0 +1,438 @@
+package memebot
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestFileServingMemepository creates a FileServingMemepository serving files from a
+// temporary directory. The caller should remove the directory when done.
+func newTestFileServingMemepository(t *testing.T, files map[string]string, serverConfig ObjectServerConfig) (
+	memepository *FileServingMemepository, router *mux.Router, dir string) {
+	return newTestFileServingMemepositoryWithTypes(t, files, serverConfig, MediaTypes{
+		"jpg": DefaultMediaTypes["jpg"],
+		"png": DefaultMediaTypes["png"],
+		"gif": DefaultMediaTypes["gif"],
+	})
+}
+
+// newTestFileServingMemepositoryWithTypes is like newTestFileServingMemepository, but
+// only loads files of mediaTypes.
+func newTestFileServingMemepositoryWithTypes(t *testing.T, files map[string]string, serverConfig ObjectServerConfig,
+	mediaTypes MediaTypes) (memepository *FileServingMemepository, router *mux.Router, dir string) {
+	dir, err := ioutil.TempDir("", "memebot-objects")
+	require.NoError(t, err)
+	for name, data := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+	}
+
+	router = mux.NewRouter()
+	memepository = NewFileServingMemepository(FileServingMemepositoryConfig{
+		Path:            dir,
+		ImageExtensions: mediaTypes.Extensions(),
+		Router:          router,
+		Server:          serverConfig,
+	})
+	_, err = memepository.Load()
+	require.NoError(t, err)
+	return
+}
+
+func serveTestRequest(t *testing.T, handler http.Handler, url string, header http.Header) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	return resp
+}
+
+func findTestFileMeme(t *testing.T, memepository *FileServingMemepository, keyword string) *FileMeme {
+	memes, err := memepository.Load()
+	require.NoError(t, err)
+	results := memes.FindByKeyword(keyword)
+	require.Len(t, results, 1)
+	return results[0].(*FileMeme)
+}
+
+func TestObjectServerCachingHeaders(t *testing.T) {
+	memepository, router, dir := newTestFileServingMemepository(t,
+		map[string]string{"foo.jpg": "foo data"}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "foo")
+
+	resp := serveTestRequest(t, router, meme.URL().String(), nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "foo data", resp.Body.String())
+	assert.Equal(t, `"`+meme.id+`"`, resp.Header().Get("ETag"))
+	assert.Equal(t, "public, max-age=31536000, immutable", resp.Header().Get("Cache-Control"))
+	assert.Equal(t, "image/jpeg", resp.Header().Get("Content-Type"))
+}
+
+func TestObjectServerCacheMaxAge(t *testing.T) {
+	memepository, router, dir := newTestFileServingMemepository(t,
+		map[string]string{"foo.jpg": "foo data"}, ObjectServerConfig{CacheMaxAge: time.Hour})
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "foo")
+
+	resp := serveTestRequest(t, router, meme.URL().String(), nil)
+	assert.Equal(t, "public, max-age=3600, immutable", resp.Header().Get("Cache-Control"))
+}
+
+func TestObjectServerIfNoneMatch(t *testing.T) {
+	memepository, router, dir := newTestFileServingMemepository(t,
+		map[string]string{"foo.jpg": "foo data"}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "foo")
+	etag := `"` + meme.id + `"`
+
+	for _, ifNoneMatch := range []string{etag, `"other", ` + etag, "W/" + etag, "*"} {
+		resp := serveTestRequest(t, router, meme.URL().String(), http.Header{"If-None-Match": {ifNoneMatch}})
+		assert.Equal(t, http.StatusNotModified, resp.Code, ifNoneMatch)
+		assert.Equal(t, 0, resp.Body.Len(), ifNoneMatch)
+		assert.Equal(t, etag, resp.Header().Get("ETag"), ifNoneMatch)
+		assert.NotEmpty(t, resp.Header().Get("Cache-Control"), ifNoneMatch)
+	}
+
+	resp := serveTestRequest(t, router, meme.URL().String(), http.Header{"If-None-Match": {`"other"`}})
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "foo data", resp.Body.String())
+}
+
+func TestObjectServerNotFound(t *testing.T) {
+	_, router, dir := newTestFileServingMemepository(t, nil, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+
+	resp := serveTestRequest(t, router, "/missing.jpg", nil)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	assert.Empty(t, resp.Header().Get("ETag"))
+}
+
+func TestObjectServerMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	memepository, router, dir := newTestFileServingMemepository(t,
+		map[string]string{"foo.jpg": "foo data"}, ObjectServerConfig{Metrics: metrics})
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "foo")
+
+	serveTestRequest(t, router, meme.URL().String(), nil)
+	serveTestRequest(t, router, meme.URL().String(), nil)
+	serveTestRequest(t, router, meme.URL().String(), http.Header{"If-None-Match": {`"` + meme.id + `"`}})
+	serveTestRequest(t, router, "/missing.jpg", nil)
+
+	assert.Equal(t, 2.0, metrics.ObjectRequests.Value("200"))
+	assert.Equal(t, float64(2*len("foo data")), metrics.ObjectBytes.Value("200"))
+	assert.Equal(t, 1.0, metrics.ObjectRequests.Value("304"))
+	assert.Equal(t, 0.0, metrics.ObjectBytes.Value("304"))
+	assert.Equal(t, 1.0, metrics.ObjectRequests.Value("404"))
+}
+
+func TestObjectServerSignedURLs(t *testing.T) {
+	memepository, router, dir := newTestFileServingMemepository(t,
+		map[string]string{"foo.jpg": "foo data"}, ObjectServerConfig{
+			SigningKeys:        []SigningKey{{"key", []byte("secret")}},
+			UnsignedUserAgents: []string{"Slackbot-LinkExpanding"},
+		})
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "foo")
+
+	signedURL := meme.URL()
+	assert.NotEmpty(t, signedURL.Query().Get("sig"))
+	resp := serveTestRequest(t, router, signedURL.String(), nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "foo data", resp.Body.String())
+
+	unsignedURL := *signedURL
+	unsignedURL.RawQuery = ""
+	resp = serveTestRequest(t, router, unsignedURL.String(), nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	tamperedURL := *signedURL
+	query := tamperedURL.Query()
+	query.Set("expires", "99999999999")
+	tamperedURL.RawQuery = query.Encode()
+	resp = serveTestRequest(t, router, tamperedURL.String(), nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	resp = serveTestRequest(t, router, unsignedURL.String(),
+		http.Header{"User-Agent": {"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"}})
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestObjectServerSignsTransforms(t *testing.T) {
+	memepository, router, dir := newTestFileServingMemepository(t,
+		map[string]string{"foo.png": string(encodeTestImage(t, "png", 40, 30))}, ObjectServerConfig{
+			SigningKeys: []SigningKey{{"key", []byte("secret")}},
+		})
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "foo")
+
+	resizedURL, err := meme.TransformedURL(url.Values{"w": {"20"}})
+	require.NoError(t, err)
+	resp := serveTestRequest(t, router, resizedURL.String(), nil)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// Transforms can't be added to or changed in a signed URL.
+	tamperedURL := meme.URL()
+	query := tamperedURL.Query()
+	query.Set("w", "10")
+	tamperedURL.RawQuery = query.Encode()
+	resp = serveTestRequest(t, router, tamperedURL.String(), nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	query = resizedURL.Query()
+	query.Set("w", "10")
+	resizedURL.RawQuery = query.Encode()
+	resp = serveTestRequest(t, router, resizedURL.String(), nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+}
+
+func TestObjectServerIgnoresUnsignedTransforms(t *testing.T) {
+	data := encodeTestImage(t, "png", 40, 30)
+	memepository, router, dir := newTestFileServingMemepository(t,
+		map[string]string{"foo.png": string(data)}, ObjectServerConfig{
+			SigningKeys:        []SigningKey{{"key", []byte("secret")}},
+			UnsignedUserAgents: []string{"Slackbot-LinkExpanding"},
+		})
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "foo")
+	exempt := http.Header{"User-Agent": {"Slackbot-LinkExpanding 1.0"}}
+
+	// Exempt requests get the original image.
+	unsignedURL := meme.URL()
+	unsignedURL.RawQuery = url.Values{"w": {"20"}, "fit": {"fill"}}.Encode()
+	resp := serveTestRequest(t, router, unsignedURL.String(), exempt)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, data, resp.Body.Bytes())
+
+	// Signed transforms are still applied.
+	resizedURL, err := meme.TransformedURL(url.Values{"w": {"20"}})
+	require.NoError(t, err)
+	resp = serveTestRequest(t, router, resizedURL.String(), exempt)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.NotEqual(t, data, resp.Body.Bytes())
+}
+
+func TestObjectServerSignedURLCaching(t *testing.T) {
+	memepository, router, dir := newTestFileServingMemepository(t,
+		map[string]string{"foo.jpg": "foo data"}, ObjectServerConfig{
+			SigningKeys:     []SigningKey{{"key", []byte("secret")}},
+			SignedURLExpiry: time.Hour,
+		})
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "foo")
+
+	now := time.Date(2016, 1, 2, 3, 10, 0, 0, time.UTC)
+	memepository.server.signer.now = func() time.Time { return now }
+	signedURL := meme.URL()
+
+	// URLs don't change until the next half of the expiry period.
+	now = now.Add(15 * time.Minute)
+	assert.Equal(t, signedURL, meme.URL())
+	now = now.Add(10 * time.Minute)
+	assert.NotEqual(t, signedURL, meme.URL())
+
+	// Expires at 04:00, 25 minutes from now.
+	resp := serveTestRequest(t, router, signedURL.String(), nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "private, max-age=1500", resp.Header().Get("Cache-Control"))
+}
+
+func TestObjectServerExpiredURL(t *testing.T) {
+	memepository, router, dir := newTestFileServingMemepository(t,
+		map[string]string{"foo.jpg": "foo data"}, ObjectServerConfig{
+			SigningKeys: []SigningKey{{"key", []byte("secret")}},
+		})
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "foo")
+
+	memepository.server.signer.now = func() time.Time {
+		return time.Now().Add(-DefaultSignedURLExpiry - time.Minute)
+	}
+	expiredURL := meme.URL()
+	memepository.server.signer.now = time.Now
+
+	resp := serveTestRequest(t, router, expiredURL.String(), nil)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	assert.Contains(t, resp.Body.String(), ErrURLExpired.Error())
+}
+
+func TestObjectServerResizesImages(t *testing.T) {
+	memepository, router, dir := newTestFileServingMemepository(t,
+		map[string]string{"foo.png": string(encodeTestImage(t, "png", 40, 30))}, ObjectServerConfig{ResizeSizes: []int{20}})
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "foo")
+
+	resp := serveTestRequest(t, router, meme.URL().String()+"?w=20", nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "image/png", resp.Header().Get("Content-Type"))
+	assert.Equal(t, `"`+meme.id+`-w20-h0-contain"`, resp.Header().Get("ETag"))
+	config, _ := decodeTestImageConfig(t, resp.Body.Bytes())
+	assert.Equal(t, 20, config.Width)
+	assert.Equal(t, 15, config.Height)
+	assert.Equal(t, 1, memepository.server.transformCache.Len())
+
+	// Served from the cache.
+	cached := serveTestRequest(t, router, meme.URL().String()+"?w=20", nil)
+	assert.Equal(t, resp.Body.Bytes(), cached.Body.Bytes())
+	assert.Equal(t, 1, memepository.server.transformCache.Len())
+
+	resp = serveTestRequest(t, router, meme.URL().String()+"?w=20", http.Header{"If-None-Match": {`"` + meme.id + `"`}})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = serveTestRequest(t, router, meme.URL().String()+"?w=-1", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestObjectServerSnapsUnsignedSizes(t *testing.T) {
+	memepository, router, dir := newTestFileServingMemepository(t,
+		map[string]string{"foo.png": string(encodeTestImage(t, "png", 400, 300))}, ObjectServerConfig{DefaultWidth: 100})
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "foo")
+
+	for query, key := range map[string]string{
+		"w=100":      "w100-h0-contain",
+		"w=99":       "w100-h0-contain",
+		"w=101":      "w240-h0-contain",
+		"w=1000":     "w240-h0-contain",
+		"w=50&h=200": "w100-h240-contain",
+	} {
+		resp := serveTestRequest(t, router, meme.URL().Path+"?"+query, nil)
+		require.Equal(t, http.StatusOK, resp.Code, query)
+		assert.Equal(t, `"`+meme.id+`-`+key+`"`, resp.Header().Get("ETag"), query)
+	}
+	assert.Equal(t, 3, memepository.server.transformCache.Len())
+}
+
+func TestObjectServerKeepsSignedSizes(t *testing.T) {
+	memepository, router, dir := newTestFileServingMemepository(t,
+		map[string]string{"foo.png": string(encodeTestImage(t, "png", 40, 30))},
+		ObjectServerConfig{SigningKeys: []SigningKey{{"key", []byte("secret")}}})
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "foo")
+
+	resizedURL, err := meme.TransformedURL(url.Values{"w": {"20"}})
+	require.NoError(t, err)
+	resp := serveTestRequest(t, router, resizedURL.String(), nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, `"`+meme.id+`-w20-h0-contain"`, resp.Header().Get("ETag"))
+}
+
+func TestTransformGroupSharesConcurrentCalls(t *testing.T) {
+	var group transformGroup
+	var calls int32
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	generate := func() ([]byte, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+			<-unblock
+		}
+		return []byte("data"), nil
+	}
+
+	results := make(chan []byte, 2)
+	go func() {
+		data, _ := group.Do("key", generate)
+		results <- data
+	}()
+	<-started
+	go func() {
+		data, _ := group.Do("key", generate)
+		results <- data
+	}()
+	// Give the second call time to start waiting.
+	time.Sleep(20 * time.Millisecond)
+	close(unblock)
+
+	assert.Equal(t, []byte("data"), <-results)
+	assert.Equal(t, []byte("data"), <-results)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// Calls made after the first finishes generate the data again.
+	data, err := group.Do("key", generate)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestObjectServerDoesNotCacheErrors(t *testing.T) {
+	memepository, router, dir := newTestFileServingMemepository(t,
+		map[string]string{"foo.png": "not a png"}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "foo")
+
+	resp := serveTestRequest(t, router, meme.URL().String()+"?w=20", nil)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	assert.Empty(t, resp.Header().Get("ETag"))
+	assert.Equal(t, "no-store", resp.Header().Get("Cache-Control"))
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header().Get("Content-Type"))
+}
+
+func TestObjectServerDefaultWidth(t *testing.T) {
+	memepository, _, dir := newTestFileServingMemepository(t,
+		map[string]string{"foo.png": "foo data"}, ObjectServerConfig{DefaultWidth: 480})
+	defer os.RemoveAll(dir)
+	meme := findTestFileMeme(t, memepository, "foo")
+
+	assert.Equal(t, "480", meme.URL().Query().Get("w"))
+}
+
+func TestObjectServerGIFTransforms(t *testing.T) {
+	memepository, router, dir := newTestFileServingMemepository(t, map[string]string{
+		"party.gif": string(encodeTestGIF(t, 20, 10, 3)),
+		"foo.jpg":   "foo data",
+	}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+	party := findTestFileMeme(t, memepository, "party")
+
+	reversedURL, err := party.TransformedURL(url.Values{"reverse": {"1"}})
+	require.NoError(t, err)
+	assert.Equal(t, "1", reversedURL.Query().Get("reverse"))
+
+	resp := serveTestRequest(t, router, reversedURL.String(), nil)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "image/gif", resp.Header().Get("Content-Type"))
+	assert.Equal(t, `"`+party.id+`-reverse"`, resp.Header().Get("ETag"))
+
+	foo := findTestFileMeme(t, memepository, "foo")
+	_, err = foo.TransformedURL(url.Values{"reverse": {"1"}})
+	assert.Equal(t, errTransformNotSupported, err)
+	resp = serveTestRequest(t, router, foo.URL().String()+"?reverse=1", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	_, err = party.TransformedURL(url.Values{"speed": {"100"}})
+	assert.Error(t, err)
+}
+
+func TestObjectServerRejectsLargeGIFs(t *testing.T) {
+	memepository, router, dir := newTestFileServingMemepository(t, map[string]string{
+		"party.gif": string(encodeTestGIF(t, 40, 30, MaxGIFFrames+1)),
+	}, ObjectServerConfig{ResizeSizes: []int{20}})
+	defer os.RemoveAll(dir)
+	party := findTestFileMeme(t, memepository, "party")
+
+	resizedURL, err := party.TransformedURL(url.Values{"w": {"20"}})
+	require.NoError(t, err)
+	resp := serveTestRequest(t, router, resizedURL.String(), nil)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	reversedURL, err := party.TransformedURL(url.Values{"reverse": {"1"}})
+	require.NoError(t, err)
+	resp = serveTestRequest(t, router, reversedURL.String(), nil)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
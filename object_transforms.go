@@ -0,0 +1,176 @@
+package memebot
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// MaxConcurrentTransforms is the most transforms that may run at once, across all object
+// servers, since each may hold several decoded copies of an image in memory.
+const MaxConcurrentTransforms = 4
+
+// transformSlots is a semaphore limiting the transforms running at once.
+var transformSlots = make(chan struct{}, MaxConcurrentTransforms)
+
+// acquireTransformSlot blocks until fewer than MaxConcurrentTransforms transforms are
+// running, and returns a function that releases the slot. Also used for other work that
+// decodes every frame of a GIF.
+func acquireTransformSlot() (release func()) {
+	transformSlots <- struct{}{}
+	return func() { <-transformSlots }
+}
+
+// objectTransform generates a variant of an object's data, e.g. a resized image.
+type objectTransform interface {
+	// Key identifies the variant generated by the transform, for caching and ETags.
+	Key() string
+
+	// Supports returns true if the transform can be applied to objects with the normalized
+	// extension ext.
+	Supports(ext string) bool
+
+	// Apply returns the transformed data. ext is the normalized extension of the object.
+	// Returns errTransformNotSupported if the transform can't be applied to this type of object.
+	Apply(data []byte, ext string) ([]byte, error)
+}
+
+// TransformableMeme is implemented by memes served by an ObjectServer.
+type TransformableMeme interface {
+	Meme
+
+	// TransformedURL returns the URL of the meme with transform parameters added,
+	// e.g. {"reverse": {"1"}}. Returns an error if the parameters are invalid or not
+	// supported for the meme.
+	TransformedURL(params url.Values) (*url.URL, error)
+}
+
+// transformableMeme returns the TransformableMeme that serves meme, if there is one.
+func transformableMeme(meme Meme) (TransformableMeme, bool) {
+	for {
+		if transformable, ok := meme.(TransformableMeme); ok {
+			return transformable, true
+		}
+		wrapper, ok := meme.(memeWrapper)
+		if !ok {
+			return nil, false
+		}
+		meme = wrapper.unwrap()
+	}
+}
+
+// transformedMeme links to a transformed variant of a meme.
+type transformedMeme struct {
+	Meme
+	url *url.URL
+}
+
+func (m *transformedMeme) URL() *url.URL {
+	return m.url
+}
+
+func (m *transformedMeme) unwrap() Meme {
+	return m.Meme
+}
+
+// transformParser parses a transform from the query parameters of an object request.
+// Returns nil if the transform wasn't requested, or a badTransformError if the parameters
+// are invalid.
+type transformParser func(query url.Values) (objectTransform, error)
+
+var errTransformNotSupported = errors.New("transform not supported for this type of object")
+
+// badTransformError is returned by transformParsers for invalid parameters.
+type badTransformError string
+
+func (e badTransformError) Error() string {
+	return string(e)
+}
+
+// Extensions of images that can be decoded and re-encoded by the image packages.
+var resizableExtensions = MakeSet("jpg", "jpeg", "png", "gif")
+
+func parseTransforms(query url.Values, parsers []transformParser) (transforms []objectTransform, err error) {
+	for _, parse := range parsers {
+		transform, err := parse(query)
+		if err != nil {
+			return nil, err
+		}
+		if transform != nil {
+			transforms = append(transforms, transform)
+		}
+	}
+	return
+}
+
+// transformsKey returns a string that identifies the result of applying transforms in order.
+func transformsKey(transforms []objectTransform) string {
+	var keys []string
+	for _, transform := range transforms {
+		keys = append(keys, transform.Key())
+	}
+	return strings.Join(keys, "-")
+}
+
+// checkTransformsSupported returns errTransformNotSupported if any of transforms can't
+// be applied to objects with extension ext.
+func checkTransformsSupported(transforms []objectTransform, ext string) error {
+	for _, transform := range transforms {
+		if !transform.Supports(ext) {
+			return errTransformNotSupported
+		}
+	}
+	return nil
+}
+
+func applyTransforms(data []byte, ext string, transforms []objectTransform) ([]byte, error) {
+	for _, transform := range transforms {
+		var err error
+		if data, err = transform.Apply(data, ext); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// transformGroup runs at most one transform for each variant at a time, sharing its result
+// with concurrent requests for the same variant. It's safe to use from multiple goroutines.
+type transformGroup struct {
+	lock  sync.Mutex
+	calls map[string]*transformCall
+}
+
+type transformCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// Do calls generate once a transform slot is free, unless a call for key is already in
+// progress, in which case it waits for that call and returns its result.
+func (g *transformGroup) Do(key string, generate func() ([]byte, error)) ([]byte, error) {
+	g.lock.Lock()
+	if call, found := g.calls[key]; found {
+		g.lock.Unlock()
+		<-call.done
+		return call.data, call.err
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*transformCall)
+	}
+	call := &transformCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.lock.Unlock()
+
+	defer func() {
+		g.lock.Lock()
+		delete(g.calls, key)
+		g.lock.Unlock()
+		close(call.done)
+	}()
+
+	defer acquireTransformSlot()()
+	call.data, call.err = generate()
+	return call.data, call.err
+}
@@ -0,0 +1,140 @@
+package memebot
+
+import "image"
+
+// DefaultSimilarityThreshold is the largest number of bits by which the perceptual hashes
+// of two images can differ for them to be considered the same image.
+const DefaultSimilarityThreshold = 8
+
+// PerceptualHasher is implemented by memes that know a perceptual hash of their image.
+// Unlike content hashes, perceptual hashes of the same image are similar after it's been
+// re-encoded, resized or slightly edited.
+type PerceptualHasher interface {
+	// Returns false if the image couldn't be hashed.
+	PerceptualHash() (hash uint64, ok bool)
+}
+
+// differenceHash returns the difference hash (dHash) of an image: the image is scaled
+// down to 9×8 greyscale pixels, and each bit records whether a pixel is brighter than
+// the one to its right.
+func differenceHash(img image.Image) (hash uint64) {
+	const width, height = 9, 8
+
+	bounds := img.Bounds()
+	small := scaleImage(img, image.Rect(0, 0, bounds.Dx(), bounds.Dy()), width, height)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			hash <<= 1
+			if luminance(small, x, y) > luminance(small, x+1, y) {
+				hash |= 1
+			}
+		}
+	}
+	return
+}
+
+// luminance returns the brightness of a pixel, treating transparent pixels as white.
+func luminance(img *image.RGBA, x, y int) uint32 {
+	offset := img.PixOffset(x, y)
+	r, g, b, a := uint32(img.Pix[offset]), uint32(img.Pix[offset+1]), uint32(img.Pix[offset+2]), uint32(img.Pix[offset+3])
+
+	// Composite premultiplied colors over white.
+	white := 255 - a
+	r, g, b = r+white, g+white, b+white
+	return 299*r + 587*g + 114*b
+}
+
+func hammingDistance(a, b uint64) (distance int) {
+	for diff := a ^ b; diff != 0; diff &= diff - 1 {
+		distance++
+	}
+	return
+}
+
+// perceptualHash returns the perceptual hash of meme, or of the meme it wraps.
+func perceptualHash(meme Meme) (uint64, bool) {
+	for {
+		if hasher, ok := meme.(PerceptualHasher); ok {
+			return hasher.PerceptualHash()
+		}
+		wrapper, ok := meme.(memeWrapper)
+		if !ok {
+			return 0, false
+		}
+		meme = wrapper.unwrap()
+	}
+}
+
+// FindSimilarMemes groups memes whose perceptual hashes differ by at most threshold bits.
+// Only groups of two or more memes are returned. Groups, and the memes in them, are in the
+// same order as memes. Memes that can't be hashed are ignored.
+func FindSimilarMemes(memes []Meme, threshold int) (clusters [][]Meme) {
+	for _, indices := range similarMemeClusters(memes, threshold) {
+		cluster := make([]Meme, len(indices))
+		for i, index := range indices {
+			cluster[i] = memes[index]
+		}
+		clusters = append(clusters, cluster)
+	}
+	return
+}
+
+// similarMemeClusters is like FindSimilarMemes, but returns indices into memes.
+func similarMemeClusters(memes []Meme, threshold int) (clusters [][]int) {
+	var hashed []int
+	var hashes []uint64
+	for i, meme := range memes {
+		if hash, ok := perceptualHash(meme); ok {
+			hashed = append(hashed, i)
+			hashes = append(hashes, hash)
+		}
+	}
+
+	// Union-find, with each cluster's root being its first meme.
+	parents := make([]int, len(hashed))
+	for i := range parents {
+		parents[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+
+	for i := range hashes {
+		for j := i + 1; j < len(hashes); j++ {
+			if hammingDistance(hashes[i], hashes[j]) <= threshold {
+				rootI, rootJ := find(i), find(j)
+				if rootI < rootJ {
+					parents[rootJ] = rootI
+				} else if rootJ < rootI {
+					parents[rootI] = rootJ
+				}
+			}
+		}
+	}
+
+	clusterIndices := make(map[int]int)
+	for i, memeIndex := range hashed {
+		root := find(i)
+		clusterIndex, found := clusterIndices[root]
+		if !found {
+			clusterIndex = len(clusters)
+			clusterIndices[root] = clusterIndex
+			clusters = append(clusters, nil)
+		}
+		clusters[clusterIndex] = append(clusters[clusterIndex], memeIndex)
+	}
+
+	// Remove singletons, preserving order.
+	similar := clusters[:0]
+	for _, cluster := range clusters {
+		if len(cluster) > 1 {
+			similar = append(similar, cluster)
+		}
+	}
+	return similar
+}
@@ -0,0 +1,129 @@
+package memebot
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type MockPerceptualMeme struct {
+	MockMeme
+	hash uint64
+}
+
+func NewMockPerceptualMeme(url string, hash uint64, keywords ...string) Meme {
+	return MockPerceptualMeme{MockMeme{mustParseURL(url), keywords}, hash}
+}
+
+func (m MockPerceptualMeme) PerceptualHash() (uint64, bool) {
+	return m.hash, true
+}
+
+// newTestPattern draws a blocky pattern that's different for each seed.
+func newTestPattern(width, height, seed int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			block := (x*8/width)*(seed+3) + (y*8/height)*(seed+5)
+			v := uint8(block * 37 % 256)
+			img.Set(x, y, color.RGBA{v, v, 255 - v, 255})
+		}
+	}
+	return img
+}
+
+func TestPerceptualHashSimilarImages(t *testing.T) {
+	var original, reencoded, resized bytes.Buffer
+	require.NoError(t, png.Encode(&original, newTestPattern(200, 160, 1)))
+	require.NoError(t, jpeg.Encode(&reencoded, newTestPattern(200, 160, 1), &jpeg.Options{Quality: 50}))
+	require.NoError(t, png.Encode(&resized, newTestPattern(100, 80, 1)))
+
+	originalHash, ok := analyzeImage(original.Bytes(), "png", nil).PerceptualHash()
+	require.True(t, ok)
+	reencodedHash, ok := analyzeImage(reencoded.Bytes(), "jpg", nil).PerceptualHash()
+	require.True(t, ok)
+	resizedHash, ok := analyzeImage(resized.Bytes(), "png", nil).PerceptualHash()
+	require.True(t, ok)
+
+	assert.True(t, hammingDistance(originalHash, reencodedHash) <= DefaultSimilarityThreshold)
+	assert.True(t, hammingDistance(originalHash, resizedHash) <= DefaultSimilarityThreshold)
+
+	var different bytes.Buffer
+	require.NoError(t, png.Encode(&different, newTestPattern(200, 160, 2)))
+	differentHash, ok := analyzeImage(different.Bytes(), "png", nil).PerceptualHash()
+	require.True(t, ok)
+	assert.True(t, hammingDistance(originalHash, differentHash) > DefaultSimilarityThreshold)
+
+	_, ok = analyzeImage([]byte("not an image"), "png", nil).PerceptualHash()
+	assert.False(t, ok)
+}
+
+func TestHammingDistance(t *testing.T) {
+	assert.Equal(t, 0, hammingDistance(0xff, 0xff))
+	assert.Equal(t, 1, hammingDistance(0x0, 0x8000000000000000))
+	assert.Equal(t, 64, hammingDistance(0, ^uint64(0)))
+}
+
+func TestFindSimilarMemes(t *testing.T) {
+	memes := []Meme{
+		NewMockPerceptualMeme("http://a.com", 0x00, "a"),
+		NewMockPerceptualMeme("http://b.com", 0xff00, "b"),
+		NewMockPerceptualMeme("http://c.com", 0x01, "c"),
+		NewMockMeme("http://unhashed.com", "d"),
+		NewMockPerceptualMeme("http://e.com", 0xff01, "e"),
+		NewMockPerceptualMeme("http://f.com", 0xff0000, "f"),
+		// Similar to c, but not to a.
+		NewMockPerceptualMeme("http://g.com", 0x03, "g"),
+	}
+
+	clusters := FindSimilarMemes(memes, 1)
+	assert.Equal(t, [][]Meme{
+		{memes[0], memes[2], memes[6]},
+		{memes[1], memes[4]},
+	}, clusters)
+
+	assert.Empty(t, FindSimilarMemes(memes[:2], 1))
+}
+
+func TestCompositeMemepositoryMergesSimilarKeywords(t *testing.T) {
+	composite := &CompositeMemepository{
+		Sources: []MemepositorySource{
+			{"a", &MockMemepository{NewTestMemeIndex(
+				NewMockPerceptualMeme("http://a.com", 0x00, "foo"),
+				NewMockPerceptualMeme("http://other.com", 0xffff, "other"),
+			)}},
+			{"b", &MockMemepository{NewTestMemeIndex(NewMockPerceptualMeme("http://b.com", 0x03, "bar"))}},
+		},
+		DuplicatePolicy:     MergeSimilarKeywords,
+		SimilarityThreshold: 2,
+	}
+
+	memes, err := composite.Load()
+	require.NoError(t, err)
+	require.Equal(t, 2, memes.Len())
+	assert.Equal(t, "a.com", memes.FindByKeyword("bar")[0].URL().Host)
+	assert.Equal(t, []string{"foo", "bar"}, memes.Memes()[0].Keywords())
+	hash, ok := perceptualHash(memes.Memes()[0])
+	assert.True(t, ok)
+	assert.Equal(t, uint64(0), hash)
+}
+
+func TestFileMemePerceptualHash(t *testing.T) {
+	memepository, _, dir := newTestFileServingMemepository(t, map[string]string{
+		"foo.png": string(encodeTestImage(t, "png", 40, 30)),
+		"bar.jpg": "not an image",
+	}, ObjectServerConfig{})
+	defer os.RemoveAll(dir)
+
+	_, ok := findTestFileMeme(t, memepository, "foo").PerceptualHash()
+	assert.True(t, ok)
+	_, ok = findTestFileMeme(t, memepository, "bar").PerceptualHash()
+	assert.False(t, ok)
+}
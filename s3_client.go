@@ -0,0 +1,333 @@
+package memebot
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3Algorithm      = "AWS4-HMAC-SHA256"
+	s3Service        = "s3"
+	s3DateFormat     = "20060102"
+	s3DateTimeFormat = "20060102T150405Z"
+
+	// Hex-encoded SHA-256 of an empty payload.
+	s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	DefaultS3Region = "us-east-1"
+)
+
+// s3Client is a minimal client for the subset of the S3 API needed to read memes
+// out of a bucket. It uses path-style addressing so that it works with
+// S3-compatible servers that don't support virtual-hosted buckets.
+type s3Client struct {
+	endpoint *url.URL
+	bucket   string
+	signer   *s3Signer
+	client   *http.Client
+}
+
+type s3ObjectInfo struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int64     `xml:"Size"`
+	ETag         string    `xml:"ETag"`
+}
+
+type s3ListBucketResult struct {
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []s3ObjectInfo `xml:"Contents"`
+	NextContinuationToken string         `xml:"NextContinuationToken"`
+}
+
+type s3ErrorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func newS3Client(endpoint *url.URL, bucket string, signer *s3Signer, client *http.Client) *s3Client {
+	if client == nil {
+		client = DefaultHTTPClient
+	}
+	return &s3Client{
+		endpoint: endpoint,
+		bucket:   bucket,
+		signer:   signer,
+		client:   client,
+	}
+}
+
+// objectURL returns the path-style URL of key, or of the bucket itself if key is empty.
+func (c *s3Client) objectURL(key string) *url.URL {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = s3URIEncode(u.Path, false)
+	u.RawQuery = ""
+	return &u
+}
+
+// ListObjects returns all the objects in the bucket whose keys start with prefix,
+// following continuation tokens until the listing is complete.
+func (c *s3Client) ListObjects(prefix string) (objects []s3ObjectInfo, err error) {
+	var continuationToken string
+
+	for {
+		u := c.objectURL("")
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		u.RawQuery = query.Encode()
+
+		var body io.ReadCloser
+		body, err = c.get(u)
+		if err != nil {
+			return
+		}
+
+		var result s3ListBucketResult
+		err = xml.NewDecoder(body).Decode(&result)
+		body.Close()
+		if err != nil {
+			err = fmt.Errorf("error decoding bucket listing: %s", err)
+			return
+		}
+
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// GetObject returns the contents of key. The caller must close the returned reader.
+func (c *s3Client) GetObject(key string) (io.ReadCloser, error) {
+	return c.get(c.objectURL(key))
+}
+
+// PresignGetObject returns a URL that can be used to get key without credentials
+// until expiry has elapsed.
+func (c *s3Client) PresignGetObject(key string, expiry time.Duration) *url.URL {
+	return c.signer.presignURL(c.objectURL(key), expiry)
+}
+
+func (c *s3Client) get(u *url.URL) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	c.signer.signRequest(req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var errResp s3ErrorResponse
+		data, _ := ioutil.ReadAll(resp.Body)
+		if xml.Unmarshal(data, &errResp) == nil && errResp.Code != "" {
+			return nil, fmt.Errorf("s3 error getting %s: %s (%s)", u.Path, errResp.Code, errResp.Message)
+		}
+		return nil, fmt.Errorf("s3 error getting %s: %s", u.Path, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// s3Signer implements AWS Signature Version 4 for S3 requests.
+// A signer with no credentials leaves requests unsigned, for public buckets.
+type s3Signer struct {
+	accessKeyID     string
+	secretAccessKey string
+	region          string
+
+	// Injectable for testing.
+	now func() time.Time
+}
+
+func newS3Signer(accessKeyID, secretAccessKey, region string) *s3Signer {
+	if region == "" {
+		region = DefaultS3Region
+	}
+	return &s3Signer{
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		region:          region,
+		now:             time.Now,
+	}
+}
+
+func (s *s3Signer) hasCredentials() bool {
+	return s.accessKeyID != "" && s.secretAccessKey != ""
+}
+
+// signRequest adds an Authorization header to req.
+func (s *s3Signer) signRequest(req *http.Request) {
+	if !s.hasCredentials() {
+		return
+	}
+
+	now := s.now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format(s3DateTimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", s3EmptyPayloadHash)
+
+	headers := map[string]string{"host": requestHost(req)}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	canonicalHeaders, signedHeaders := s3CanonicalHeaders(headers)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3URIEncode(req.URL.Path, false),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		s3EmptyPayloadHash,
+	}, "\n")
+
+	signature := s.signature(now, canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.accessKeyID, s.scope(now), signedHeaders, signature))
+}
+
+// presignURL returns a copy of u with query-string authentication parameters added.
+func (s *s3Signer) presignURL(u *url.URL, expiry time.Duration) *url.URL {
+	if !s.hasCredentials() {
+		return u
+	}
+
+	now := s.now().UTC()
+	signed := *u
+
+	query := signed.Query()
+	query.Set("X-Amz-Algorithm", s3Algorithm)
+	query.Set("X-Amz-Credential", s.accessKeyID+"/"+s.scope(now))
+	query.Set("X-Amz-Date", now.Format(s3DateTimeFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expiry/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalHeaders, signedHeaders := s3CanonicalHeaders(map[string]string{"host": u.Host})
+	canonicalRequest := strings.Join([]string{
+		"GET",
+		s3URIEncode(u.Path, false),
+		s3CanonicalQuery(query),
+		canonicalHeaders,
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	query.Set("X-Amz-Signature", s.signature(now, canonicalRequest))
+	signed.RawQuery = s3CanonicalQuery(query)
+	return &signed
+}
+
+func (s *s3Signer) scope(t time.Time) string {
+	return strings.Join([]string{t.Format(s3DateFormat), s.region, s3Service, "aws4_request"}, "/")
+}
+
+func (s *s3Signer) signature(t time.Time, canonicalRequest string) string {
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		t.Format(s3DateTimeFormat),
+		s.scope(t),
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+s.secretAccessKey), t.Format(s3DateFormat))
+	key = hmacSha256(key, s.region)
+	key = hmacSha256(key, s3Service)
+	key = hmacSha256(key, "aws4_request")
+	return hex.EncodeToString(hmacSha256(key, stringToSign))
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func requestHost(req *http.Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+	return req.URL.Host
+}
+
+// s3CanonicalHeaders returns the canonical header block and the signed header list
+// for a map of lowercase header names to values.
+func s3CanonicalHeaders(headers map[string]string) (canonical, signed string) {
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		buf.WriteString(name)
+		buf.WriteByte(':')
+		buf.WriteString(headers[name])
+		buf.WriteByte('\n')
+	}
+	return buf.String(), strings.Join(names, ";")
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	var keys []string
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var params []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			params = append(params, s3URIEncode(key, true)+"="+s3URIEncode(value, true))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// s3URIEncode percent-encodes every byte except the unreserved characters,
+// as required by Signature Version 4.
+func s3URIEncode(s string, encodeSlash bool) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			buf.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,343 @@
+package memebot
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type S3MemepositoryConfig struct {
+	Endpoint string // Base URL of the S3-compatible service, e.g. https://s3.amazonaws.com.
+	Region   string // Defaults to DefaultS3Region.
+	Bucket   string
+	Prefix   string // Only objects with keys starting with this prefix are loaded.
+
+	// Leave empty to access a public bucket anonymously.
+	AccessKeyID     string
+	SecretAccessKey string
+
+	ImageExtensions StringSet // Extensions to recognize as image files.
+
+	// If true, objects whose content doesn't match their extension, or can't be decoded
+	// completely, are skipped. Otherwise they're loaded, and only logged.
+	ValidateImages bool
+
+	// How often to list the bucket for changes. If zero, the bucket is only listed again
+	// if loading it failed. Objects that haven't changed aren't downloaded again.
+	RefreshInterval time.Duration
+
+	// If non-zero, meme URLs will be presigned bucket URLs valid for this long,
+	// and Router is not used. Otherwise images are proxied through an ObjectServer.
+	PresignExpiry time.Duration
+	Router        *mux.Router // Root router to serve image IDs from.
+	Server        ObjectServerConfig
+
+	Client *http.Client // Defaults to DefaultHTTPClient.
+
+	// Defaults to DefaultLogger. Also used by the object server if Server.Log is nil.
+	Log *Logger
+}
+
+// S3Memepository is a Memepository that loads images stored in an S3-compatible bucket.
+// Object keys are parsed for keywords the same way as file names in a FileServingMemepository.
+// Every object is downloaded once at load time to generate its ID.
+type S3Memepository struct {
+	S3MemepositoryConfig
+
+	client *s3Client
+	server *ObjectServer
+
+	loadOnce sync.Once
+	stopOnce sync.Once
+	stop     chan struct{}
+
+	// Held while listing the bucket, so it's only listed once at a time.
+	reloadLock sync.Mutex
+	// How long to wait before listing the bucket again if it was never loaded.
+	retryDelay time.Duration
+
+	lock        sync.RWMutex
+	memes       *MemeIndex
+	memesById   map[string]*S3Meme
+	loadErr     error
+	attemptedAt time.Time
+}
+
+var _ ObjectRepository = &S3Memepository{}
+
+func NewS3Memepository(config S3MemepositoryConfig) (*S3Memepository, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("Bucket must be specified")
+	}
+	if config.PresignExpiry == 0 && config.Router == nil {
+		return nil, errors.New("Router must be specified if not presigning URLs")
+	}
+	if config.PresignExpiry != 0 && (config.AccessKeyID == "" || config.SecretAccessKey == "") {
+		return nil, errors.New("credentials must be specified to presign URLs")
+	}
+
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	config.ImageExtensions = config.ImageExtensions.Apply(strings.ToLower)
+	if config.Server.Log == nil {
+		config.Server.Log = config.Log
+	}
+
+	memepository := &S3Memepository{
+		S3MemepositoryConfig: config,
+		client: newS3Client(endpoint, config.Bucket,
+			newS3Signer(config.AccessKeyID, config.SecretAccessKey, config.Region), config.Client),
+		stop:       make(chan struct{}),
+		retryDelay: s3LoadRetryDelay,
+	}
+	if config.PresignExpiry == 0 {
+		memepository.server = CreateObjectServerWithConfig(config.Router, memepository, config.Server)
+	}
+
+	return memepository, nil
+}
+
+// s3LoadRetryDelay is how often Load lists the bucket again until it's loaded once.
+const s3LoadRetryDelay = 10 * time.Second
+
+// Load returns the most recently loaded memes. The first call loads the bucket and
+// starts refreshing it in the background. If the bucket has never been loaded, later
+// calls try again.
+// Once memes have been loaded, refresh errors are logged but not returned.
+func (m *S3Memepository) Load() (*MemeIndex, error) {
+	m.loadOnce.Do(func() {
+		m.Reload()
+		if m.RefreshInterval > 0 {
+			go m.refreshPeriodically()
+		}
+	})
+
+	m.lock.RLock()
+	memes, err, attemptedAt := m.memes, m.loadErr, m.attemptedAt
+	m.lock.RUnlock()
+	if memes == nil && time.Since(attemptedAt) >= m.retryDelay {
+		m.Reload()
+		m.lock.RLock()
+		memes, err = m.memes, m.loadErr
+		m.lock.RUnlock()
+	}
+
+	if memes != nil {
+		return memes, nil
+	}
+	return nil, err
+}
+
+// Stop stops refreshing the bucket in the background.
+func (m *S3Memepository) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+func (m *S3Memepository) refreshPeriodically() {
+	ticker := time.NewTicker(m.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.Reload()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *S3Memepository) FindObject(id string) (Object, bool) {
+	if _, err := m.Load(); err != nil {
+		return nil, false
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	meme, found := m.memesById[id]
+	return meme, found
+}
+
+// Reload lists the bucket again. If it fails, the memes loaded before are kept.
+func (m *S3Memepository) Reload() error {
+	m.reloadLock.Lock()
+	defer m.reloadLock.Unlock()
+
+	m.lock.RLock()
+	previous := m.memesById
+	m.lock.RUnlock()
+
+	memes, memesById, err := m.load(previous)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.attemptedAt = time.Now()
+	m.loadErr = err
+	if err != nil {
+		return err
+	}
+	m.memes = memes
+	m.memesById = memesById
+	return nil
+}
+
+// load reuses the memes in previous whose objects haven't changed.
+func (m *S3Memepository) load(previous map[string]*S3Meme) (memes *MemeIndex, memesById map[string]*S3Meme, err error) {
+	m.Log.Info("loading memes from s3", "bucket", m.Bucket, "prefix", m.Prefix)
+
+	objects, err := m.client.ListObjects(m.Prefix)
+	if err != nil {
+		m.Log.Error("error listing bucket", "bucket", m.Bucket, "err", err)
+		return nil, nil, err
+	}
+
+	unchanged := make(map[string]*S3Meme)
+	for _, meme := range previous {
+		unchanged[meme.key] = meme
+	}
+
+	memes = NewMemeIndex()
+	memesById = make(map[string]*S3Meme)
+
+	invalid := make(invalidImageCounts)
+	for _, object := range objects {
+		if m.isImageObject(object) {
+			meme, found := unchanged[object.Key]
+			if !found || !meme.lastModified.Equal(object.LastModified) || meme.size != object.Size {
+				meme, err = newS3Meme(object, m)
+				if err != nil {
+					m.Log.Warn("couldn't load meme", "key", object.Key, "err", err)
+					continue
+				}
+			}
+			if meme.invalid != nil {
+				invalid[meme.invalid]++
+				m.Log.Warn("invalid image", "key", object.Key, "err", meme.invalid, "detected", meme.info.ContentType)
+				if m.ValidateImages {
+					continue
+				}
+			}
+			memes.Add(meme)
+			memesById[meme.id] = meme
+		}
+	}
+
+	logInvalidImages(m.Log, invalid, m.ValidateImages)
+	m.Log.Info("loaded memes from s3", "bucket", m.Bucket, "count", memes.Len())
+	return memes, memesById, nil
+}
+
+func (m *S3Memepository) isImageObject(object s3ObjectInfo) bool {
+	if strings.HasSuffix(object.Key, "/") {
+		// Directory placeholder.
+		return false
+	}
+
+	extension := getNormalizedExtensionWithoutDot(object.Key)
+	return m.ImageExtensions.Contains(extension)
+}
+
+type S3Meme struct {
+	owner        *S3Memepository
+	id           string
+	key          string
+	lastModified time.Time
+	size         int64
+	keywords     []string
+
+	imageAnalysis
+}
+
+var (
+	_ Object           = &S3Meme{}
+	_ PerceptualHasher = &S3Meme{}
+	_ ImageDescriber   = &S3Meme{}
+	_ ContentTyper     = &S3Meme{}
+)
+
+func newS3Meme(object s3ObjectInfo, owner *S3Memepository) (*S3Meme, error) {
+	body, err := owner.client.GetObject(object.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := generateSha1Base64Hash(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	// Append the extension to the ID for content-type detection
+	extension := getNormalizedExtensionWithoutDot(object.Key)
+	id = id + "." + extension
+
+	return &S3Meme{
+		owner:         owner,
+		id:            id,
+		key:           object.Key,
+		lastModified:  object.LastModified,
+		size:          object.Size,
+		keywords:      parseKeywords(path.Base(object.Key)),
+		imageAnalysis: analyzeImage(data, extension, owner.Server.MediaTypes),
+	}, nil
+}
+
+func (m *S3Meme) URL() *url.URL {
+	if m.owner.server == nil {
+		return m.owner.client.PresignGetObject(m.key, m.owner.PresignExpiry)
+	}
+	return m.owner.server.URL(m.id)
+}
+
+// TransformedURL returns errTransformNotSupported if memes are linked to with presigned URLs.
+func (m *S3Meme) TransformedURL(params url.Values) (*url.URL, error) {
+	if m.owner.server == nil {
+		return nil, errTransformNotSupported
+	}
+	return m.owner.server.TransformedURL(m.id, params)
+}
+
+func (m *S3Meme) Keywords() []string {
+	return m.keywords
+}
+
+func (m *S3Meme) ContentHash() string {
+	return idContentHash(m.id)
+}
+
+// Open downloads the entire object into memory, since the object server needs to seek.
+func (m *S3Meme) Open() (ReadSeekerCloser, error) {
+	body, err := m.owner.client.GetObject(m.key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return nopCloser{bytes.NewReader(data)}, nil
+}
+
+func (m *S3Meme) LastModified() time.Time {
+	return m.lastModified
+}
+
+func (m *S3Meme) Size() int64 {
+	return m.size
+}
@@ -0,0 +1,242 @@
+package memebot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3Server serves a single bucket of in-memory objects using path-style addressing.
+type fakeS3Server struct {
+	*httptest.Server
+	bucket  string
+	objects map[string]string
+
+	// Number of keys to return per listing page.
+	pageSize int
+
+	// If true, every request fails.
+	down bool
+	// Number of objects downloaded.
+	downloads int
+}
+
+func newFakeS3Server(bucket string, objects map[string]string) *fakeS3Server {
+	s := &fakeS3Server{
+		bucket:   bucket,
+		objects:  objects,
+		pageSize: 1000,
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+func (s *fakeS3Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if s.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "<Error><Code>ServiceUnavailable</Code><Message>Please reduce your request rate.</Message></Error>")
+		return
+	}
+	if !strings.HasPrefix(req.Header.Get("Authorization"), s3Algorithm) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>")
+		return
+	}
+
+	bucketPath := "/" + s.bucket
+	if req.URL.Path == bucketPath {
+		s.list(w, req)
+		return
+	}
+
+	key := strings.TrimPrefix(req.URL.Path, bucketPath+"/")
+	if data, found := s.objects[key]; found {
+		s.downloads++
+		fmt.Fprint(w, data)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprint(w, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>")
+}
+
+func (s *fakeS3Server) list(w http.ResponseWriter, req *http.Request) {
+	prefix := req.URL.Query().Get("prefix")
+	start := req.URL.Query().Get("continuation-token")
+
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) && key > start {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	truncated := len(keys) > s.pageSize
+	if truncated {
+		keys = keys[:s.pageSize]
+	}
+
+	fmt.Fprint(w, "<ListBucketResult>")
+	for _, key := range keys {
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><LastModified>2015-11-01T12:00:00.000Z</LastModified><Size>%d</Size></Contents>",
+			key, len(s.objects[key]))
+	}
+	if truncated {
+		fmt.Fprintf(w, "<IsTruncated>true</IsTruncated><NextContinuationToken>%s</NextContinuationToken>", keys[len(keys)-1])
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
+
+func newTestS3Memepository(t *testing.T, server *fakeS3Server, presignExpiry time.Duration) *S3Memepository {
+	memepository, err := NewS3Memepository(S3MemepositoryConfig{
+		Endpoint:        server.URL,
+		Bucket:          server.bucket,
+		Prefix:          "memes/",
+		AccessKeyID:     testS3AccessKeyID,
+		SecretAccessKey: testS3SecretAccessKey,
+		ImageExtensions: MakeSet("jpg", "GIF"),
+		PresignExpiry:   presignExpiry,
+		Router:          mux.NewRouter(),
+	})
+	require.NoError(t, err)
+	return memepository
+}
+
+func TestS3MemepositoryLoad(t *testing.T) {
+	server := newFakeS3Server("bucket", map[string]string{
+		"memes/foo, bar.jpg": "foo",
+		"memes/baz.GIF":      "baz",
+		"memes/notes.txt":    "not an image",
+		"memes/subdir/":      "",
+		"other/qux.jpg":      "qux",
+	})
+	defer server.Close()
+	server.pageSize = 2
+
+	memes, err := newTestS3Memepository(t, server, 0).Load()
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, memes.Len())
+	assert.Equal(t, []string{"bar", "baz", "foo"}, memes.Keywords())
+	assert.Len(t, memes.FindByKeyword("foo"), 1)
+}
+
+func TestS3MemepositoryServesObjects(t *testing.T) {
+	server := newFakeS3Server("bucket", map[string]string{
+		"memes/foo.jpg": "foo data",
+	})
+	defer server.Close()
+
+	memepository := newTestS3Memepository(t, server, 0)
+	memes, err := memepository.Load()
+	require.NoError(t, err)
+	require.Equal(t, 1, memes.Len())
+
+	meme := memes.FindByKeyword("foo")[0].(*S3Meme)
+	assert.Equal(t, "/"+meme.id, meme.URL().Path)
+	assert.Equal(t, int64(len("foo data")), meme.Size())
+	assert.Equal(t, time.Date(2015, 11, 1, 12, 0, 0, 0, time.UTC), meme.LastModified())
+
+	object, found := memepository.FindObject(meme.id)
+	require.True(t, found)
+	data, err := object.Open()
+	require.NoError(t, err)
+	defer data.Close()
+	contents, err := ioutil.ReadAll(data)
+	require.NoError(t, err)
+	assert.Equal(t, "foo data", string(contents))
+}
+
+func TestS3MemepositoryPresignsURLs(t *testing.T) {
+	server := newFakeS3Server("bucket", map[string]string{
+		"memes/foo.jpg": "foo data",
+	})
+	defer server.Close()
+
+	memes, err := newTestS3Memepository(t, server, time.Hour).Load()
+	require.NoError(t, err)
+	require.Equal(t, 1, memes.Len())
+
+	url := memes.FindByKeyword("foo")[0].URL()
+	assert.Equal(t, "/bucket/memes/foo.jpg", url.Path)
+	assert.Equal(t, "3600", url.Query().Get("X-Amz-Expires"))
+	assert.NotEmpty(t, url.Query().Get("X-Amz-Signature"))
+}
+
+func TestS3MemepositoryLoadError(t *testing.T) {
+	server := newFakeS3Server("bucket", nil)
+	defer server.Close()
+
+	memepository, err := NewS3Memepository(S3MemepositoryConfig{
+		Endpoint: server.URL,
+		Bucket:   "bucket",
+		Router:   mux.NewRouter(),
+	})
+	require.NoError(t, err)
+
+	_, err = memepository.Load()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "AccessDenied")
+}
+
+func TestS3MemepositoryRetriesAfterLoadError(t *testing.T) {
+	server := newFakeS3Server("bucket", map[string]string{
+		"memes/foo.jpg": "foo data",
+	})
+	defer server.Close()
+	server.down = true
+
+	memepository := newTestS3Memepository(t, server, 0)
+	_, err := memepository.Load()
+	assert.Error(t, err)
+
+	// Not retried until the delay has passed.
+	server.down = false
+	_, err = memepository.Load()
+	assert.Error(t, err)
+
+	memepository.retryDelay = 0
+	memes, err := memepository.Load()
+	require.NoError(t, err)
+	assert.Equal(t, 1, memes.Len())
+}
+
+func TestS3MemepositoryReload(t *testing.T) {
+	server := newFakeS3Server("bucket", map[string]string{
+		"memes/foo.jpg": "foo data",
+	})
+	defer server.Close()
+
+	memepository := newTestS3Memepository(t, server, 0)
+	memes, err := memepository.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, memes.Keywords())
+	foo := memes.FindByKeyword("foo")[0]
+
+	// Unchanged objects aren't downloaded again.
+	server.objects["memes/bar.jpg"] = "bar data"
+	require.NoError(t, memepository.Reload())
+	memes, err = memepository.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bar", "foo"}, memes.Keywords())
+	assert.True(t, foo == memes.FindByKeyword("foo")[0])
+	assert.Equal(t, 2, server.downloads)
+
+	// The memes loaded before are kept if the bucket can't be listed.
+	server.down = true
+	assert.Error(t, memepository.Reload())
+	stale, err := memepository.Load()
+	require.NoError(t, err)
+	assert.True(t, memes == stale)
+	_, found := memepository.FindObject(memes.FindByKeyword("bar")[0].(*S3Meme).id)
+	assert.True(t, found)
+}
@@ -1,20 +1,32 @@
 package memebot
 
-import "math/rand"
+import (
+	"math/rand"
+	"strings"
+)
 
 type MemepositorySearcher struct {
 	Memepository
 }
 
-var _ MemeSearcher = &MemepositorySearcher{}
+var (
+	_ OptionsSearcher = &MemepositorySearcher{}
+	_ MultiSearcher   = &MemepositorySearcher{}
+)
 
 func (s *MemepositorySearcher) FindMeme(keyword string) (Meme, error) {
+	return s.FindMemeWithOptions(keyword, SearchOptions{})
+}
+
+// FindMemeWithOptions returns a random meme for keyword. Memes flagged as NSFW are only
+// found if options allow them.
+func (s *MemepositorySearcher) FindMemeWithOptions(keyword string, options SearchOptions) (Meme, error) {
 	memes, err := s.Load()
 	if err != nil {
 		return nil, err
 	}
 
-	results := memes.FindByKeyword(keyword)
+	results := filterNSFW(memes.FindByKeyword(keyword), options)
 	if len(results) == 0 {
 		return nil, ErrNoMemeFound
 	}
@@ -22,3 +34,30 @@ func (s *MemepositorySearcher) FindMeme(keyword string) (Meme, error) {
 	index := rand.Intn(len(results))
 	return results[index], nil
 }
+
+// FindMemes returns up to count distinct memes for keyword, in random order.
+// If no memes match the keyword exactly, and it looks plural, the singular is tried,
+// since requests for multiple memes are usually plural (e.g. "4 cats").
+func (s *MemepositorySearcher) FindMemes(keyword string, count int, options SearchOptions) ([]Meme, error) {
+	memes, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	results := filterNSFW(memes.FindByKeyword(keyword), options)
+	if len(results) == 0 && len(keyword) > 1 && strings.HasSuffix(strings.ToLower(keyword), "s") {
+		results = filterNSFW(memes.FindByKeyword(keyword[:len(keyword)-1]), options)
+	}
+	if len(results) == 0 {
+		return nil, ErrNoMemeFound
+	}
+
+	found := make([]Meme, 0, minInt(count, len(results)))
+	for _, i := range rand.Perm(len(results)) {
+		if len(found) == count {
+			break
+		}
+		found = append(found, results[i])
+	}
+	return found, nil
+}
@@ -48,3 +48,25 @@ func TestMemepositorySearcherPicksRandomMeme(t *testing.T) {
 	assert.True(t, fooCount > 0)
 	assert.True(t, barCount > 0)
 }
+
+func TestMemepositorySearcherFindMemes(t *testing.T) {
+	mp := &MockMemepository{NewTestMemeIndex(
+		NewMockMeme("http://1.com", "cat"),
+		NewMockMeme("http://2.com", "cat"),
+		NewMockMeme("http://3.com", "cat"),
+	)}
+	searcher := &MemepositorySearcher{mp}
+
+	memes, err := searcher.FindMemes("cat", 2, SearchOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, memes, 2)
+	assert.NotEqual(t, memes[0].URL().Host, memes[1].URL().Host)
+
+	// Plural keyword.
+	memes, err = searcher.FindMemes("cats", 4, SearchOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, memes, 3)
+
+	_, err = searcher.FindMemes("dogs", 4, SearchOptions{})
+	assert.Equal(t, ErrNoMemeFound, err)
+}
@@ -0,0 +1,564 @@
+package memebot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultStatsWindow is how far back usage statistics go if no window is given.
+const DefaultStatsWindow = 7 * 24 * time.Hour
+
+// MaxStatsWindow is the longest window usage statistics can be summarized over.
+const MaxStatsWindow = 10 * 365 * 24 * time.Hour
+
+// DefaultStatsTopCount is the number of keywords and memes listed in each part of
+// usage statistics.
+const DefaultStatsTopCount = 5
+
+// UsageRecord is a single request for a meme.
+type UsageRecord struct {
+	Time    time.Time `json:"time"`
+	Channel string    `json:"channel,omitempty"`
+	Keyword string    `json:"keyword"`
+
+	// The memes posted, before captions or modifiers were applied. Empty if no meme was
+	// found, or the search failed.
+	Memes []UsageMeme `json:"memes,omitempty"`
+
+	// If true, the search failed, as opposed to not finding anything.
+	Error bool `json:"error,omitempty"`
+}
+
+// UsageMeme identifies a meme in a UsageRecord.
+type UsageMeme struct {
+	// Same as in the API, so copies of the same image are counted together.
+	ID string `json:"id"`
+
+	// Only set for memes that aren't identified by their content, e.g. from external
+	// searches. URLs of other memes may be signed and expire, so they're looked up by ID
+	// when stats are shown.
+	URL string `json:"url,omitempty"`
+}
+
+// Miss returns true if no meme was found for the request.
+func (r UsageRecord) Miss() bool {
+	return len(r.Memes) == 0 && !r.Error
+}
+
+// StatsStore stores UsageRecords.
+type StatsStore interface {
+	Record(record UsageRecord) error
+
+	// Records returns the records made at or after since, in the order they were recorded.
+	Records(since time.Time) ([]UsageRecord, error)
+}
+
+// DefaultStatsMaxRecords is the number of records a MemoryStatsStore keeps by default.
+const DefaultStatsMaxRecords = 100000
+
+// MemoryStatsStore is a StatsStore that only keeps records until the process exits.
+// Once it's full, the oldest records are dropped to make room for new ones.
+type MemoryStatsStore struct {
+	MaxRecords int // Defaults to DefaultStatsMaxRecords.
+
+	lock sync.Mutex
+	// Ring buffer of records. Once it's full, the oldest is at start.
+	records []UsageRecord
+	start   int
+	// Time of the newest record that was dropped, if any.
+	droppedUntil time.Time
+}
+
+func NewMemoryStatsStore() *MemoryStatsStore {
+	return &MemoryStatsStore{}
+}
+
+func (s *MemoryStatsStore) Record(record UsageRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	maxRecords := s.MaxRecords
+	if maxRecords <= 0 {
+		maxRecords = DefaultStatsMaxRecords
+	}
+	if len(s.records) < maxRecords {
+		s.records = append(s.records, record)
+		return nil
+	}
+
+	s.droppedUntil = s.records[s.start].Time
+	s.records[s.start] = record
+	s.start = (s.start + 1) % len(s.records)
+	return nil
+}
+
+// Records only returns the records that haven't been dropped.
+func (s *MemoryStatsStore) Records(since time.Time) ([]UsageRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var found []UsageRecord
+	for i := range s.records {
+		record := s.records[(s.start+i)%len(s.records)]
+		if !record.Time.Before(since) {
+			found = append(found, record)
+		}
+	}
+	return found, nil
+}
+
+// hasAllRecords returns true if no records made at or after since have been dropped.
+func (s *MemoryStatsStore) hasAllRecords(since time.Time) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.droppedUntil.IsZero() || since.After(s.droppedUntil)
+}
+
+// FileStatsStore is a StatsStore that appends records to a file, one JSON object per line.
+// The latest records are also kept in memory, and the file is only read again to get
+// older ones. Lines that can't be decoded, e.g. if the process was killed in the middle
+// of writing one, are skipped.
+type FileStatsStore struct {
+	Path string
+	Log  *Logger // Invalid lines are logged here. Defaults to DefaultLogger.
+
+	lock sync.Mutex
+	file *os.File
+	// Loaded from the file the first time records are read.
+	tail *MemoryStatsStore
+
+	// Held while the tail is loaded, so it's only loaded once. The file is read without
+	// holding lock, so requests can still be recorded.
+	loadLock sync.Mutex
+}
+
+// OpenFileStatsStore opens the store at path, creating the file if it doesn't exist.
+func OpenFileStatsStore(path string) (*FileStatsStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := terminateLastLine(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &FileStatsStore{
+		Path: path,
+		file: file,
+	}, nil
+}
+
+// terminateLastLine appends a newline to file if it doesn't end with one, so records
+// aren't appended to a truncated line.
+func terminateLastLine(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] != '\n' {
+		_, err = file.Write([]byte{'\n'})
+	}
+	return err
+}
+
+func (s *FileStatsStore) Record(record UsageRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err = s.file.Write(data); err != nil {
+		return err
+	}
+	if s.tail != nil {
+		s.tail.Record(record)
+	}
+	return nil
+}
+
+func (s *FileStatsStore) Records(since time.Time) ([]UsageRecord, error) {
+	tail, err := s.loadTail()
+	if err != nil {
+		return nil, err
+	}
+	if tail.hasAllRecords(since) {
+		return tail.Records(since)
+	}
+
+	file, size, err := s.openForReading()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []UsageRecord
+	err = scanUsageRecords(s.Log, io.LimitReader(file, size), func(record UsageRecord) {
+		if !record.Time.Before(since) {
+			records = append(records, record)
+		}
+	})
+	return records, err
+}
+
+// loadTail returns the latest records, reading them from the file the first time.
+func (s *FileStatsStore) loadTail() (*MemoryStatsStore, error) {
+	s.loadLock.Lock()
+	defer s.loadLock.Unlock()
+
+	s.lock.Lock()
+	tail := s.tail
+	s.lock.Unlock()
+	if tail != nil {
+		return tail, nil
+	}
+
+	file, size, err := s.openForReading()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tail = NewMemoryStatsStore()
+	if err := scanUsageRecords(s.Log, io.LimitReader(file, size), recordTo(tail)); err != nil {
+		return nil, err
+	}
+
+	// Catch up on the records made while the file was read, and keep the tail up to date
+	// from now on.
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := scanUsageRecords(s.Log, file, recordTo(tail)); err != nil {
+		return nil, err
+	}
+	s.tail = tail
+	return tail, nil
+}
+
+// openForReading opens the file for reading, and returns its size. Lines before size are
+// complete, while records appended after it may still be being written.
+func (s *FileStatsStore) openForReading() (file *os.File, size int64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	info, err := s.file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	file, err = os.Open(s.Path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+func recordTo(store StatsStore) func(UsageRecord) {
+	return func(record UsageRecord) {
+		store.Record(record)
+	}
+}
+
+func (s *FileStatsStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
+
+// scanUsageRecords calls handle with each record read from r, one at a time, so the records
+// don't all have to be kept in memory.
+func scanUsageRecords(log *Logger, r io.Reader, handle func(UsageRecord)) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var record UsageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.Warn("skipping invalid usage record", "line", line, "err", err)
+			continue
+		}
+		handle(record)
+	}
+	return scanner.Err()
+}
+
+// UsageStats summarizes the UsageRecords over a window of time.
+type UsageStats struct {
+	Since time.Time
+	Until time.Time
+
+	Requests int
+	Misses   int
+
+	// Most requested keywords, found or not.
+	TopKeywords []KeywordCount
+
+	// Most posted memes.
+	TopMemes []MemeCount
+
+	// Most requested keywords that no meme was found for.
+	TopMisses []KeywordCount
+}
+
+type KeywordCount struct {
+	Keyword string
+	Count   int
+}
+
+type MemeCount struct {
+	UsageMeme
+
+	// The keyword the meme was last posted for.
+	Keyword string
+
+	Count int
+}
+
+// LoadUsageStats summarizes the records in store over the window before until. If channel
+// isn't empty, only the requests made in it are counted.
+func LoadUsageStats(store StatsStore, channel string, window time.Duration, until time.Time, top int) (UsageStats, error) {
+	since := until.Add(-window)
+	records, err := store.Records(since)
+	if err != nil {
+		return UsageStats{}, err
+	}
+	if channel != "" {
+		var inChannel []UsageRecord
+		for _, record := range records {
+			if record.Channel == channel {
+				inChannel = append(inChannel, record)
+			}
+		}
+		records = inChannel
+	}
+	return SummarizeUsage(records, since, until, top), nil
+}
+
+// SummarizeUsage summarizes the records made in [since, until), listing up to top
+// keywords and memes in each part. Keywords are counted case-insensitively.
+func SummarizeUsage(records []UsageRecord, since, until time.Time, top int) UsageStats {
+	stats := UsageStats{
+		Since: since,
+		Until: until,
+	}
+
+	keywords := make(map[string]int)
+	misses := make(map[string]int)
+	memes := make(map[string]*MemeCount)
+	for _, record := range records {
+		if record.Time.Before(since) || !record.Time.Before(until) {
+			continue
+		}
+
+		keyword := strings.ToLower(record.Keyword)
+		stats.Requests++
+		keywords[keyword]++
+		if record.Miss() {
+			stats.Misses++
+			misses[keyword]++
+		}
+
+		for _, meme := range record.Memes {
+			count, found := memes[meme.ID]
+			if !found {
+				count = &MemeCount{}
+				memes[meme.ID] = count
+			}
+			count.UsageMeme = meme
+			count.Keyword = keyword
+			count.Count++
+		}
+	}
+
+	stats.TopKeywords = topKeywordCounts(keywords, top)
+	stats.TopMisses = topKeywordCounts(misses, top)
+	for _, count := range memes {
+		stats.TopMemes = append(stats.TopMemes, *count)
+	}
+	sort.Sort(memeCountsByCount(stats.TopMemes))
+	if len(stats.TopMemes) > top {
+		stats.TopMemes = stats.TopMemes[:top]
+	}
+	return stats
+}
+
+func topKeywordCounts(counts map[string]int, top int) []KeywordCount {
+	var sorted []KeywordCount
+	for keyword, count := range counts {
+		sorted = append(sorted, KeywordCount{keyword, count})
+	}
+	sort.Sort(keywordCountsByCount(sorted))
+	if len(sorted) > top {
+		sorted = sorted[:top]
+	}
+	return sorted
+}
+
+// keywordCountsByCount sorts by decreasing count, then keyword.
+type keywordCountsByCount []KeywordCount
+
+func (s keywordCountsByCount) Len() int      { return len(s) }
+func (s keywordCountsByCount) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s keywordCountsByCount) Less(i, j int) bool {
+	if s[i].Count != s[j].Count {
+		return s[i].Count > s[j].Count
+	}
+	return s[i].Keyword < s[j].Keyword
+}
+
+// memeCountsByCount sorts by decreasing count, then ID.
+type memeCountsByCount []MemeCount
+
+func (s memeCountsByCount) Len() int      { return len(s) }
+func (s memeCountsByCount) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s memeCountsByCount) Less(i, j int) bool {
+	if s[i].Count != s[j].Count {
+		return s[i].Count > s[j].Count
+	}
+	return s[i].ID < s[j].ID
+}
+
+// String formats the stats as plain text. Memes without URLs are listed by ID.
+func (s UsageStats) String() string {
+	return s.format(func(meme MemeCount) string {
+		if meme.URL == "" {
+			return fmt.Sprintf("%s (%s)", meme.ID, meme.Keyword)
+		}
+		return fmt.Sprintf("%s (%s)", meme.URL, meme.Keyword)
+	})
+}
+
+// slackString formats the stats for a Slack message, with memes linked by keyword so
+// they aren't all unfurled.
+func (s UsageStats) slackString() string {
+	return s.format(func(meme MemeCount) string {
+		if meme.URL == "" {
+			return meme.Keyword
+		}
+		return fmt.Sprintf("<%s|%s>", meme.URL, meme.Keyword)
+	})
+}
+
+// linkMemes sets the URLs of the top memes that are in memes, so links to memes served
+// by the bot are signed when they're shown.
+func (s *UsageStats) linkMemes(memes *MemeIndex) {
+	ids := make(map[string]*MemeCount)
+	for i := range s.TopMemes {
+		ids[s.TopMemes[i].ID] = &s.TopMemes[i]
+	}
+	for _, meme := range memes.Memes() {
+		if count, found := ids[apiMemeID(meme)]; found {
+			count.URL = meme.URL().String()
+		}
+	}
+}
+
+func (s UsageStats) format(formatMeme func(MemeCount) string) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Usage in the last %s: %d requests, %d without a meme.", formatStatsWindow(s.Until.Sub(s.Since)),
+		s.Requests, s.Misses)
+	if s.Requests == 0 {
+		return buf.String()
+	}
+
+	fmt.Fprint(&buf, "\nTop keywords: ")
+	writeKeywordCounts(&buf, s.TopKeywords)
+
+	if len(s.TopMemes) > 0 {
+		fmt.Fprint(&buf, "\nMost posted memes: ")
+		for i, meme := range s.TopMemes {
+			if i > 0 {
+				fmt.Fprint(&buf, ", ")
+			}
+			fmt.Fprintf(&buf, "%s ×%d", formatMeme(meme), meme.Count)
+		}
+	}
+
+	if len(s.TopMisses) > 0 {
+		fmt.Fprint(&buf, "\nMost requested missing keywords: ")
+		writeKeywordCounts(&buf, s.TopMisses)
+	}
+	return buf.String()
+}
+
+func writeKeywordCounts(w io.Writer, counts []KeywordCount) {
+	for i, count := range counts {
+		if i > 0 {
+			fmt.Fprint(w, ", ")
+		}
+		fmt.Fprintf(w, "%s ×%d", count.Keyword, count.Count)
+	}
+}
+
+// formatStatsWindow formats whole days like "7 days", and anything else like a Duration.
+func formatStatsWindow(window time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case window == day:
+		return "day"
+	case window > 0 && window%day == 0:
+		return fmt.Sprintf("%d days", window/day)
+	}
+	return window.String()
+}
+
+// ParseStatsWindow parses a duration like "36h", "7d" or "2w", up to MaxStatsWindow.
+func ParseStatsWindow(s string) (time.Duration, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit != 0 {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		// Checked before multiplying, so it can't overflow.
+		if err != nil || n <= 0 || n > int(MaxStatsWindow/unit) {
+			return 0, fmt.Errorf("invalid window: %q", s)
+		}
+		return time.Duration(n) * unit, nil
+	}
+
+	window, err := time.ParseDuration(s)
+	if err != nil || window <= 0 || window > MaxStatsWindow {
+		return 0, fmt.Errorf("invalid window: %q", s)
+	}
+	return window, nil
+}
+
+// newUsageRecord describes a request for keyword that found memes, or failed with err.
+func newUsageRecord(channel, keyword string, memes []Meme, err error) UsageRecord {
+	record := UsageRecord{
+		Time:    time.Now(),
+		Channel: channel,
+		Keyword: keyword,
+		Error:   err != nil && err != ErrNoMemeFound,
+	}
+	for _, meme := range memes {
+		usage := UsageMeme{ID: apiMemeID(meme)}
+		if memeContentHash(meme) == "" {
+			usage.URL = meme.URL().String()
+		}
+		record.Memes = append(record.Memes, usage)
+	}
+	return record
+}
@@ -0,0 +1,236 @@
+package memebot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testStatsTime = time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestUsageRecord(age time.Duration, keyword string, memeIds ...string) UsageRecord {
+	record := UsageRecord{
+		Time:    testStatsTime.Add(-age),
+		Channel: "C1",
+		Keyword: keyword,
+	}
+	for _, id := range memeIds {
+		record.Memes = append(record.Memes, UsageMeme{ID: id, URL: "http://example.com/" + id + ".jpg"})
+	}
+	return record
+}
+
+func TestMemoryStatsStore(t *testing.T) {
+	store := NewMemoryStatsStore()
+	old := newTestUsageRecord(2*time.Hour, "cat", "a")
+	recent := newTestUsageRecord(time.Minute, "dog")
+	require.NoError(t, store.Record(old))
+	require.NoError(t, store.Record(recent))
+
+	records, err := store.Records(testStatsTime.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []UsageRecord{recent}, records)
+}
+
+func TestMemoryStatsStoreDropsOldestRecords(t *testing.T) {
+	store := &MemoryStatsStore{MaxRecords: 2}
+	var records []UsageRecord
+	for i, keyword := range []string{"a", "b", "c", "d", "e"} {
+		record := newTestUsageRecord(time.Duration(5-i)*time.Minute, keyword)
+		records = append(records, record)
+		require.NoError(t, store.Record(record))
+	}
+
+	found, err := store.Records(time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, records[3:], found)
+	assert.False(t, store.hasAllRecords(records[2].Time))
+	assert.True(t, store.hasAllRecords(records[3].Time))
+}
+
+func TestFileStatsStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memebot-stats")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stats.jsonl")
+
+	store, err := OpenFileStatsStore(path)
+	require.NoError(t, err)
+	old := newTestUsageRecord(2*time.Hour, "cat", "a")
+	recent := newTestUsageRecord(time.Minute, "dog")
+	require.NoError(t, store.Record(old))
+	require.NoError(t, store.Record(recent))
+	require.NoError(t, store.Close())
+
+	// Records are appended after truncated lines when the store is reopened, and truncated
+	// lines are skipped.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"time": "2016-03-01T`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	store, err = OpenFileStatsStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+	failed := newTestUsageRecord(0, "fish")
+	failed.Error = true
+	require.NoError(t, store.Record(failed))
+
+	records, err := store.Records(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, "cat", records[0].Keyword)
+	assert.Equal(t, []UsageMeme{{ID: "a", URL: "http://example.com/a.jpg"}}, records[0].Memes)
+	assert.True(t, old.Time.Equal(records[0].Time))
+	assert.Equal(t, "dog", records[1].Keyword)
+	assert.True(t, records[2].Error)
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 4)
+	assert.Contains(t, lines[3], `"error":true`)
+
+	records, err = store.Records(testStatsTime.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "dog", records[0].Keyword)
+	assert.Equal(t, "fish", records[1].Keyword)
+}
+
+func TestFileStatsStoreKeepsLatestRecordsInMemory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memebot-stats")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stats.jsonl")
+
+	store, err := OpenFileStatsStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+	require.NoError(t, store.Record(newTestUsageRecord(2*time.Hour, "cat")))
+	records, err := store.Records(time.Time{})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+
+	store.tail.MaxRecords = 1
+	require.NoError(t, store.Record(newTestUsageRecord(time.Hour, "dog")))
+	require.NoError(t, store.Record(newTestUsageRecord(time.Minute, "fish")))
+	require.NoError(t, ioutil.WriteFile(path, nil, 0600))
+
+	// Records that are still in memory aren't read from the file.
+	records, err = store.Records(testStatsTime.Add(-30 * time.Minute))
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "fish", records[0].Keyword)
+
+	// Older records are.
+	records, err = store.Records(time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestFileStatsStoreRecordsWhileLoading(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memebot-stats")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stats.jsonl")
+
+	store, err := OpenFileStatsStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, store.Record(newTestUsageRecord(time.Hour, "cat")))
+	}
+
+	loaded := make(chan error)
+	go func() {
+		_, err := store.Records(time.Time{})
+		loaded <- err
+	}()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, store.Record(newTestUsageRecord(time.Minute, "dog")))
+	}
+	require.NoError(t, <-loaded)
+
+	// Records made while the file was being read aren't lost or duplicated.
+	records, err := store.Records(time.Time{})
+	require.NoError(t, err)
+	assert.Len(t, records, 1100)
+	records, err = store.Records(testStatsTime.Add(-30 * time.Minute))
+	require.NoError(t, err)
+	assert.Len(t, records, 100)
+}
+
+func TestSummarizeUsage(t *testing.T) {
+	failed := newTestUsageRecord(time.Minute, "fish")
+	failed.Error = true
+	records := []UsageRecord{
+		newTestUsageRecord(48*time.Hour, "old", "z"),
+		newTestUsageRecord(time.Hour, "cat", "a"),
+		newTestUsageRecord(time.Hour, "Cat", "b"),
+		newTestUsageRecord(time.Hour, "cat", "a"),
+		newTestUsageRecord(time.Hour, "cats", "a", "b", "c"),
+		newTestUsageRecord(time.Hour, "unicorn"),
+		newTestUsageRecord(time.Hour, "unicorn"),
+		newTestUsageRecord(time.Hour, "dragon"),
+		failed,
+	}
+
+	stats := SummarizeUsage(records, testStatsTime.Add(-24*time.Hour), testStatsTime, 2)
+	assert.Equal(t, 8, stats.Requests)
+	assert.Equal(t, 3, stats.Misses)
+	assert.Equal(t, []KeywordCount{{"cat", 3}, {"unicorn", 2}}, stats.TopKeywords)
+	assert.Equal(t, []KeywordCount{{"unicorn", 2}, {"dragon", 1}}, stats.TopMisses)
+	require.Len(t, stats.TopMemes, 2)
+	assert.Equal(t, "a", stats.TopMemes[0].ID)
+	assert.Equal(t, 3, stats.TopMemes[0].Count)
+	assert.Equal(t, "cats", stats.TopMemes[0].Keyword)
+	assert.Equal(t, "b", stats.TopMemes[1].ID)
+	assert.Equal(t, 2, stats.TopMemes[1].Count)
+}
+
+func TestUsageStatsString(t *testing.T) {
+	stats := SummarizeUsage([]UsageRecord{
+		newTestUsageRecord(time.Hour, "cat", "a"),
+		newTestUsageRecord(time.Hour, "unicorn"),
+	}, testStatsTime.Add(-7*24*time.Hour), testStatsTime, DefaultStatsTopCount)
+
+	assert.Equal(t, `Usage in the last 7 days: 2 requests, 1 without a meme.
+Top keywords: cat ×1, unicorn ×1
+Most posted memes: http://example.com/a.jpg (cat) ×1
+Most requested missing keywords: unicorn ×1`, stats.String())
+	assert.Contains(t, stats.slackString(), "<http://example.com/a.jpg|cat> ×1")
+
+	// Memes served by the bot are only recorded by ID.
+	stats.TopMemes[0].URL = ""
+	assert.Contains(t, stats.String(), "Most posted memes: a (cat) ×1")
+	assert.Contains(t, stats.slackString(), "Most posted memes: cat ×1")
+
+	stats = SummarizeUsage(nil, testStatsTime.Add(-time.Hour), testStatsTime, DefaultStatsTopCount)
+	assert.Equal(t, "Usage in the last 1h0m0s: 0 requests, 0 without a meme.", stats.String())
+}
+
+func TestParseStatsWindow(t *testing.T) {
+	for s, expected := range map[string]time.Duration{
+		"36h": 36 * time.Hour,
+		"7d":  7 * 24 * time.Hour,
+		"2W":  14 * 24 * time.Hour,
+		"90m": 90 * time.Minute,
+	} {
+		window, err := ParseStatsWindow(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, window, s)
+	}
+
+	for _, s := range []string{"", "d", "-1d", "0h", "1.5d", "week", "3651d", "9999999999999w", "87601h"} {
+		_, err := ParseStatsWindow(s)
+		assert.Error(t, err, s)
+	}
+}
@@ -0,0 +1,144 @@
+package memebot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultSignedURLExpiry = 7 * 24 * time.Hour
+
+	signatureExpiresParam = "expires"
+	signatureKeyIdParam   = "kid"
+	signatureParam        = "sig"
+)
+
+var (
+	ErrURLNotSigned     = errors.New("url not signed")
+	ErrURLExpired       = errors.New("url expired")
+	ErrInvalidSignature = errors.New("invalid url signature")
+)
+
+// SigningKey is a secret used to sign object URLs. The ID is included in signed URLs
+// so the right key can be used to verify them after keys are rotated.
+type SigningKey struct {
+	ID     string
+	Secret []byte
+}
+
+// ParseSigningKeys parses a comma-separated list of id:secret pairs.
+func ParseSigningKeys(keys string) (parsed []SigningKey, err error) {
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+
+		parts := strings.SplitN(key, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.New("signing keys must be formatted as id:secret")
+		}
+		parsed = append(parsed, SigningKey{parts[0], []byte(parts[1])})
+	}
+	return
+}
+
+// urlSigner signs object IDs and their query parameters, e.g. transforms, with an expiry
+// time using HMAC-SHA256.
+type urlSigner struct {
+	// The first key is used to sign, all keys are accepted.
+	keys   []SigningKey
+	expiry time.Duration
+	now    func() time.Time
+}
+
+// Sign returns params with the parameters that authorize access to id with exactly
+// those params added.
+// URLs signed within the same half of the expiry period expire at the same time, so the
+// same object gets the same URL for a while. They're valid for at least half the period.
+func (s *urlSigner) Sign(id string, params url.Values) url.Values {
+	key := s.keys[0]
+
+	signed := make(url.Values)
+	for name, values := range params {
+		signed[name] = values
+	}
+	signed.Set(signatureExpiresParam, strconv.FormatInt(s.expires().Unix(), 10))
+	signed.Set(signatureKeyIdParam, key.ID)
+	signed.Set(signatureParam, s.signature(key, id, signed))
+	return signed
+}
+
+func (s *urlSigner) expires() time.Time {
+	period := int64(s.expiry / 2 / time.Second)
+	now := s.now().Unix()
+	if period > 0 {
+		now -= now % period
+	}
+	return time.Unix(now, 0).Add(s.expiry)
+}
+
+// Verify checks the signature parameters in query for id, and that no other parameters
+// were added to or changed in query since it was signed.
+func (s *urlSigner) Verify(id string, query url.Values) error {
+	signature := query.Get(signatureParam)
+	if query.Get(signatureExpiresParam) == "" || signature == "" {
+		return ErrURLNotSigned
+	}
+
+	key, found := s.findKey(query.Get(signatureKeyIdParam))
+	if !found {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signature(key, id, query))) {
+		return ErrInvalidSignature
+	}
+
+	expires, err := s.Expires(query)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if s.now().After(expires) {
+		return ErrURLExpired
+	}
+	return nil
+}
+
+// Expires returns the expiry time of a signed query.
+func (s *urlSigner) Expires(query url.Values) (time.Time, error) {
+	expiresUnix, err := strconv.ParseInt(query.Get(signatureExpiresParam), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(expiresUnix, 0), nil
+}
+
+func (s *urlSigner) findKey(id string) (SigningKey, bool) {
+	for _, key := range s.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return SigningKey{}, false
+}
+
+// signature signs id and every parameter in query except the signature itself.
+// Parameters are sorted by Encode, so their order doesn't matter.
+func (s *urlSigner) signature(key SigningKey, id string, query url.Values) string {
+	signed := make(url.Values)
+	for name, values := range query {
+		if name != signatureParam {
+			signed[name] = values
+		}
+	}
+
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte(id + "\n" + signed.Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,73 @@
+package memebot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestURLSigner(keys ...SigningKey) (signer *urlSigner, now *time.Time) {
+	now = new(time.Time)
+	*now = time.Date(2015, 11, 1, 12, 0, 0, 0, time.UTC)
+	signer = &urlSigner{
+		keys:   keys,
+		expiry: time.Hour,
+		now: func() time.Time {
+			return *now
+		},
+	}
+	return
+}
+
+func TestURLSignerSignAndVerify(t *testing.T) {
+	signer, now := newTestURLSigner(SigningKey{"new", []byte("secret")})
+
+	query := signer.Sign("foo.jpg", nil)
+	assert.Equal(t, "new", query.Get("kid"))
+	assert.Equal(t, "1446382800", query.Get("expires"))
+	assert.NoError(t, signer.Verify("foo.jpg", query))
+
+	assert.Equal(t, ErrInvalidSignature, signer.Verify("bar.jpg", query))
+
+	*now = now.Add(2 * time.Hour)
+	assert.Equal(t, ErrURLExpired, signer.Verify("foo.jpg", query))
+}
+
+func TestURLSignerRejectsTampering(t *testing.T) {
+	signer, _ := newTestURLSigner(SigningKey{"key", []byte("secret")})
+
+	query := signer.Sign("foo.jpg", nil)
+	query.Set("expires", "99999999999")
+	assert.Equal(t, ErrInvalidSignature, signer.Verify("foo.jpg", query))
+
+	query = signer.Sign("foo.jpg", nil)
+	query.Set("kid", "other")
+	assert.Equal(t, ErrInvalidSignature, signer.Verify("foo.jpg", query))
+
+	query = signer.Sign("foo.jpg", nil)
+	query.Del("sig")
+	assert.Equal(t, ErrURLNotSigned, signer.Verify("foo.jpg", query))
+}
+
+func TestURLSignerKeyRotation(t *testing.T) {
+	oldSigner, _ := newTestURLSigner(SigningKey{"old", []byte("old secret")})
+	rotatedSigner, _ := newTestURLSigner(SigningKey{"new", []byte("new secret")}, SigningKey{"old", []byte("old secret")})
+
+	assert.NoError(t, rotatedSigner.Verify("foo.jpg", oldSigner.Sign("foo.jpg", nil)))
+	assert.Equal(t, "new", rotatedSigner.Sign("foo.jpg", nil).Get("kid"))
+}
+
+func TestParseSigningKeys(t *testing.T) {
+	keys, err := ParseSigningKeys("a:secret, b:other:secret,")
+	require.NoError(t, err)
+	assert.Equal(t, []SigningKey{{"a", []byte("secret")}, {"b", []byte("other:secret")}}, keys)
+
+	_, err = ParseSigningKeys("nosecret")
+	assert.Error(t, err)
+
+	keys, err = ParseSigningKeys("")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
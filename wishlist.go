@@ -0,0 +1,338 @@
+package memebot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WishReaction is the reaction users add to a "no meme found" reply to wish for a meme.
+const WishReaction = "pray"
+
+// WishOfferExpiry is how long reactions to a "no meme found" reply are accepted for.
+const WishOfferExpiry = 24 * time.Hour
+
+// DefaultWishCheckInterval is how often the bot checks for memes that fulfill wishes.
+const DefaultWishCheckInterval = time.Minute
+
+// DefaultWishlistTopCount is the number of wishes listed when asked for the wishlist.
+const DefaultWishlistTopCount = 10
+
+type WishRequest struct {
+	User    string    `json:"user"`
+	Channel string    `json:"channel"`
+	Time    time.Time `json:"time"`
+}
+
+// Wish is a keyword that users want a meme for.
+type Wish struct {
+	Keyword  string        `json:"keyword"`
+	Requests []WishRequest `json:"requests"`
+}
+
+// FulfilledWish is a request for a keyword that now has a meme.
+type FulfilledWish struct {
+	WishRequest
+	Keyword string
+	Meme    Meme
+}
+
+// Wishlist stores the keywords users want memes for, and who wants them.
+// Keywords are case-insensitive.
+type Wishlist struct {
+	// If not empty, wishes are saved to this file as JSON after every change.
+	Path string
+
+	lock   sync.Mutex
+	wishes map[string]*Wish
+}
+
+// NewWishlist returns a wishlist that's only kept in memory.
+func NewWishlist() *Wishlist {
+	return &Wishlist{wishes: make(map[string]*Wish)}
+}
+
+// LoadWishlist returns a wishlist saved in path. If path doesn't exist, the wishlist is
+// empty, and the file is created when a wish is added.
+func LoadWishlist(path string) (*Wishlist, error) {
+	wishlist := NewWishlist()
+	wishlist.Path = path
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return wishlist, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var wishes []*Wish
+	if err := json.Unmarshal(data, &wishes); err != nil {
+		return nil, fmt.Errorf("error decoding wishlist %s: %s", path, err)
+	}
+	for _, wish := range wishes {
+		wishlist.wishes[normalizeKeyword(wish.Keyword)] = wish
+	}
+	return wishlist, nil
+}
+
+// Add records that user wants a meme for keyword, from channel. Returns false if the
+// user had already asked for it.
+func (w *Wishlist) Add(keyword, user, channel string) (bool, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	keyword = normalizeKeyword(keyword)
+	wish, found := w.wishes[keyword]
+	if !found {
+		wish = &Wish{Keyword: keyword}
+		w.wishes[keyword] = wish
+	}
+	for _, request := range wish.Requests {
+		if request.User == user {
+			return false, nil
+		}
+	}
+
+	wish.Requests = append(wish.Requests, WishRequest{
+		User:    user,
+		Channel: channel,
+		Time:    time.Now(),
+	})
+	return true, w.save()
+}
+
+// Wishes returns the wishes, most requested first. If channel isn't empty, only the
+// requests made from it are included.
+func (w *Wishlist) Wishes(channel string) []Wish {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	wishes := make([]Wish, 0, len(w.wishes))
+	for _, wish := range w.wishes {
+		if channel == "" {
+			wishes = append(wishes, *wish)
+			continue
+		}
+
+		inChannel := Wish{Keyword: wish.Keyword}
+		for _, request := range wish.Requests {
+			if request.Channel == channel {
+				inChannel.Requests = append(inChannel.Requests, request)
+			}
+		}
+		if len(inChannel.Requests) > 0 {
+			wishes = append(wishes, inChannel)
+		}
+	}
+	sort.Sort(wishesByPopularity(wishes))
+	return wishes
+}
+
+// Fulfill removes and returns the requests for keywords that now have memes in memes.
+// Memes flagged as NSFW only fulfill requests from channels whose options allow them.
+func (w *Wishlist) Fulfill(memes *MemeIndex, options func(channel string) SearchOptions) ([]FulfilledWish, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var fulfilled []FulfilledWish
+	for keyword, wish := range w.wishes {
+		found := memes.FindByKeyword(keyword)
+		if len(found) == 0 {
+			continue
+		}
+
+		var remaining []WishRequest
+		for _, request := range wish.Requests {
+			allowed := filterNSFW(found, options(request.Channel))
+			if len(allowed) == 0 {
+				remaining = append(remaining, request)
+				continue
+			}
+			fulfilled = append(fulfilled, FulfilledWish{request, keyword, allowed[0]})
+		}
+
+		if len(remaining) == 0 {
+			delete(w.wishes, keyword)
+		} else {
+			wish.Requests = remaining
+		}
+	}
+	if len(fulfilled) == 0 {
+		return nil, nil
+	}
+
+	sort.Sort(fulfilledWishesByTime(fulfilled))
+	return fulfilled, w.save()
+}
+
+// save must be called with lock held.
+func (w *Wishlist) save() error {
+	if w.Path == "" {
+		return nil
+	}
+
+	wishes := make([]*Wish, 0, len(w.wishes))
+	for _, wish := range w.wishes {
+		wishes = append(wishes, wish)
+	}
+	sort.Sort(wishPointersByKeyword(wishes))
+
+	data, err := json.MarshalIndent(wishes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomically(w.Path, append(data, '\n'), 0644)
+}
+
+// wishesByPopularity sorts by decreasing number of requests, then keyword.
+type wishesByPopularity []Wish
+
+func (s wishesByPopularity) Len() int      { return len(s) }
+func (s wishesByPopularity) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s wishesByPopularity) Less(i, j int) bool {
+	if len(s[i].Requests) != len(s[j].Requests) {
+		return len(s[i].Requests) > len(s[j].Requests)
+	}
+	return s[i].Keyword < s[j].Keyword
+}
+
+type wishPointersByKeyword []*Wish
+
+func (s wishPointersByKeyword) Len() int           { return len(s) }
+func (s wishPointersByKeyword) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s wishPointersByKeyword) Less(i, j int) bool { return s[i].Keyword < s[j].Keyword }
+
+// fulfilledWishesByTime sorts by the time the wishes were made, then keyword.
+type fulfilledWishesByTime []FulfilledWish
+
+func (s fulfilledWishesByTime) Len() int      { return len(s) }
+func (s fulfilledWishesByTime) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s fulfilledWishesByTime) Less(i, j int) bool {
+	if !s[i].Time.Equal(s[j].Time) {
+		return s[i].Time.Before(s[j].Time)
+	}
+	return s[i].Keyword < s[j].Keyword
+}
+
+// wishNotification is a message telling users their wish was fulfilled.
+type wishNotification struct {
+	// Exactly one of Channel and User is set. If User is set, the message is sent as a
+	// direct message.
+	Channel string
+	User    string
+
+	Text string
+}
+
+// wishNotifications returns the messages to send for fulfilled. If dm is false,
+// requesters are mentioned in the channel they asked in, one message per keyword and channel.
+func wishNotifications(fulfilled []FulfilledWish, dm bool) []wishNotification {
+	var notifications []wishNotification
+	if dm {
+		for _, wish := range fulfilled {
+			notifications = append(notifications, wishNotification{
+				User: wish.User,
+				Text: fmt.Sprintf("You wished for a meme for “%s”, and now there is one: %s", wish.Keyword, wish.Meme.URL()),
+			})
+		}
+		return notifications
+	}
+
+	// Group by channel and keyword, in the order the first wish in each group was made.
+	type group struct {
+		channel, keyword string
+	}
+	var groups []group
+	users := make(map[group][]string)
+	memes := make(map[group]Meme)
+	for _, wish := range fulfilled {
+		g := group{wish.Channel, wish.Keyword}
+		if _, found := users[g]; !found {
+			groups = append(groups, g)
+			memes[g] = wish.Meme
+		}
+		users[g] = append(users[g], wish.User)
+	}
+
+	for _, g := range groups {
+		var mentions bytes.Buffer
+		for _, user := range users[g] {
+			fmt.Fprintf(&mentions, "<@%s> ", user)
+		}
+		notifications = append(notifications, wishNotification{
+			Channel: g.channel,
+			Text: fmt.Sprintf("%sYou wished for a meme for “%s”, and now there is one: %s",
+				mentions.String(), g.keyword, memes[g].URL()),
+		})
+	}
+	return notifications
+}
+
+// formatWishlist lists up to top wishes, or says there aren't any.
+func formatWishlist(wishes []Wish, top int) string {
+	if len(wishes) == 0 {
+		return "Nobody has wished for any memes."
+	}
+
+	parts := make([]string, 0, top)
+	for i, wish := range wishes {
+		if i == top {
+			break
+		}
+		parts = append(parts, fmt.Sprintf("%s ×%d", wish.Keyword, len(wish.Requests)))
+	}
+	text := "Most wished-for memes: " + strings.Join(parts, ", ")
+	if len(wishes) > top {
+		text += fmt.Sprintf(" (and %d more)", len(wishes)-top)
+	}
+	return text
+}
+
+// wishOffers remembers the "no meme found" replies that can be reacted to with
+// WishReaction, keyed by channel and message timestamp.
+type wishOffers struct {
+	lock   sync.Mutex
+	offers map[string]wishOffer
+}
+
+type wishOffer struct {
+	Keyword string
+	Channel string
+	Time    time.Time
+}
+
+func newWishOffers() *wishOffers {
+	return &wishOffers{offers: make(map[string]wishOffer)}
+}
+
+// Add remembers that the message at timestamp in channel offered a wish for keyword,
+// and forgets offers older than WishOfferExpiry.
+func (o *wishOffers) Add(channel, timestamp, keyword string, now time.Time) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	for key, offer := range o.offers {
+		if now.Sub(offer.Time) > WishOfferExpiry {
+			delete(o.offers, key)
+		}
+	}
+	o.offers[channel+"/"+timestamp] = wishOffer{keyword, channel, now}
+}
+
+// Find returns the offer made by the message at timestamp in channel, if it hasn't expired.
+func (o *wishOffers) Find(channel, timestamp string, now time.Time) (wishOffer, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	offer, found := o.offers[channel+"/"+timestamp]
+	if !found || now.Sub(offer.Time) > WishOfferExpiry {
+		return wishOffer{}, false
+	}
+	return offer, true
+}
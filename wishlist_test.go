@@ -0,0 +1,167 @@
+package memebot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWishlistAdd(t *testing.T) {
+	wishlist := NewWishlist()
+
+	added, err := wishlist.Add("Unicorn", "U1", "C1")
+	require.NoError(t, err)
+	assert.True(t, added)
+	added, err = wishlist.Add("unicorn", "U1", "C2")
+	require.NoError(t, err)
+	assert.False(t, added)
+	wishlist.Add("unicorn", "U2", "C2")
+	wishlist.Add("dragon", "U1", "C1")
+	wishlist.Add("beaver", "U3", "C1")
+
+	wishes := wishlist.Wishes("")
+	require.Len(t, wishes, 3)
+	assert.Equal(t, "unicorn", wishes[0].Keyword)
+	require.Len(t, wishes[0].Requests, 2)
+	assert.Equal(t, "U1", wishes[0].Requests[0].User)
+	assert.Equal(t, "C1", wishes[0].Requests[0].Channel)
+	assert.Equal(t, "beaver", wishes[1].Keyword)
+	assert.Equal(t, "dragon", wishes[2].Keyword)
+
+	// Only requests from the channel are included.
+	wishes = wishlist.Wishes("C2")
+	require.Len(t, wishes, 1)
+	assert.Equal(t, "unicorn", wishes[0].Keyword)
+	require.Len(t, wishes[0].Requests, 1)
+	assert.Equal(t, "U2", wishes[0].Requests[0].User)
+	assert.Len(t, wishlist.Wishes("")[0].Requests, 2)
+}
+
+func TestWishlistSaves(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memebot-wishlist")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wishlist.json")
+
+	wishlist, err := LoadWishlist(path)
+	require.NoError(t, err)
+	assert.Empty(t, wishlist.Wishes(""))
+	wishlist.Add("unicorn", "U1", "C1")
+	wishlist.Add("dragon", "U2", "C1")
+
+	loaded, err := LoadWishlist(path)
+	require.NoError(t, err)
+	wishes := loaded.Wishes("")
+	require.Len(t, wishes, 2)
+	assert.Equal(t, "dragon", wishes[0].Keyword)
+	assert.Equal(t, "U2", wishes[0].Requests[0].User)
+
+	_, err = loaded.Fulfill(NewTestMemeIndex(NewMockMeme("http://example.com/dragon.jpg", "dragon")),
+		func(string) SearchOptions { return SearchOptions{} })
+	require.NoError(t, err)
+	loaded, err = LoadWishlist(path)
+	require.NoError(t, err)
+	require.Len(t, loaded.Wishes(""), 1)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("{"), 0644))
+	_, err = LoadWishlist(path)
+	assert.Error(t, err)
+}
+
+func TestWishlistFulfill(t *testing.T) {
+	wishlist := NewWishlist()
+	wishlist.Add("unicorn", "U1", "C1")
+	wishlist.Add("unicorn", "U2", "nsfw")
+	wishlist.Add("dragon", "U1", "C1")
+	wishlist.Add("beaver", "U3", "C1")
+
+	memes := NewTestMemeIndex(
+		NewMockFlaggedMeme("http://example.com/unicorn.jpg", MemeFlags{NSFW: true}, "Unicorn"),
+		NewMockMeme("http://example.com/beaver.jpg", "beaver"),
+	)
+	options := func(channel string) SearchOptions {
+		return SearchOptions{AllowNSFW: channel == "nsfw"}
+	}
+
+	fulfilled, err := wishlist.Fulfill(memes, options)
+	require.NoError(t, err)
+	require.Len(t, fulfilled, 2)
+	assert.Equal(t, "unicorn", fulfilled[0].Keyword)
+	assert.Equal(t, "U2", fulfilled[0].User)
+	assert.Equal(t, "http://example.com/unicorn.jpg", fulfilled[0].Meme.URL().String())
+	assert.Equal(t, "beaver", fulfilled[1].Keyword)
+
+	// The NSFW meme doesn't fulfill the wish from a channel that doesn't allow it.
+	wishes := wishlist.Wishes("")
+	require.Len(t, wishes, 2)
+	assert.Equal(t, "dragon", wishes[0].Keyword)
+	assert.Equal(t, "unicorn", wishes[1].Keyword)
+	assert.Equal(t, "U1", wishes[1].Requests[0].User)
+
+	fulfilled, err = wishlist.Fulfill(memes, options)
+	require.NoError(t, err)
+	assert.Empty(t, fulfilled)
+}
+
+func TestWishNotifications(t *testing.T) {
+	unicorn := NewMockMeme("http://example.com/unicorn.jpg", "unicorn")
+	fulfilled := []FulfilledWish{
+		{WishRequest{User: "U1", Channel: "C1"}, "unicorn", unicorn},
+		{WishRequest{User: "U2", Channel: "C2"}, "unicorn", unicorn},
+		{WishRequest{User: "U3", Channel: "C1"}, "unicorn", unicorn},
+	}
+
+	assert.Equal(t, []wishNotification{
+		{Channel: "C1", Text: "<@U1> <@U3> You wished for a meme for “unicorn”, and now there is one: http://example.com/unicorn.jpg"},
+		{Channel: "C2", Text: "<@U2> You wished for a meme for “unicorn”, and now there is one: http://example.com/unicorn.jpg"},
+	}, wishNotifications(fulfilled, false))
+
+	notifications := wishNotifications(fulfilled, true)
+	require.Len(t, notifications, 3)
+	assert.Equal(t, wishNotification{
+		User: "U1",
+		Text: "You wished for a meme for “unicorn”, and now there is one: http://example.com/unicorn.jpg",
+	}, notifications[0])
+}
+
+func TestFormatWishlist(t *testing.T) {
+	assert.Equal(t, "Nobody has wished for any memes.", formatWishlist(nil, 2))
+
+	wishes := []Wish{
+		{Keyword: "unicorn", Requests: make([]WishRequest, 3)},
+		{Keyword: "dragon", Requests: make([]WishRequest, 1)},
+		{Keyword: "beaver", Requests: make([]WishRequest, 1)},
+	}
+	assert.Equal(t, "Most wished-for memes: unicorn ×3, dragon ×1 (and 1 more)", formatWishlist(wishes, 2))
+}
+
+func TestWishForReaction(t *testing.T) {
+	bot := &MemeBot{wishOffers: newWishOffers()}
+	now := time.Now()
+	bot.wishOffers.Add("C1", "123.456", "unicorn", now.Add(-time.Hour))
+	bot.wishOffers.Add("C1", "100.000", "dragon", now.Add(-2*WishOfferExpiry))
+
+	event := &slack.ReactionAddedEvent{User: "U1", Reaction: WishReaction}
+	event.Item.Type = slack.TYPE_MESSAGE
+	event.Item.Channel = "C1"
+	event.Item.Timestamp = "123.456"
+	offer, found := bot.wishForReaction(event, now)
+	require.True(t, found)
+	assert.Equal(t, "unicorn", offer.Keyword)
+	assert.Equal(t, "C1", offer.Channel)
+
+	event.Reaction = "thumbsup"
+	_, found = bot.wishForReaction(event, now)
+	assert.False(t, found)
+
+	event.Reaction = WishReaction
+	event.Item.Timestamp = "100.000"
+	_, found = bot.wishForReaction(event, now)
+	assert.False(t, found, "offer expired")
+}